@@ -0,0 +1,430 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/RobertMNewton/bambu-golang-api/pkg/ftp"
+	"github.com/RobertMNewton/bambu-golang-api/pkg/hms"
+	"github.com/RobertMNewton/bambu-golang-api/pkg/mqtt"
+	"github.com/RobertMNewton/bambu-golang-api/pkg/mqtt/report"
+	"github.com/RobertMNewton/bambu-golang-api/pkg/printer"
+	"github.com/RobertMNewton/bambu-golang-api/pkg/state"
+)
+
+func init() {
+	register(&command{name: "status", summary: "show the printer status", run: runStatus})
+	register(&command{name: "watch", summary: "stream status changes until interrupted", run: runWatch})
+	register(&command{name: "gcode", usage: "<line>...", summary: "send G-code lines", run: runGCode})
+	register(&command{name: "print", usage: "[options] <file.3mf>", summary: "upload and start a print", run: runPrint})
+	register(&command{name: "pause", summary: "pause the current print", run: simpleCommand((*printer.Printer).PausePrintAndWait)})
+	register(&command{name: "resume", summary: "resume the current print", run: simpleCommand((*printer.Printer).ResumePrintAndWait)})
+	register(&command{name: "stop", summary: "stop the current print", run: simpleCommand((*printer.Printer).StopPrintAndWait)})
+	register(&command{name: "light", usage: "[-node chamber_light] on|off", summary: "switch a light", run: runLight})
+	register(&command{name: "speed", usage: "silent|standard|sport|ludicrous|1-4", summary: "set the print speed", run: runSpeed})
+	register(&command{name: "ams", usage: "[set [options] <tray> | switch <tray> | resume | reset | pause]", summary: "show or control the AMS", run: runAMS})
+}
+
+// withPrinter connects to the selected printer and runs fn with it.
+func (app *app) withPrinter(ctx context.Context, fn func(p *printer.Printer) error) error {
+	p, err := app.connect(ctx)
+	if err != nil {
+		return err
+	}
+	defer p.Disconnect()
+
+	return fn(p)
+}
+
+// waitForState waits until the reply to the pushall sent by Connect has been
+// merged into the printer state.
+func waitForState(ctx context.Context, p *printer.Printer) (state.PrinterState, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	reports := p.Reports(ctx, mqtt.ReportsOptions{Buffer: 1, Policy: mqtt.DropOldest, Types: []string{report.TypePrint}})
+
+	for {
+		if s := p.State(); !s.UpdatedAt.IsZero() {
+			return s, nil
+		}
+
+		select {
+		case <-reports:
+		case <-ctx.Done():
+			return state.PrinterState{}, fmt.Errorf("no status received: %w", ctx.Err())
+		}
+	}
+}
+
+func simpleCommand(fn func(p *printer.Printer, ctx context.Context) error) func(app *app, ctx context.Context, args []string) error {
+	return func(app *app, ctx context.Context, args []string) error {
+		if _, err := app.parse(app.flags("command"), args, 0, 0); err != nil {
+			return err
+		}
+
+		ctx, cancel := app.context(ctx, false)
+		defer cancel()
+
+		return app.withPrinter(ctx, func(p *printer.Printer) error {
+			return fn(p, ctx)
+		})
+	}
+}
+
+func runStatus(app *app, ctx context.Context, args []string) error {
+	if _, err := app.parse(app.flags("status"), args, 0, 0); err != nil {
+		return err
+	}
+
+	ctx, cancel := app.context(ctx, false)
+	defer cancel()
+
+	return app.withPrinter(ctx, func(p *printer.Printer) error {
+		s, err := waitForState(ctx, p)
+		if err != nil {
+			return err
+		}
+		return app.printState(s)
+	})
+}
+
+type watchEvent struct {
+	Time  time.Time           `json:"time"`
+	State *state.PrinterState `json:"state,omitempty"`
+	HMS   *hmsEvent           `json:"hms,omitempty"`
+}
+
+type hmsEvent struct {
+	Event   string `json:"event"`
+	ID      string `json:"id"`
+	Message string `json:"message,omitempty"`
+}
+
+func runWatch(app *app, ctx context.Context, args []string) error {
+	if _, err := app.parse(app.flags("watch"), args, 0, 0); err != nil {
+		return err
+	}
+
+	ctx, cancel := app.context(ctx, true)
+	defer cancel()
+
+	return app.withPrinter(ctx, func(p *printer.Printer) error {
+		reports := p.Reports(ctx, mqtt.ReportsOptions{Buffer: 64, Policy: mqtt.DropOldest, Types: []string{report.TypePrint}})
+		tracker := hms.NewTracker()
+
+		last := ""
+		for r := range reports {
+			if r.Payload.Command != "push_status" {
+				continue
+			}
+
+			now := time.Now()
+			s := p.State()
+
+			for _, event := range tracker.Apply(r) {
+				if app.json {
+					app.printJSONLine(watchEvent{Time: now, HMS: &hmsEvent{Event: event.Type.String(), ID: event.Error.ID, Message: event.Error.Message}})
+				} else {
+					fmt.Fprintf(app.stdout, "%s HMS %s %v\n", now.Format(time.TimeOnly), event.Type, event.Error)
+				}
+			}
+
+			if app.json {
+				app.printJSONLine(watchEvent{Time: now, State: &s})
+				continue
+			}
+
+			line := fmt.Sprintf("%s, nozzle %.0f/%.0f °C, bed %.0f/%.0f °C",
+				describeProgress(s), s.Temperatures.Nozzle, s.Temperatures.NozzleTarget,
+				s.Temperatures.Bed, s.Temperatures.BedTarget)
+			if line != last {
+				fmt.Fprintf(app.stdout, "%s %s\n", now.Format(time.TimeOnly), line)
+				last = line
+			}
+		}
+
+		if ctx.Err() == context.Canceled {
+			return nil
+		}
+		return ctx.Err()
+	})
+}
+
+func runGCode(app *app, ctx context.Context, args []string) error {
+	lines, err := app.parse(app.flags("gcode"), args, 1, -1)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := app.context(ctx, false)
+	defer cancel()
+
+	return app.withPrinter(ctx, func(p *printer.Printer) error {
+		return p.SendGCodeAndWait(ctx, strings.Join(lines, "\n"))
+	})
+}
+
+func runPrint(app *app, ctx context.Context, args []string) error {
+	options := printer.DefaultPrintOptions()
+	var amsMapping string
+
+	flags := app.flags("print")
+	flags.StringVar(&options.RemoteName, "name", "", "file name on the SD card")
+	flags.IntVar(&options.Plate, "plate", 1, "plate to print")
+	flags.StringVar(&options.BedType, "bed-type", options.BedType, "bed type")
+	flags.BoolVar(&options.Timelapse, "timelapse", options.Timelapse, "record a timelapse")
+	flags.BoolVar(&options.BedLeveling, "bed-leveling", options.BedLeveling, "level the bed first")
+	flags.BoolVar(&options.FlowCalibration, "flow-cali", options.FlowCalibration, "calibrate the flow first")
+	flags.BoolVar(&options.VibrationCalibration, "vibration-cali", options.VibrationCalibration, "calibrate vibrations first")
+	flags.BoolVar(&options.LayerInspect, "layer-inspect", options.LayerInspect, "inspect the first layer")
+	flags.BoolVar(&options.UseAMS, "ams", options.UseAMS, "feed filament from the AMS")
+	flags.StringVar(&amsMapping, "ams-mapping", "", "comma separated tray for each filament, e.g. 0,2")
+
+	files, err := app.parse(flags, args, 1, 1)
+	if err != nil {
+		return err
+	}
+
+	if amsMapping != "" {
+		for _, field := range strings.Split(amsMapping, ",") {
+			tray, err := strconv.Atoi(strings.TrimSpace(field))
+			if err != nil {
+				return fmt.Errorf("invalid -ams-mapping: %w", err)
+			}
+			options.AMSMapping = append(options.AMSMapping, tray)
+		}
+	}
+
+	if !app.json {
+		options.Upload.Progress = func(progress ftp.UploadProgress) {
+			fmt.Fprintf(app.stderr, "\ruploading %d/%d bytes", progress.BytesSent, progress.TotalBytes)
+		}
+	}
+
+	ctx, cancel := app.context(ctx, true)
+	defer cancel()
+
+	return app.withPrinter(ctx, func(p *printer.Printer) error {
+		if _, err := waitForState(ctx, p); err != nil {
+			return err
+		}
+
+		err := p.PrintFile(ctx, printer.FileSource(files[0]), options)
+		if !app.json {
+			fmt.Fprintln(app.stderr)
+		}
+		if err != nil {
+			return err
+		}
+
+		return app.printState(p.State())
+	})
+}
+
+func runLight(app *app, ctx context.Context, args []string) error {
+	flags := app.flags("light")
+	node := flags.String("node", "chamber_light", "light to switch")
+
+	modes, err := app.parse(flags, args, 1, 1)
+	if err != nil {
+		return err
+	}
+
+	var on bool
+	switch modes[0] {
+	case "on":
+		on = true
+	case "off":
+	default:
+		return errUsage
+	}
+
+	ctx, cancel := app.context(ctx, false)
+	defer cancel()
+
+	return app.withPrinter(ctx, func(p *printer.Printer) error {
+		return p.SetLight(ctx, *node, on)
+	})
+}
+
+func runSpeed(app *app, ctx context.Context, args []string) error {
+	levels, err := app.parse(app.flags("speed"), args, 1, 1)
+	if err != nil {
+		return err
+	}
+
+	level, err := parseSpeedLevel(levels[0])
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := app.context(ctx, false)
+	defer cancel()
+
+	return app.withPrinter(ctx, func(p *printer.Printer) error {
+		return p.SetSpeed(ctx, level)
+	})
+}
+
+func parseSpeedLevel(value string) (state.SpeedLevel, error) {
+	for level := state.SpeedSilent; level <= state.SpeedLudicrous; level++ {
+		if value == level.String() || value == strconv.Itoa(int(level)) {
+			return level, nil
+		}
+	}
+	return 0, fmt.Errorf("invalid speed %q", value)
+}
+
+func runAMS(app *app, ctx context.Context, args []string) error {
+	flags := app.flags("ams")
+
+	var filament printer.TrayFilament
+	if len(args) > 0 && args[0] == "set" {
+		flags.StringVar(&filament.Type, "type", "", "filament type, e.g. PLA")
+		flags.StringVar(&filament.Color, "color", "FFFFFFFF", "colour as RRGGBBAA")
+		flags.StringVar(&filament.InfoIdx, "preset", "", "Bambu Studio filament preset id, e.g. GFA00")
+		flags.IntVar(&filament.NozzleTempMin, "min-temp", 190, "minimum nozzle temperature")
+		flags.IntVar(&filament.NozzleTempMax, "max-temp", 230, "maximum nozzle temperature")
+	}
+
+	var rest []string
+	if len(args) > 0 {
+		rest = args[1:]
+	}
+
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		if _, err := app.parse(flags, args, 0, 0); err != nil {
+			return err
+		}
+		return app.showAMS(ctx)
+	}
+
+	var run func(ctx context.Context, p *printer.Printer) error
+
+	switch action := args[0]; action {
+	case "set", "switch":
+		trays, err := app.parse(flags, rest, 1, 1)
+		if err != nil {
+			return err
+		}
+
+		tray, err := parseTrayID(trays[0])
+		if err != nil {
+			return err
+		}
+
+		if action == "set" {
+			if filament.Type == "" {
+				return fmt.Errorf("-type is required")
+			}
+			run = func(ctx context.Context, p *printer.Printer) error {
+				return p.SetTrayFilament(ctx, tray, filament)
+			}
+		} else {
+			run = func(ctx context.Context, p *printer.Printer) error {
+				return p.SwitchTray(ctx, tray)
+			}
+		}
+	case "resume", "reset", "pause":
+		if _, err := app.parse(flags, rest, 0, 0); err != nil {
+			return err
+		}
+		run = func(ctx context.Context, p *printer.Printer) error {
+			return p.AMSControl(ctx, printer.AMSAction(action))
+		}
+	default:
+		return errUsage
+	}
+
+	ctx, cancel := app.context(ctx, false)
+	defer cancel()
+
+	return app.withPrinter(ctx, func(p *printer.Printer) error {
+		// Tray ids are validated against the installed units.
+		if _, err := waitForState(ctx, p); err != nil {
+			return err
+		}
+		return run(ctx, p)
+	})
+}
+
+// parseTrayID accepts a global tray id, "ext" for the external spool, or a
+// unit letter and slot number such as A1.
+func parseTrayID(value string) (int, error) {
+	if value == "ext" {
+		return state.ExternalTrayID, nil
+	}
+
+	if id, err := strconv.Atoi(value); err == nil {
+		return id, nil
+	}
+
+	if len(value) == 2 && value[0] >= 'A' && value[0] <= 'D' && value[1] >= '1' && value[1] <= '4' {
+		return int(value[0]-'A')*4 + int(value[1]-'1'), nil
+	}
+
+	return 0, fmt.Errorf("invalid tray %q, use 0-15, A1-D4 or ext", value)
+}
+
+func (app *app) showAMS(ctx context.Context) error {
+	ctx, cancel := app.context(ctx, false)
+	defer cancel()
+
+	return app.withPrinter(ctx, func(p *printer.Printer) error {
+		s, err := waitForState(ctx, p)
+		if err != nil {
+			return err
+		}
+
+		if app.json {
+			return app.printJSON(s.AMS)
+		}
+
+		w := app.table()
+		fmt.Fprintln(w, "TRAY\tTYPE\tCOLOR\tREMAIN\tTEMP\tTAG")
+		for _, unit := range s.AMS.Units {
+			unitID, _ := strconv.Atoi(unit.ID)
+			for _, tray := range unit.Trays {
+				slot, _ := strconv.Atoi(tray.ID)
+				name := fmt.Sprintf("%c%d", 'A'+unitID, slot+1)
+				if s.AMS.TrayNow == strconv.Itoa(unitID*4+slot) {
+					name += "*"
+				}
+				printTray(w, name, tray)
+			}
+		}
+		if s.AMS.TrayNow == strconv.Itoa(state.ExternalTrayID) {
+			printTray(w, "ext*", s.AMS.ExternalSpool)
+		} else {
+			printTray(w, "ext", s.AMS.ExternalSpool)
+		}
+		if err := w.Flush(); err != nil {
+			return err
+		}
+
+		for _, unit := range s.AMS.Units {
+			unitID, _ := strconv.Atoi(unit.ID)
+			fmt.Fprintf(app.stdout, "AMS %c: humidity level %s, %.1f °C\n", 'A'+unitID, unit.Humidity, unit.Temperature)
+		}
+		return nil
+	})
+}
+
+func printTray(w io.Writer, name string, tray state.Tray) {
+	if tray.Empty() {
+		fmt.Fprintf(w, "%s\t-\t\t\t\t\n", name)
+		return
+	}
+
+	remain := "?"
+	if tray.Remain >= 0 {
+		remain = fmt.Sprintf("%d%%", tray.Remain)
+	}
+
+	fmt.Fprintf(w, "%s\t%s\t#%s\t%s\t%d-%d °C\t%s\n", name, tray.Type, tray.Color, remain, tray.NozzleTempMin, tray.NozzleTempMax, tray.TagUID)
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/RobertMNewton/bambu-golang-api/pkg/ftp"
+)
+
+func init() {
+	register(&command{name: "files", usage: "ls [dir] | get <remote> [local|-] | put <local> [remote] | rm <remote>", summary: "manage files on the SD card", run: runFiles})
+}
+
+// withFTP connects to the selected printer's FTP server and runs fn with it.
+func (app *app) withFTP(ctx context.Context, fn func(client *ftp.Client) error) error {
+	cfg, err := app.printerConfig()
+	if err != nil {
+		return err
+	}
+
+	client := ftp.NewClient(cfg)
+	if err := client.Connect(ctx); err != nil {
+		return err
+	}
+	defer client.Disconnect()
+
+	return fn(client)
+}
+
+func runFiles(app *app, ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		return errUsage
+	}
+
+	flags := app.flags("files")
+
+	switch args[0] {
+	case "ls":
+		dirs, err := app.parse(flags, args[1:], 0, 1)
+		if err != nil {
+			return err
+		}
+		dir := "/"
+		if len(dirs) == 1 {
+			dir = dirs[0]
+		}
+
+		ctx, cancel := app.context(ctx, false)
+		defer cancel()
+
+		return app.withFTP(ctx, func(client *ftp.Client) error {
+			files, err := client.ListFiles(ctx, dir)
+			if err != nil {
+				return err
+			}
+			return app.printFiles(files)
+		})
+	case "get":
+		paths, err := app.parse(flags, args[1:], 1, 2)
+		if err != nil {
+			return err
+		}
+		remote, local := paths[0], path.Base(paths[0])
+		if len(paths) == 2 {
+			local = paths[1]
+		}
+
+		ctx, cancel := app.context(ctx, true)
+		defer cancel()
+
+		return app.withFTP(ctx, func(client *ftp.Client) error {
+			return download(ctx, client, remote, local, app.stdout)
+		})
+	case "put":
+		paths, err := app.parse(flags, args[1:], 1, 2)
+		if err != nil {
+			return err
+		}
+		local, remote := paths[0], "/"+filepath.Base(paths[0])
+		if len(paths) == 2 {
+			remote = paths[1]
+		}
+
+		file, err := os.Open(local)
+		if err != nil {
+			return fmt.Errorf("failed to open file: %w", err)
+		}
+		defer file.Close()
+
+		info, err := file.Stat()
+		if err != nil {
+			return fmt.Errorf("failed to stat file: %w", err)
+		}
+
+		options := ftp.UploadOptions{Retries: 1}
+		if !app.json {
+			options.Progress = func(progress ftp.UploadProgress) {
+				fmt.Fprintf(app.stderr, "\ruploading %d/%d bytes", progress.BytesSent, progress.TotalBytes)
+			}
+		}
+
+		ctx, cancel := app.context(ctx, true)
+		defer cancel()
+
+		return app.withFTP(ctx, func(client *ftp.Client) error {
+			err := client.Upload(ctx, remote, file, info.Size(), options)
+			if options.Progress != nil {
+				fmt.Fprintln(app.stderr)
+			}
+			return err
+		})
+	case "rm":
+		paths, err := app.parse(flags, args[1:], 1, 1)
+		if err != nil {
+			return err
+		}
+
+		ctx, cancel := app.context(ctx, false)
+		defer cancel()
+
+		return app.withFTP(ctx, func(client *ftp.Client) error {
+			return client.Delete(ctx, paths[0])
+		})
+	default:
+		return errUsage
+	}
+}
+
+func download(ctx context.Context, client *ftp.Client, remote, local string, stdout io.Writer) error {
+	if local == "-" {
+		return client.Download(ctx, remote, stdout)
+	}
+
+	file, err := os.Create(local)
+	if err != nil {
+		return fmt.Errorf("failed to create file: %w", err)
+	}
+
+	if err := client.Download(ctx, remote, file); err != nil {
+		file.Close()
+		os.Remove(local)
+		return err
+	}
+
+	return file.Close()
+}
+
+func (app *app) printFiles(files []ftp.FileInfo) error {
+	if app.json {
+		if files == nil {
+			files = []ftp.FileInfo{}
+		}
+		return app.printJSON(files)
+	}
+
+	w := app.table()
+	for _, file := range files {
+		name := file.Name
+		if file.IsDir {
+			name += "/"
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\n", file.Size, file.ModTime.Format("2006-01-02 15:04"), name)
+	}
+	return w.Flush()
+}
@@ -0,0 +1,159 @@
+// Command bambu controls Bambu Lab printers over the LAN.
+//
+// Printers are read from a JSON, YAML or TOML profile file, by default
+// $XDG_CONFIG_HOME/bambu/config.yaml (see config.DefaultProfilePath):
+//
+//	default: workshop
+//	printers:
+//	  workshop:
+//	    serial: 01S00A000000000
+//	    host: 192.168.1.20
+//	    access_code: ${WORKSHOP_ACCESS_CODE}
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"sort"
+	"syscall"
+	"time"
+)
+
+const defaultTimeout = 30 * time.Second
+
+type command struct {
+	name    string
+	usage   string
+	summary string
+
+	run func(app *app, ctx context.Context, args []string) error
+}
+
+var commands = map[string]*command{}
+
+func register(cmd *command) {
+	commands[cmd.name] = cmd
+}
+
+// errUsage is returned by commands called with invalid arguments.
+var errUsage = errors.New("usage")
+
+type app struct {
+	configPath string
+	printer    string
+	json       bool
+	timeout    time.Duration
+
+	stdout io.Writer
+	stderr io.Writer
+}
+
+// flags returns a flag set with the global options, so that they can be given
+// before or after the command name.
+func (app *app) flags(name string) *flag.FlagSet {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	flags.SetOutput(app.stderr)
+	flags.StringVar(&app.configPath, "config", app.configPath, "profile file")
+	flags.StringVar(&app.printer, "printer", app.printer, "printer profile to use")
+	flags.StringVar(&app.printer, "p", app.printer, "shorthand for -printer")
+	flags.BoolVar(&app.json, "json", app.json, "print JSON")
+	flags.DurationVar(&app.timeout, "timeout", app.timeout, "timeout for the command")
+	return flags
+}
+
+func main() {
+	app := &app{
+		configPath: os.Getenv("BAMBU_CONFIG"),
+		printer:    os.Getenv("BAMBU_PRINTER"),
+		stdout:     os.Stdout,
+		stderr:     os.Stderr,
+	}
+
+	os.Exit(app.main(os.Args[1:]))
+}
+
+func (app *app) main(args []string) int {
+	flags := app.flags("bambu")
+	flags.Usage = app.usage
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	if flags.NArg() == 0 {
+		app.usage()
+		return 2
+	}
+
+	name := flags.Arg(0)
+	cmd, ok := commands[name]
+	if !ok {
+		fmt.Fprintf(app.stderr, "bambu: unknown command %q\n", name)
+		app.usage()
+		return 2
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	err := cmd.run(app, ctx, flags.Args()[1:])
+	switch {
+	case err == nil:
+		return 0
+	case errors.Is(err, flag.ErrHelp):
+		return 0
+	case errors.Is(err, errUsage):
+		fmt.Fprintf(app.stderr, "usage: bambu %s %s\n", cmd.name, cmd.usage)
+		return 2
+	default:
+		fmt.Fprintf(app.stderr, "bambu: %v\n", err)
+		return 1
+	}
+}
+
+// context applies the -timeout option, or the default timeout for commands
+// that are not streaming.
+func (app *app) context(ctx context.Context, streaming bool) (context.Context, context.CancelFunc) {
+	timeout := app.timeout
+	if timeout == 0 && !streaming {
+		timeout = defaultTimeout
+	}
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// parse parses the flags of a command, returning the positional arguments.
+// It fails with errUsage if their number is out of range.
+func (app *app) parse(flags *flag.FlagSet, args []string, minArgs, maxArgs int) ([]string, error) {
+	if err := flags.Parse(args); err != nil {
+		return nil, err
+	}
+
+	if flags.NArg() < minArgs || (maxArgs >= 0 && flags.NArg() > maxArgs) {
+		return nil, errUsage
+	}
+
+	return flags.Args(), nil
+}
+
+func (app *app) usage() {
+	fmt.Fprintln(app.stderr, "usage: bambu [-config file] [-printer name] [-json] [-timeout d] <command> [arguments]")
+	fmt.Fprintln(app.stderr)
+	fmt.Fprintln(app.stderr, "commands:")
+
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		fmt.Fprintf(app.stderr, "  %-10s %s\n", name, commands[name].summary)
+	}
+}
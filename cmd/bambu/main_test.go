@@ -0,0 +1,255 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/RobertMNewton/bambu-golang-api/pkg/bambutest"
+	"github.com/RobertMNewton/bambu-golang-api/pkg/ftp"
+	"github.com/RobertMNewton/bambu-golang-api/pkg/state"
+	"github.com/RobertMNewton/bambu-golang-api/pkg/types/config"
+)
+
+// newSimulator starts a simulated printer and writes a profile file for it.
+func newSimulator(t *testing.T) (*bambutest.Simulator, string) {
+	t.Helper()
+
+	options := bambutest.DefaultSimulatorOptions()
+	options.TimeScale = 600
+	options.TickInterval = 10 * time.Millisecond
+
+	sim, err := bambutest.NewSimulator(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { sim.Close() })
+
+	dir := t.TempDir()
+	caPath := filepath.Join(dir, "ca.pem")
+	if err := os.WriteFile(caPath, sim.CACertPEM(), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := sim.Config()
+	data, err := json.Marshal(config.Profiles{
+		Printers: map[string]config.Profile{
+			"sim": {
+				Serial:     cfg.GetDeviceID(),
+				Host:       cfg.GetDeviceIPAddress(),
+				AccessCode: cfg.GetDeviceAccessCode(),
+				CACert:     caPath,
+				MQTTPort:   cfg.GetPort(config.ServiceMQTT),
+				FTPPort:    cfg.GetPort(config.ServiceFTP),
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(dir, "config.json")
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	return sim, path
+}
+
+// run runs the command line and returns the exit code and output.
+func run(configPath string, args ...string) (int, string, string) {
+	var stdout, stderr bytes.Buffer
+	app := &app{configPath: configPath, timeout: 10 * time.Second, stdout: &stdout, stderr: &stderr}
+
+	code := app.main(args)
+	return code, stdout.String(), stderr.String()
+}
+
+func TestExitCodes(t *testing.T) {
+	missing := filepath.Join(t.TempDir(), "missing.json")
+
+	tests := []struct {
+		name       string
+		args       []string
+		wantCode   int
+		wantStderr string
+	}{
+		{name: "no command", args: nil, wantCode: 2, wantStderr: "usage: bambu"},
+		{name: "unknown command", args: []string{"explode"}, wantCode: 2, wantStderr: `unknown command "explode"`},
+		{name: "unknown flag", args: []string{"-bogus", "status"}, wantCode: 2},
+		{name: "help", args: []string{"status", "-h"}, wantCode: 0},
+		{name: "too many arguments", args: []string{"status", "extra"}, wantCode: 2, wantStderr: "usage: bambu status"},
+		{name: "missing gcode", args: []string{"gcode"}, wantCode: 2, wantStderr: "usage: bambu gcode <line>..."},
+		{name: "invalid light mode", args: []string{"light", "dim"}, wantCode: 2},
+		{name: "missing files action", args: []string{"files"}, wantCode: 2},
+		{name: "missing profile file", args: []string{"status"}, wantCode: 1, wantStderr: "failed to read profiles"},
+		{name: "invalid speed", args: []string{"speed", "warp"}, wantCode: 1, wantStderr: `invalid speed "warp"`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			code, _, stderr := run(missing, test.args...)
+			if code != test.wantCode {
+				t.Errorf("exit code = %d, want %d; stderr: %s", code, test.wantCode, stderr)
+			}
+			if !strings.Contains(stderr, test.wantStderr) {
+				t.Errorf("stderr = %q, want %q", stderr, test.wantStderr)
+			}
+		})
+	}
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		args    []string
+		minArgs int
+		maxArgs int
+		wantErr error
+	}{
+		{args: nil, minArgs: 0, maxArgs: 0},
+		{args: []string{"a"}, minArgs: 0, maxArgs: 0, wantErr: errUsage},
+		{args: nil, minArgs: 1, maxArgs: 1, wantErr: errUsage},
+		{args: []string{"a", "b"}, minArgs: 1, maxArgs: 2},
+		{args: []string{"a", "b", "c"}, minArgs: 1, maxArgs: 2, wantErr: errUsage},
+		{args: []string{"a", "b", "c"}, minArgs: 1, maxArgs: -1},
+		{args: []string{"-json", "a"}, minArgs: 1, maxArgs: 1},
+	}
+
+	for _, test := range tests {
+		app := &app{stdout: &bytes.Buffer{}, stderr: &bytes.Buffer{}}
+
+		args, err := app.parse(app.flags("test"), test.args, test.minArgs, test.maxArgs)
+		if !errors.Is(err, test.wantErr) {
+			t.Errorf("parse(%q, %d, %d) = %v, want %v", test.args, test.minArgs, test.maxArgs, err, test.wantErr)
+			continue
+		}
+		if err == nil && len(args) < test.minArgs {
+			t.Errorf("parse(%q) = %q", test.args, args)
+		}
+	}
+
+	// Global flags are accepted after the command name
+	app := &app{stdout: &bytes.Buffer{}, stderr: &bytes.Buffer{}}
+	if _, err := app.parse(app.flags("test"), []string{"-json", "-printer", "sim"}, 0, 0); err != nil || !app.json || app.printer != "sim" {
+		t.Errorf("global flags not parsed: %v, json %v, printer %q", err, app.json, app.printer)
+	}
+}
+
+func TestStatus(t *testing.T) {
+	_, path := newSimulator(t)
+
+	code, stdout, stderr := run(path, "status")
+	if code != 0 {
+		t.Fatalf("exit code %d: %s", code, stderr)
+	}
+	if !strings.Contains(stdout, "State:") || !strings.Contains(stdout, "IDLE") {
+		t.Errorf("stdout = %q", stdout)
+	}
+
+	code, stdout, stderr = run(path, "-json", "status")
+	if code != 0 {
+		t.Fatalf("exit code %d: %s", code, stderr)
+	}
+
+	var s state.PrinterState
+	if err := json.Unmarshal([]byte(stdout), &s); err != nil {
+		t.Fatalf("invalid JSON %q: %v", stdout, err)
+	}
+	if s.Progress.GCodeState != state.GCodeStateIdle {
+		t.Errorf("gcode_state = %q", s.Progress.GCodeState)
+	}
+}
+
+func TestFilesList(t *testing.T) {
+	sim, path := newSimulator(t)
+	sim.WriteFile("/cache/cube.gcode", []byte("G28"))
+
+	code, stdout, stderr := run(path, "files", "ls", "/cache")
+	if code != 0 {
+		t.Fatalf("exit code %d: %s", code, stderr)
+	}
+	if !strings.Contains(stdout, "cube.gcode") {
+		t.Errorf("stdout = %q", stdout)
+	}
+
+	code, stdout, stderr = run(path, "files", "ls", "-json", "/cache")
+	if code != 0 {
+		t.Fatalf("exit code %d: %s", code, stderr)
+	}
+
+	var files []ftp.FileInfo
+	if err := json.Unmarshal([]byte(stdout), &files); err != nil {
+		t.Fatalf("invalid JSON %q: %v", stdout, err)
+	}
+	if len(files) != 1 || files[0].Name != "cube.gcode" || files[0].Size != 3 {
+		t.Errorf("files = %+v", files)
+	}
+
+	if code, _, _ := run(path, "files", "ls", "/missing"); code != 1 {
+		t.Errorf("listing a missing directory exited with %d", code)
+	}
+}
+
+func TestPrint(t *testing.T) {
+	sim, path := newSimulator(t)
+
+	project := filepath.Join(t.TempDir(), "cube.3mf")
+	if err := os.WriteFile(project, []byte("project"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	code, stdout, stderr := run(path, "-json", "print", "-plate", "2", project)
+	if code != 0 {
+		t.Fatalf("exit code %d: %s", code, stderr)
+	}
+
+	var s state.PrinterState
+	if err := json.Unmarshal([]byte(stdout), &s); err != nil {
+		t.Fatalf("invalid JSON %q: %v", stdout, err)
+	}
+	if s.Progress.SubtaskName != "cube" {
+		t.Errorf("subtask = %q", s.Progress.SubtaskName)
+	}
+
+	if data, ok := sim.ReadFile("/cube.3mf"); !ok || string(data) != "project" {
+		t.Errorf("uploaded file = %q", data)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	req, err := sim.WaitForRequest(ctx, "project_file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if req.Payload.Params["param"] != "Metadata/plate_2.gcode" {
+		t.Errorf("param = %v", req.Payload.Params["param"])
+	}
+}
+
+func TestSpeedAndLight(t *testing.T) {
+	sim, path := newSimulator(t)
+
+	if code, _, stderr := run(path, "speed", "sport"); code != 0 {
+		t.Fatalf("speed exited with %d: %s", code, stderr)
+	}
+	if code, _, stderr := run(path, "light", "off"); code != 0 {
+		t.Fatalf("light exited with %d: %s", code, stderr)
+	}
+
+	status := sim.Status()
+	if status["spd_lvl"] != int(state.SpeedSport) {
+		t.Errorf("spd_lvl = %v", status["spd_lvl"])
+	}
+	for _, item := range status["lights_report"].([]interface{}) {
+		if light := item.(map[string]interface{}); light["node"] == "chamber_light" && light["mode"] != "off" {
+			t.Errorf("chamber light = %v", light["mode"])
+		}
+	}
+}
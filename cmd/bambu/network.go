@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"encoding/pem"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/RobertMNewton/bambu-golang-api/pkg/discovery"
+	"github.com/RobertMNewton/bambu-golang-api/pkg/types/config"
+	"github.com/RobertMNewton/bambu-golang-api/pkg/utils"
+)
+
+func init() {
+	register(&command{name: "discover", usage: "[-listen :2021] [-for 5s]", summary: "find printers on the local network", run: runDiscover})
+	register(&command{name: "cert", usage: "fetch [-host host] [-port 8883] [-o file] | pin | unpin", summary: "fetch or pin the printer's certificate", run: runCert})
+}
+
+func runDiscover(app *app, ctx context.Context, args []string) error {
+	flags := app.flags("discover")
+	listen := flags.String("listen", "", "UDP address to listen on")
+	duration := flags.Duration("for", discovery.DefaultScanDuration, "how long to listen")
+
+	if _, err := app.parse(flags, args, 0, 0); err != nil {
+		return err
+	}
+
+	printers, err := discovery.ScanWithOptions(ctx, discovery.Options{Address: *listen}, *duration)
+	if err != nil {
+		return err
+	}
+
+	if app.json {
+		if printers == nil {
+			printers = []discovery.DiscoveredPrinter{}
+		}
+		return app.printJSON(printers)
+	}
+
+	w := app.table()
+	fmt.Fprintln(w, "SERIAL\tHOST\tMODEL\tNAME\tMODE\tFIRMWARE")
+	for _, printer := range printers {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", printer.Serial, printer.IPAddress, printer.ModelName(), printer.Name, printer.Connection, printer.Version)
+	}
+	return w.Flush()
+}
+
+type certInfo struct {
+	Subject     string    `json:"subject"`
+	Issuer      string    `json:"issuer"`
+	NotAfter    time.Time `json:"not_after"`
+	Fingerprint string    `json:"sha256"`
+	PEM         string    `json:"pem"`
+}
+
+func runCert(app *app, ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		return errUsage
+	}
+
+	switch args[0] {
+	case "fetch":
+		return runCertFetch(app, args[1:])
+	case "pin", "unpin":
+		return runCertPin(app, args[0], args[1:])
+	default:
+		return errUsage
+	}
+}
+
+// runCertPin replaces or forgets the pinned certificate of the selected
+// printer, e.g. after a factory reset.
+func runCertPin(app *app, action string, args []string) error {
+	if _, err := app.parse(app.flags("cert"), args, 0, 0); err != nil {
+		return err
+	}
+
+	p, err := app.profile()
+	if err != nil {
+		return err
+	}
+
+	store, err := app.pinStore()
+	if err != nil {
+		return err
+	}
+
+	if action == "unpin" {
+		return store.Unpin(p.Serial)
+	}
+
+	port := p.MQTTPort
+	if port == 0 {
+		port = config.DefaultPort(config.ServiceMQTT)
+	}
+
+	cert, err := utils.GetPrinterCert(p.Host, strconv.Itoa(port))
+	if err != nil {
+		return err
+	}
+
+	if err := store.Repin(p.Serial, cert); err != nil {
+		return err
+	}
+
+	if app.json {
+		return app.printJSON(map[string]string{"serial": p.Serial, "sha256": config.Fingerprint(cert)})
+	}
+	fmt.Fprintf(app.stdout, "pinned %s sha256 %s\n", p.Serial, config.Fingerprint(cert))
+	return nil
+}
+
+func runCertFetch(app *app, args []string) error {
+	flags := app.flags("cert")
+	host := flags.String("host", "", "printer address, defaults to the profile's host")
+	port := flags.Int("port", config.DefaultPort(config.ServiceMQTT), "TLS port")
+	output := flags.String("o", "", "file to write the PEM certificate to")
+
+	if _, err := app.parse(flags, args, 0, 0); err != nil {
+		return err
+	}
+
+	if *host == "" {
+		p, err := app.profile()
+		if err != nil {
+			return fmt.Errorf("no -host given: %w", err)
+		}
+		*host = p.Host
+	}
+
+	cert, err := utils.GetPrinterCert(*host, strconv.Itoa(*port))
+	if err != nil {
+		return err
+	}
+
+	if *output != "" {
+		if err := utils.SaveCertToPEM(cert, *output); err != nil {
+			return err
+		}
+	}
+
+	info := certInfo{
+		Subject:     cert.Subject.String(),
+		Issuer:      cert.Issuer.String(),
+		NotAfter:    cert.NotAfter,
+		Fingerprint: config.Fingerprint(cert),
+		PEM:         string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})),
+	}
+
+	if app.json {
+		return app.printJSON(info)
+	}
+
+	if *output == "" {
+		_, err := fmt.Fprint(app.stdout, info.PEM)
+		return err
+	}
+
+	fmt.Fprintf(app.stdout, "%s\nissuer %s, expires %s\nsha256 %s\n", info.Subject, info.Issuer, info.NotAfter.Format(time.DateOnly), info.Fingerprint)
+	return nil
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/RobertMNewton/bambu-golang-api/pkg/hms"
+	"github.com/RobertMNewton/bambu-golang-api/pkg/state"
+)
+
+func (app *app) printJSON(v interface{}) error {
+	encoder := json.NewEncoder(app.stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(v)
+}
+
+// printJSONLine prints a value on a single line, for streams.
+func (app *app) printJSONLine(v interface{}) error {
+	return json.NewEncoder(app.stdout).Encode(v)
+}
+
+func (app *app) table() *tabwriter.Writer {
+	return tabwriter.NewWriter(app.stdout, 0, 4, 2, ' ', 0)
+}
+
+func (app *app) printState(s state.PrinterState) error {
+	if app.json {
+		return app.printJSON(s)
+	}
+
+	w := app.table()
+
+	fmt.Fprintf(w, "State:\t%s\n", describeProgress(s))
+	if s.Stage.Current > 0 {
+		fmt.Fprintf(w, "Stage:\t%s\n", s.Stage.Name())
+	}
+	fmt.Fprintf(w, "Nozzle:\t%.1f / %.0f °C\n", s.Temperatures.Nozzle, s.Temperatures.NozzleTarget)
+	fmt.Fprintf(w, "Bed:\t%.1f / %.0f °C\n", s.Temperatures.Bed, s.Temperatures.BedTarget)
+	fmt.Fprintf(w, "Chamber:\t%.1f °C\n", s.Temperatures.Chamber)
+	fmt.Fprintf(w, "Fans:\tpart %d%%, aux %d%%, chamber %d%%\n", s.Fans.PartCooling, s.Fans.Auxiliary, s.Fans.Chamber)
+	fmt.Fprintf(w, "Speed:\t%s (%d%%)\n", s.Speed.Level, s.Speed.Magnitude)
+
+	for _, light := range s.Lights {
+		fmt.Fprintf(w, "Light:\t%s %s\n", light.Node, light.Mode)
+	}
+
+	if tray, ok := s.AMS.ActiveTray(); ok {
+		fmt.Fprintf(w, "Filament:\t%s\n", describeTray(s.AMS.TrayNow, tray))
+	}
+
+	if s.Progress.PrintError != 0 {
+		fmt.Fprintf(w, "Error:\t%s\n", describePrintError(s.Progress.PrintError))
+	}
+	for _, e := range s.HMS {
+		fmt.Fprintf(w, "HMS:\t%v\n", hms.Decode(e.Attr, e.Code))
+	}
+
+	fmt.Fprintf(w, "Wi-Fi:\t%s\n", s.WifiSignal)
+
+	return w.Flush()
+}
+
+func describeProgress(s state.PrinterState) string {
+	progress := s.Progress
+
+	switch progress.GCodeState {
+	case state.GCodeStateRunning, state.GCodeStatePause, state.GCodeStatePrepare:
+		return fmt.Sprintf("%s %s: %d%%, layer %d/%d, %s left",
+			progress.GCodeState, progress.SubtaskName, progress.Percent,
+			progress.Layer, progress.TotalLayers, formatMinutes(progress.RemainingMinutes))
+	case state.GCodeStateFinish, state.GCodeStateFailed:
+		return fmt.Sprintf("%s %s", progress.GCodeState, progress.SubtaskName)
+	default:
+		return string(progress.GCodeState)
+	}
+}
+
+func describeTray(id string, tray state.Tray) string {
+	if tray.Empty() {
+		return fmt.Sprintf("tray %s empty", id)
+	}
+
+	parts := []string{fmt.Sprintf("tray %s", id), tray.Type}
+	if tray.SubBrand != "" && tray.SubBrand != tray.Type {
+		parts = append(parts, "("+tray.SubBrand+")")
+	}
+	parts = append(parts, "#"+tray.Color)
+	if tray.Remain >= 0 {
+		parts = append(parts, fmt.Sprintf("%d%%", tray.Remain))
+	}
+	if tray.NozzleTempMax > 0 {
+		parts = append(parts, fmt.Sprintf("%d-%d °C", tray.NozzleTempMin, tray.NozzleTempMax))
+	}
+
+	return strings.Join(parts, " ")
+}
+
+func describePrintError(code int) string {
+	if message, ok := hms.DefaultCatalogue().PrintErrorMessage(code); ok {
+		return fmt.Sprintf("%s: %s", hms.FormatPrintError(code), message)
+	}
+	return hms.FormatPrintError(code)
+}
+
+func formatMinutes(minutes int) string {
+	if minutes < 60 {
+		return fmt.Sprintf("%dm", minutes)
+	}
+	return fmt.Sprintf("%dh%02dm", minutes/60, minutes%60)
+}
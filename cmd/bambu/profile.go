@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/RobertMNewton/bambu-golang-api/pkg/printer"
+	"github.com/RobertMNewton/bambu-golang-api/pkg/types/config"
+)
+
+// profile loads the printer selected with -printer, or the default printer
+// of the profile file.
+func (app *app) profile() (config.Profile, error) {
+	path := app.configPath
+	if path == "" {
+		var err error
+		if path, err = config.DefaultProfilePath(); err != nil {
+			return config.Profile{}, err
+		}
+	}
+
+	profiles, err := config.LoadProfiles(path)
+	if err != nil {
+		return config.Profile{}, err
+	}
+
+	return profiles.Get(app.printer)
+}
+
+// printerConfig returns the config of the selected printer. Printers without
+// a CA certificate are pinned on first use.
+func (app *app) printerConfig() (config.PrinterConfig, error) {
+	p, err := app.profile()
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := p.Config()
+
+	pinned, ok := cfg.(interface{ SetPinStore(*config.PinStore) })
+	if ok && p.CACert == "" {
+		store, err := app.pinStore()
+		if err != nil {
+			return nil, err
+		}
+		pinned.SetPinStore(store)
+	}
+
+	return cfg, nil
+}
+
+func (app *app) pinStore() (*config.PinStore, error) {
+	path, err := config.DefaultPinStorePath()
+	if err != nil {
+		return nil, err
+	}
+	return config.LoadPinStore(path)
+}
+
+// connect connects to the selected printer. The caller must disconnect it.
+func (app *app) connect(ctx context.Context) (*printer.Printer, error) {
+	cfg, err := app.printerConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	p := printer.NewPrinter(cfg)
+	if err := p.Connect(ctx); err != nil {
+		return nil, fmt.Errorf("failed to connect to %s: %w", cfg.GetDeviceID(), err)
+	}
+
+	return p, nil
+}
@@ -0,0 +1,62 @@
+// Command bambusim runs the bambutest printer simulator on localhost so that
+// clients can be developed without a printer.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/RobertMNewton/bambu-golang-api/pkg/bambutest"
+	"github.com/RobertMNewton/bambu-golang-api/pkg/types/config"
+)
+
+const announceInterval = 5 * time.Second
+
+func main() {
+	options := bambutest.DefaultSimulatorOptions()
+
+	flag.StringVar(&options.Server.DeviceID, "serial", bambutest.DefaultDeviceID, "serial number of the simulated printer")
+	flag.StringVar(&options.Server.AccessCode, "access-code", bambutest.DefaultAccessCode, "LAN access code")
+	flag.Float64Var(&options.TimeScale, "timescale", options.TimeScale, "simulated seconds per real second")
+	flag.DurationVar(&options.JobDuration, "job-duration", options.JobDuration, "simulated duration of a print")
+	flag.IntVar(&options.Layers, "layers", options.Layers, "layers per print")
+	caPath := flag.String("ca", "bambusim-ca.pem", "file to write the CA certificate to")
+	announce := flag.String("announce", "", "address to send SSDP announcements to, e.g. 127.0.0.1:2021")
+	flag.Parse()
+
+	sim, err := bambutest.NewSimulator(options)
+	if err != nil {
+		log.Fatalf("failed to start simulator: %v", err)
+	}
+	defer sim.Close()
+
+	if err := os.WriteFile(*caPath, sim.CACertPEM(), 0644); err != nil {
+		log.Fatalf("failed to write CA certificate: %v", err)
+	}
+
+	cfg := sim.Config()
+	fmt.Printf("serial:      %s\n", sim.DeviceID())
+	fmt.Printf("access code: %s\n", cfg.GetDeviceAccessCode())
+	fmt.Printf("mqtt:        %s\n", cfg.GetBrokerUrl())
+	fmt.Printf("ftps:        127.0.0.1:%d\n", cfg.GetPort(config.ServiceFTP))
+	fmt.Printf("ca:          %s\n", *caPath)
+
+	if *announce != "" {
+		go func() {
+			for ; ; time.Sleep(announceInterval) {
+				if err := sim.Announce(*announce); err != nil {
+					log.Printf("failed to announce: %v", err)
+				}
+			}
+		}()
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	<-signals
+}
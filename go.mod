@@ -2,7 +2,11 @@ module github.com/RobertMNewton/bambu-golang-api
 
 go 1.23.4
 
-require github.com/eclipse/paho.mqtt.golang v1.5.0
+require (
+	github.com/BurntSushi/toml v1.4.0
+	github.com/eclipse/paho.mqtt.golang v1.5.0
+	gopkg.in/yaml.v3 v3.0.1
+)
 
 require (
 	github.com/hashicorp/errwrap v1.0.0 // indirect
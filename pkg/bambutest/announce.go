@@ -0,0 +1,43 @@
+package bambutest
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// Announce sends the SSDP NOTIFY message a printer broadcasts on the LAN to
+// address, e.g. 127.0.0.1:2021.
+func (server *Server) Announce(address string) error {
+	conn, err := net.Dial("udp", address)
+	if err != nil {
+		return fmt.Errorf("failed to dial %s: %w", address, err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.Write(server.notifyMessage()); err != nil {
+		return fmt.Errorf("failed to send announcement: %w", err)
+	}
+	return nil
+}
+
+func (server *Server) notifyMessage() []byte {
+	lines := []string{
+		"NOTIFY * HTTP/1.1",
+		"HOST: 239.255.255.250:1990",
+		"Server: UPnP/1.0",
+		"Location: 127.0.0.1",
+		"NT: urn:bambulab-com:device:3dprinter:1",
+		"USN: " + server.deviceID,
+		"Cache-Control: max-age=1800",
+		"DevModel.bambu.com: C12",
+		"DevName.bambu.com: bambutest",
+		"DevSignal.bambu.com: -40",
+		"DevConnect.bambu.com: lan",
+		"DevBind.bambu.com: free",
+		"Devseclink.bambu.com: secure",
+		"DevVersion.bambu.com: 01.07.00.00",
+		"DevCap.bambu.com: 1",
+	}
+	return []byte(strings.Join(lines, "\r\n") + "\r\n\r\n")
+}
@@ -0,0 +1,177 @@
+package bambutest
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// DefaultCameraInterval is the time between frames of the camera stand-in,
+// P1 series printers send about two frames per second.
+const DefaultCameraInterval = 500 * time.Millisecond
+
+// cameraServer replays JPEG frames like the port 6000 camera stream of P1
+// and A1 printers: after an 80 byte auth packet it sends every frame with a
+// 16 byte header. Wrong credentials close the connection without a reply.
+type cameraServer struct {
+	listener net.Listener
+	username string
+	password string
+
+	mu       sync.Mutex
+	frames   [][]byte
+	interval time.Duration
+	conns    map[net.Conn]struct{}
+	wg       sync.WaitGroup
+	done     chan struct{}
+}
+
+func newCameraServer(tlsConfig *tls.Config, username, password string, frames [][]byte, interval time.Duration) (*cameraServer, error) {
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", tlsConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen: %w", err)
+	}
+
+	server := &cameraServer{
+		listener: listener,
+		username: username,
+		password: password,
+		frames:   frames,
+		interval: interval,
+		conns:    make(map[net.Conn]struct{}),
+		done:     make(chan struct{}),
+	}
+
+	server.wg.Add(1)
+	go server.serve()
+
+	return server, nil
+}
+
+func (server *cameraServer) port() int {
+	return server.listener.Addr().(*net.TCPAddr).Port
+}
+
+func (server *cameraServer) close() {
+	server.listener.Close()
+	close(server.done)
+
+	server.mu.Lock()
+	for conn := range server.conns {
+		conn.Close()
+	}
+	server.mu.Unlock()
+
+	server.wg.Wait()
+}
+
+func (server *cameraServer) setFrames(frames [][]byte, interval time.Duration) {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+
+	server.frames = frames
+	server.interval = interval
+}
+
+func (server *cameraServer) clients() int {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+
+	return len(server.conns)
+}
+
+func (server *cameraServer) serve() {
+	defer server.wg.Done()
+
+	for {
+		conn, err := server.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		server.mu.Lock()
+		server.conns[conn] = struct{}{}
+		server.mu.Unlock()
+
+		server.wg.Add(1)
+		go func() {
+			defer server.wg.Done()
+			defer func() {
+				server.mu.Lock()
+				delete(server.conns, conn)
+				server.mu.Unlock()
+			}()
+			defer conn.Close()
+
+			server.handle(conn)
+		}()
+	}
+}
+
+func (server *cameraServer) handle(conn net.Conn) {
+	auth := make([]byte, 80)
+	if _, err := io.ReadFull(conn, auth); err != nil {
+		return
+	}
+
+	username := string(bytes.TrimRight(auth[16:48], "\x00"))
+	password := string(bytes.TrimRight(auth[48:80], "\x00"))
+	if binary.LittleEndian.Uint32(auth[0:4]) != 0x40 || username != server.username || password != server.password {
+		return
+	}
+
+	for i := 0; ; i++ {
+		server.mu.Lock()
+		frames := server.frames
+		interval := server.interval
+		server.mu.Unlock()
+
+		if len(frames) > 0 {
+			frame := frames[i%len(frames)]
+
+			header := make([]byte, 16)
+			binary.LittleEndian.PutUint32(header[0:4], uint32(len(frame)))
+			binary.LittleEndian.PutUint32(header[8:12], 1)
+
+			if _, err := conn.Write(append(header, frame...)); err != nil {
+				return
+			}
+		}
+
+		select {
+		case <-time.After(interval):
+		case <-server.done:
+			return
+		}
+	}
+}
+
+// DefaultCameraFrames returns a few small JPEG images of different colours.
+func DefaultCameraFrames() [][]byte {
+	colors := []color.RGBA{
+		{R: 0x20, G: 0x20, B: 0x20, A: 0xFF},
+		{R: 0x00, G: 0xAE, B: 0x42, A: 0xFF},
+		{R: 0xF0, G: 0xF0, B: 0xF0, A: 0xFF},
+	}
+
+	frames := make([][]byte, 0, len(colors))
+	for _, c := range colors {
+		img := image.NewRGBA(image.Rect(0, 0, 64, 48))
+		for i := 0; i < len(img.Pix); i += 4 {
+			img.Pix[i], img.Pix[i+1], img.Pix[i+2], img.Pix[i+3] = c.R, c.G, c.B, c.A
+		}
+
+		var buf bytes.Buffer
+		jpeg.Encode(&buf, img, nil)
+		frames = append(frames, buf.Bytes())
+	}
+	return frames
+}
@@ -0,0 +1,437 @@
+package bambutest
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	bambuhttp "github.com/RobertMNewton/bambu-golang-api/pkg/http"
+)
+
+const (
+	DefaultAccount          = "maker@example.com"
+	DefaultPassword         = "password"
+	DefaultUID              = 1234567890
+	DefaultVerificationCode = "123456"
+	DefaultTFACode          = "654321"
+)
+
+type CloudOptions struct {
+	Account  string
+	Password string
+	UID      int64
+
+	// RequireCode makes password logins ask for an emailed verification
+	// code, RequireTFA for a two-factor code
+	RequireCode bool
+	RequireTFA  bool
+
+	VerificationCode string
+	TFACode          string
+
+	// TokenLifetime is the lifetime of access tokens, refresh tokens live
+	// ten times as long
+	TokenLifetime time.Duration
+
+	Devices  []bambuhttp.Device
+	Tasks    []bambuhttp.Task
+	Projects []bambuhttp.ProjectDetail
+}
+
+func DefaultCloudOptions() CloudOptions {
+	return CloudOptions{
+		Account:          DefaultAccount,
+		Password:         DefaultPassword,
+		UID:              DefaultUID,
+		VerificationCode: DefaultVerificationCode,
+		TFACode:          DefaultTFACode,
+		TokenLifetime:    90 * 24 * time.Hour,
+		Devices: []bambuhttp.Device{{
+			Serial:         DefaultDeviceID,
+			Name:           "bambutest",
+			Online:         true,
+			PrintStatus:    "ACTIVE",
+			Model:          "C12",
+			ProductName:    "P1S",
+			AccessCode:     DefaultAccessCode,
+			NozzleDiameter: 0.4,
+		}},
+	}
+}
+
+// CloudServer is a stand-in for the Bambu Lab cloud API. It serves the
+// login, device, profile, task and project endpoints used by pkg/http.
+type CloudServer struct {
+	options CloudOptions
+	server  *httptest.Server
+
+	mu            sync.Mutex
+	tokens        map[string]time.Time
+	refreshTokens map[string]time.Time
+	tfaKeys       map[string]bool
+	codeSent      bool
+}
+
+func NewCloudServer() *CloudServer {
+	return NewCloudServerWithOptions(DefaultCloudOptions())
+}
+
+func NewCloudServerWithOptions(options CloudOptions) *CloudServer {
+	defaults := DefaultCloudOptions()
+	if options.Account == "" {
+		options.Account = defaults.Account
+	}
+	if options.Password == "" {
+		options.Password = defaults.Password
+	}
+	if options.UID == 0 {
+		options.UID = defaults.UID
+	}
+	if options.VerificationCode == "" {
+		options.VerificationCode = defaults.VerificationCode
+	}
+	if options.TFACode == "" {
+		options.TFACode = defaults.TFACode
+	}
+	if options.TokenLifetime == 0 {
+		options.TokenLifetime = defaults.TokenLifetime
+	}
+
+	cloud := &CloudServer{
+		options:       options,
+		tokens:        make(map[string]time.Time),
+		refreshTokens: make(map[string]time.Time),
+		tfaKeys:       make(map[string]bool),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /v1/user-service/user/login", cloud.handleLogin)
+	mux.HandleFunc("POST /v1/user-service/user/sendemail/code", cloud.handleSendCode)
+	mux.HandleFunc("POST /v1/user-service/user/refreshtoken", cloud.handleRefresh)
+	mux.HandleFunc("POST /api/sign-in/tfa", cloud.handleTFA)
+	mux.HandleFunc("GET /v1/iot-service/api/user/bind", cloud.authenticated(cloud.handleDevices))
+	mux.HandleFunc("GET /v1/user-service/my/profile", cloud.authenticated(cloud.handleProfile))
+	mux.HandleFunc("GET /v1/user-service/my/tasks", cloud.authenticated(cloud.handleTasks))
+	mux.HandleFunc("GET /v1/iot-service/api/user/project", cloud.authenticated(cloud.handleProjects))
+	mux.HandleFunc("GET /v1/iot-service/api/user/project/{id}", cloud.authenticated(cloud.handleProject))
+
+	cloud.server = httptest.NewServer(mux)
+	return cloud
+}
+
+// URL returns the base URL of the API, for both Options.BaseURL and
+// Options.TFAURL.
+func (cloud *CloudServer) URL() string {
+	return cloud.server.URL
+}
+
+// Client returns a client pointed at the server.
+func (cloud *CloudServer) Client() *bambuhttp.Client {
+	return bambuhttp.NewClientWithOptions(bambuhttp.Options{
+		BaseURL:    cloud.server.URL,
+		TFAURL:     cloud.server.URL,
+		HTTPClient: cloud.server.Client(),
+	})
+}
+
+func (cloud *CloudServer) Close() {
+	cloud.server.Close()
+}
+
+// RevokeTokens invalidates all access tokens, refresh tokens keep working.
+func (cloud *CloudServer) RevokeTokens() {
+	cloud.mu.Lock()
+	defer cloud.mu.Unlock()
+
+	cloud.tokens = make(map[string]time.Time)
+}
+
+// SetDevices replaces the devices bound to the account.
+func (cloud *CloudServer) SetDevices(devices []bambuhttp.Device) {
+	cloud.mu.Lock()
+	defer cloud.mu.Unlock()
+
+	cloud.options.Devices = devices
+}
+
+// AcceptCloudLogins makes the printer's broker also accept the account's
+// MQTT username with a valid access token, standing in for the cloud broker.
+func (server *Server) AcceptCloudLogins(cloud *CloudServer) {
+	username := "u_" + strconv.FormatInt(cloud.options.UID, 10)
+
+	server.broker.mu.Lock()
+	defer server.broker.mu.Unlock()
+
+	server.broker.accept = func(user, password string) bool {
+		return user == username && cloud.validToken(password)
+	}
+}
+
+func (cloud *CloudServer) validToken(token string) bool {
+	cloud.mu.Lock()
+	defer cloud.mu.Unlock()
+
+	expires, ok := cloud.tokens[token]
+	return ok && time.Now().Before(expires)
+}
+
+func (cloud *CloudServer) handleLogin(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Account  string `json:"account"`
+		Password string `json:"password"`
+		Code     string `json:"code"`
+	}
+	if !decodeBody(w, r, &req) {
+		return
+	}
+
+	cloud.mu.Lock()
+	defer cloud.mu.Unlock()
+
+	if req.Account != cloud.options.Account {
+		writeCloudError(w, http.StatusBadRequest, 1, "Account does not exist")
+		return
+	}
+
+	if req.Code != "" {
+		if !cloud.codeSent || req.Code != cloud.options.VerificationCode {
+			writeCloudError(w, http.StatusBadRequest, 2, "Incorrect verification code")
+			return
+		}
+		cloud.codeSent = false
+		writeJSON(w, cloud.issueToken())
+		return
+	}
+
+	if req.Password != cloud.options.Password {
+		writeCloudError(w, http.StatusBadRequest, 3, "Incorrect password")
+		return
+	}
+
+	switch {
+	case cloud.options.RequireCode:
+		writeJSON(w, map[string]interface{}{"accessToken": "", "loginType": "verifyCode"})
+	case cloud.options.RequireTFA:
+		key := randomHex(16)
+		cloud.tfaKeys[key] = true
+		writeJSON(w, map[string]interface{}{"accessToken": "", "loginType": "tfa", "tfaKey": key})
+	default:
+		writeJSON(w, cloud.issueToken())
+	}
+}
+
+func (cloud *CloudServer) handleSendCode(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Email string `json:"email"`
+		Type  string `json:"type"`
+	}
+	if !decodeBody(w, r, &req) {
+		return
+	}
+
+	cloud.mu.Lock()
+	defer cloud.mu.Unlock()
+
+	if req.Email != cloud.options.Account || req.Type != "codeLogin" {
+		writeCloudError(w, http.StatusBadRequest, 1, "Account does not exist")
+		return
+	}
+
+	cloud.codeSent = true
+	writeJSON(w, map[string]interface{}{})
+}
+
+// handleTFA answers like the sign-in service, with the token in a cookie.
+func (cloud *CloudServer) handleTFA(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		TFAKey  string `json:"tfaKey"`
+		TFACode string `json:"tfaCode"`
+	}
+	if !decodeBody(w, r, &req) {
+		return
+	}
+
+	cloud.mu.Lock()
+	defer cloud.mu.Unlock()
+
+	if !cloud.tfaKeys[req.TFAKey] || req.TFACode != cloud.options.TFACode {
+		writeCloudError(w, http.StatusBadRequest, 4, "Incorrect two-factor code")
+		return
+	}
+	delete(cloud.tfaKeys, req.TFAKey)
+
+	token := cloud.issueToken()
+	http.SetCookie(w, &http.Cookie{
+		Name:    "token",
+		Value:   token["accessToken"].(string),
+		Path:    "/",
+		Expires: cloud.tokens[token["accessToken"].(string)],
+	})
+	http.SetCookie(w, &http.Cookie{
+		Name:  "refreshToken",
+		Value: token["refreshToken"].(string),
+		Path:  "/",
+	})
+	writeJSON(w, map[string]interface{}{})
+}
+
+func (cloud *CloudServer) handleRefresh(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		RefreshToken string `json:"refreshToken"`
+	}
+	if !decodeBody(w, r, &req) {
+		return
+	}
+
+	cloud.mu.Lock()
+	defer cloud.mu.Unlock()
+
+	expires, ok := cloud.refreshTokens[req.RefreshToken]
+	if !ok || time.Now().After(expires) {
+		writeCloudError(w, http.StatusUnauthorized, 401, "Invalid refresh token")
+		return
+	}
+	delete(cloud.refreshTokens, req.RefreshToken)
+
+	writeJSON(w, cloud.issueToken())
+}
+
+// issueToken creates an access and refresh token. The caller must hold mu.
+func (cloud *CloudServer) issueToken() map[string]interface{} {
+	access := randomHex(32)
+	refresh := randomHex(32)
+
+	lifetime := cloud.options.TokenLifetime
+	cloud.tokens[access] = time.Now().Add(lifetime)
+	cloud.refreshTokens[refresh] = time.Now().Add(10 * lifetime)
+
+	return map[string]interface{}{
+		"accessToken":      access,
+		"refreshToken":     refresh,
+		"expiresIn":        int64(lifetime / time.Second),
+		"refreshExpiresIn": int64(10 * lifetime / time.Second),
+		"loginType":        "",
+		"tfaKey":           "",
+	}
+}
+
+func (cloud *CloudServer) authenticated(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+
+		if !cloud.validToken(token) {
+			writeCloudError(w, http.StatusUnauthorized, 401, "Unauthorized")
+			return
+		}
+		handler(w, r)
+	}
+}
+
+func (cloud *CloudServer) handleDevices(w http.ResponseWriter, r *http.Request) {
+	cloud.mu.Lock()
+	devices := append([]bambuhttp.Device{}, cloud.options.Devices...)
+	cloud.mu.Unlock()
+
+	writeJSON(w, map[string]interface{}{
+		"message": "success",
+		"code":    nil,
+		"error":   nil,
+		"devices": devices,
+	})
+}
+
+func (cloud *CloudServer) handleProfile(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, bambuhttp.UserProfile{
+		UID:     cloud.options.UID,
+		Name:    "bambutest",
+		Account: cloud.options.Account,
+	})
+}
+
+func (cloud *CloudServer) handleTasks(w http.ResponseWriter, r *http.Request) {
+	deviceID := r.URL.Query().Get("deviceId")
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+
+	cloud.mu.Lock()
+	defer cloud.mu.Unlock()
+
+	hits := []bambuhttp.Task{}
+	for _, task := range cloud.options.Tasks {
+		if deviceID != "" && task.DeviceID != deviceID {
+			continue
+		}
+		hits = append(hits, task)
+	}
+	total := len(hits)
+	if limit > 0 && len(hits) > limit {
+		hits = hits[:limit]
+	}
+
+	writeJSON(w, map[string]interface{}{"total": total, "hits": hits})
+}
+
+func (cloud *CloudServer) handleProjects(w http.ResponseWriter, r *http.Request) {
+	cloud.mu.Lock()
+	defer cloud.mu.Unlock()
+
+	projects := []bambuhttp.Project{}
+	for _, project := range cloud.options.Projects {
+		projects = append(projects, project.Project)
+	}
+
+	writeJSON(w, map[string]interface{}{
+		"message":  "success",
+		"code":     nil,
+		"error":    nil,
+		"projects": projects,
+	})
+}
+
+func (cloud *CloudServer) handleProject(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+
+	cloud.mu.Lock()
+	defer cloud.mu.Unlock()
+
+	for _, project := range cloud.options.Projects {
+		if project.ID == id {
+			writeJSON(w, project)
+			return
+		}
+	}
+	writeCloudError(w, http.StatusNotFound, 404, "Project not found")
+}
+
+func decodeBody(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		writeCloudError(w, http.StatusBadRequest, 400, "Invalid request body")
+		return false
+	}
+	return true
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeCloudError(w http.ResponseWriter, status, code int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"code":  code,
+		"error": message,
+	})
+}
+
+func randomHex(n int) string {
+	buf := make([]byte, n)
+	rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
@@ -0,0 +1,634 @@
+package bambutest
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const dataTimeout = 10 * time.Second
+
+var errUploadDropped = errors.New("upload dropped")
+
+// ftpServer is a minimal implicit FTPS server backed by an in-memory file
+// system. Like the printer it only accepts TLS, and by default it refuses data
+// connections that do not resume the control connection's TLS session.
+type ftpServer struct {
+	listener  net.Listener
+	tlsConfig *tls.Config
+	username  string
+	password  string
+	files     *memFS
+
+	mu                  sync.Mutex
+	requireSessionReuse bool
+	dropUploadAfter     int64
+	conns               map[net.Conn]struct{}
+	wg                  sync.WaitGroup
+}
+
+func newFTPServer(tlsConfig *tls.Config, username, password string, files *memFS) (*ftpServer, error) {
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", tlsConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen: %w", err)
+	}
+
+	server := &ftpServer{
+		listener:            listener,
+		tlsConfig:           tlsConfig,
+		username:            username,
+		password:            password,
+		files:               files,
+		requireSessionReuse: true,
+		dropUploadAfter:     -1,
+		conns:               make(map[net.Conn]struct{}),
+	}
+
+	server.wg.Add(1)
+	go server.serve()
+
+	return server, nil
+}
+
+func (server *ftpServer) port() int {
+	return server.listener.Addr().(*net.TCPAddr).Port
+}
+
+func (server *ftpServer) close() {
+	server.listener.Close()
+
+	server.mu.Lock()
+	for conn := range server.conns {
+		conn.Close()
+	}
+	server.mu.Unlock()
+
+	server.wg.Wait()
+}
+
+func (server *ftpServer) setRequireSessionReuse(require bool) {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+
+	server.requireSessionReuse = require
+}
+
+func (server *ftpServer) dropNextUpload(afterBytes int64) {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+
+	server.dropUploadAfter = afterBytes
+}
+
+// takeDropUpload returns the byte count after which the current upload must
+// be dropped, or a negative number to let it complete.
+func (server *ftpServer) takeDropUpload() int64 {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+
+	after := server.dropUploadAfter
+	server.dropUploadAfter = -1
+	return after
+}
+
+func (server *ftpServer) serve() {
+	defer server.wg.Done()
+
+	for {
+		conn, err := server.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		server.track(conn, true)
+
+		server.wg.Add(1)
+		go func() {
+			defer server.wg.Done()
+			defer server.track(conn, false)
+
+			session := &ftpSession{
+				server: server,
+				conn:   conn,
+				reader: bufio.NewReader(conn),
+				cwd:    "/",
+			}
+			session.run()
+		}()
+	}
+}
+
+func (server *ftpServer) track(conn net.Conn, add bool) {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+
+	if add {
+		server.conns[conn] = struct{}{}
+	} else {
+		delete(server.conns, conn)
+	}
+}
+
+type ftpSession struct {
+	server *ftpServer
+	conn   net.Conn
+	reader *bufio.Reader
+
+	user          string
+	authenticated bool
+	cwd           string
+	restOffset    int64
+	renameFrom    string
+	passive       net.Listener
+}
+
+func (session *ftpSession) run() {
+	defer session.conn.Close()
+	defer session.closePassive()
+
+	session.reply(220, "bambutest FTP ready")
+
+	for {
+		line, err := session.reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+
+		command, arg, _ := strings.Cut(strings.TrimRight(line, "\r\n"), " ")
+		command = strings.ToUpper(command)
+
+		if !session.authenticated && command != "USER" && command != "PASS" && command != "QUIT" && command != "FEAT" {
+			session.reply(530, "Please login with USER and PASS")
+			continue
+		}
+
+		if command != "RETR" && command != "STOR" && command != "APPE" && command != "REST" {
+			session.restOffset = 0
+		}
+
+		if !session.handle(command, arg) {
+			return
+		}
+	}
+}
+
+func (session *ftpSession) handle(command, arg string) bool {
+	files := session.server.files
+
+	switch command {
+	case "USER":
+		session.user = arg
+		session.reply(331, "Please specify the password")
+	case "PASS":
+		if session.user != session.server.username || arg != session.server.password {
+			session.reply(530, "Login incorrect")
+			return true
+		}
+		session.authenticated = true
+		session.reply(230, "Login successful")
+	case "FEAT":
+		session.replyLines(211, []string{"Features:", " EPSV", " MDTM", " PASV", " PBSZ", " PROT", " REST STREAM", " SIZE", " UTF8"}, "End")
+	case "SYST":
+		session.reply(215, "UNIX Type: L8")
+	case "OPTS", "TYPE", "PBSZ", "PROT", "NOOP":
+		session.reply(200, "OK")
+	case "PWD":
+		session.reply(257, fmt.Sprintf("%q is the current directory", session.cwd))
+	case "CWD":
+		dir := session.resolve(arg)
+		if !files.isDir(dir) {
+			session.reply(550, "Failed to change directory")
+			return true
+		}
+		session.cwd = dir
+		session.reply(250, "Directory successfully changed")
+	case "CDUP":
+		session.cwd = path.Dir(session.cwd)
+		session.reply(250, "Directory successfully changed")
+	case "MKD":
+		if err := files.mkdir(session.resolve(arg)); err != nil {
+			session.reply(550, err.Error())
+			return true
+		}
+		session.reply(257, "Created")
+	case "RMD", "DELE":
+		if err := files.remove(session.resolve(arg)); err != nil {
+			session.reply(550, err.Error())
+			return true
+		}
+		session.reply(250, "Removed")
+	case "RNFR":
+		if !files.exists(session.resolve(arg)) {
+			session.reply(550, "File not found")
+			return true
+		}
+		session.renameFrom = session.resolve(arg)
+		session.reply(350, "Ready for RNTO")
+	case "RNTO":
+		if err := files.rename(session.renameFrom, session.resolve(arg)); err != nil {
+			session.reply(550, err.Error())
+			return true
+		}
+		session.reply(250, "Rename successful")
+	case "SIZE":
+		info, ok := files.stat(session.resolve(arg))
+		if !ok || info.dir {
+			session.reply(550, "Could not get file size")
+			return true
+		}
+		session.reply(213, strconv.Itoa(len(info.data)))
+	case "MDTM":
+		info, ok := files.stat(session.resolve(arg))
+		if !ok {
+			session.reply(550, "Could not get modification time")
+			return true
+		}
+		session.reply(213, info.modTime.UTC().Format("20060102150405"))
+	case "REST":
+		offset, err := strconv.ParseInt(arg, 10, 64)
+		if err != nil || offset < 0 {
+			session.reply(501, "Invalid offset")
+			return true
+		}
+		session.restOffset = offset
+		session.reply(350, fmt.Sprintf("Restart position accepted (%d)", offset))
+	case "EPSV":
+		port, err := session.openPassive()
+		if err != nil {
+			session.reply(425, err.Error())
+			return true
+		}
+		session.reply(229, fmt.Sprintf("Entering Extended Passive Mode (|||%d|)", port))
+	case "PASV":
+		port, err := session.openPassive()
+		if err != nil {
+			session.reply(425, err.Error())
+			return true
+		}
+		session.reply(227, fmt.Sprintf("Entering Passive Mode (127,0,0,1,%d,%d)", port>>8, port&0xFF))
+	case "LIST", "NLST":
+		session.list(command, arg)
+	case "RETR":
+		session.retrieve(session.resolve(arg))
+	case "STOR", "APPE":
+		session.store(session.resolve(arg), command == "APPE")
+	case "QUIT":
+		session.reply(221, "Goodbye")
+		return false
+	default:
+		session.reply(502, "Command not implemented")
+	}
+
+	return true
+}
+
+func (session *ftpSession) list(command, arg string) {
+	// Ignore ls style flags such as -a
+	if strings.HasPrefix(arg, "-") {
+		arg = ""
+	}
+
+	target := session.resolve(arg)
+	entries, ok := session.server.files.list(target)
+	if !ok {
+		session.reply(550, "Failed to list directory")
+		return
+	}
+
+	var buf bytes.Buffer
+	for _, entry := range entries {
+		if command == "NLST" {
+			fmt.Fprintf(&buf, "%s\r\n", entry.name)
+			continue
+		}
+
+		mode := "-rw-r--r--"
+		if entry.dir {
+			mode = "drwxr-xr-x"
+		}
+		fmt.Fprintf(&buf, "%s 1 root root %d %s %s\r\n", mode, len(entry.data), entry.modTime.UTC().Format("Jan _2 15:04"), entry.name)
+	}
+
+	session.transfer(func(conn net.Conn) error {
+		_, err := conn.Write(buf.Bytes())
+		return err
+	})
+}
+
+func (session *ftpSession) retrieve(name string) {
+	info, ok := session.server.files.stat(name)
+	if !ok || info.dir {
+		session.reply(550, "Failed to open file")
+		return
+	}
+
+	data := info.data
+	if session.restOffset > int64(len(data)) {
+		session.reply(551, "Restart position beyond end of file")
+		return
+	}
+	data = data[session.restOffset:]
+
+	session.transfer(func(conn net.Conn) error {
+		_, err := conn.Write(data)
+		return err
+	})
+}
+
+func (session *ftpSession) store(name string, appendData bool) {
+	files := session.server.files
+	if !files.isDir(path.Dir(name)) {
+		session.reply(553, "Could not create file")
+		return
+	}
+
+	offset := session.restOffset
+	session.transfer(func(conn net.Conn) error {
+		var r io.Reader = conn
+		drop := session.server.takeDropUpload()
+		if drop >= 0 {
+			r = io.LimitReader(conn, drop)
+		}
+
+		data, err := io.ReadAll(r)
+		files.write(name, data, offset, appendData)
+
+		if drop >= 0 {
+			return errUploadDropped
+		}
+		return err
+	})
+}
+
+// transfer accepts the pending data connection, secures it and runs fn on it.
+func (session *ftpSession) transfer(fn func(conn net.Conn) error) {
+	if session.passive == nil {
+		session.reply(425, "Use PASV or EPSV first")
+		return
+	}
+
+	listener := session.passive
+	session.passive = nil
+	defer listener.Close()
+
+	listener.(*net.TCPListener).SetDeadline(time.Now().Add(dataTimeout))
+	raw, err := listener.Accept()
+	if err != nil {
+		session.reply(425, "Failed to establish connection")
+		return
+	}
+
+	session.server.track(raw, true)
+	defer session.server.track(raw, false)
+
+	session.reply(150, "Opening BINARY mode data connection")
+
+	conn := tls.Server(raw, session.server.tlsConfig)
+	defer conn.Close()
+
+	if err := conn.Handshake(); err != nil {
+		session.reply(522, "SSL connection failed")
+		return
+	}
+
+	session.server.mu.Lock()
+	requireReuse := session.server.requireSessionReuse
+	session.server.mu.Unlock()
+
+	if requireReuse && !conn.ConnectionState().DidResume {
+		session.reply(522, "SSL connection failed: session reuse required")
+		return
+	}
+
+	if err := fn(conn); err != nil {
+		session.reply(426, "Failure writing network stream")
+		return
+	}
+
+	conn.Close()
+	session.reply(226, "Transfer complete")
+}
+
+func (session *ftpSession) openPassive() (int, error) {
+	session.closePassive()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return 0, err
+	}
+
+	session.passive = listener
+	return listener.Addr().(*net.TCPAddr).Port, nil
+}
+
+func (session *ftpSession) closePassive() {
+	if session.passive != nil {
+		session.passive.Close()
+		session.passive = nil
+	}
+}
+
+func (session *ftpSession) resolve(name string) string {
+	if name == "" {
+		return session.cwd
+	}
+	if !strings.HasPrefix(name, "/") {
+		name = path.Join(session.cwd, name)
+	}
+	return path.Clean(name)
+}
+
+func (session *ftpSession) reply(code int, message string) {
+	fmt.Fprintf(session.conn, "%d %s\r\n", code, message)
+}
+
+func (session *ftpSession) replyLines(code int, lines []string, last string) {
+	var buf bytes.Buffer
+	for i, line := range lines {
+		if i == 0 {
+			fmt.Fprintf(&buf, "%d-%s\r\n", code, line)
+		} else {
+			fmt.Fprintf(&buf, "%s\r\n", line)
+		}
+	}
+	fmt.Fprintf(&buf, "%d %s\r\n", code, last)
+	session.conn.Write(buf.Bytes())
+}
+
+type memFile struct {
+	name    string
+	dir     bool
+	data    []byte
+	modTime time.Time
+}
+
+// memFS is the in-memory SD card served over FTP.
+type memFS struct {
+	mu    sync.RWMutex
+	files map[string]*memFile
+}
+
+func newMemFS(dirs ...string) *memFS {
+	fs := &memFS{
+		files: map[string]*memFile{
+			"/": {name: "/", dir: true, modTime: time.Now()},
+		},
+	}
+	for _, dir := range dirs {
+		fs.mkdirAll(dir)
+	}
+	return fs
+}
+
+func (fs *memFS) stat(name string) (memFile, bool) {
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+
+	file, ok := fs.files[path.Clean(name)]
+	if !ok {
+		return memFile{}, false
+	}
+	return *file, true
+}
+
+func (fs *memFS) exists(name string) bool {
+	_, ok := fs.stat(name)
+	return ok
+}
+
+func (fs *memFS) isDir(name string) bool {
+	file, ok := fs.stat(name)
+	return ok && file.dir
+}
+
+func (fs *memFS) list(dir string) ([]memFile, bool) {
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+
+	dir = path.Clean(dir)
+	target, ok := fs.files[dir]
+	if !ok {
+		return nil, false
+	}
+	if !target.dir {
+		return []memFile{*target}, true
+	}
+
+	var entries []memFile
+	for name, file := range fs.files {
+		if name != "/" && path.Dir(name) == dir {
+			entries = append(entries, *file)
+		}
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].name < entries[j].name
+	})
+
+	return entries, true
+}
+
+func (fs *memFS) mkdir(name string) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	name = path.Clean(name)
+	if _, ok := fs.files[name]; ok {
+		return fmt.Errorf("file exists")
+	}
+	if parent, ok := fs.files[path.Dir(name)]; !ok || !parent.dir {
+		return fmt.Errorf("parent directory does not exist")
+	}
+
+	fs.files[name] = &memFile{name: path.Base(name), dir: true, modTime: time.Now()}
+	return nil
+}
+
+func (fs *memFS) mkdirAll(name string) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	name = path.Clean(name)
+	for name != "/" {
+		if _, ok := fs.files[name]; !ok {
+			fs.files[name] = &memFile{name: path.Base(name), dir: true, modTime: time.Now()}
+		}
+		name = path.Dir(name)
+	}
+}
+
+func (fs *memFS) write(name string, data []byte, offset int64, appendData bool) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	name = path.Clean(name)
+
+	var existing []byte
+	if file, ok := fs.files[name]; ok && !file.dir {
+		existing = file.data
+	}
+
+	switch {
+	case appendData:
+		data = append(append([]byte{}, existing...), data...)
+	case offset > 0:
+		if offset > int64(len(existing)) {
+			offset = int64(len(existing))
+		}
+		data = append(append([]byte{}, existing[:offset]...), data...)
+	}
+
+	fs.files[name] = &memFile{name: path.Base(name), data: data, modTime: time.Now()}
+}
+
+func (fs *memFS) remove(name string) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	name = path.Clean(name)
+	file, ok := fs.files[name]
+	if !ok || name == "/" {
+		return fmt.Errorf("file not found")
+	}
+
+	if file.dir {
+		for other := range fs.files {
+			if path.Dir(other) == name {
+				return fmt.Errorf("directory not empty")
+			}
+		}
+	}
+
+	delete(fs.files, name)
+	return nil
+}
+
+func (fs *memFS) rename(from, to string) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	from, to = path.Clean(from), path.Clean(to)
+	file, ok := fs.files[from]
+	if !ok {
+		return fmt.Errorf("file not found")
+	}
+	if file.dir {
+		return fmt.Errorf("renaming directories is not supported")
+	}
+
+	delete(fs.files, from)
+	renamed := *file
+	renamed.name = path.Base(to)
+	fs.files[to] = &renamed
+	return nil
+}
@@ -0,0 +1,417 @@
+package bambutest
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+)
+
+// MQTT 3.1.1 packet types
+const (
+	packetConnect     = 1
+	packetConnack     = 2
+	packetPublish     = 3
+	packetPuback      = 4
+	packetSubscribe   = 8
+	packetSuback      = 9
+	packetUnsubscribe = 10
+	packetUnsuback    = 11
+	packetPingreq     = 12
+	packetPingresp    = 13
+	packetDisconnect  = 14
+)
+
+const (
+	connackAccepted       = 0
+	connackBadCredentials = 4
+)
+
+// broker is a minimal MQTT 3.1.1 broker. It supports what the printer's
+// broker is used for: password authentication, subscriptions with wildcards
+// and QoS 0 and 1 publishes. Messages are delivered to subscribers with QoS 0.
+type broker struct {
+	listener net.Listener
+	username string
+	password string
+
+	// accept, if set, is asked about credentials other than the above
+	accept func(username, password string) bool
+
+	// onPublish is called for every message published by a client
+	onPublish func(topic string, payload []byte)
+
+	mu      sync.Mutex
+	clients map[*brokerClient]struct{}
+	wg      sync.WaitGroup
+}
+
+type brokerClient struct {
+	conn net.Conn
+
+	mu            sync.Mutex
+	subscriptions map[string]struct{}
+}
+
+func newBroker(tlsConfig *tls.Config, username, password string) (*broker, error) {
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", tlsConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen: %w", err)
+	}
+
+	b := &broker{
+		listener: listener,
+		username: username,
+		password: password,
+		clients:  make(map[*brokerClient]struct{}),
+	}
+
+	b.wg.Add(1)
+	go b.serve()
+
+	return b, nil
+}
+
+func (b *broker) port() int {
+	return b.listener.Addr().(*net.TCPAddr).Port
+}
+
+func (b *broker) close() {
+	b.listener.Close()
+	b.disconnectAll()
+	b.wg.Wait()
+}
+
+func (b *broker) disconnectAll() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for client := range b.clients {
+		client.conn.Close()
+	}
+}
+
+func (b *broker) connectedClients() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return len(b.clients)
+}
+
+// publish sends a message to every client subscribed to a matching filter.
+func (b *broker) publish(topic string, payload []byte) {
+	b.mu.Lock()
+	clients := make([]*brokerClient, 0, len(b.clients))
+	for client := range b.clients {
+		clients = append(clients, client)
+	}
+	b.mu.Unlock()
+
+	packet := encodePublish(topic, payload)
+	for _, client := range clients {
+		if client.subscribed(topic) {
+			client.write(packet)
+		}
+	}
+}
+
+func (b *broker) serve() {
+	defer b.wg.Done()
+
+	for {
+		conn, err := b.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		b.wg.Add(1)
+		go func() {
+			defer b.wg.Done()
+			b.handle(conn)
+		}()
+	}
+}
+
+func (b *broker) handle(conn net.Conn) {
+	defer conn.Close()
+
+	reader := bufio.NewReader(conn)
+	client := &brokerClient{
+		conn:          conn,
+		subscriptions: make(map[string]struct{}),
+	}
+
+	packetType, _, body, err := readPacket(reader)
+	if err != nil || packetType != packetConnect {
+		return
+	}
+
+	if !b.authenticate(body) {
+		client.write([]byte{packetConnack << 4, 2, 0, connackBadCredentials})
+		return
+	}
+	client.write([]byte{packetConnack << 4, 2, 0, connackAccepted})
+
+	b.mu.Lock()
+	b.clients[client] = struct{}{}
+	b.mu.Unlock()
+
+	defer func() {
+		b.mu.Lock()
+		delete(b.clients, client)
+		b.mu.Unlock()
+	}()
+
+	for {
+		packetType, flags, body, err := readPacket(reader)
+		if err != nil {
+			return
+		}
+
+		switch packetType {
+		case packetPublish:
+			topic, packetID, payload, err := decodePublish(flags, body)
+			if err != nil {
+				return
+			}
+			if packetID != 0 {
+				client.write([]byte{packetPuback << 4, 2, byte(packetID >> 8), byte(packetID)})
+			}
+			if b.onPublish != nil {
+				b.onPublish(topic, payload)
+			}
+			b.publish(topic, payload)
+		case packetSubscribe:
+			packetID, filters, err := decodeSubscribe(body, true)
+			if err != nil {
+				return
+			}
+			client.subscribe(filters)
+
+			ack := []byte{byte(packetID >> 8), byte(packetID)}
+			for range filters {
+				ack = append(ack, 0) // granted QoS 0
+			}
+			client.write(encodePacket(packetSuback, 0, ack))
+		case packetUnsubscribe:
+			packetID, filters, err := decodeSubscribe(body, false)
+			if err != nil {
+				return
+			}
+			client.unsubscribe(filters)
+			client.write([]byte{packetUnsuback << 4, 2, byte(packetID >> 8), byte(packetID)})
+		case packetPingreq:
+			client.write([]byte{packetPingresp << 4, 0})
+		case packetDisconnect:
+			return
+		}
+	}
+}
+
+func (b *broker) authenticate(body []byte) bool {
+	r := &packetReader{data: body}
+
+	r.readString() // protocol name
+	r.readByte()   // protocol level
+	flags := r.readByte()
+	r.readUint16() // keep alive
+	r.readString() // client id
+
+	if flags&0x04 != 0 { // will
+		r.readString()
+		r.readString()
+	}
+
+	var username, password string
+	if flags&0x80 != 0 {
+		username = r.readString()
+	}
+	if flags&0x40 != 0 {
+		password = r.readString()
+	}
+
+	if r.err != nil {
+		return false
+	}
+	if username == b.username && password == b.password {
+		return true
+	}
+
+	b.mu.Lock()
+	accept := b.accept
+	b.mu.Unlock()
+
+	return accept != nil && accept(username, password)
+}
+
+func (client *brokerClient) write(packet []byte) {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+
+	client.conn.Write(packet)
+}
+
+func (client *brokerClient) subscribe(filters []string) {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+
+	for _, filter := range filters {
+		client.subscriptions[filter] = struct{}{}
+	}
+}
+
+func (client *brokerClient) unsubscribe(filters []string) {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+
+	for _, filter := range filters {
+		delete(client.subscriptions, filter)
+	}
+}
+
+func (client *brokerClient) subscribed(topic string) bool {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+
+	for filter := range client.subscriptions {
+		if topicMatches(filter, topic) {
+			return true
+		}
+	}
+	return false
+}
+
+func topicMatches(filter, topic string) bool {
+	filterLevels := strings.Split(filter, "/")
+	topicLevels := strings.Split(topic, "/")
+
+	for i, level := range filterLevels {
+		if level == "#" {
+			return true
+		}
+		if i >= len(topicLevels) {
+			return false
+		}
+		if level != "+" && level != topicLevels[i] {
+			return false
+		}
+	}
+
+	return len(filterLevels) == len(topicLevels)
+}
+
+func readPacket(reader *bufio.Reader) (packetType byte, flags byte, body []byte, err error) {
+	header, err := reader.ReadByte()
+	if err != nil {
+		return 0, 0, nil, err
+	}
+
+	length, err := binary.ReadUvarint(reader)
+	if err != nil {
+		return 0, 0, nil, err
+	}
+
+	body = make([]byte, length)
+	if _, err := io.ReadFull(reader, body); err != nil {
+		return 0, 0, nil, err
+	}
+
+	return header >> 4, header & 0x0F, body, nil
+}
+
+func encodePacket(packetType, flags byte, body []byte) []byte {
+	packet := []byte{packetType<<4 | flags}
+	packet = binary.AppendUvarint(packet, uint64(len(body)))
+	return append(packet, body...)
+}
+
+func encodePublish(topic string, payload []byte) []byte {
+	body := appendString(nil, topic)
+	body = append(body, payload...)
+	return encodePacket(packetPublish, 0, body)
+}
+
+func decodePublish(flags byte, body []byte) (topic string, packetID uint16, payload []byte, err error) {
+	r := &packetReader{data: body}
+
+	topic = r.readString()
+	if qos := (flags >> 1) & 0x03; qos > 0 {
+		packetID = r.readUint16()
+	}
+
+	return topic, packetID, r.rest(), r.err
+}
+
+func decodeSubscribe(body []byte, withQoS bool) (packetID uint16, filters []string, err error) {
+	r := &packetReader{data: body}
+
+	packetID = r.readUint16()
+	for r.err == nil && r.remaining() > 0 {
+		filters = append(filters, r.readString())
+		if withQoS {
+			r.readByte()
+		}
+	}
+
+	return packetID, filters, r.err
+}
+
+func appendString(buf []byte, s string) []byte {
+	buf = append(buf, byte(len(s)>>8), byte(len(s)))
+	return append(buf, s...)
+}
+
+var errShortPacket = errors.New("short packet")
+
+// packetReader reads the fields of a packet body, remembering the first
+// error so that callers can check it once at the end.
+type packetReader struct {
+	data []byte
+	err  error
+}
+
+func (r *packetReader) remaining() int {
+	return len(r.data)
+}
+
+func (r *packetReader) readByte() byte {
+	if len(r.data) < 1 {
+		r.err = errShortPacket
+		return 0
+	}
+	b := r.data[0]
+	r.data = r.data[1:]
+	return b
+}
+
+func (r *packetReader) readUint16() uint16 {
+	if len(r.data) < 2 {
+		r.err = errShortPacket
+		return 0
+	}
+	v := binary.BigEndian.Uint16(r.data)
+	r.data = r.data[2:]
+	return v
+}
+
+func (r *packetReader) readString() string {
+	length := int(r.readUint16())
+	if len(r.data) < length {
+		r.err = errShortPacket
+		return ""
+	}
+	s := string(r.data[:length])
+	r.data = r.data[length:]
+	return s
+}
+
+func (r *packetReader) rest() []byte {
+	rest := r.data
+	r.data = nil
+	return rest
+}
@@ -0,0 +1,429 @@
+package bambutest
+
+import (
+	"bufio"
+	"crypto/md5"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/textproto"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	rtspRealm     = "bambutest"
+	rtspNonce     = "5f1b3a2c9d0e4f6a"
+	rtspSessionID = "bambutest1"
+	rtspPath      = "/streaming/live/1"
+
+	// rtpMTU is the largest RTP payload, bigger NAL units are sent as FU-A
+	rtpMTU = 1200
+)
+
+// rtspServer replays an H.264 stream like the RTSPS live view of X1
+// printers. It requires Digest authentication and only supports RTP
+// interleaved in the RTSP connection.
+type rtspServer struct {
+	listener net.Listener
+	username string
+	password string
+
+	mu         sync.Mutex
+	video      [][][]byte
+	interval   time.Duration
+	badPackets bool
+	conns      map[net.Conn]struct{}
+	wg         sync.WaitGroup
+	done       chan struct{}
+}
+
+func newRTSPServer(tlsConfig *tls.Config, username, password string, video [][][]byte, interval time.Duration) (*rtspServer, error) {
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", tlsConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen: %w", err)
+	}
+
+	server := &rtspServer{
+		listener: listener,
+		username: username,
+		password: password,
+		video:    video,
+		interval: interval,
+		conns:    make(map[net.Conn]struct{}),
+		done:     make(chan struct{}),
+	}
+
+	server.wg.Add(1)
+	go server.serve()
+
+	return server, nil
+}
+
+func (server *rtspServer) port() int {
+	return server.listener.Addr().(*net.TCPAddr).Port
+}
+
+func (server *rtspServer) close() {
+	server.listener.Close()
+	close(server.done)
+
+	server.mu.Lock()
+	for conn := range server.conns {
+		conn.Close()
+	}
+	server.mu.Unlock()
+
+	server.wg.Wait()
+}
+
+func (server *rtspServer) clients() int {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+
+	return len(server.conns)
+}
+
+// sendBadPackets makes the next frame sent by any session follow two
+// malformed packets.
+func (server *rtspServer) sendBadPackets() {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+
+	server.badPackets = true
+}
+
+func (server *rtspServer) serve() {
+	defer server.wg.Done()
+
+	for {
+		conn, err := server.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		server.mu.Lock()
+		server.conns[conn] = struct{}{}
+		server.mu.Unlock()
+
+		server.wg.Add(1)
+		go func() {
+			defer server.wg.Done()
+			defer func() {
+				server.mu.Lock()
+				delete(server.conns, conn)
+				server.mu.Unlock()
+			}()
+			defer conn.Close()
+
+			session := &rtspSession{server: server, conn: conn, stop: make(chan struct{})}
+			session.run()
+		}()
+	}
+}
+
+type rtspSession struct {
+	server *rtspServer
+	conn   net.Conn
+
+	writeMu sync.Mutex
+	playing bool
+	stop    chan struct{}
+}
+
+func (session *rtspSession) run() {
+	defer close(session.stop)
+
+	reader := textproto.NewReader(bufio.NewReader(session.conn))
+	base := fmt.Sprintf("rtsps://127.0.0.1:%d%s", session.server.port(), rtspPath)
+
+	for {
+		line, err := reader.ReadLine()
+		if err != nil {
+			return
+		}
+		header, err := reader.ReadMIMEHeader()
+		if err != nil {
+			return
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) != 3 {
+			return
+		}
+		method, uri := fields[0], fields[1]
+		cseq := header.Get("CSeq")
+
+		if !session.authorized(method, uri, header.Get("Authorization")) {
+			session.reply(401, "Unauthorized", cseq, map[string]string{
+				"WWW-Authenticate": fmt.Sprintf(`Digest realm="%s", nonce="%s"`, rtspRealm, rtspNonce),
+			}, nil)
+			continue
+		}
+
+		if !strings.HasPrefix(uri, base) {
+			session.reply(404, "Stream Not Found", cseq, nil, nil)
+			continue
+		}
+
+		switch method {
+		case "OPTIONS", "GET_PARAMETER":
+			session.reply(200, "OK", cseq, map[string]string{
+				"Public": "OPTIONS, DESCRIBE, SETUP, PLAY, TEARDOWN, GET_PARAMETER",
+			}, nil)
+		case "DESCRIBE":
+			session.reply(200, "OK", cseq, map[string]string{
+				"Content-Base": base + "/",
+				"Content-Type": "application/sdp",
+			}, session.server.sdp())
+		case "SETUP":
+			session.reply(200, "OK", cseq, map[string]string{
+				"Transport": "RTP/AVP/TCP;unicast;interleaved=0-1",
+				"Session":   rtspSessionID + ";timeout=60",
+			}, nil)
+		case "PLAY":
+			session.reply(200, "OK", cseq, map[string]string{"Session": rtspSessionID}, nil)
+			if !session.playing {
+				session.playing = true
+				go session.play()
+			}
+		case "TEARDOWN":
+			session.reply(200, "OK", cseq, nil, nil)
+			return
+		default:
+			session.reply(405, "Method Not Allowed", cseq, nil, nil)
+		}
+	}
+}
+
+func (session *rtspSession) authorized(method, uri, authorization string) bool {
+	params, ok := strings.CutPrefix(authorization, "Digest ")
+	if !ok {
+		return false
+	}
+
+	values := make(map[string]string)
+	for _, param := range strings.Split(params, ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+		values[key] = strings.Trim(value, `"`)
+	}
+
+	ha1 := md5Hex(session.server.username + ":" + rtspRealm + ":" + session.server.password)
+	ha2 := md5Hex(method + ":" + values["uri"])
+
+	var expected string
+	if values["qop"] == "auth" {
+		expected = md5Hex(ha1 + ":" + rtspNonce + ":" + values["nc"] + ":" + values["cnonce"] + ":auth:" + ha2)
+	} else {
+		expected = md5Hex(ha1 + ":" + rtspNonce + ":" + ha2)
+	}
+
+	return values["username"] == session.server.username && values["uri"] == uri && values["response"] == expected
+}
+
+func (session *rtspSession) reply(code int, status, cseq string, headers map[string]string, body []byte) {
+	var resp strings.Builder
+	fmt.Fprintf(&resp, "RTSP/1.0 %d %s\r\nCSeq: %s\r\n", code, status, cseq)
+	for key, value := range headers {
+		fmt.Fprintf(&resp, "%s: %s\r\n", key, value)
+	}
+	if body != nil {
+		fmt.Fprintf(&resp, "Content-Length: %d\r\n", len(body))
+	}
+	resp.WriteString("\r\n")
+	resp.Write(body)
+
+	session.write([]byte(resp.String()))
+}
+
+func (session *rtspSession) write(data []byte) error {
+	session.writeMu.Lock()
+	defer session.writeMu.Unlock()
+
+	_, err := session.conn.Write(data)
+	return err
+}
+
+// play sends the video in a loop as interleaved RTP on channel 0.
+func (session *rtspSession) play() {
+	var sequence uint16
+	var timestamp uint32
+
+	for i := 0; ; i++ {
+		session.server.mu.Lock()
+		video := session.server.video
+		interval := session.server.interval
+		badPackets := session.server.badPackets
+		session.server.badPackets = false
+		session.server.mu.Unlock()
+
+		if len(video) > 0 {
+			var packets [][]byte
+			if badPackets {
+				// A packet that is not RTP, then a STAP-A cut short
+				packets = append(packets, make([]byte, 12), rtpPacket(sequence, timestamp, false, []byte{24, 0, 5, 0x67}))
+				sequence++
+			}
+
+			payloads := packetize(video[i%len(video)])
+			for j, payload := range payloads {
+				packets = append(packets, rtpPacket(sequence, timestamp, j == len(payloads)-1, payload))
+				sequence++
+			}
+
+			for _, packet := range packets {
+				frame := []byte{'$', 0, 0, 0}
+				binary.BigEndian.PutUint16(frame[2:4], uint16(len(packet)))
+
+				if err := session.write(append(frame, packet...)); err != nil {
+					return
+				}
+			}
+		}
+
+		timestamp += uint32(interval * 90000 / time.Second)
+
+		select {
+		case <-time.After(interval):
+		case <-session.stop:
+			return
+		case <-session.server.done:
+			return
+		}
+	}
+}
+
+func rtpPacket(sequence uint16, timestamp uint32, marker bool, payload []byte) []byte {
+	header := make([]byte, 12)
+	header[0] = 0x80
+	header[1] = 96
+	if marker {
+		header[1] |= 0x80
+	}
+	binary.BigEndian.PutUint16(header[2:4], sequence)
+	binary.BigEndian.PutUint32(header[4:8], timestamp)
+	binary.BigEndian.PutUint32(header[8:12], 0x0BB1)
+
+	return append(header, payload...)
+}
+
+// packetize splits an access unit into RTP payloads: SPS and PPS are
+// aggregated into a STAP-A, large NAL units fragmented into FU-As and the
+// rest sent as single NAL unit packets.
+func packetize(nalus [][]byte) [][]byte {
+	var payloads [][]byte
+	var stap []byte
+
+	for _, nalu := range nalus {
+		nalType := nalu[0] & 0x1F
+
+		if nalType == 7 || nalType == 8 {
+			if stap == nil {
+				stap = []byte{nalu[0]&0xE0 | 24}
+			}
+			stap = binary.BigEndian.AppendUint16(stap, uint16(len(nalu)))
+			stap = append(stap, nalu...)
+			continue
+		}
+
+		// Parameter sets go before the slices they describe
+		if stap != nil {
+			payloads = append(payloads, stap)
+			stap = nil
+		}
+
+		if len(nalu) <= rtpMTU {
+			payloads = append(payloads, nalu)
+			continue
+		}
+
+		indicator := nalu[0]&0xE0 | 28
+		for offset := 1; offset < len(nalu); offset += rtpMTU {
+			end := min(offset+rtpMTU, len(nalu))
+
+			header := nalType
+			if offset == 1 {
+				header |= 0x80
+			}
+			if end == len(nalu) {
+				header |= 0x40
+			}
+			payloads = append(payloads, append([]byte{indicator, header}, nalu[offset:end]...))
+		}
+	}
+
+	if stap != nil {
+		payloads = append(payloads, stap)
+	}
+	return payloads
+}
+
+func (server *rtspServer) sdp() []byte {
+	var sets []string
+	for _, nalu := range firstParameterSets(server.video) {
+		sets = append(sets, base64.StdEncoding.EncodeToString(nalu))
+	}
+
+	return []byte(strings.Join([]string{
+		"v=0",
+		"o=- 0 0 IN IP4 127.0.0.1",
+		"s=bambutest",
+		"t=0 0",
+		"m=video 0 RTP/AVP 96",
+		"a=rtpmap:96 H264/90000",
+		"a=fmtp:96 packetization-mode=1;sprop-parameter-sets=" + strings.Join(sets, ","),
+		"a=control:track1",
+		"",
+	}, "\r\n"))
+}
+
+func firstParameterSets(video [][][]byte) [][]byte {
+	var sets [][]byte
+	for _, au := range video {
+		for _, nalu := range au {
+			if t := nalu[0] & 0x1F; t == 7 || t == 8 {
+				sets = append(sets, nalu)
+			}
+		}
+		if sets != nil {
+			return sets
+		}
+	}
+	return nil
+}
+
+// DefaultVideoInterval is the time between frames of DefaultVideo.
+const DefaultVideoInterval = 100 * time.Millisecond
+
+// DefaultVideo returns a synthetic H.264 stream of one second at 10 frames
+// per second: a keyframe with SPS and PPS followed by nine P frames. The
+// slices are filler, the stream exercises packetization rather than
+// decoding.
+func DefaultVideo() [][][]byte {
+	sps := []byte{0x67, 0x64, 0x00, 0x1F, 0xAC, 0xD9, 0x40, 0x50, 0x05, 0xBB, 0x01, 0x10}
+	pps := []byte{0x68, 0xEB, 0xE3, 0xCB, 0x22, 0xC0}
+
+	video := [][][]byte{{sps, pps, filler(0x65, 5000)}}
+	for i := 0; i < 9; i++ {
+		video = append(video, [][]byte{filler(0x41, 300+i)})
+	}
+	return video
+}
+
+func filler(header byte, size int) []byte {
+	nalu := make([]byte, size)
+	nalu[0] = header
+	for i := 1; i < size; i++ {
+		nalu[i] = byte(i%0xFE) + 1 // no zeros, which could form start codes
+	}
+	return nalu
+}
+
+func md5Hex(s string) string {
+	sum := md5.Sum([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
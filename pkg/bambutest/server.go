@@ -0,0 +1,579 @@
+// Package bambutest provides an in-process fake Bambu Lab printer for tests.
+// It serves the printer's TLS MQTT broker, implicit FTPS server, camera
+// stream and RTSPS live view on localhost with a self-signed device
+// certificate, answers requests on device/<id>/request and records them for
+// assertions.
+package bambutest
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/RobertMNewton/bambu-golang-api/pkg/mqtt/report"
+	"github.com/RobertMNewton/bambu-golang-api/pkg/mqtt/request"
+	"github.com/RobertMNewton/bambu-golang-api/pkg/types/config"
+)
+
+const (
+	DefaultDeviceID   = "01S00A000000000"
+	DefaultAccessCode = "12345678"
+
+	username = "bblp"
+)
+
+// CommandHandler produces the reports sent in reply to a request. Returning
+// no reports sends nothing.
+type CommandHandler func(req request.Request) []report.Report
+
+type Options struct {
+	DeviceID   string
+	AccessCode string
+
+	// Status is the initial push_status sent in reply to pushall
+	Status map[string]interface{}
+
+	// CameraFrames are the JPEG images replayed in a loop by the camera
+	// stream, every CameraInterval
+	CameraFrames   [][]byte
+	CameraInterval time.Duration
+
+	// Video is the H.264 stream replayed over RTSP like an X1 printer, one
+	// slice of NAL units per frame, every VideoInterval
+	Video         [][][]byte
+	VideoInterval time.Duration
+}
+
+// Server is a fake printer. Create one with NewServer and point a client at
+// it with Config.
+type Server struct {
+	deviceID   string
+	accessCode string
+
+	certs      *certificates
+	certDir    string
+	broker     *broker
+	ftp        *ftpServer
+	camera     *cameraServer
+	rtsp       *rtspServer
+	files      *memFS
+	printerCfg config.LocalPrinterConfig
+
+	mu       sync.Mutex
+	status   map[string]interface{}
+	handlers map[string]CommandHandler
+	requests []request.Request
+	received chan struct{}
+	silent   bool
+}
+
+func NewServer() (*Server, error) {
+	return NewServerWithOptions(Options{})
+}
+
+func NewServerWithOptions(options Options) (*Server, error) {
+	if options.DeviceID == "" {
+		options.DeviceID = DefaultDeviceID
+	}
+	if options.AccessCode == "" {
+		options.AccessCode = DefaultAccessCode
+	}
+	if options.Status == nil {
+		options.Status = DefaultStatus()
+	}
+	if options.CameraFrames == nil {
+		options.CameraFrames = DefaultCameraFrames()
+	}
+	if options.CameraInterval == 0 {
+		options.CameraInterval = DefaultCameraInterval
+	}
+	if options.Video == nil {
+		options.Video = DefaultVideo()
+	}
+	if options.VideoInterval == 0 {
+		options.VideoInterval = DefaultVideoInterval
+	}
+
+	certs, err := newCertificates(options.DeviceID)
+	if err != nil {
+		return nil, err
+	}
+
+	certDir, err := os.MkdirTemp("", "bambutest")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create certificate directory: %w", err)
+	}
+
+	caPath := filepath.Join(certDir, "ca.pem")
+	if err := os.WriteFile(caPath, certs.caPEM, 0600); err != nil {
+		os.RemoveAll(certDir)
+		return nil, fmt.Errorf("failed to write CA certificate: %w", err)
+	}
+
+	server := &Server{
+		deviceID:   options.DeviceID,
+		accessCode: options.AccessCode,
+		certs:      certs,
+		certDir:    certDir,
+		files:      newMemFS("/cache", "/model", "/timelapse/thumbnail"),
+		status:     copyMap(options.Status),
+		handlers:   make(map[string]CommandHandler),
+		received:   make(chan struct{}),
+	}
+
+	server.broker, err = newBroker(certs.serverTLSConfig(), username, options.AccessCode)
+	if err != nil {
+		server.Close()
+		return nil, err
+	}
+	server.broker.onPublish = server.handlePublish
+
+	server.ftp, err = newFTPServer(certs.serverTLSConfig(), username, options.AccessCode, server.files)
+	if err != nil {
+		server.Close()
+		return nil, err
+	}
+
+	server.camera, err = newCameraServer(certs.serverTLSConfig(), username, options.AccessCode, options.CameraFrames, options.CameraInterval)
+	if err != nil {
+		server.Close()
+		return nil, err
+	}
+
+	server.rtsp, err = newRTSPServer(certs.serverTLSConfig(), username, options.AccessCode, options.Video, options.VideoInterval)
+	if err != nil {
+		server.Close()
+		return nil, err
+	}
+
+	server.printerCfg = config.NewLocalPrinterConfig(options.DeviceID, "127.0.0.1", options.AccessCode, caPath)
+	server.printerCfg.SetPort(config.ServiceMQTT, server.broker.port())
+	server.printerCfg.SetPort(config.ServiceFTP, server.ftp.port())
+	server.printerCfg.SetPort(config.ServiceCamera, server.camera.port())
+	server.printerCfg.SetPort(config.ServiceRTSP, server.rtsp.port())
+
+	return server, nil
+}
+
+// Config returns a printer config that connects to the fake printer. Every
+// call returns a new config.
+func (server *Server) Config() *config.LocalPrinterConfig {
+	cfg := server.printerCfg.Clone()
+	return &cfg
+}
+
+func (server *Server) DeviceID() string {
+	return server.deviceID
+}
+
+// CACertPEM returns the certificate of the CA that signed the device
+// certificate.
+func (server *Server) CACertPEM() []byte {
+	return server.certs.caPEM
+}
+
+func (server *Server) Close() error {
+	if server.broker != nil {
+		server.broker.close()
+	}
+	if server.ftp != nil {
+		server.ftp.close()
+	}
+	if server.camera != nil {
+		server.camera.close()
+	}
+	if server.rtsp != nil {
+		server.rtsp.close()
+	}
+	return os.RemoveAll(server.certDir)
+}
+
+// HandleCommand replaces the reply to a command. By default pushall is
+// answered with the full status, get_version with a module list and every
+// other command is echoed back with result "success".
+func (server *Server) HandleCommand(command string, handler CommandHandler) {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+
+	server.handlers[command] = handler
+}
+
+// FailCommand makes the printer reject a command with the given reason.
+func (server *Server) FailCommand(command, reason string) {
+	server.HandleCommand(command, func(req request.Request) []report.Report {
+		return []report.Report{Reply(req, "fail", reason)}
+	})
+}
+
+// SetSilent stops the printer from replying to requests and sending reports,
+// like a printer whose firmware hung while the connection stays up.
+func (server *Server) SetSilent(silent bool) {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+
+	server.silent = silent
+}
+
+// SetRequireSessionReuse controls whether FTP data connections must resume
+// the TLS session of the control connection, which the printer requires. It
+// is enabled by default.
+func (server *Server) SetRequireSessionReuse(require bool) {
+	server.ftp.setRequireSessionReuse(require)
+}
+
+// DropNextUpload closes the data connection of the next FTP upload once
+// afterBytes bytes have arrived, like a printer losing Wi-Fi mid-transfer.
+// The bytes received so far are kept so that the upload can be resumed.
+func (server *Server) DropNextUpload(afterBytes int64) {
+	server.ftp.dropNextUpload(afterBytes)
+}
+
+// SendBadVideoPackets sends a packet that is not RTP and one with a
+// truncated payload ahead of the next RTSP video frame, like a lossy link.
+func (server *Server) SendBadVideoPackets() {
+	server.rtsp.sendBadPackets()
+}
+
+// SetCameraFrames replaces the frames replayed by the camera stream.
+func (server *Server) SetCameraFrames(frames [][]byte, interval time.Duration) {
+	server.camera.setFrames(frames, interval)
+}
+
+// CameraClients returns the number of open camera connections.
+func (server *Server) CameraClients() int {
+	return server.camera.clients()
+}
+
+// RTSPClients returns the number of open RTSP connections.
+func (server *Server) RTSPClients() int {
+	return server.rtsp.clients()
+}
+
+// DisconnectClients drops every MQTT connection, as a printer reboot would.
+func (server *Server) DisconnectClients() {
+	server.broker.disconnectAll()
+}
+
+// ConnectedClients returns the number of connected MQTT clients.
+func (server *Server) ConnectedClients() int {
+	return server.broker.connectedClients()
+}
+
+// Requests returns every request received so far.
+func (server *Server) Requests() []request.Request {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+
+	return append([]request.Request(nil), server.requests...)
+}
+
+// WaitForRequest waits until a request with the given command has been
+// received and returns the first one.
+func (server *Server) WaitForRequest(ctx context.Context, command string) (request.Request, error) {
+	for {
+		server.mu.Lock()
+		for _, req := range server.requests {
+			if req.Payload.Command == command {
+				server.mu.Unlock()
+				return req, nil
+			}
+		}
+		received := server.received
+		server.mu.Unlock()
+
+		select {
+		case <-received:
+		case <-ctx.Done():
+			return request.Request{}, fmt.Errorf("no %s request received: %w", command, ctx.Err())
+		}
+	}
+}
+
+// Send publishes a report on the report topic.
+func (server *Server) Send(r report.Report) error {
+	msg, err := r.ToMessage()
+	if err != nil {
+		return err
+	}
+
+	server.SendRaw(msg)
+	return nil
+}
+
+// SendRaw publishes an arbitrary payload on the report topic, which is useful
+// to test malformed reports.
+func (server *Server) SendRaw(payload []byte) {
+	server.mu.Lock()
+	silent := server.silent
+	server.mu.Unlock()
+
+	if !silent {
+		server.broker.publish(server.reportTopic(), payload)
+	}
+}
+
+// Status returns a copy of the full status sent in reply to pushall.
+func (server *Server) Status() map[string]interface{} {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+
+	return copyMap(server.status)
+}
+
+// UpdateStatus merges delta into the full status and sends it as a
+// push_status report, the way P1 and A1 printers report changes.
+func (server *Server) UpdateStatus(delta map[string]interface{}) error {
+	server.mu.Lock()
+	mergeMap(server.status, delta)
+	server.mu.Unlock()
+
+	return server.Send(PushStatus(delta))
+}
+
+// Step is a report in a scripted sequence, sent After the previous one.
+type Step struct {
+	After  time.Duration
+	Report report.Report
+}
+
+// Play sends a scripted sequence of reports. Status reports are merged into
+// the full status as they are sent.
+func (server *Server) Play(ctx context.Context, steps []Step) error {
+	for _, step := range steps {
+		select {
+		case <-time.After(step.After):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+
+		if step.Report.Type == report.TypePrint && step.Report.Payload.Command == "push_status" {
+			server.mu.Lock()
+			mergeMap(server.status, step.Report.Payload.Params)
+			server.mu.Unlock()
+		}
+
+		if err := server.Send(step.Report); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteFile stores a file on the fake SD card, creating its directories.
+func (server *Server) WriteFile(name string, data []byte) {
+	server.files.mkdirAll(filepath.ToSlash(filepath.Dir(name)))
+	server.files.write(name, data, 0, false)
+}
+
+// ReadFile returns a file from the fake SD card.
+func (server *Server) ReadFile(name string) ([]byte, bool) {
+	file, ok := server.files.stat(name)
+	if !ok || file.dir {
+		return nil, false
+	}
+	return file.data, true
+}
+
+func (server *Server) reportTopic() string {
+	return fmt.Sprintf("device/%s/report", server.deviceID)
+}
+
+func (server *Server) requestTopic() string {
+	return fmt.Sprintf("device/%s/request", server.deviceID)
+}
+
+func (server *Server) handlePublish(topic string, payload []byte) {
+	if topic != server.requestTopic() {
+		return
+	}
+
+	req, err := request.FromMessage(payload)
+	if err != nil {
+		return
+	}
+
+	server.mu.Lock()
+	server.requests = append(server.requests, req)
+	close(server.received)
+	server.received = make(chan struct{})
+
+	handler, ok := server.handlers[req.Payload.Command]
+	if !ok {
+		handler = server.defaultHandler
+	}
+	server.mu.Unlock()
+
+	for _, r := range handler(req) {
+		server.Send(r)
+	}
+}
+
+func (server *Server) defaultHandler(req request.Request) []report.Report {
+	switch req.Payload.Command {
+	case "pushall":
+		status := server.Status()
+		status["msg"] = 0
+
+		r := PushStatus(status)
+		r.Payload.SequenceID = req.Payload.SequenceID
+		return []report.Report{r}
+	case "get_version":
+		r := Reply(req, "success", "")
+		r.Payload.Params["module"] = []interface{}{
+			map[string]interface{}{"name": "ota", "sw_ver": "01.07.00.00", "hw_ver": "OTA", "sn": server.deviceID},
+			map[string]interface{}{"name": "mc", "sw_ver": "00.00.28.55", "hw_ver": "MC", "sn": server.deviceID},
+		}
+		return []report.Report{r}
+	default:
+		return []report.Report{Reply(req, "success", "SUCCESS")}
+	}
+}
+
+// Reply builds the echo the printer sends in reply to a request.
+func Reply(req request.Request, result, reason string) report.Report {
+	params := copyMap(req.Payload.Params)
+	if params == nil {
+		params = make(map[string]interface{})
+	}
+
+	return report.Report{
+		Type: req.Type,
+		Payload: report.ReportPayload{
+			SequenceID: req.Payload.SequenceID,
+			Command:    req.Payload.Command,
+			Result:     result,
+			Reason:     reason,
+			Params:     params,
+		},
+	}
+}
+
+// PushStatus builds a push_status report carrying the given fields.
+func PushStatus(fields map[string]interface{}) report.Report {
+	return report.Report{
+		Type: report.TypePrint,
+		Payload: report.ReportPayload{
+			SequenceID: "0",
+			Command:    "push_status",
+			Params:     copyMap(fields),
+		},
+	}
+}
+
+// DefaultStatus returns the status of an idle printer with one AMS.
+func DefaultStatus() map[string]interface{} {
+	return map[string]interface{}{
+		"gcode_state":          "IDLE",
+		"gcode_file":           "",
+		"subtask_name":         "",
+		"mc_percent":           0,
+		"mc_remaining_time":    0,
+		"mc_print_stage":       "1",
+		"stg_cur":              -1,
+		"layer_num":            0,
+		"total_layer_num":      0,
+		"print_error":          0,
+		"nozzle_temper":        25.0,
+		"nozzle_target_temper": 0.0,
+		"bed_temper":           25.0,
+		"bed_target_temper":    0.0,
+		"chamber_temper":       25.0,
+		"cooling_fan_speed":    "0",
+		"big_fan1_speed":       "0",
+		"big_fan2_speed":       "0",
+		"heatbreak_fan_speed":  "0",
+		"spd_lvl":              2,
+		"spd_mag":              100,
+		"wifi_signal":          "-40dBm",
+		"sdcard":               true,
+		"hms":                  []interface{}{},
+		"lights_report": []interface{}{
+			map[string]interface{}{"node": "chamber_light", "mode": "on"},
+		},
+		"ams": map[string]interface{}{
+			"tray_now": "255",
+			"tray_tar": "255",
+			"ams": []interface{}{
+				map[string]interface{}{
+					"id":           "0",
+					"humidity":     "4",
+					"humidity_raw": "21",
+					"temp":         "25.0",
+					"tray": []interface{}{
+						tray("0", "GFA00", "PLA", "FFFFFFFF", 100, 190, 230),
+						tray("1", "GFA00", "PLA", "000000FF", 80, 190, 230),
+						tray("2", "GFG00", "PETG", "FF0000FF", 50, 220, 260),
+						map[string]interface{}{"id": "3", "remain": -1},
+					},
+				},
+			},
+		},
+		"vt_tray": map[string]interface{}{
+			"id":              "254",
+			"tag_uid":         "0000000000000000",
+			"tray_type":       "",
+			"tray_color":      "00000000",
+			"nozzle_temp_min": "0",
+			"nozzle_temp_max": "0",
+			"remain":          -1,
+		},
+		"xcam": map[string]interface{}{
+			"allow_skip_parts":           false,
+			"buildplate_marker_detector": true,
+			"first_layer_inspector":      true,
+			"halt_print_sensitivity":     "medium",
+			"print_halt":                 true,
+			"printing_monitor":           true,
+			"spaghetti_detector":         true,
+		},
+		"upgrade_state": map[string]interface{}{
+			"status":            "IDLE",
+			"progress":          "0",
+			"new_version_state": 2,
+		},
+	}
+}
+
+func tray(id, infoIdx, trayType, color string, remain, nozzleTempMin, nozzleTempMax int) map[string]interface{} {
+	return map[string]interface{}{
+		"id":              id,
+		"tag_uid":         "5A3C9E7B0000000" + id,
+		"tray_info_idx":   infoIdx,
+		"tray_type":       trayType,
+		"tray_sub_brands": trayType + " Basic",
+		"tray_color":      color,
+		"remain":          remain,
+		"nozzle_temp_min": fmt.Sprint(nozzleTempMin),
+		"nozzle_temp_max": fmt.Sprint(nozzleTempMax),
+	}
+}
+
+func copyMap(src map[string]interface{}) map[string]interface{} {
+	if src == nil {
+		return nil
+	}
+
+	dst := make(map[string]interface{}, len(src))
+	mergeMap(dst, src)
+	return dst
+}
+
+// mergeMap merges src into dst recursively. Lists are replaced.
+func mergeMap(dst, src map[string]interface{}) {
+	for key, value := range src {
+		if nested, ok := value.(map[string]interface{}); ok {
+			existing, ok := dst[key].(map[string]interface{})
+			if !ok {
+				existing = make(map[string]interface{})
+				dst[key] = existing
+			}
+			mergeMap(existing, nested)
+			continue
+		}
+
+		dst[key] = value
+	}
+}
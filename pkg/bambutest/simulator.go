@@ -0,0 +1,580 @@
+package bambutest
+
+import (
+	"fmt"
+	"math"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/RobertMNewton/bambu-golang-api/pkg/mqtt/report"
+	"github.com/RobertMNewton/bambu-golang-api/pkg/mqtt/request"
+)
+
+const (
+	ambientTemp = 25.0
+
+	// Time constants of the simulated heaters, in simulated time
+	nozzleTau = 20 * time.Second
+	bedTau    = 60 * time.Second
+
+	preheatNozzleTemp = 220.0
+	preheatBedTemp    = 55.0
+	preheatTolerance  = 3.0
+
+	// PrintErrorCancelled is reported when a print is stopped
+	PrintErrorCancelled = 0x0300400C
+)
+
+var speedFactors = map[int]float64{
+	1: 0.5,
+	2: 1,
+	3: 1.24,
+	4: 1.66,
+}
+
+var temperatureCommand = regexp.MustCompile(`^(M104|M109|M140|M190)\b.*?\bS(-?[0-9.]+)`)
+
+// Fault is an HMS error the simulator raises when a print reaches a given
+// progress.
+type Fault struct {
+	AtPercent  int
+	Attr       uint32
+	Code       uint32
+	PrintError int
+
+	// Pause pauses the print when the fault is raised, Fail fails it.
+	Pause bool
+	Fail  bool
+}
+
+type SimulatorOptions struct {
+	Server Options
+
+	// TimeScale is the number of simulated seconds per real second
+	TimeScale float64
+
+	// TickInterval is the real time between two simulation steps
+	TickInterval time.Duration
+
+	// JobDuration and Layers describe every simulated job at standard speed
+	JobDuration time.Duration
+	Layers      int
+
+	Faults []Fault
+}
+
+func DefaultSimulatorOptions() SimulatorOptions {
+	return SimulatorOptions{
+		TimeScale:    60,
+		TickInterval: 100 * time.Millisecond,
+		JobDuration:  30 * time.Minute,
+		Layers:       100,
+	}
+}
+
+// Simulator is a fake printer that executes print jobs. It accepts
+// project_file and gcode_file requests for files uploaded over its FTP
+// server, advances progress over accelerated time, heats and cools in
+// response to temperature G-code and honours pause, resume, stop and
+// print_speed.
+type Simulator struct {
+	*Server
+
+	options SimulatorOptions
+
+	mu        sync.Mutex
+	job       *simulatedJob
+	nozzle    heater
+	bed       heater
+	speed     int
+	hms       []interface{}
+	printErr  int
+	faultsHit map[int]bool
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+type simulatedJob struct {
+	file      string
+	name      string
+	state     string
+	elapsed   time.Duration
+	timelapse bool
+}
+
+type heater struct {
+	temp   float64
+	target float64
+	tau    time.Duration
+}
+
+func (h *heater) step(dt time.Duration) {
+	goal := h.target
+	if goal <= 0 {
+		goal = ambientTemp
+	}
+	h.temp += (goal - h.temp) * (1 - math.Exp(-dt.Seconds()/h.tau.Seconds()))
+}
+
+func NewSimulator(options SimulatorOptions) (*Simulator, error) {
+	defaults := DefaultSimulatorOptions()
+	if options.TimeScale <= 0 {
+		options.TimeScale = defaults.TimeScale
+	}
+	if options.TickInterval <= 0 {
+		options.TickInterval = defaults.TickInterval
+	}
+	if options.JobDuration <= 0 {
+		options.JobDuration = defaults.JobDuration
+	}
+	if options.Layers <= 0 {
+		options.Layers = defaults.Layers
+	}
+
+	server, err := NewServerWithOptions(options.Server)
+	if err != nil {
+		return nil, err
+	}
+
+	sim := &Simulator{
+		Server:    server,
+		options:   options,
+		nozzle:    heater{temp: ambientTemp, tau: nozzleTau},
+		bed:       heater{temp: ambientTemp, tau: bedTau},
+		speed:     2,
+		faultsHit: make(map[int]bool),
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+
+	server.HandleCommand("project_file", sim.handleProjectFile)
+	server.HandleCommand("gcode_file", sim.handleGCodeFile)
+	server.HandleCommand("gcode_line", sim.handleGCodeLine)
+	server.HandleCommand("pause", sim.handlePause)
+	server.HandleCommand("resume", sim.handleResume)
+	server.HandleCommand("stop", sim.handleStop)
+	server.HandleCommand("print_speed", sim.handlePrintSpeed)
+	server.HandleCommand("ams_filament_setting", sim.handleFilamentSetting)
+	server.HandleCommand("ams_change_filament", sim.handleChangeFilament)
+	server.HandleCommand("ledctrl", sim.handleLEDControl)
+
+	go sim.run()
+
+	return sim, nil
+}
+
+func (sim *Simulator) Close() error {
+	close(sim.stop)
+	<-sim.done
+	return sim.Server.Close()
+}
+
+// RaiseHMS adds an HMS error to the reported list.
+func (sim *Simulator) RaiseHMS(attr, code uint32) {
+	sim.mu.Lock()
+	sim.hms = append(sim.hms, map[string]interface{}{"attr": attr, "code": code})
+	hms := append([]interface{}{}, sim.hms...)
+	sim.mu.Unlock()
+
+	sim.UpdateStatus(map[string]interface{}{"hms": hms})
+}
+
+// ClearHMS clears every HMS error and the print error.
+func (sim *Simulator) ClearHMS() {
+	sim.mu.Lock()
+	sim.hms = nil
+	sim.printErr = 0
+	sim.mu.Unlock()
+
+	sim.UpdateStatus(map[string]interface{}{"hms": []interface{}{}, "print_error": 0})
+}
+
+func (sim *Simulator) handleProjectFile(req request.Request) []report.Report {
+	file, _ := req.Payload.Params["url"].(string)
+	file = strings.TrimPrefix(strings.TrimPrefix(file, "file:///sdcard"), "ftp://")
+	if file == "" {
+		file, _ = req.Payload.Params["file"].(string)
+	}
+
+	name, _ := req.Payload.Params["subtask_name"].(string)
+	timelapse, _ := req.Payload.Params["timelapse"].(bool)
+
+	return sim.startJob(req, file, name, timelapse)
+}
+
+func (sim *Simulator) handleGCodeFile(req request.Request) []report.Report {
+	file, _ := req.Payload.Params["param"].(string)
+	return sim.startJob(req, file, "", false)
+}
+
+func (sim *Simulator) startJob(req request.Request, file, name string, timelapse bool) []report.Report {
+	file = path.Join("/", file)
+	if _, ok := sim.ReadFile(file); !ok {
+		return []report.Report{Reply(req, "fail", fmt.Sprintf("file %s not found", file))}
+	}
+
+	if name == "" {
+		name = strings.TrimSuffix(strings.TrimSuffix(path.Base(file), ".3mf"), ".gcode")
+	}
+
+	sim.mu.Lock()
+	if sim.job != nil && (sim.job.state == "PREPARE" || sim.job.state == "RUNNING" || sim.job.state == "PAUSE") {
+		sim.mu.Unlock()
+		return []report.Report{Reply(req, "fail", "printer is busy")}
+	}
+
+	sim.job = &simulatedJob{file: file, name: name, state: "PREPARE", timelapse: timelapse}
+	sim.nozzle.target = preheatNozzleTemp
+	sim.bed.target = preheatBedTemp
+	sim.printErr = 0
+	sim.faultsHit = make(map[int]bool)
+	sim.mu.Unlock()
+
+	sim.UpdateStatus(map[string]interface{}{
+		"gcode_state":          "PREPARE",
+		"gcode_file":           file,
+		"subtask_name":         name,
+		"mc_percent":           0,
+		"layer_num":            0,
+		"total_layer_num":      sim.options.Layers,
+		"mc_remaining_time":    int(sim.options.JobDuration.Minutes()),
+		"print_error":          0,
+		"stg_cur":              2,
+		"mc_print_stage":       "2",
+		"nozzle_target_temper": preheatNozzleTemp,
+		"bed_target_temper":    preheatBedTemp,
+	})
+
+	return []report.Report{Reply(req, "success", "")}
+}
+
+func (sim *Simulator) handleGCodeLine(req request.Request) []report.Report {
+	gcode, _ := req.Payload.Params["param"].(string)
+
+	delta := map[string]interface{}{}
+
+	sim.mu.Lock()
+	for _, line := range strings.Split(gcode, "\n") {
+		match := temperatureCommand.FindStringSubmatch(strings.TrimSpace(line))
+		if match == nil {
+			continue
+		}
+
+		target, err := strconv.ParseFloat(match[2], 64)
+		if err != nil {
+			continue
+		}
+
+		switch match[1] {
+		case "M104", "M109":
+			sim.nozzle.target = target
+			delta["nozzle_target_temper"] = target
+		case "M140", "M190":
+			sim.bed.target = target
+			delta["bed_target_temper"] = target
+		}
+	}
+	sim.mu.Unlock()
+
+	if len(delta) > 0 {
+		sim.UpdateStatus(delta)
+	}
+
+	return []report.Report{Reply(req, "success", "SUCCESS")}
+}
+
+func (sim *Simulator) handlePause(req request.Request) []report.Report {
+	return sim.transition(req, "PAUSE", "RUNNING", "PREPARE")
+}
+
+func (sim *Simulator) handleResume(req request.Request) []report.Report {
+	return sim.transition(req, "RUNNING", "PAUSE")
+}
+
+func (sim *Simulator) handleStop(req request.Request) []report.Report {
+	sim.mu.Lock()
+	if sim.job == nil || (sim.job.state != "RUNNING" && sim.job.state != "PAUSE" && sim.job.state != "PREPARE") {
+		sim.mu.Unlock()
+		return []report.Report{Reply(req, "fail", "no print in progress")}
+	}
+
+	sim.job.state = "FAILED"
+	sim.printErr = PrintErrorCancelled
+	sim.nozzle.target = 0
+	sim.bed.target = 0
+	sim.mu.Unlock()
+
+	sim.UpdateStatus(map[string]interface{}{
+		"gcode_state":          "FAILED",
+		"print_error":          PrintErrorCancelled,
+		"stg_cur":              -1,
+		"mc_print_stage":       "1",
+		"nozzle_target_temper": 0.0,
+		"bed_target_temper":    0.0,
+	})
+
+	return []report.Report{Reply(req, "success", "")}
+}
+
+func (sim *Simulator) transition(req request.Request, to string, from ...string) []report.Report {
+	sim.mu.Lock()
+	if sim.job == nil || !contains(from, sim.job.state) {
+		state := "IDLE"
+		if sim.job != nil {
+			state = sim.job.state
+		}
+		sim.mu.Unlock()
+		return []report.Report{Reply(req, "fail", fmt.Sprintf("cannot %s while %s", req.Payload.Command, state))}
+	}
+
+	sim.job.state = to
+	sim.mu.Unlock()
+
+	sim.UpdateStatus(map[string]interface{}{"gcode_state": to})
+
+	return []report.Report{Reply(req, "success", "")}
+}
+
+func (sim *Simulator) handlePrintSpeed(req request.Request) []report.Report {
+	param, _ := req.Payload.Params["param"].(string)
+	level, err := strconv.Atoi(param)
+	if _, ok := speedFactors[level]; err != nil || !ok {
+		return []report.Report{Reply(req, "fail", fmt.Sprintf("invalid speed level %q", param))}
+	}
+
+	sim.mu.Lock()
+	sim.speed = level
+	sim.mu.Unlock()
+
+	sim.UpdateStatus(map[string]interface{}{
+		"spd_lvl": level,
+		"spd_mag": int(speedFactors[level] * 100),
+	})
+
+	return []report.Report{Reply(req, "success", "")}
+}
+
+func (sim *Simulator) handleFilamentSetting(req request.Request) []report.Report {
+	amsID := fmt.Sprint(req.Payload.Params["ams_id"])
+	trayID := fmt.Sprint(req.Payload.Params["tray_id"])
+
+	setting := map[string]interface{}{
+		"tray_info_idx":   req.Payload.Params["tray_info_idx"],
+		"tray_type":       req.Payload.Params["tray_type"],
+		"tray_color":      req.Payload.Params["tray_color"],
+		"nozzle_temp_min": fmt.Sprint(req.Payload.Params["nozzle_temp_min"]),
+		"nozzle_temp_max": fmt.Sprint(req.Payload.Params["nozzle_temp_max"]),
+	}
+
+	if amsID == "255" {
+		sim.UpdateStatus(map[string]interface{}{"vt_tray": setting})
+		return []report.Report{Reply(req, "success", "")}
+	}
+
+	// Lists are replaced rather than merged, so the whole AMS list is copied
+	// and sent with the updated tray.
+	ams, _ := sim.Status()["ams"].(map[string]interface{})
+	units, _ := ams["ams"].([]interface{})
+
+	found := false
+	updated := make([]interface{}, 0, len(units))
+	for _, item := range units {
+		unit := copyMap(item.(map[string]interface{}))
+		trays, _ := unit["tray"].([]interface{})
+
+		updatedTrays := make([]interface{}, 0, len(trays))
+		for _, trayItem := range trays {
+			tray := copyMap(trayItem.(map[string]interface{}))
+			if unit["id"] == amsID && tray["id"] == trayID {
+				mergeMap(tray, setting)
+				found = true
+			}
+			updatedTrays = append(updatedTrays, tray)
+		}
+
+		unit["tray"] = updatedTrays
+		updated = append(updated, unit)
+	}
+
+	if !found {
+		return []report.Report{Reply(req, "fail", fmt.Sprintf("AMS %s tray %s not found", amsID, trayID))}
+	}
+
+	sim.UpdateStatus(map[string]interface{}{"ams": map[string]interface{}{"ams": updated}})
+
+	return []report.Report{Reply(req, "success", "")}
+}
+
+func (sim *Simulator) handleChangeFilament(req request.Request) []report.Report {
+	target := fmt.Sprint(req.Payload.Params["target"])
+
+	sim.UpdateStatus(map[string]interface{}{
+		"ams": map[string]interface{}{
+			"tray_now": target,
+			"tray_tar": target,
+		},
+	})
+
+	return []report.Report{Reply(req, "success", "")}
+}
+
+func (sim *Simulator) handleLEDControl(req request.Request) []report.Report {
+	node, _ := req.Payload.Params["led_node"].(string)
+	mode, _ := req.Payload.Params["led_mode"].(string)
+
+	lights, _ := sim.Status()["lights_report"].([]interface{})
+
+	found := false
+	updated := make([]interface{}, 0, len(lights)+1)
+	for _, item := range lights {
+		light := copyMap(item.(map[string]interface{}))
+		if light["node"] == node {
+			light["mode"] = mode
+			found = true
+		}
+		updated = append(updated, light)
+	}
+	if !found {
+		updated = append(updated, map[string]interface{}{"node": node, "mode": mode})
+	}
+
+	sim.UpdateStatus(map[string]interface{}{"lights_report": updated})
+
+	return []report.Report{Reply(req, "success", "")}
+}
+
+func (sim *Simulator) run() {
+	defer close(sim.done)
+
+	ticker := time.NewTicker(sim.options.TickInterval)
+	defer ticker.Stop()
+
+	dt := time.Duration(float64(sim.options.TickInterval) * sim.options.TimeScale)
+
+	for {
+		select {
+		case <-ticker.C:
+			if delta := sim.step(dt); len(delta) > 0 {
+				sim.UpdateStatus(delta)
+			}
+		case <-sim.stop:
+			return
+		}
+	}
+}
+
+// step advances the simulation by dt of simulated time and returns the
+// changed status fields.
+func (sim *Simulator) step(dt time.Duration) map[string]interface{} {
+	sim.mu.Lock()
+	defer sim.mu.Unlock()
+
+	delta := map[string]interface{}{}
+
+	oldNozzle, oldBed := math.Round(sim.nozzle.temp), math.Round(sim.bed.temp)
+	sim.nozzle.step(dt)
+	sim.bed.step(dt)
+	if math.Round(sim.nozzle.temp) != oldNozzle {
+		delta["nozzle_temper"] = math.Round(sim.nozzle.temp*10) / 10
+	}
+	if math.Round(sim.bed.temp) != oldBed {
+		delta["bed_temper"] = math.Round(sim.bed.temp*10) / 10
+	}
+
+	job := sim.job
+	if job == nil {
+		return delta
+	}
+
+	switch job.state {
+	case "PREPARE":
+		if math.Abs(sim.nozzle.temp-sim.nozzle.target) <= preheatTolerance && math.Abs(sim.bed.temp-sim.bed.target) <= preheatTolerance {
+			job.state = "RUNNING"
+			delta["gcode_state"] = "RUNNING"
+			delta["stg_cur"] = 0
+			delta["cooling_fan_speed"] = "15"
+		}
+	case "RUNNING":
+		sim.advance(job, dt, delta)
+	}
+
+	return delta
+}
+
+func (sim *Simulator) advance(job *simulatedJob, dt time.Duration, delta map[string]interface{}) {
+	job.elapsed += time.Duration(float64(dt) * speedFactors[sim.speed])
+
+	fraction := math.Min(float64(job.elapsed)/float64(sim.options.JobDuration), 1)
+	percent := int(fraction * 100)
+	layer := int(math.Ceil(fraction * float64(sim.options.Layers)))
+	remaining := (sim.options.JobDuration - job.elapsed).Minutes() / speedFactors[sim.speed]
+
+	delta["mc_percent"] = percent
+	delta["layer_num"] = layer
+	delta["mc_remaining_time"] = int(math.Max(math.Ceil(remaining), 0))
+
+	for i, fault := range sim.options.Faults {
+		if sim.faultsHit[i] || percent < fault.AtPercent {
+			continue
+		}
+		sim.faultsHit[i] = true
+
+		sim.hms = append(sim.hms, map[string]interface{}{"attr": fault.Attr, "code": fault.Code})
+		delta["hms"] = append([]interface{}{}, sim.hms...)
+
+		if fault.PrintError != 0 {
+			sim.printErr = fault.PrintError
+			delta["print_error"] = fault.PrintError
+		}
+
+		switch {
+		case fault.Fail:
+			job.state = "FAILED"
+			delta["gcode_state"] = "FAILED"
+			sim.nozzle.target, sim.bed.target = 0, 0
+			return
+		case fault.Pause:
+			job.state = "PAUSE"
+			delta["gcode_state"] = "PAUSE"
+			return
+		}
+	}
+
+	if fraction >= 1 {
+		job.state = "FINISH"
+		sim.nozzle.target, sim.bed.target = 0, 0
+
+		delta["gcode_state"] = "FINISH"
+		delta["stg_cur"] = -1
+		delta["mc_print_stage"] = "1"
+		delta["nozzle_target_temper"] = 0.0
+		delta["bed_target_temper"] = 0.0
+		delta["cooling_fan_speed"] = "0"
+
+		if job.timelapse {
+			sim.saveTimelapse(job)
+		}
+	}
+}
+
+// saveTimelapse stores a placeholder video and thumbnail where the printer
+// keeps its timelapses.
+func (sim *Simulator) saveTimelapse(job *simulatedJob) {
+	name := "video_" + time.Now().Format("2006-01-02_15-04-05")
+	sim.WriteFile(path.Join("/timelapse", name+".mp4"), []byte("timelapse of "+job.name))
+	sim.WriteFile(path.Join("/timelapse/thumbnail", name+".jpg"), []byte{0xFF, 0xD8, 0xFF, 0xD9})
+}
+
+func contains(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,388 @@
+package bambutest
+
+import (
+	"testing"
+	"time"
+
+	"github.com/RobertMNewton/bambu-golang-api/pkg/mqtt/request"
+)
+
+const simulationStep = 10 * time.Second
+
+// newTestSimulator returns a simulator whose clock only moves when the test
+// calls tick.
+func newTestSimulator(t *testing.T, faults ...Fault) *Simulator {
+	t.Helper()
+
+	options := DefaultSimulatorOptions()
+	options.TickInterval = time.Hour
+	options.JobDuration = 10 * time.Minute
+	options.Layers = 20
+	options.Faults = faults
+
+	sim, err := NewSimulator(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { sim.Close() })
+	return sim
+}
+
+// tick runs n simulation steps like the simulation loop does.
+func tick(sim *Simulator, n int) {
+	for i := 0; i < n; i++ {
+		if delta := sim.step(simulationStep); len(delta) > 0 {
+			sim.UpdateStatus(delta)
+		}
+	}
+}
+
+// tickUntil steps the simulation until the job reaches state.
+func tickUntil(t *testing.T, sim *Simulator, state string) {
+	t.Helper()
+
+	for i := 0; i < 1000; i++ {
+		if sim.Status()["gcode_state"] == state {
+			return
+		}
+		tick(sim, 1)
+	}
+	t.Fatalf("gcode_state = %v, want %s", sim.Status()["gcode_state"], state)
+}
+
+func startJob(t *testing.T, sim *Simulator, options request.ProjectFileOptions) {
+	t.Helper()
+
+	sim.WriteFile("/"+options.Filename, []byte("project"))
+	options.URL = "ftp:///" + options.Filename
+
+	reply := sim.handleProjectFile(request.CreateProjectFileRequestWithOptions("1", options))
+	if len(reply) != 1 || reply[0].Payload.Result != "success" {
+		t.Fatalf("project_file reply = %+v", reply)
+	}
+}
+
+func TestSimulatorPrintsJob(t *testing.T) {
+	sim := newTestSimulator(t)
+	startJob(t, sim, request.ProjectFileOptions{Filename: "cube.3mf", Plate: 1})
+
+	status := sim.Status()
+	if status["gcode_state"] != "PREPARE" || status["subtask_name"] != "cube" || status["gcode_file"] != "/cube.3mf" {
+		t.Fatalf("status after project_file = %v", status)
+	}
+	if status["nozzle_target_temper"] != preheatNozzleTemp || status["bed_target_temper"] != preheatBedTemp {
+		t.Errorf("preheat targets = %v, %v", status["nozzle_target_temper"], status["bed_target_temper"])
+	}
+
+	// The job waits for the heaters before it runs
+	tickUntil(t, sim, "RUNNING")
+	status = sim.Status()
+	if nozzle, _ := status["nozzle_temper"].(float64); nozzle < preheatNozzleTemp-preheatTolerance {
+		t.Errorf("running with the nozzle at %v", status["nozzle_temper"])
+	}
+
+	tick(sim, 6)
+	if percent, _ := sim.Status()["mc_percent"].(int); percent != 10 {
+		t.Errorf("mc_percent after a minute = %v, want 10", sim.Status()["mc_percent"])
+	}
+
+	tickUntil(t, sim, "FINISH")
+	status = sim.Status()
+	if status["mc_percent"] != 100 || status["layer_num"] != 20 || status["mc_remaining_time"] != 0 {
+		t.Errorf("status after finishing = %v", status)
+	}
+	if status["nozzle_target_temper"] != 0.0 || status["bed_target_temper"] != 0.0 {
+		t.Errorf("heaters still on after finishing")
+	}
+
+	// A finished printer accepts the next job
+	startJob(t, sim, request.ProjectFileOptions{Filename: "cube.3mf", Plate: 1})
+}
+
+func TestSimulatorGCodeFile(t *testing.T) {
+	sim := newTestSimulator(t)
+	sim.WriteFile("/cache/benchy.gcode", []byte("G28"))
+
+	reply := sim.handleGCodeFile(request.CreateGCodeFileRequest("1", "cache/benchy.gcode"))
+	if reply[0].Payload.Result != "success" {
+		t.Fatalf("gcode_file reply = %+v", reply[0].Payload)
+	}
+	if status := sim.Status(); status["gcode_file"] != "/cache/benchy.gcode" || status["subtask_name"] != "benchy" {
+		t.Errorf("status = %v", status)
+	}
+}
+
+func TestSimulatorRejects(t *testing.T) {
+	tests := []struct {
+		name   string
+		setup  func(t *testing.T, sim *Simulator)
+		handle func(sim *Simulator) string
+	}{
+		{
+			name: "missing file",
+			handle: func(sim *Simulator) string {
+				return sim.handleProjectFile(request.CreateProjectFileRequestWithOptions("1", request.ProjectFileOptions{URL: "ftp:///missing.3mf"}))[0].Payload.Result
+			},
+		},
+		{
+			name:  "busy",
+			setup: func(t *testing.T, sim *Simulator) { startJob(t, sim, request.ProjectFileOptions{Filename: "cube.3mf"}) },
+			handle: func(sim *Simulator) string {
+				return sim.handleProjectFile(request.CreateProjectFileRequestWithOptions("2", request.ProjectFileOptions{URL: "ftp:///cube.3mf"}))[0].Payload.Result
+			},
+		},
+		{
+			name: "pause while idle",
+			handle: func(sim *Simulator) string {
+				return sim.handlePause(request.CreatePausePrintRequest("1"))[0].Payload.Result
+			},
+		},
+		{
+			name:  "resume while running",
+			setup: func(t *testing.T, sim *Simulator) { startJob(t, sim, request.ProjectFileOptions{Filename: "cube.3mf"}) },
+			handle: func(sim *Simulator) string {
+				return sim.handleResume(request.CreateResumePrintRequest("1"))[0].Payload.Result
+			},
+		},
+		{
+			name: "stop while idle",
+			handle: func(sim *Simulator) string {
+				return sim.handleStop(request.CreateStopPrintRequest("1"))[0].Payload.Result
+			},
+		},
+		{
+			name: "invalid speed",
+			handle: func(sim *Simulator) string {
+				return sim.handlePrintSpeed(request.CreatePrintSpeedRequest("1", 7))[0].Payload.Result
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			sim := newTestSimulator(t)
+			if test.setup != nil {
+				test.setup(t, sim)
+			}
+
+			before := sim.Status()["gcode_state"]
+			if result := test.handle(sim); result != "fail" {
+				t.Errorf("result = %q, want fail", result)
+			}
+			if after := sim.Status()["gcode_state"]; after != before {
+				t.Errorf("gcode_state changed from %v to %v", before, after)
+			}
+		})
+	}
+}
+
+func TestSimulatorPauseResumeStop(t *testing.T) {
+	sim := newTestSimulator(t)
+	startJob(t, sim, request.ProjectFileOptions{Filename: "cube.3mf"})
+	tickUntil(t, sim, "RUNNING")
+	tick(sim, 3)
+
+	if reply := sim.handlePause(request.CreatePausePrintRequest("1")); reply[0].Payload.Result != "success" {
+		t.Fatalf("pause reply = %+v", reply[0].Payload)
+	}
+	paused := sim.Status()["mc_percent"]
+	tick(sim, 10)
+	if sim.Status()["gcode_state"] != "PAUSE" || sim.Status()["mc_percent"] != paused {
+		t.Errorf("paused job moved on to %v at %v%%", sim.Status()["gcode_state"], sim.Status()["mc_percent"])
+	}
+
+	if reply := sim.handleResume(request.CreateResumePrintRequest("2")); reply[0].Payload.Result != "success" {
+		t.Fatalf("resume reply = %+v", reply[0].Payload)
+	}
+	tick(sim, 1)
+	if sim.Status()["gcode_state"] != "RUNNING" || sim.Status()["mc_percent"] == paused {
+		t.Errorf("resumed job at %v, %v%%", sim.Status()["gcode_state"], sim.Status()["mc_percent"])
+	}
+
+	if reply := sim.handleStop(request.CreateStopPrintRequest("3")); reply[0].Payload.Result != "success" {
+		t.Fatalf("stop reply = %+v", reply[0].Payload)
+	}
+	if status := sim.Status(); status["gcode_state"] != "FAILED" || status["print_error"] != PrintErrorCancelled {
+		t.Errorf("status after stop = %v", status)
+	}
+}
+
+func TestSimulatorSpeed(t *testing.T) {
+	progress := func(level int) int {
+		sim := newTestSimulator(t)
+		startJob(t, sim, request.ProjectFileOptions{Filename: "cube.3mf"})
+
+		reply := sim.handlePrintSpeed(request.CreatePrintSpeedRequest("1", level))
+		if reply[0].Payload.Result != "success" {
+			t.Fatalf("print_speed reply = %+v", reply[0].Payload)
+		}
+		if status := sim.Status(); status["spd_lvl"] != level || status["spd_mag"] != int(speedFactors[level]*100) {
+			t.Errorf("speed status = %v, %v", status["spd_lvl"], status["spd_mag"])
+		}
+
+		tickUntil(t, sim, "RUNNING")
+		tick(sim, 6)
+		return sim.Status()["mc_percent"].(int)
+	}
+
+	standard, ludicrous := progress(2), progress(4)
+	if ludicrous <= standard {
+		t.Errorf("ludicrous speed reached %d%%, standard %d%%", ludicrous, standard)
+	}
+}
+
+func TestSimulatorTemperatureGCode(t *testing.T) {
+	sim := newTestSimulator(t)
+
+	reply := sim.handleGCodeLine(request.CreateGCodeLineRequest("1", "G28\nM104 S200\nM140 S60 ; bed"))
+	if reply[0].Payload.Result != "success" {
+		t.Fatalf("gcode_line reply = %+v", reply[0].Payload)
+	}
+
+	status := sim.Status()
+	if status["nozzle_target_temper"] != 200.0 || status["bed_target_temper"] != 60.0 {
+		t.Fatalf("targets = %v, %v", status["nozzle_target_temper"], status["bed_target_temper"])
+	}
+
+	tick(sim, 60)
+	status = sim.Status()
+	if nozzle, _ := status["nozzle_temper"].(float64); nozzle < 199 || nozzle > 200 {
+		t.Errorf("nozzle_temper = %v, want about 200", status["nozzle_temper"])
+	}
+	if bed, _ := status["bed_temper"].(float64); bed < 59 || bed > 60 {
+		t.Errorf("bed_temper = %v, want about 60", status["bed_temper"])
+	}
+
+	sim.handleGCodeLine(request.CreateGCodeLineRequest("2", "M104 S0"))
+	tick(sim, 60)
+	if nozzle, _ := sim.Status()["nozzle_temper"].(float64); nozzle > ambientTemp+1 {
+		t.Errorf("nozzle did not cool down: %v", nozzle)
+	}
+}
+
+func TestSimulatorFaults(t *testing.T) {
+	tests := []struct {
+		name      string
+		fault     Fault
+		wantState string
+	}{
+		{name: "pause", fault: Fault{AtPercent: 30, Attr: 0x07008000, Code: 0x00020001, Pause: true}, wantState: "PAUSE"},
+		{name: "fail", fault: Fault{AtPercent: 50, Attr: 0x03008000, Code: 0x00010001, PrintError: 0x0300800A, Fail: true}, wantState: "FAILED"},
+		{name: "warning", fault: Fault{AtPercent: 10, Attr: 0x05008000, Code: 0x00030001}, wantState: "FINISH"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			sim := newTestSimulator(t, test.fault)
+			startJob(t, sim, request.ProjectFileOptions{Filename: "cube.3mf"})
+			tickUntil(t, sim, test.wantState)
+
+			status := sim.Status()
+			if test.wantState != "FINISH" {
+				if percent, _ := status["mc_percent"].(int); percent < test.fault.AtPercent || percent > test.fault.AtPercent+5 {
+					t.Errorf("stopped at %d%%, fault at %d%%", percent, test.fault.AtPercent)
+				}
+			}
+
+			hms, _ := status["hms"].([]interface{})
+			if len(hms) != 1 {
+				t.Fatalf("hms = %v", status["hms"])
+			}
+			if entry := hms[0].(map[string]interface{}); entry["attr"] != test.fault.Attr || entry["code"] != test.fault.Code {
+				t.Errorf("hms entry = %v", entry)
+			}
+			if test.fault.PrintError != 0 && status["print_error"] != test.fault.PrintError {
+				t.Errorf("print_error = %v, want %#x", status["print_error"], test.fault.PrintError)
+			}
+
+			sim.ClearHMS()
+			if status := sim.Status(); len(status["hms"].([]interface{})) != 0 || status["print_error"] != 0 {
+				t.Errorf("status after ClearHMS = %v, %v", status["hms"], status["print_error"])
+			}
+		})
+	}
+}
+
+func TestSimulatorRaiseHMS(t *testing.T) {
+	sim := newTestSimulator(t)
+
+	sim.RaiseHMS(0x07008000, 0x00020001)
+	sim.RaiseHMS(0x12008000, 0x00020002)
+
+	hms, _ := sim.Status()["hms"].([]interface{})
+	if len(hms) != 2 {
+		t.Fatalf("hms = %v", sim.Status()["hms"])
+	}
+}
+
+func TestSimulatorTimelapse(t *testing.T) {
+	sim := newTestSimulator(t)
+	startJob(t, sim, request.ProjectFileOptions{Filename: "cube.3mf", SubtaskName: "Cube", Timelapse: true})
+	tickUntil(t, sim, "FINISH")
+
+	entries, ok := sim.files.list("/timelapse")
+	if !ok {
+		t.Fatal("no timelapse directory")
+	}
+
+	var videos int
+	for _, entry := range entries {
+		if !entry.dir {
+			videos++
+			if data, _ := sim.ReadFile("/timelapse/" + entry.name); string(data) != "timelapse of Cube" {
+				t.Errorf("video %s = %q", entry.name, data)
+			}
+		}
+	}
+	if videos != 1 {
+		t.Errorf("found %d videos, want 1", videos)
+	}
+}
+
+func TestSimulatorFilamentSetting(t *testing.T) {
+	sim := newTestSimulator(t)
+
+	reply := sim.handleFilamentSetting(request.PrintAmsFilamentSettingRequest("1", 0, 3, "GFA00", "FF0000FF", "PLA", 190, 230))
+	if reply[0].Payload.Result != "success" {
+		t.Fatalf("ams_filament_setting reply = %+v", reply[0].Payload)
+	}
+
+	ams := sim.Status()["ams"].(map[string]interface{})
+	unit := ams["ams"].([]interface{})[0].(map[string]interface{})
+	tray := unit["tray"].([]interface{})[3].(map[string]interface{})
+	if tray["tray_type"] != "PLA" || tray["tray_color"] != "FF0000FF" || tray["nozzle_temp_max"] != "230" {
+		t.Errorf("tray 3 = %v", tray)
+	}
+
+	reply = sim.handleFilamentSetting(request.PrintAmsFilamentSettingRequest("2", 1, 0, "GFA00", "FF0000FF", "PLA", 190, 230))
+	if reply[0].Payload.Result != "fail" {
+		t.Errorf("set a tray of a missing AMS: %+v", reply[0].Payload)
+	}
+
+	reply = sim.handleChangeFilament(request.CreateAMSChangeFilamentRequest("3", 3, 220, 230))
+	if reply[0].Payload.Result != "success" || sim.Status()["ams"].(map[string]interface{})["tray_now"] != "3" {
+		t.Errorf("tray_now = %v", sim.Status()["ams"].(map[string]interface{})["tray_now"])
+	}
+}
+
+func TestSimulatorLEDControl(t *testing.T) {
+	sim := newTestSimulator(t)
+
+	for _, req := range []request.Request{
+		request.CreateLEDControlRequest("1", "chamber_light", "off", 0, 0, 0, 0),
+		request.CreateLEDControlRequest("2", "work_light", "on", 0, 0, 0, 0),
+	} {
+		if reply := sim.handleLEDControl(req); reply[0].Payload.Result != "success" {
+			t.Fatalf("ledctrl reply = %+v", reply[0].Payload)
+		}
+	}
+
+	modes := map[interface{}]interface{}{}
+	for _, item := range sim.Status()["lights_report"].([]interface{}) {
+		light := item.(map[string]interface{})
+		modes[light["node"]] = light["mode"]
+	}
+	if modes["chamber_light"] != "off" || modes["work_light"] != "on" {
+		t.Errorf("lights = %v", modes)
+	}
+}
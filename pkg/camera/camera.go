@@ -0,0 +1,219 @@
+// Package camera reads the chamber camera of Bambu Lab printers. P1 and A1
+// series printers send JPEG frames over a TLS connection on port 6000, see
+// Client.
+package camera
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/RobertMNewton/bambu-golang-api/pkg/types/config"
+)
+
+const (
+	cameraUsername = "bblp"
+	connectTimeout = 10 * time.Second
+
+	// The auth packet is a 16 byte header followed by the username and
+	// access code, each NUL padded to 32 bytes
+	authPacketSize  = 80
+	authPayloadSize = 0x40
+	authType        = 0x3000
+	credentialSize  = 32
+
+	// Every frame is preceded by a 16 byte header starting with the little
+	// endian payload size
+	frameHeaderSize = 16
+	maxFrameSize    = 8 << 20
+)
+
+var (
+	jpegStart = []byte{0xFF, 0xD8}
+	jpegEnd   = []byte{0xFF, 0xD9}
+)
+
+// ErrInvalidFrame is returned for frames that are not complete JPEG images.
+var ErrInvalidFrame = errors.New("invalid camera frame")
+
+// Frame is a JPEG image from the camera.
+type Frame struct {
+	Data []byte
+	Time time.Time
+
+	// Sequence counts the frames of a stream, starting at 1
+	Sequence uint64
+}
+
+// Client connects to the JPEG camera stream of P1 and A1 series printers
+// with the printer's access code.
+type Client struct {
+	config config.PrinterConfig
+}
+
+func NewClient(config config.PrinterConfig) *Client {
+	return &Client{
+		config: config,
+	}
+}
+
+// Snapshot returns the next frame from the camera.
+func (client *Client) Snapshot(ctx context.Context) ([]byte, error) {
+	stream, err := client.Open(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer stream.Close()
+
+	frame, err := stream.Next()
+	if err != nil {
+		return nil, err
+	}
+	return frame.Data, nil
+}
+
+// Frames streams frames until the context is cancelled or the connection
+// fails, then closes the channel. Use Open and Stream.Next to see errors.
+func (client *Client) Frames(ctx context.Context) <-chan Frame {
+	frames := make(chan Frame)
+
+	stream, err := client.Open(ctx)
+	if err != nil {
+		close(frames)
+		return frames
+	}
+
+	go func() {
+		defer close(frames)
+		defer stream.Close()
+
+		for {
+			frame, err := stream.Next()
+			if err != nil {
+				return
+			}
+
+			select {
+			case frames <- frame:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return frames
+}
+
+// Stream is an open camera connection.
+type Stream struct {
+	ctx      context.Context
+	conn     *tls.Conn
+	header   [frameHeaderSize]byte
+	sequence uint64
+
+	closeOnce sync.Once
+	stop      chan struct{}
+}
+
+// Open connects and authenticates. The stream is closed when the context is
+// cancelled; the caller must close it when done.
+func (client *Client) Open(ctx context.Context) (*Stream, error) {
+	tlsConfig, err := client.config.CreateLANTLSConfig()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create tls config: %w", err)
+	}
+
+	dialer := &tls.Dialer{Config: tlsConfig}
+
+	dialCtx, cancel := context.WithTimeout(ctx, connectTimeout)
+	defer cancel()
+
+	addr := fmt.Sprintf("%s:%d", client.config.GetDeviceIPAddress(), client.config.GetPort(config.ServiceCamera))
+	conn, err := dialer.DialContext(dialCtx, "tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("camera connection failed: %w", err)
+	}
+
+	stream := &Stream{
+		ctx:  ctx,
+		conn: conn.(*tls.Conn),
+		stop: make(chan struct{}),
+	}
+
+	if _, err := conn.Write(authPacket(client.config.GetDeviceAccessCode())); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("camera authentication failed: %w", err)
+	}
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			stream.Close()
+		case <-stream.stop:
+		}
+	}()
+
+	return stream, nil
+}
+
+func authPacket(accessCode string) []byte {
+	packet := make([]byte, authPacketSize)
+	binary.LittleEndian.PutUint32(packet[0:], authPayloadSize)
+	binary.LittleEndian.PutUint32(packet[4:], authType)
+	copy(packet[16:16+credentialSize], cameraUsername)
+	copy(packet[16+credentialSize:], accessCode)
+	return packet
+}
+
+// Next reads the next frame. The printer closes the connection without a
+// frame if the access code is wrong, which is reported as io.EOF.
+func (stream *Stream) Next() (Frame, error) {
+	if _, err := io.ReadFull(stream.conn, stream.header[:]); err != nil {
+		return Frame{}, stream.readError(err)
+	}
+
+	size := binary.LittleEndian.Uint32(stream.header[0:4])
+	if size > maxFrameSize {
+		return Frame{}, fmt.Errorf("%w: frame size %d exceeds %d bytes", ErrInvalidFrame, size, maxFrameSize)
+	}
+
+	data := make([]byte, size)
+	if _, err := io.ReadFull(stream.conn, data); err != nil {
+		return Frame{}, stream.readError(err)
+	}
+
+	if !bytes.HasPrefix(data, jpegStart) || !bytes.HasSuffix(data, jpegEnd) {
+		return Frame{}, fmt.Errorf("%w: not a JPEG image", ErrInvalidFrame)
+	}
+
+	stream.sequence++
+	return Frame{Data: data, Time: time.Now(), Sequence: stream.sequence}, nil
+}
+
+func (stream *Stream) readError(err error) error {
+	if ctxErr := stream.ctx.Err(); ctxErr != nil {
+		return fmt.Errorf("camera stream closed: %w", ctxErr)
+	}
+
+	select {
+	case <-stream.stop:
+		return fmt.Errorf("camera stream closed: %w", err)
+	default:
+		return fmt.Errorf("failed to read camera frame: %w", err)
+	}
+}
+
+func (stream *Stream) Close() error {
+	var err error
+	stream.closeOnce.Do(func() {
+		close(stream.stop)
+		err = stream.conn.Close()
+	})
+	return err
+}
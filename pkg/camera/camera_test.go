@@ -0,0 +1,215 @@
+package camera_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/RobertMNewton/bambu-golang-api/pkg/bambutest"
+	"github.com/RobertMNewton/bambu-golang-api/pkg/camera"
+	"github.com/RobertMNewton/bambu-golang-api/pkg/types/config"
+)
+
+const timeout = 10 * time.Second
+
+func newServer(t *testing.T, options bambutest.Options) *bambutest.Server {
+	t.Helper()
+
+	if options.CameraInterval == 0 {
+		options.CameraInterval = 20 * time.Millisecond
+	}
+	if options.VideoInterval == 0 {
+		options.VideoInterval = 20 * time.Millisecond
+	}
+
+	server, err := bambutest.NewServerWithOptions(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { server.Close() })
+	return server
+}
+
+// wrongAccessCode returns a config for server with another access code.
+func wrongAccessCode(t *testing.T, server *bambutest.Server) *config.LocalPrinterConfig {
+	t.Helper()
+
+	caPath := filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(caPath, server.CACertPEM(), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := config.NewLocalPrinterConfig(server.DeviceID(), "127.0.0.1", "00000000", caPath)
+	for _, service := range []config.Service{config.ServiceCamera, config.ServiceRTSP} {
+		cfg.SetPort(service, server.Config().GetPort(service))
+	}
+	return &cfg
+}
+
+func waitFor(t *testing.T, condition func() bool, message string) {
+	t.Helper()
+
+	for deadline := time.Now().Add(timeout); !condition(); time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal(message)
+		}
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	server := newServer(t, bambutest.Options{})
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	data, err := camera.NewClient(server.Config()).Snapshot(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, bambutest.DefaultCameraFrames()[0]) {
+		t.Error("snapshot is not the first frame")
+	}
+
+	waitFor(t, func() bool { return server.CameraClients() == 0 }, "snapshot left the connection open")
+}
+
+func TestStream(t *testing.T) {
+	frames := bambutest.DefaultCameraFrames()
+	server := newServer(t, bambutest.Options{CameraFrames: frames})
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	stream, err := camera.NewClient(server.Config()).Open(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stream.Close()
+
+	for i := 0; i < 2*len(frames); i++ {
+		frame, err := stream.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if frame.Sequence != uint64(i+1) || frame.Time.IsZero() {
+			t.Errorf("frame %d: sequence %d at %s", i, frame.Sequence, frame.Time)
+		}
+		if !bytes.Equal(frame.Data, frames[i%len(frames)]) {
+			t.Errorf("frame %d has the wrong image", i)
+		}
+	}
+
+	if err := stream.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := stream.Close(); err != nil {
+		t.Errorf("second Close = %v", err)
+	}
+	if _, err := stream.Next(); err == nil {
+		t.Error("Next succeeded after Close")
+	}
+}
+
+func TestStreamErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		frames  [][]byte
+		config  func(t *testing.T, server *bambutest.Server) config.PrinterConfig
+		wantErr error
+	}{
+		{
+			name:    "wrong access code",
+			config:  func(t *testing.T, server *bambutest.Server) config.PrinterConfig { return wrongAccessCode(t, server) },
+			wantErr: io.EOF,
+		},
+		{
+			name:    "not a jpeg",
+			frames:  [][]byte{[]byte("not an image")},
+			wantErr: camera.ErrInvalidFrame,
+		},
+		{
+			name:    "truncated jpeg",
+			frames:  [][]byte{{0xFF, 0xD8, 0x00}},
+			wantErr: camera.ErrInvalidFrame,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := newServer(t, bambutest.Options{CameraFrames: test.frames})
+
+			cfg := config.PrinterConfig(server.Config())
+			if test.config != nil {
+				cfg = test.config(t, server)
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), timeout)
+			defer cancel()
+
+			_, err := camera.NewClient(cfg).Snapshot(ctx)
+			if !errors.Is(err, test.wantErr) {
+				t.Errorf("Snapshot = %v, want %v", err, test.wantErr)
+			}
+		})
+	}
+}
+
+func TestStreamCancel(t *testing.T) {
+	server := newServer(t, bambutest.Options{})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	stream, err := camera.NewClient(server.Config()).Open(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stream.Close()
+
+	if _, err := stream.Next(); err != nil {
+		t.Fatal(err)
+	}
+
+	cancel()
+	for {
+		if _, err := stream.Next(); err != nil {
+			if !errors.Is(err, context.Canceled) {
+				t.Errorf("Next = %v, want context.Canceled", err)
+			}
+			break
+		}
+	}
+}
+
+func TestFrames(t *testing.T) {
+	server := newServer(t, bambutest.Options{})
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	frames := camera.NewClient(server.Config()).Frames(ctx)
+	for want := uint64(1); want <= 3; want++ {
+		frame, ok := <-frames
+		if !ok {
+			t.Fatal("frames closed early")
+		}
+		if frame.Sequence != want {
+			t.Errorf("sequence = %d, want %d", frame.Sequence, want)
+		}
+	}
+
+	cancel()
+	for range frames {
+	}
+	waitFor(t, func() bool { return server.CameraClients() == 0 }, "connection open after cancel")
+
+	// Connection errors close the channel straight away
+	if _, ok := <-camera.NewClient(wrongAccessCode(t, server)).Frames(context.Background()); ok {
+		t.Error("frame received with the wrong access code")
+	}
+}
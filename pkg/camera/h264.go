@@ -0,0 +1,219 @@
+package camera
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// H.264 NAL unit types, see NALType
+const (
+	NALTypeSlice = 1
+	NALTypeIDR   = 5
+	NALTypeSEI   = 6
+	NALTypeSPS   = 7
+	NALTypePPS   = 8
+
+	// RTP aggregation and fragmentation units, RFC 6184
+	nalTypeSTAPA = 24
+	nalTypeFUA   = 28
+)
+
+var annexBStartCode = []byte{0, 0, 0, 1}
+
+// NALType returns the type of a NAL unit.
+func NALType(nalu []byte) int {
+	if len(nalu) == 0 {
+		return 0
+	}
+	return int(nalu[0] & 0x1F)
+}
+
+// AccessUnit is the set of NAL units making up one video frame.
+type AccessUnit struct {
+	NALUs [][]byte
+
+	// Timestamp is the RTP timestamp in units of 1/90000 s
+	Timestamp uint32
+
+	// Keyframe is set if the frame contains an IDR slice, which can be
+	// decoded without earlier frames
+	Keyframe bool
+}
+
+// AnnexB returns the NAL units with start codes, the format expected by
+// decoders and tools such as ffmpeg.
+func (au AccessUnit) AnnexB() []byte {
+	var size int
+	for _, nalu := range au.NALUs {
+		size += len(annexBStartCode) + len(nalu)
+	}
+
+	buf := make([]byte, 0, size)
+	for _, nalu := range au.NALUs {
+		buf = append(buf, annexBStartCode...)
+		buf = append(buf, nalu...)
+	}
+	return buf
+}
+
+func (au AccessUnit) contains(nalType int) bool {
+	for _, nalu := range au.NALUs {
+		if NALType(nalu) == nalType {
+			return true
+		}
+	}
+	return false
+}
+
+var errInvalidRTP = errors.New("invalid RTP packet")
+
+type rtpPacket struct {
+	marker    bool
+	sequence  uint16
+	timestamp uint32
+	payload   []byte
+}
+
+func parseRTP(data []byte) (rtpPacket, error) {
+	if len(data) < 12 || data[0]>>6 != 2 {
+		return rtpPacket{}, errInvalidRTP
+	}
+
+	packet := rtpPacket{
+		marker:    data[1]&0x80 != 0,
+		sequence:  binary.BigEndian.Uint16(data[2:4]),
+		timestamp: binary.BigEndian.Uint32(data[4:8]),
+	}
+
+	offset := 12 + 4*int(data[0]&0x0F)
+	if data[0]&0x10 != 0 { // header extension
+		if len(data) < offset+4 {
+			return rtpPacket{}, errInvalidRTP
+		}
+		offset += 4 + 4*int(binary.BigEndian.Uint16(data[offset+2:offset+4]))
+	}
+
+	end := len(data)
+	if data[0]&0x20 != 0 { // padding
+		end -= int(data[end-1])
+	}
+
+	if offset > end {
+		return rtpPacket{}, errInvalidRTP
+	}
+
+	packet.payload = data[offset:end]
+	return packet, nil
+}
+
+// depacketizer reassembles H.264 access units from RTP packets carrying
+// single NAL units, STAP-A aggregates and FU-A fragments.
+type depacketizer struct {
+	current  AccessUnit
+	started  bool
+	fragment []byte
+
+	lastSequence uint16
+	haveSequence bool
+}
+
+// push adds a packet and returns the access units it completed. An access
+// unit ends with the marker bit or when the timestamp changes.
+func (d *depacketizer) push(packet rtpPacket) ([]AccessUnit, error) {
+	var done []AccessUnit
+
+	if d.haveSequence && packet.sequence != d.lastSequence+1 {
+		// A lost packet breaks any fragment in progress
+		d.fragment = nil
+	}
+	d.lastSequence = packet.sequence
+	d.haveSequence = true
+
+	if d.started && packet.timestamp != d.current.Timestamp {
+		if au, ok := d.flush(); ok {
+			done = append(done, au)
+		}
+	}
+	if !d.started {
+		d.current = AccessUnit{Timestamp: packet.timestamp}
+		d.started = true
+	}
+
+	if err := d.depacketize(packet.payload); err != nil {
+		// The rest of a fragmented NAL unit cannot be trusted
+		d.fragment = nil
+		return done, err
+	}
+
+	if packet.marker {
+		if au, ok := d.flush(); ok {
+			done = append(done, au)
+		}
+	}
+
+	return done, nil
+}
+
+func (d *depacketizer) depacketize(payload []byte) error {
+	if len(payload) == 0 {
+		return fmt.Errorf("%w: empty payload", errInvalidRTP)
+	}
+
+	switch nalType := NALType(payload); {
+	case nalType >= 1 && nalType <= 23:
+		d.add(payload)
+
+	case nalType == nalTypeSTAPA:
+		for rest := payload[1:]; len(rest) > 0; {
+			if len(rest) < 2 {
+				return fmt.Errorf("%w: truncated STAP-A", errInvalidRTP)
+			}
+			size := int(binary.BigEndian.Uint16(rest))
+			if len(rest) < 2+size {
+				return fmt.Errorf("%w: truncated STAP-A", errInvalidRTP)
+			}
+			d.add(rest[2 : 2+size])
+			rest = rest[2+size:]
+		}
+
+	case nalType == nalTypeFUA:
+		if len(payload) < 2 {
+			return fmt.Errorf("%w: truncated FU-A", errInvalidRTP)
+		}
+		header := payload[1]
+		start, end := header&0x80 != 0, header&0x40 != 0
+
+		if start {
+			d.fragment = append([]byte{payload[0]&0xE0 | header&0x1F}, payload[2:]...)
+		} else if d.fragment != nil {
+			d.fragment = append(d.fragment, payload[2:]...)
+		}
+
+		if end && d.fragment != nil {
+			d.add(d.fragment)
+			d.fragment = nil
+		}
+
+	default:
+		// STAP-B, MTAP and FU-B are not used by the printers
+	}
+
+	return nil
+}
+
+func (d *depacketizer) add(nalu []byte) {
+	d.current.NALUs = append(d.current.NALUs, append([]byte(nil), nalu...))
+	if NALType(nalu) == NALTypeIDR {
+		d.current.Keyframe = true
+	}
+}
+
+func (d *depacketizer) flush() (AccessUnit, bool) {
+	au := d.current
+	d.current = AccessUnit{}
+	d.started = false
+	d.fragment = nil
+
+	return au, len(au.NALUs) > 0
+}
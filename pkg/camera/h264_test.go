@@ -0,0 +1,205 @@
+package camera
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"testing"
+)
+
+func rtp(marker bool, sequence uint16, timestamp uint32, payload []byte) rtpPacket {
+	return rtpPacket{marker: marker, sequence: sequence, timestamp: timestamp, payload: payload}
+}
+
+func TestParseRTP(t *testing.T) {
+	header := func(first byte) []byte {
+		return []byte{first, 0x80 | 96, 0x01, 0x02, 0, 0, 0x03, 0xE8, 0, 0, 0x0B, 0xB1}
+	}
+
+	tests := []struct {
+		name    string
+		data    []byte
+		payload []byte
+		wantErr bool
+	}{
+		{name: "plain", data: append(header(0x80), 0x41, 0x01), payload: []byte{0x41, 0x01}},
+		{name: "csrc", data: append(header(0x81), 0, 0, 0, 1, 0x41), payload: []byte{0x41}},
+		{name: "extension", data: append(header(0x90), 0xBE, 0xDE, 0, 1, 1, 2, 3, 4, 0x41), payload: []byte{0x41}},
+		{name: "padding", data: append(header(0xA0), 0x41, 0x01, 0, 0, 3), payload: []byte{0x41, 0x01}},
+		{name: "short", data: header(0x80)[:8], wantErr: true},
+		{name: "version 1", data: append(header(0x40), 0x41), wantErr: true},
+		{name: "truncated extension", data: append(header(0x90), 0xBE), wantErr: true},
+		{name: "padding past header", data: append(header(0xA0), 20), wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			packet, err := parseRTP(test.data)
+			if test.wantErr {
+				if !errors.Is(err, errInvalidRTP) {
+					t.Fatalf("parseRTP = %v, want errInvalidRTP", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !packet.marker || packet.sequence != 0x0102 || packet.timestamp != 1000 {
+				t.Errorf("packet = %+v", packet)
+			}
+			if !bytes.Equal(packet.payload, test.payload) {
+				t.Errorf("payload = %x, want %x", packet.payload, test.payload)
+			}
+		})
+	}
+}
+
+func TestDepacketizer(t *testing.T) {
+	sps := []byte{0x67, 0x42}
+	pps := []byte{0x68, 0xCE}
+	stap := []byte{0x18}
+	for _, nalu := range [][]byte{sps, pps} {
+		stap = binary.BigEndian.AppendUint16(stap, uint16(len(nalu)))
+		stap = append(stap, nalu...)
+	}
+
+	tests := []struct {
+		name    string
+		packets []rtpPacket
+		want    []AccessUnit
+		wantErr bool
+	}{
+		{
+			name:    "single nal units",
+			packets: []rtpPacket{rtp(false, 1, 10, []byte{0x06, 0x01}), rtp(true, 2, 10, []byte{0x41, 0x02})},
+			want:    []AccessUnit{{NALUs: [][]byte{{0x06, 0x01}, {0x41, 0x02}}, Timestamp: 10}},
+		},
+		{
+			name: "stap-a and fu-a",
+			packets: []rtpPacket{
+				rtp(false, 1, 10, stap),
+				rtp(false, 2, 10, []byte{0x7C, 0x85, 0xAA}),
+				rtp(false, 3, 10, []byte{0x7C, 0x05, 0xBB}),
+				rtp(true, 4, 10, []byte{0x7C, 0x45, 0xCC}),
+			},
+			want: []AccessUnit{{NALUs: [][]byte{sps, pps, {0x65, 0xAA, 0xBB, 0xCC}}, Timestamp: 10, Keyframe: true}},
+		},
+		{
+			name:    "timestamp change without marker",
+			packets: []rtpPacket{rtp(false, 1, 10, []byte{0x41, 0x01}), rtp(true, 2, 20, []byte{0x41, 0x02})},
+			want: []AccessUnit{
+				{NALUs: [][]byte{{0x41, 0x01}}, Timestamp: 10},
+				{NALUs: [][]byte{{0x41, 0x02}}, Timestamp: 20},
+			},
+		},
+		{
+			name: "lost fragment",
+			packets: []rtpPacket{
+				rtp(false, 1, 10, []byte{0x7C, 0x85, 0xAA}),
+				rtp(false, 3, 10, []byte{0x7C, 0x45, 0xCC}),
+				rtp(true, 4, 10, []byte{0x41, 0x02}),
+			},
+			want: []AccessUnit{{NALUs: [][]byte{{0x41, 0x02}}, Timestamp: 10}},
+		},
+		{name: "empty payload", packets: []rtpPacket{rtp(true, 1, 10, nil)}, wantErr: true},
+		{name: "truncated stap-a", packets: []rtpPacket{rtp(true, 1, 10, []byte{0x18, 0x00, 0x05, 0x67})}, wantErr: true},
+		{name: "truncated fu-a", packets: []rtpPacket{rtp(true, 1, 10, []byte{0x7C})}, wantErr: true},
+		{
+			name:    "bad payload after a frame",
+			packets: []rtpPacket{rtp(false, 1, 10, []byte{0x41, 0x01}), rtp(false, 2, 20, nil), rtp(true, 3, 20, []byte{0x41, 0x02})},
+			want: []AccessUnit{
+				{NALUs: [][]byte{{0x41, 0x01}}, Timestamp: 10},
+				{NALUs: [][]byte{{0x41, 0x02}}, Timestamp: 20},
+			},
+			wantErr: true,
+		},
+		{
+			name: "bad fragment",
+			packets: []rtpPacket{
+				rtp(false, 1, 10, []byte{0x7C, 0x85, 0xAA}),
+				rtp(false, 2, 10, []byte{0x7C}),
+				rtp(false, 3, 10, []byte{0x7C, 0x45, 0xCC}),
+				rtp(true, 4, 10, []byte{0x41, 0x02}),
+			},
+			want:    []AccessUnit{{NALUs: [][]byte{{0x41, 0x02}}, Timestamp: 10}},
+			wantErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var d depacketizer
+			var got []AccessUnit
+			var failed bool
+			for _, packet := range test.packets {
+				units, err := d.push(packet)
+				if err != nil {
+					if !test.wantErr || !errors.Is(err, errInvalidRTP) {
+						t.Fatalf("push = %v", err)
+					}
+					failed = true
+				}
+				got = append(got, units...)
+			}
+			if test.wantErr && !failed {
+				t.Fatal("no error")
+			}
+
+			if len(got) != len(test.want) {
+				t.Fatalf("got %d access units, want %d", len(got), len(test.want))
+			}
+			for i := range got {
+				if !bytes.Equal(got[i].AnnexB(), test.want[i].AnnexB()) || got[i].Timestamp != test.want[i].Timestamp || got[i].Keyframe != test.want[i].Keyframe {
+					t.Errorf("access unit %d = %+v, want %+v", i, got[i], test.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestParseSDP(t *testing.T) {
+	tests := []struct {
+		name    string
+		sdp     string
+		control string
+		wantSPS bool
+		wantErr error
+	}{
+		{
+			name:    "h264",
+			sdp:     "v=0\r\nm=video 0 RTP/AVP 96\r\na=rtpmap:96 H264/90000\r\na=fmtp:96 packetization-mode=1;sprop-parameter-sets=Z0IAHg==,aM48gA==\r\na=control:track1\r\n",
+			control: "track1",
+			wantSPS: true,
+		},
+		{
+			name:    "audio first",
+			sdp:     "v=0\nm=audio 0 RTP/AVP 97\na=rtpmap:97 MPEG4-GENERIC/48000\na=control:track2\nm=video 0 RTP/AVP 96\na=rtpmap:96 h264/90000\na=control:track1\n",
+			control: "track1",
+		},
+		{
+			name:    "no video",
+			sdp:     "v=0\nm=audio 0 RTP/AVP 97\na=rtpmap:97 MPEG4-GENERIC/48000\n",
+			wantErr: ErrNoVideo,
+		},
+		{
+			name:    "other codec",
+			sdp:     "v=0\nm=video 0 RTP/AVP 96\na=rtpmap:96 H265/90000\n",
+			wantErr: ErrNoVideo,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			track, err := parseSDP([]byte(test.sdp))
+			if !errors.Is(err, test.wantErr) {
+				t.Fatalf("parseSDP = %v, want %v", err, test.wantErr)
+			}
+			if track.control != test.control {
+				t.Errorf("control = %q, want %q", track.control, test.control)
+			}
+			if (track.sps != nil && track.pps != nil) != test.wantSPS {
+				t.Errorf("parameter sets = %x, %x", track.sps, track.pps)
+			}
+		})
+	}
+}
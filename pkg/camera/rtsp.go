@@ -0,0 +1,686 @@
+package camera
+
+import (
+	"bufio"
+	"context"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/RobertMNewton/bambu-golang-api/pkg/types/config"
+)
+
+const (
+	rtspPath      = "/streaming/live/1"
+	rtspUserAgent = "bambu-golang-api"
+
+	// defaultSessionTimeout applies when SETUP does not announce one
+	defaultSessionTimeout = 60 * time.Second
+
+	maxRTSPBody = 64 << 10
+)
+
+// ErrNoVideo is returned when the stream has no H.264 video track.
+var ErrNoVideo = errors.New("stream has no H.264 video track")
+
+// RTSPError is a non-success RTSP response.
+type RTSPError struct {
+	Method     string
+	StatusCode int
+	Status     string
+}
+
+func (err *RTSPError) Error() string {
+	return fmt.Sprintf("rtsp %s failed: %d %s", err.Method, err.StatusCode, err.Status)
+}
+
+// RTSPClient connects to the RTSPS live view of X1 series printers, served
+// on port 322 with the access code as password. The video is H.264 over
+// RTP, interleaved in the TLS connection.
+type RTSPClient struct {
+	config config.PrinterConfig
+}
+
+func NewRTSPClient(config config.PrinterConfig) *RTSPClient {
+	return &RTSPClient{
+		config: config,
+	}
+}
+
+// URL returns the stream URL without credentials.
+func (client *RTSPClient) URL() string {
+	return fmt.Sprintf("rtsps://%s:%d%s", client.config.GetDeviceIPAddress(), client.config.GetPort(config.ServiceRTSP), rtspPath)
+}
+
+// Keyframe returns the next keyframe with its SPS and PPS as an Annex B
+// byte stream, which can be decoded into an image with any H.264 decoder.
+func (client *RTSPClient) Keyframe(ctx context.Context) ([]byte, error) {
+	session, err := client.Open(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer session.Close()
+
+	for {
+		au, err := session.Next()
+		if err != nil {
+			return nil, err
+		}
+		if !au.Keyframe {
+			continue
+		}
+
+		sps, pps := session.Parameters()
+		if !au.contains(NALTypeSPS) && sps != nil && pps != nil {
+			au.NALUs = append([][]byte{sps, pps}, au.NALUs...)
+		}
+		return au.AnnexB(), nil
+	}
+}
+
+// AccessUnits streams video frames until the context is cancelled or the
+// connection fails, then closes the channel. Use Open and RTSPSession.Next
+// to see errors.
+func (client *RTSPClient) AccessUnits(ctx context.Context) <-chan AccessUnit {
+	units := make(chan AccessUnit)
+
+	session, err := client.Open(ctx)
+	if err != nil {
+		close(units)
+		return units
+	}
+
+	go func() {
+		defer close(units)
+		defer session.Close()
+
+		for {
+			au, err := session.Next()
+			if err != nil {
+				return
+			}
+
+			select {
+			case units <- au:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return units
+}
+
+// RTSPSession is a playing RTSP stream.
+type RTSPSession struct {
+	ctx    context.Context
+	conn   *tls.Conn
+	reader *bufio.Reader
+
+	// writeMu guards requests, which keep-alives send concurrently with
+	// reads
+	writeMu  sync.Mutex
+	cseq     int
+	auth     *rtspAuth
+	session  string
+	baseURL  string
+	channel  byte
+	timeout  time.Duration
+	username string
+	password string
+
+	paramMu sync.Mutex
+	sps     []byte
+	pps     []byte
+
+	depacketizer depacketizer
+	pending      []AccessUnit
+	dropped      atomic.Int64
+
+	closeOnce sync.Once
+	stop      chan struct{}
+}
+
+// Open connects, negotiates the video track and starts playing. The session
+// is closed when the context is cancelled; the caller must close it when
+// done.
+func (client *RTSPClient) Open(ctx context.Context) (*RTSPSession, error) {
+	tlsConfig, err := client.config.CreateLANTLSConfig()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create tls config: %w", err)
+	}
+
+	dialer := &tls.Dialer{Config: tlsConfig}
+
+	dialCtx, cancel := context.WithTimeout(ctx, connectTimeout)
+	defer cancel()
+
+	addr := fmt.Sprintf("%s:%d", client.config.GetDeviceIPAddress(), client.config.GetPort(config.ServiceRTSP))
+	conn, err := dialer.DialContext(dialCtx, "tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("rtsp connection failed: %w", err)
+	}
+
+	session := &RTSPSession{
+		ctx:      ctx,
+		conn:     conn.(*tls.Conn),
+		reader:   bufio.NewReader(conn),
+		username: cameraUsername,
+		password: client.config.GetDeviceAccessCode(),
+		stop:     make(chan struct{}),
+	}
+
+	// Negotiation is bounded by the connect timeout, the stream itself only
+	// by the context
+	conn.SetDeadline(time.Now().Add(connectTimeout))
+	stopCancel := context.AfterFunc(ctx, func() { conn.SetDeadline(time.Now()) })
+	err = session.setup(client.URL())
+	stopCancel()
+	if err != nil {
+		conn.Close()
+		if ctx.Err() != nil {
+			return nil, fmt.Errorf("rtsp connection failed: %w", ctx.Err())
+		}
+		return nil, err
+	}
+	conn.SetDeadline(time.Time{})
+
+	go session.keepAlive(ctx)
+
+	return session, nil
+}
+
+func (session *RTSPSession) setup(url string) error {
+	resp, err := session.request("DESCRIBE", url, map[string]string{"Accept": "application/sdp"})
+	if err != nil {
+		return err
+	}
+
+	session.baseURL = url
+	if base := resp.header.Get("Content-Base"); base != "" {
+		session.baseURL = base
+	}
+
+	track, err := parseSDP(resp.body)
+	if err != nil {
+		return err
+	}
+	session.sps, session.pps = track.sps, track.pps
+
+	resp, err = session.request("SETUP", session.controlURL(track.control), map[string]string{
+		"Transport": "RTP/AVP/TCP;unicast;interleaved=0-1",
+	})
+	if err != nil {
+		return err
+	}
+
+	session.timeout = defaultSessionTimeout
+	id, params, _ := strings.Cut(resp.header.Get("Session"), ";")
+	session.session = strings.TrimSpace(id)
+	if value, ok := strings.CutPrefix(strings.TrimSpace(params), "timeout="); ok {
+		if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+			session.timeout = time.Duration(seconds) * time.Second
+		}
+	}
+
+	for _, part := range strings.Split(resp.header.Get("Transport"), ";") {
+		if value, ok := strings.CutPrefix(part, "interleaved="); ok {
+			first, _, _ := strings.Cut(value, "-")
+			if channel, err := strconv.Atoi(first); err == nil {
+				session.channel = byte(channel)
+			}
+		}
+	}
+
+	_, err = session.request("PLAY", session.baseURL, map[string]string{"Range": "npt=0.000-"})
+	return err
+}
+
+// controlURL resolves the track's control attribute against the base URL.
+func (session *RTSPSession) controlURL(control string) string {
+	switch {
+	case control == "" || control == "*":
+		return session.baseURL
+	case strings.HasPrefix(control, "rtsp://") || strings.HasPrefix(control, "rtsps://"):
+		return control
+	case strings.HasSuffix(session.baseURL, "/"):
+		return session.baseURL + control
+	default:
+		return session.baseURL + "/" + control
+	}
+}
+
+// keepAlive sends OPTIONS well within the session timeout so that the
+// printer does not end the session.
+func (session *RTSPSession) keepAlive(ctx context.Context) {
+	ticker := time.NewTicker(session.timeout / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			// The reply is skipped by Next
+			session.send("OPTIONS", session.baseURL, nil)
+		case <-ctx.Done():
+			session.Close()
+			return
+		case <-session.stop:
+			return
+		}
+	}
+}
+
+// Parameters returns the latest SPS and PPS, from the SDP or the stream.
+func (session *RTSPSession) Parameters() (sps, pps []byte) {
+	session.paramMu.Lock()
+	defer session.paramMu.Unlock()
+
+	return session.sps, session.pps
+}
+
+// Next returns the next video frame. Malformed packets are skipped and
+// counted, see Dropped; only a failure to read the stream ends the session.
+func (session *RTSPSession) Next() (AccessUnit, error) {
+	for len(session.pending) == 0 {
+		channel, data, err := session.readInterleaved()
+		if err != nil {
+			return AccessUnit{}, session.readError(err)
+		}
+		if channel != session.channel {
+			continue // RTCP
+		}
+
+		packet, err := parseRTP(data)
+		if err != nil {
+			session.dropped.Add(1)
+			continue
+		}
+
+		// Frames completed before a bad payload are still delivered
+		units, err := session.depacketizer.push(packet)
+		session.pending = append(session.pending, units...)
+		if err != nil {
+			session.dropped.Add(1)
+		}
+	}
+
+	au := session.pending[0]
+	session.pending = session.pending[1:]
+
+	session.paramMu.Lock()
+	for _, nalu := range au.NALUs {
+		switch NALType(nalu) {
+		case NALTypeSPS:
+			session.sps = nalu
+		case NALTypePPS:
+			session.pps = nalu
+		}
+	}
+	session.paramMu.Unlock()
+
+	return au, nil
+}
+
+// Dropped returns the number of malformed RTP packets Next has skipped.
+func (session *RTSPSession) Dropped() int64 {
+	return session.dropped.Load()
+}
+
+// readInterleaved reads the next $-framed packet, skipping RTSP responses
+// to keep-alives.
+func (session *RTSPSession) readInterleaved() (byte, []byte, error) {
+	for {
+		marker, err := session.reader.Peek(1)
+		if err != nil {
+			return 0, nil, err
+		}
+
+		if marker[0] != '$' {
+			if _, err := readResponse(session.reader); err != nil {
+				return 0, nil, err
+			}
+			continue
+		}
+
+		var header [4]byte
+		if _, err := io.ReadFull(session.reader, header[:]); err != nil {
+			return 0, nil, err
+		}
+
+		data := make([]byte, binary.BigEndian.Uint16(header[2:4]))
+		if _, err := io.ReadFull(session.reader, data); err != nil {
+			return 0, nil, err
+		}
+		return header[1], data, nil
+	}
+}
+
+func (session *RTSPSession) readError(err error) error {
+	if ctxErr := session.ctx.Err(); ctxErr != nil {
+		return fmt.Errorf("rtsp session closed: %w", ctxErr)
+	}
+
+	select {
+	case <-session.stop:
+		return fmt.Errorf("rtsp session closed: %w", err)
+	default:
+		return fmt.Errorf("failed to read rtsp stream: %w", err)
+	}
+}
+
+// Close tears the session down and closes the connection.
+func (session *RTSPSession) Close() error {
+	var err error
+	session.closeOnce.Do(func() {
+		close(session.stop)
+
+		session.conn.SetWriteDeadline(time.Now().Add(time.Second))
+		session.send("TEARDOWN", session.baseURL, nil)
+
+		err = session.conn.Close()
+	})
+	return err
+}
+
+type rtspResponse struct {
+	statusCode int
+	status     string
+	header     textproto.MIMEHeader
+	body       []byte
+}
+
+// request sends a request and reads its response, answering an
+// authentication challenge once.
+func (session *RTSPSession) request(method, url string, headers map[string]string) (*rtspResponse, error) {
+	for attempt := 0; ; attempt++ {
+		if err := session.send(method, url, headers); err != nil {
+			return nil, fmt.Errorf("rtsp %s failed: %w", method, err)
+		}
+
+		resp, err := readResponse(session.reader)
+		if err != nil {
+			return nil, fmt.Errorf("rtsp %s failed: %w", method, err)
+		}
+
+		if resp.statusCode == 401 && attempt == 0 {
+			auth, err := parseChallenge(resp.header.Values("WWW-Authenticate"), session.username, session.password)
+			if err != nil {
+				return nil, fmt.Errorf("rtsp %s failed: %w", method, err)
+			}
+			session.auth = auth
+			continue
+		}
+
+		if resp.statusCode != 200 {
+			return nil, &RTSPError{Method: method, StatusCode: resp.statusCode, Status: resp.status}
+		}
+		return resp, nil
+	}
+}
+
+func (session *RTSPSession) send(method, url string, headers map[string]string) error {
+	session.writeMu.Lock()
+	defer session.writeMu.Unlock()
+
+	session.cseq++
+
+	var req strings.Builder
+	fmt.Fprintf(&req, "%s %s RTSP/1.0\r\n", method, url)
+	fmt.Fprintf(&req, "CSeq: %d\r\n", session.cseq)
+	fmt.Fprintf(&req, "User-Agent: %s\r\n", rtspUserAgent)
+	if session.session != "" {
+		fmt.Fprintf(&req, "Session: %s\r\n", session.session)
+	}
+	if session.auth != nil {
+		fmt.Fprintf(&req, "Authorization: %s\r\n", session.auth.authorization(method, url))
+	}
+	for key, value := range headers {
+		fmt.Fprintf(&req, "%s: %s\r\n", key, value)
+	}
+	req.WriteString("\r\n")
+
+	_, err := io.WriteString(session.conn, req.String())
+	return err
+}
+
+func readResponse(reader *bufio.Reader) (*rtspResponse, error) {
+	tp := textproto.NewReader(reader)
+
+	line, err := tp.ReadLine()
+	if err != nil {
+		return nil, err
+	}
+
+	proto, rest, _ := strings.Cut(line, " ")
+	code, status, _ := strings.Cut(rest, " ")
+	statusCode, err := strconv.Atoi(code)
+	if !strings.HasPrefix(proto, "RTSP/") || err != nil {
+		return nil, fmt.Errorf("malformed rtsp status line %q", line)
+	}
+
+	header, err := tp.ReadMIMEHeader()
+	if err != nil {
+		return nil, fmt.Errorf("malformed rtsp header: %w", err)
+	}
+
+	resp := &rtspResponse{statusCode: statusCode, status: status, header: header}
+
+	if value := header.Get("Content-Length"); value != "" {
+		length, err := strconv.Atoi(value)
+		if err != nil || length < 0 || length > maxRTSPBody {
+			return nil, fmt.Errorf("invalid rtsp content length %q", value)
+		}
+		resp.body = make([]byte, length)
+		if _, err := io.ReadFull(reader, resp.body); err != nil {
+			return nil, err
+		}
+	}
+
+	return resp, nil
+}
+
+// rtspAuth answers Basic and Digest challenges, RFC 2617.
+type rtspAuth struct {
+	username string
+	password string
+
+	digest bool
+	realm  string
+	nonce  string
+	opaque string
+	qop    string
+	nc     int
+}
+
+func parseChallenge(challenges []string, username, password string) (*rtspAuth, error) {
+	var basic *rtspAuth
+
+	for _, challenge := range challenges {
+		scheme, params, _ := strings.Cut(challenge, " ")
+
+		switch strings.ToLower(scheme) {
+		case "digest":
+			auth := &rtspAuth{username: username, password: password, digest: true}
+			for key, value := range parseAuthParams(params) {
+				switch key {
+				case "realm":
+					auth.realm = value
+				case "nonce":
+					auth.nonce = value
+				case "opaque":
+					auth.opaque = value
+				case "qop":
+					for _, qop := range strings.Split(value, ",") {
+						if strings.TrimSpace(qop) == "auth" {
+							auth.qop = "auth"
+						}
+					}
+				}
+			}
+			return auth, nil
+		case "basic":
+			basic = &rtspAuth{username: username, password: password}
+		}
+	}
+
+	if basic != nil {
+		return basic, nil
+	}
+	return nil, fmt.Errorf("unsupported authentication challenge %q", strings.Join(challenges, ", "))
+}
+
+func parseAuthParams(params string) map[string]string {
+	values := make(map[string]string)
+
+	for params = strings.TrimSpace(params); params != ""; {
+		key, rest, ok := strings.Cut(params, "=")
+		if !ok {
+			break
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		rest = strings.TrimSpace(rest)
+
+		var value string
+		if strings.HasPrefix(rest, `"`) {
+			end := strings.Index(rest[1:], `"`)
+			if end < 0 {
+				break
+			}
+			value, rest = rest[1:end+1], rest[end+2:]
+		} else {
+			value, rest, _ = strings.Cut(rest, ",")
+		}
+
+		values[key] = strings.TrimSpace(value)
+		params = strings.TrimLeft(strings.TrimSpace(rest), ", ")
+	}
+
+	return values
+}
+
+func (auth *rtspAuth) authorization(method, uri string) string {
+	if !auth.digest {
+		credentials := base64.StdEncoding.EncodeToString([]byte(auth.username + ":" + auth.password))
+		return "Basic " + credentials
+	}
+
+	ha1 := md5Hex(auth.username + ":" + auth.realm + ":" + auth.password)
+	ha2 := md5Hex(method + ":" + uri)
+
+	header := fmt.Sprintf(`Digest username="%s", realm="%s", nonce="%s", uri="%s"`, auth.username, auth.realm, auth.nonce, uri)
+
+	if auth.qop == "auth" {
+		auth.nc++
+		nc := fmt.Sprintf("%08x", auth.nc)
+		cnonce := randomNonce()
+		response := md5Hex(ha1 + ":" + auth.nonce + ":" + nc + ":" + cnonce + ":auth:" + ha2)
+		header += fmt.Sprintf(`, qop=auth, nc=%s, cnonce="%s", response="%s"`, nc, cnonce, response)
+	} else {
+		header += fmt.Sprintf(`, response="%s"`, md5Hex(ha1+":"+auth.nonce+":"+ha2))
+	}
+
+	if auth.opaque != "" {
+		header += fmt.Sprintf(`, opaque="%s"`, auth.opaque)
+	}
+	return header
+}
+
+func md5Hex(s string) string {
+	sum := md5.Sum([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+func randomNonce() string {
+	buf := make([]byte, 8)
+	rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
+
+type sdpTrack struct {
+	control string
+	sps     []byte
+	pps     []byte
+}
+
+// parseSDP finds the first H.264 video track of a session description.
+func parseSDP(body []byte) (sdpTrack, error) {
+	var (
+		track       sdpTrack
+		inVideo     bool
+		found       bool
+		payloadType string
+	)
+
+	for _, line := range strings.Split(string(body), "\n") {
+		line = strings.TrimSpace(line)
+
+		if media, ok := strings.CutPrefix(line, "m="); ok {
+			if found {
+				break
+			}
+			track = sdpTrack{}
+			fields := strings.Fields(media)
+			inVideo = len(fields) >= 4 && fields[0] == "video"
+			if inVideo {
+				payloadType = fields[3]
+			}
+			continue
+		}
+		if !inVideo {
+			continue
+		}
+
+		attr, ok := strings.CutPrefix(line, "a=")
+		if !ok {
+			continue
+		}
+		name, value, _ := strings.Cut(attr, ":")
+
+		switch name {
+		case "rtpmap":
+			pt, codec, _ := strings.Cut(value, " ")
+			if pt == payloadType && strings.HasPrefix(strings.ToUpper(codec), "H264/") {
+				found = true
+			}
+		case "control":
+			track.control = value
+		case "fmtp":
+			_, params, _ := strings.Cut(value, " ")
+			for _, param := range strings.Split(params, ";") {
+				sets, ok := strings.CutPrefix(strings.TrimSpace(param), "sprop-parameter-sets=")
+				if !ok {
+					continue
+				}
+				for _, set := range strings.Split(sets, ",") {
+					nalu, err := base64.StdEncoding.DecodeString(set)
+					if err != nil {
+						continue
+					}
+					switch NALType(nalu) {
+					case NALTypeSPS:
+						track.sps = nalu
+					case NALTypePPS:
+						track.pps = nalu
+					}
+				}
+			}
+		}
+	}
+
+	if !found {
+		return sdpTrack{}, ErrNoVideo
+	}
+	return track, nil
+}
@@ -0,0 +1,180 @@
+package camera_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/RobertMNewton/bambu-golang-api/pkg/bambutest"
+	"github.com/RobertMNewton/bambu-golang-api/pkg/camera"
+	"github.com/RobertMNewton/bambu-golang-api/pkg/types/config"
+)
+
+func annexB(nalus ...[]byte) []byte {
+	var buf []byte
+	for _, nalu := range nalus {
+		buf = append(buf, 0, 0, 0, 1)
+		buf = append(buf, nalu...)
+	}
+	return buf
+}
+
+func TestRTSPURL(t *testing.T) {
+	server := newServer(t, bambutest.Options{})
+
+	want := fmt.Sprintf("rtsps://127.0.0.1:%d/streaming/live/1", server.Config().GetPort(config.ServiceRTSP))
+	if url := camera.NewRTSPClient(server.Config()).URL(); url != want {
+		t.Errorf("URL = %q, want %q", url, want)
+	}
+}
+
+func TestRTSPSession(t *testing.T) {
+	video := bambutest.DefaultVideo()
+	server := newServer(t, bambutest.Options{Video: video})
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	session, err := camera.NewRTSPClient(server.Config()).Open(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.Close()
+
+	sps, pps := session.Parameters()
+	if !bytes.Equal(sps, video[0][0]) || !bytes.Equal(pps, video[0][1]) {
+		t.Errorf("parameters from the SDP = %x, %x", sps, pps)
+	}
+
+	var last uint32
+	for i := 0; i < len(video)+2; i++ {
+		au, err := session.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		want := video[i%len(video)]
+		if !bytes.Equal(au.AnnexB(), annexB(want...)) {
+			t.Errorf("frame %d: %d NAL units, want %d", i, len(au.NALUs), len(want))
+		}
+		if au.Keyframe != (i%len(video) == 0) {
+			t.Errorf("frame %d: keyframe = %t", i, au.Keyframe)
+		}
+		if i > 0 && au.Timestamp <= last {
+			t.Errorf("frame %d: timestamp %d after %d", i, au.Timestamp, last)
+		}
+		last = au.Timestamp
+	}
+
+	if err := session.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := session.Next(); err == nil {
+		t.Error("Next succeeded after Close")
+	}
+	waitFor(t, func() bool { return server.RTSPClients() == 0 }, "session open after Close")
+}
+
+func TestRTSPBadPackets(t *testing.T) {
+	video := bambutest.DefaultVideo()
+	server := newServer(t, bambutest.Options{Video: video})
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	session, err := camera.NewRTSPClient(server.Config()).Open(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.Close()
+
+	if _, err := session.Next(); err != nil {
+		t.Fatal(err)
+	}
+	server.SendBadVideoPackets()
+
+	for i := 1; i < len(video)+3; i++ {
+		au, err := session.Next()
+		if err != nil {
+			t.Fatalf("frame %d: %v", i, err)
+		}
+		if want := video[i%len(video)]; !bytes.Equal(au.AnnexB(), annexB(want...)) {
+			t.Errorf("frame %d: %d NAL units, want %d", i, len(au.NALUs), len(want))
+		}
+	}
+
+	if dropped := session.Dropped(); dropped != 2 {
+		t.Errorf("Dropped = %d, want 2", dropped)
+	}
+}
+
+func TestRTSPKeyframe(t *testing.T) {
+	sps := []byte{0x67, 0x42, 0x00, 0x1E}
+	pps := []byte{0x68, 0xCE, 0x3C, 0x80}
+	idr := append([]byte{0x65}, bytes.Repeat([]byte{0x11}, 3000)...)
+	slice := []byte{0x41, 0x22, 0x33}
+
+	tests := []struct {
+		name  string
+		video [][][]byte
+	}{
+		{name: "parameter sets in the stream", video: [][][]byte{{slice}, {sps, pps, idr}, {slice}}},
+		{name: "parameter sets in the sdp only", video: [][][]byte{{sps, pps}, {slice}, {idr}}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := newServer(t, bambutest.Options{Video: test.video})
+
+			ctx, cancel := context.WithTimeout(context.Background(), timeout)
+			defer cancel()
+
+			data, err := camera.NewRTSPClient(server.Config()).Keyframe(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(data, annexB(sps, pps, idr)) {
+				t.Errorf("keyframe = %x...", data[:min(len(data), 32)])
+			}
+		})
+	}
+}
+
+func TestRTSPUnauthorized(t *testing.T) {
+	server := newServer(t, bambutest.Options{})
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	_, err := camera.NewRTSPClient(wrongAccessCode(t, server)).Open(ctx)
+
+	var rtspErr *camera.RTSPError
+	if !errors.As(err, &rtspErr) || rtspErr.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("Open = %v, want a 401 RTSPError", err)
+	}
+}
+
+func TestRTSPCancel(t *testing.T) {
+	server := newServer(t, bambutest.Options{})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	units := camera.NewRTSPClient(server.Config()).AccessUnits(ctx)
+	if au, ok := <-units; !ok || !au.Keyframe {
+		t.Fatalf("first frame = %+v, %t", au, ok)
+	}
+
+	cancel()
+	for range units {
+	}
+	waitFor(t, func() bool { return server.RTSPClients() == 0 }, "session open after cancel")
+
+	// Connection errors close the channel straight away
+	if _, ok := <-camera.NewRTSPClient(wrongAccessCode(t, server)).AccessUnits(context.Background()); ok {
+		t.Error("frame received with the wrong access code")
+	}
+}
@@ -0,0 +1,385 @@
+package camera
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	mjpegBoundary = "frame"
+
+	// snapshotMaxAge is how old the latest frame may be to answer a snapshot
+	// without waiting for the next one
+	snapshotMaxAge = 2 * time.Second
+)
+
+type ServerOptions struct {
+	// MaxFPS limits the frames sent to each client, which can ask for less
+	// with ?fps=. Zero sends every frame.
+	MaxFPS float64
+
+	// IdleTimeout is how long the upstream connection is kept after the last
+	// viewer leaves, so that reloading a page does not reconnect.
+	IdleTimeout time.Duration
+
+	// ReconnectDelay is the pause between upstream connection attempts.
+	ReconnectDelay time.Duration
+
+	// SnapshotTimeout bounds the wait for a frame in /snapshot.jpg.
+	SnapshotTimeout time.Duration
+}
+
+func DefaultServerOptions() ServerOptions {
+	return ServerOptions{
+		MaxFPS:          5,
+		IdleTimeout:     10 * time.Second,
+		ReconnectDelay:  2 * time.Second,
+		SnapshotTimeout: 10 * time.Second,
+	}
+}
+
+// frameReader is an open upstream connection, such as a Stream.
+type frameReader interface {
+	Next() (Frame, error)
+	Close() error
+}
+
+// Server re-streams a printer camera to HTTP clients: /stream.mjpg as
+// multipart/x-mixed-replace MJPEG, which browsers show in an <img> tag, and
+// /snapshot.jpg. All clients share one upstream connection, which is only
+// open while someone is watching, since printers accept few camera
+// connections.
+type Server struct {
+	options ServerOptions
+	open    func(ctx context.Context) (frameReader, error)
+	mux     *http.ServeMux
+
+	mu       sync.Mutex
+	viewers  map[chan Frame]struct{}
+	latest   Frame
+	lastErr  error
+	cancel   context.CancelFunc
+	idle     *time.Timer
+	closed   bool
+	upstream sync.WaitGroup
+}
+
+func NewServer(client *Client) *Server {
+	return NewServerWithOptions(client, DefaultServerOptions())
+}
+
+func NewServerWithOptions(client *Client, options ServerOptions) *Server {
+	server := &Server{
+		options: options,
+		open: func(ctx context.Context) (frameReader, error) {
+			return client.Open(ctx)
+		},
+		viewers: make(map[chan Frame]struct{}),
+		mux:     http.NewServeMux(),
+	}
+
+	server.mux.HandleFunc("/stream.mjpg", server.handleStream)
+	server.mux.HandleFunc("/snapshot.jpg", server.handleSnapshot)
+
+	return server
+}
+
+func (server *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	server.mux.ServeHTTP(w, r)
+}
+
+// Viewers returns the number of clients currently receiving frames.
+func (server *Server) Viewers() int {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+
+	return len(server.viewers)
+}
+
+// Close disconnects from the camera and ends all streams.
+func (server *Server) Close() error {
+	server.mu.Lock()
+	server.closed = true
+	server.stopUpstream()
+	for viewer := range server.viewers {
+		close(viewer)
+		delete(server.viewers, viewer)
+	}
+	server.mu.Unlock()
+
+	server.upstream.Wait()
+	return nil
+}
+
+// subscribe adds a viewer and connects upstream if needed. The channel
+// holds the latest frame only, slow viewers skip frames.
+func (server *Server) subscribe() (chan Frame, error) {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+
+	if server.closed {
+		return nil, errors.New("camera server closed")
+	}
+
+	if server.idle != nil {
+		server.idle.Stop()
+		server.idle = nil
+	}
+
+	frames := make(chan Frame, 1)
+	server.viewers[frames] = struct{}{}
+
+	if server.cancel == nil {
+		ctx, cancel := context.WithCancel(context.Background())
+		server.cancel = cancel
+		server.upstream.Add(1)
+		go server.run(ctx)
+	}
+
+	return frames, nil
+}
+
+// unsubscribe removes a viewer and disconnects upstream once no viewer is
+// left for IdleTimeout.
+func (server *Server) unsubscribe(frames chan Frame) {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+
+	delete(server.viewers, frames)
+	if len(server.viewers) > 0 || server.cancel == nil {
+		return
+	}
+
+	if server.options.IdleTimeout <= 0 {
+		server.stopUpstream()
+		return
+	}
+
+	server.idle = time.AfterFunc(server.options.IdleTimeout, func() {
+		server.mu.Lock()
+		defer server.mu.Unlock()
+
+		if len(server.viewers) == 0 {
+			server.stopUpstream()
+		}
+	})
+}
+
+// stopUpstream must be called with mu held.
+func (server *Server) stopUpstream() {
+	if server.idle != nil {
+		server.idle.Stop()
+		server.idle = nil
+	}
+	if server.cancel != nil {
+		server.cancel()
+		server.cancel = nil
+	}
+}
+
+// run keeps the upstream connection open until cancelled, reconnecting
+// after failures.
+func (server *Server) run(ctx context.Context) {
+	defer server.upstream.Done()
+
+	for ctx.Err() == nil {
+		err := server.stream(ctx)
+
+		server.mu.Lock()
+		server.lastErr = err
+		server.mu.Unlock()
+
+		select {
+		case <-time.After(server.options.ReconnectDelay):
+		case <-ctx.Done():
+		}
+	}
+}
+
+func (server *Server) stream(ctx context.Context) error {
+	reader, err := server.open(ctx)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	for {
+		frame, err := reader.Next()
+		if err != nil {
+			return err
+		}
+		server.broadcast(frame)
+	}
+}
+
+func (server *Server) broadcast(frame Frame) {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+
+	server.latest = frame
+	server.lastErr = nil
+
+	for viewer := range server.viewers {
+		select {
+		case <-viewer:
+		default:
+		}
+		viewer <- frame
+	}
+}
+
+// Snapshot returns a recent frame, connecting to the camera if nobody is
+// watching.
+func (server *Server) Snapshot(ctx context.Context) (Frame, error) {
+	server.mu.Lock()
+	latest := server.latest
+	streaming := server.cancel != nil
+	server.mu.Unlock()
+
+	if streaming && time.Since(latest.Time) < snapshotMaxAge {
+		return latest, nil
+	}
+
+	frames, err := server.subscribe()
+	if err != nil {
+		return Frame{}, err
+	}
+	defer server.unsubscribe(frames)
+
+	ctx, cancel := context.WithTimeout(ctx, server.options.SnapshotTimeout)
+	defer cancel()
+
+	select {
+	case frame, ok := <-frames:
+		if !ok {
+			return Frame{}, errors.New("camera server closed")
+		}
+		return frame, nil
+	case <-ctx.Done():
+		server.mu.Lock()
+		lastErr := server.lastErr
+		server.mu.Unlock()
+
+		if lastErr != nil {
+			return Frame{}, fmt.Errorf("camera unavailable: %w", lastErr)
+		}
+		return Frame{}, fmt.Errorf("camera unavailable: %w", ctx.Err())
+	}
+}
+
+func (server *Server) handleSnapshot(w http.ResponseWriter, r *http.Request) {
+	frame, err := server.Snapshot(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusGatewayTimeout)
+		return
+	}
+
+	w.Header().Set("Content-Type", "image/jpeg")
+	w.Header().Set("Content-Length", strconv.Itoa(len(frame.Data)))
+	w.Header().Set("Cache-Control", "no-store")
+	w.Write(frame.Data)
+}
+
+func (server *Server) handleStream(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	interval, err := server.frameInterval(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	frames, err := server.subscribe()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	defer server.unsubscribe(frames)
+
+	w.Header().Set("Content-Type", "multipart/x-mixed-replace; boundary="+mjpegBoundary)
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	var (
+		lastSent time.Time
+		pending  *Frame
+		timer    = time.NewTimer(0)
+	)
+	<-timer.C
+	defer timer.Stop()
+
+	for {
+		select {
+		case frame, ok := <-frames:
+			if !ok {
+				return
+			}
+			if wait := interval - time.Since(lastSent); wait > 0 {
+				// Too early for this client, send the newest frame once
+				// its interval has passed
+				if pending == nil {
+					timer.Reset(wait)
+				}
+				pending = &frame
+				continue
+			}
+			pending = &frame
+		case <-timer.C:
+		case <-r.Context().Done():
+			return
+		}
+
+		if pending == nil {
+			continue
+		}
+		if err := writePart(w, pending.Data); err != nil {
+			return
+		}
+		flusher.Flush()
+
+		lastSent = time.Now()
+		pending = nil
+	}
+}
+
+// frameInterval returns the minimum time between frames for a client.
+func (server *Server) frameInterval(r *http.Request) (time.Duration, error) {
+	fps := server.options.MaxFPS
+
+	if value := r.URL.Query().Get("fps"); value != "" {
+		requested, err := strconv.ParseFloat(value, 64)
+		if err != nil || requested <= 0 {
+			return 0, fmt.Errorf("invalid fps %q", value)
+		}
+		if fps <= 0 || requested < fps {
+			fps = requested
+		}
+	}
+
+	if fps <= 0 {
+		return 0, nil
+	}
+	return time.Duration(float64(time.Second) / fps), nil
+}
+
+func writePart(w http.ResponseWriter, data []byte) error {
+	header := fmt.Sprintf("--%s\r\nContent-Type: image/jpeg\r\nContent-Length: %d\r\n\r\n", mjpegBoundary, len(data))
+	if _, err := w.Write([]byte(header)); err != nil {
+		return err
+	}
+	if _, err := w.Write(data); err != nil {
+		return err
+	}
+	_, err := w.Write([]byte("\r\n"))
+	return err
+}
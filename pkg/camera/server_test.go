@@ -0,0 +1,175 @@
+package camera_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/RobertMNewton/bambu-golang-api/pkg/bambutest"
+	"github.com/RobertMNewton/bambu-golang-api/pkg/camera"
+)
+
+func newCameraServer(t *testing.T, server *bambutest.Server, options camera.ServerOptions) (*camera.Server, *httptest.Server) {
+	t.Helper()
+
+	cameraServer := camera.NewServerWithOptions(camera.NewClient(server.Config()), options)
+	httpServer := httptest.NewServer(cameraServer)
+	t.Cleanup(func() {
+		httpServer.Close()
+		cameraServer.Close()
+	})
+	return cameraServer, httpServer
+}
+
+func TestServerSnapshot(t *testing.T) {
+	server := newServer(t, bambutest.Options{})
+	_, httpServer := newCameraServer(t, server, camera.DefaultServerOptions())
+
+	resp, err := http.Get(httpServer.URL + "/snapshot.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	data, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "image/jpeg" {
+		t.Fatalf("status %d, content type %q", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+	if !bytes.HasPrefix(data, []byte{0xFF, 0xD8}) {
+		t.Error("snapshot is not a JPEG image")
+	}
+}
+
+func TestServerSnapshotUnavailable(t *testing.T) {
+	server := newServer(t, bambutest.Options{})
+
+	options := camera.DefaultServerOptions()
+	options.SnapshotTimeout = 200 * time.Millisecond
+	options.ReconnectDelay = 20 * time.Millisecond
+
+	cameraServer := camera.NewServerWithOptions(camera.NewClient(wrongAccessCode(t, server)), options)
+	defer cameraServer.Close()
+
+	recorder := httptest.NewRecorder()
+	cameraServer.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/snapshot.jpg", nil))
+	if recorder.Code != http.StatusGatewayTimeout {
+		t.Errorf("status = %d, want %d", recorder.Code, http.StatusGatewayTimeout)
+	}
+}
+
+func TestServerStream(t *testing.T) {
+	tests := []struct {
+		name       string
+		query      string
+		wantStatus int
+	}{
+		{name: "default", wantStatus: http.StatusOK},
+		{name: "fps", query: "?fps=2", wantStatus: http.StatusOK},
+		{name: "invalid fps", query: "?fps=fast", wantStatus: http.StatusBadRequest},
+		{name: "zero fps", query: "?fps=0", wantStatus: http.StatusBadRequest},
+	}
+
+	server := newServer(t, bambutest.Options{})
+	_, httpServer := newCameraServer(t, server, camera.DefaultServerOptions())
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), timeout)
+			defer cancel()
+
+			req, _ := http.NewRequestWithContext(ctx, http.MethodGet, httpServer.URL+"/stream.mjpg"+test.query, nil)
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+
+			if resp.StatusCode != test.wantStatus {
+				t.Fatalf("status = %d, want %d", resp.StatusCode, test.wantStatus)
+			}
+			if resp.StatusCode != http.StatusOK {
+				return
+			}
+
+			mediaType, params, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+			if err != nil || mediaType != "multipart/x-mixed-replace" {
+				t.Fatalf("content type = %q", resp.Header.Get("Content-Type"))
+			}
+
+			parts := multipart.NewReader(resp.Body, params["boundary"])
+			for i := 0; i < 2; i++ {
+				part, err := parts.NextPart()
+				if err != nil {
+					t.Fatal(err)
+				}
+				data, _ := io.ReadAll(part)
+				if part.Header.Get("Content-Type") != "image/jpeg" || !bytes.HasPrefix(data, []byte{0xFF, 0xD8}) {
+					t.Errorf("part %d is not a JPEG image", i)
+				}
+			}
+		})
+	}
+}
+
+func TestServerSharesUpstream(t *testing.T) {
+	server := newServer(t, bambutest.Options{})
+
+	options := camera.DefaultServerOptions()
+	options.IdleTimeout = 50 * time.Millisecond
+	cameraServer, httpServer := newCameraServer(t, server, options)
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	var bodies []io.Closer
+	for i := 0; i < 3; i++ {
+		req, _ := http.NewRequestWithContext(ctx, http.MethodGet, httpServer.URL+"/stream.mjpg", nil)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		bodies = append(bodies, resp.Body)
+	}
+
+	waitFor(t, func() bool { return cameraServer.Viewers() == 3 }, "viewers not counted")
+	if clients := server.CameraClients(); clients != 1 {
+		t.Errorf("camera connections = %d, want 1", clients)
+	}
+
+	for _, body := range bodies {
+		body.Close()
+	}
+	waitFor(t, func() bool { return cameraServer.Viewers() == 0 }, "viewers left behind")
+	waitFor(t, func() bool { return server.CameraClients() == 0 }, "upstream open after the idle timeout")
+}
+
+func TestServerClose(t *testing.T) {
+	server := newServer(t, bambutest.Options{})
+	cameraServer, httpServer := newCameraServer(t, server, camera.DefaultServerOptions())
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, httpServer.URL+"/stream.mjpg", nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	cameraServer.Close()
+	if _, err := io.Copy(io.Discard, resp.Body); err != nil {
+		t.Errorf("stream did not end cleanly: %v", err)
+	}
+	waitFor(t, func() bool { return server.CameraClients() == 0 }, "upstream open after Close")
+
+	if _, err := cameraServer.Snapshot(ctx); err == nil {
+		t.Error("Snapshot succeeded after Close")
+	}
+}
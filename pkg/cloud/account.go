@@ -0,0 +1,195 @@
+// Package cloud connects to printers through a Bambu Lab cloud account. An
+// Account logs in once, lists the printers bound to the account and returns
+// ready CloudPrinterConfigs whose MQTT password is refreshed as needed, or
+// ahead of expiry with KeepRefreshed.
+package cloud
+
+import (
+	"context"
+	"crypto/x509"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	bambuhttp "github.com/RobertMNewton/bambu-golang-api/pkg/http"
+	"github.com/RobertMNewton/bambu-golang-api/pkg/types/config"
+)
+
+// tokenTimeout bounds token refreshes made on behalf of the MQTT client,
+// which cannot pass a context.
+const tokenTimeout = 30 * time.Second
+
+type Options struct {
+	// Region selects the global or China cloud, RegionGlobal by default
+	Region config.Region
+
+	// APIURL, WebURL and BrokerURL override the region's hosts, for test
+	// doubles
+	APIURL    string
+	WebURL    string
+	BrokerURL string
+
+	HTTPClient *http.Client
+
+	// RootCAs verifies the broker, the system roots are used when nil
+	RootCAs *x509.CertPool
+}
+
+// Account is a logged in Bambu Lab cloud account.
+type Account struct {
+	options Options
+	client  *bambuhttp.Client
+
+	mu     sync.Mutex
+	userID string
+}
+
+func NewAccount() *Account {
+	return NewAccountWithOptions(Options{})
+}
+
+func NewAccountWithOptions(options Options) *Account {
+	if options.Region == "" {
+		options.Region = config.RegionGlobal
+	}
+	if options.APIURL == "" {
+		options.APIURL = options.Region.APIURL()
+	}
+	if options.WebURL == "" {
+		options.WebURL = options.Region.WebURL()
+	}
+
+	return &Account{
+		options: options,
+		client: bambuhttp.NewClientWithOptions(bambuhttp.Options{
+			BaseURL:    options.APIURL,
+			TFAURL:     options.WebURL,
+			HTTPClient: options.HTTPClient,
+		}),
+	}
+}
+
+// Region returns the cloud region of the account.
+func (account *Account) Region() config.Region {
+	return account.options.Region
+}
+
+// Client returns the API client of the account, for calls such as the print
+// history that are not wrapped by Account.
+func (account *Account) Client() *bambuhttp.Client {
+	return account.client
+}
+
+// Login logs in with an email address and password. If the result asks for
+// a verification or two-factor code, finish with LoginWithCode or
+// LoginWithTFA.
+func (account *Account) Login(ctx context.Context, email, password string) (bambuhttp.LoginResult, error) {
+	return account.client.LoginWithPassword(ctx, email, password)
+}
+
+// SendVerificationCode emails a login code for LoginWithCode.
+func (account *Account) SendVerificationCode(ctx context.Context, email string) error {
+	return account.client.SendVerificationCode(ctx, email)
+}
+
+func (account *Account) LoginWithCode(ctx context.Context, email, code string) error {
+	return account.client.LoginWithCode(ctx, email, code)
+}
+
+func (account *Account) LoginWithTFA(ctx context.Context, tfaKey, code string) error {
+	return account.client.LoginWithTFA(ctx, tfaKey, code)
+}
+
+// Token returns the current token, to be saved and restored with SetToken
+// instead of logging in again.
+func (account *Account) Token() bambuhttp.Token {
+	return account.client.Token()
+}
+
+func (account *Account) SetToken(token bambuhttp.Token) {
+	account.client.SetToken(token)
+}
+
+// AccessToken returns a valid access token, refreshing it if it is about to
+// expire. It makes the account a config.TokenSource.
+func (account *Account) AccessToken() (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), tokenTimeout)
+	defer cancel()
+
+	return account.client.AccessToken(ctx)
+}
+
+// UserID returns the account's user id, which is part of the MQTT username.
+func (account *Account) UserID(ctx context.Context) (string, error) {
+	account.mu.Lock()
+	defer account.mu.Unlock()
+
+	if account.userID != "" {
+		return account.userID, nil
+	}
+
+	profile, err := account.client.Profile(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	account.userID = strconv.FormatInt(profile.UID, 10)
+	return account.userID, nil
+}
+
+// Printers lists the printers bound to the account.
+func (account *Account) Printers(ctx context.Context) ([]bambuhttp.Device, error) {
+	return account.client.Devices(ctx)
+}
+
+// PrinterConfigs returns a config for every printer bound to the account.
+func (account *Account) PrinterConfigs(ctx context.Context) ([]*config.CloudPrinterConfig, error) {
+	userID, err := account.UserID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	devices, err := account.Printers(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	configs := make([]*config.CloudPrinterConfig, 0, len(devices))
+	for _, device := range devices {
+		configs = append(configs, account.printerConfig(userID, device))
+	}
+	return configs, nil
+}
+
+// PrinterConfig returns the config of the printer with the given serial.
+func (account *Account) PrinterConfig(ctx context.Context, serial string) (*config.CloudPrinterConfig, error) {
+	userID, err := account.UserID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	device, err := account.client.Device(ctx, serial)
+	if err != nil {
+		return nil, err
+	}
+
+	return account.printerConfig(userID, device), nil
+}
+
+// printerConfig builds the config of a device. The cloud does not know the
+// printer's LAN address, set it with SetIPAddress to use FTP.
+func (account *Account) printerConfig(userID string, device bambuhttp.Device) *config.CloudPrinterConfig {
+	token := account.client.Token().AccessToken
+
+	cfg := config.NewCloudPrinterConfig(device.Serial, userID, token, "", device.AccessCode)
+	cfg.SetRegion(account.options.Region)
+	cfg.SetTokenSource(account)
+	if account.options.BrokerURL != "" {
+		cfg.SetBrokerURL(account.options.BrokerURL)
+	}
+	if account.options.RootCAs != nil {
+		cfg.SetRootCAs(account.options.RootCAs)
+	}
+	return cfg
+}
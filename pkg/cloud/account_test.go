@@ -0,0 +1,100 @@
+package cloud_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/RobertMNewton/bambu-golang-api/pkg/bambutest"
+	"github.com/RobertMNewton/bambu-golang-api/pkg/cloud"
+	"github.com/RobertMNewton/bambu-golang-api/pkg/types/config"
+)
+
+// newAccount returns an account logged in to fake, whose printers connect
+// to server's broker.
+func newAccount(t *testing.T, fake *bambutest.CloudServer, server *bambutest.Server) *cloud.Account {
+	t.Helper()
+
+	options := cloud.Options{
+		APIURL: fake.URL(),
+		WebURL: fake.URL(),
+	}
+	if server != nil {
+		options.BrokerURL = server.Config().GetBrokerUrl()
+		options.RootCAs = x509Pool(t, server.CACertPEM())
+	}
+
+	account := cloud.NewAccountWithOptions(options)
+	if _, err := account.Login(context.Background(), bambutest.DefaultAccount, bambutest.DefaultPassword); err != nil {
+		t.Fatalf("login failed: %v", err)
+	}
+	return account
+}
+
+func TestPrinterConfigs(t *testing.T) {
+	fake := bambutest.NewCloudServer()
+	defer fake.Close()
+
+	ctx := context.Background()
+	account := newAccount(t, fake, nil)
+
+	configs, err := account.PrinterConfigs(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(configs) != 1 {
+		t.Fatalf("configs = %d, want 1", len(configs))
+	}
+
+	cfg := configs[0]
+	if cfg.GetDeviceID() != bambutest.DefaultDeviceID || cfg.GetDeviceAccessCode() != bambutest.DefaultAccessCode {
+		t.Errorf("config = %s, %s", cfg.GetDeviceID(), cfg.GetDeviceAccessCode())
+	}
+	if cfg.GetUsername() != "u_1234567890" {
+		t.Errorf("username = %q", cfg.GetUsername())
+	}
+	if cfg.GetPassword() != account.Token().AccessToken {
+		t.Error("password is not the access token")
+	}
+	if cfg.GetRegion() != config.RegionGlobal {
+		t.Errorf("region = %q", cfg.GetRegion())
+	}
+
+	if _, err := account.PrinterConfig(ctx, "UNKNOWN"); err == nil {
+		t.Error("PrinterConfig found an unbound printer")
+	}
+}
+
+func TestRegion(t *testing.T) {
+	tests := []struct {
+		region config.Region
+		broker string
+	}{
+		{region: "", broker: "tls://us.mqtt.bambulab.com:8883"},
+		{region: config.RegionGlobal, broker: "tls://us.mqtt.bambulab.com:8883"},
+		{region: config.RegionChina, broker: "tls://cn.mqtt.bambulab.com:8883"},
+	}
+
+	for _, test := range tests {
+		t.Run(string(test.region), func(t *testing.T) {
+			fake := bambutest.NewCloudServer()
+			defer fake.Close()
+
+			account := cloud.NewAccountWithOptions(cloud.Options{
+				Region: test.region,
+				APIURL: fake.URL(),
+				WebURL: fake.URL(),
+			})
+			if _, err := account.Login(context.Background(), bambutest.DefaultAccount, bambutest.DefaultPassword); err != nil {
+				t.Fatal(err)
+			}
+
+			cfg, err := account.PrinterConfig(context.Background(), bambutest.DefaultDeviceID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if cfg.GetBrokerUrl() != test.broker {
+				t.Errorf("broker = %s, want %s", cfg.GetBrokerUrl(), test.broker)
+			}
+		})
+	}
+}
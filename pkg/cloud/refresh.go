@@ -0,0 +1,125 @@
+package cloud
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	bambuhttp "github.com/RobertMNewton/bambu-golang-api/pkg/http"
+)
+
+// Reconnecter is a connection that authenticates with the account's token,
+// such as a *printer.Printer or *mqtt.Client of a cloud printer.
+type Reconnecter interface {
+	Reconnect(ctx context.Context) error
+}
+
+type RefreshOptions struct {
+	// Reconnect lists the connections that are re-established with the new
+	// token after every refresh
+	Reconnect []Reconnecter
+
+	// RetryInterval is the delay before a failed refresh is retried. Tokens
+	// without an expiry are checked for changes at the same interval.
+	RetryInterval time.Duration
+}
+
+func DefaultRefreshOptions() RefreshOptions {
+	return RefreshOptions{
+		RetryInterval: 30 * time.Second,
+	}
+}
+
+// RefreshEvent is sent whenever the access token changed or could not be
+// refreshed.
+type RefreshEvent struct {
+	Token bambuhttp.Token
+
+	// Err is set if the token could not be refreshed or a connection could
+	// not be re-established with it
+	Err error
+}
+
+// KeepRefreshed refreshes the access token shortly before it expires, rather
+// than when it is next needed, and reconnects options.Reconnect with every
+// new token, including tokens refreshed by other calls or set with SetToken.
+// An event is sent for every new token and every failure, failed refreshes
+// are retried after RetryInterval. The channel is closed when ctx is done.
+// Zero options are taken from DefaultRefreshOptions.
+func (account *Account) KeepRefreshed(ctx context.Context, options RefreshOptions) (<-chan RefreshEvent, error) {
+	if options.RetryInterval == 0 {
+		options.RetryInterval = DefaultRefreshOptions().RetryInterval
+	}
+	if options.RetryInterval < 0 {
+		return nil, fmt.Errorf("invalid retry interval %s", options.RetryInterval)
+	}
+
+	events := make(chan RefreshEvent, 1)
+
+	go func() {
+		defer close(events)
+
+		current := account.Token().AccessToken
+		failed := false
+
+		for {
+			wait := options.RetryInterval
+			if at := account.Token().RefreshAt(); !failed && !at.IsZero() {
+				wait = time.Until(at)
+			}
+
+			timer := time.NewTimer(wait)
+			select {
+			case <-timer.C:
+			case <-ctx.Done():
+				timer.Stop()
+				return
+			}
+
+			event, changed := account.refresh(ctx, current, options.Reconnect)
+			failed = event.Err != nil && !changed
+			if changed {
+				current = event.Token.AccessToken
+			}
+			if !changed && event.Err == nil {
+				continue
+			}
+
+			select {
+			case events <- event:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return events, nil
+}
+
+// refresh makes sure the access token is valid and reconnects if it is no
+// longer the current one.
+func (account *Account) refresh(ctx context.Context, current string, reconnect []Reconnecter) (RefreshEvent, bool) {
+	ctx, cancel := context.WithTimeout(ctx, tokenTimeout)
+	defer cancel()
+
+	if _, err := account.client.AccessToken(ctx); err != nil {
+		return RefreshEvent{Token: account.Token(), Err: err}, false
+	}
+
+	event := RefreshEvent{Token: account.Token()}
+	if event.Token.AccessToken == current {
+		return event, false
+	}
+
+	var errs []error
+	for _, conn := range reconnect {
+		if err := conn.Reconnect(ctx); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) > 0 {
+		event.Err = fmt.Errorf("failed to reconnect with the new token: %w", errors.Join(errs...))
+	}
+	return event, true
+}
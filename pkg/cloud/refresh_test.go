@@ -0,0 +1,234 @@
+package cloud_test
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/RobertMNewton/bambu-golang-api/pkg/bambutest"
+	"github.com/RobertMNewton/bambu-golang-api/pkg/cloud"
+	bambuhttp "github.com/RobertMNewton/bambu-golang-api/pkg/http"
+	"github.com/RobertMNewton/bambu-golang-api/pkg/mqtt"
+	"github.com/RobertMNewton/bambu-golang-api/pkg/printer"
+)
+
+// shortLived tokens are refreshed a second after they are issued, tokens
+// are refreshed a minute before they expire.
+const shortLived = 61 * time.Second
+
+func x509Pool(t *testing.T, pem []byte) *x509.CertPool {
+	t.Helper()
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		t.Fatal("failed to parse CA certificate")
+	}
+	return pool
+}
+
+type reconnecter struct {
+	calls atomic.Int32
+	err   error
+}
+
+func (r *reconnecter) Reconnect(ctx context.Context) error {
+	r.calls.Add(1)
+	return r.err
+}
+
+func nextEvent(t *testing.T, events <-chan cloud.RefreshEvent) cloud.RefreshEvent {
+	t.Helper()
+
+	select {
+	case event, ok := <-events:
+		if !ok {
+			t.Fatal("events closed")
+		}
+		return event
+	case <-time.After(10 * time.Second):
+		t.Fatal("no refresh event")
+	}
+	return cloud.RefreshEvent{}
+}
+
+func TestKeepRefreshed(t *testing.T) {
+	tests := []struct {
+		name         string
+		reconnectErr error
+		wantErr      bool
+	}{
+		{name: "reconnects"},
+		{name: "reconnect fails", reconnectErr: errors.New("broker down"), wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			options := bambutest.DefaultCloudOptions()
+			options.TokenLifetime = shortLived
+			fake := bambutest.NewCloudServerWithOptions(options)
+			defer fake.Close()
+
+			account := newAccount(t, fake, nil)
+			before := account.Token()
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			conn := &reconnecter{err: test.reconnectErr}
+			events, err := account.KeepRefreshed(ctx, cloud.RefreshOptions{Reconnect: []cloud.Reconnecter{conn}})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			event := nextEvent(t, events)
+			if (event.Err != nil) != test.wantErr {
+				t.Errorf("event error = %v, want error %t", event.Err, test.wantErr)
+			}
+			if event.Token.AccessToken == before.AccessToken {
+				t.Error("event does not carry the new token")
+			}
+			if time.Since(before.RefreshAt()) > 5*time.Second {
+				t.Errorf("refreshed %s after it was due", time.Since(before.RefreshAt()))
+			}
+			if conn.calls.Load() != 1 {
+				t.Errorf("reconnected %d times, want 1", conn.calls.Load())
+			}
+
+			cancel()
+			for range events {
+			}
+		})
+	}
+}
+
+func TestKeepRefreshedReportsFailures(t *testing.T) {
+	fake := bambutest.NewCloudServer()
+	defer fake.Close()
+
+	account := newAccount(t, fake, nil)
+	account.SetToken(bambuhttp.Token{
+		AccessToken:  "expired",
+		RefreshToken: "revoked",
+		ExpiresAt:    time.Now().Add(-time.Minute),
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	conn := &reconnecter{}
+	events, err := account.KeepRefreshed(ctx, cloud.RefreshOptions{
+		Reconnect:     []cloud.Reconnecter{conn},
+		RetryInterval: 50 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		event := nextEvent(t, events)
+		if !errors.Is(event.Err, bambuhttp.ErrUnauthorized) {
+			t.Errorf("event %d error = %v, want ErrUnauthorized", i, event.Err)
+		}
+	}
+	if conn.calls.Load() != 0 {
+		t.Error("reconnected without a new token")
+	}
+
+	// A new login is picked up by the next retry
+	if _, err := account.Login(ctx, bambutest.DefaultAccount, bambutest.DefaultPassword); err != nil {
+		t.Fatal(err)
+	}
+	for {
+		event := nextEvent(t, events)
+		if event.Err == nil {
+			break
+		}
+	}
+	if conn.calls.Load() != 1 {
+		t.Errorf("reconnected %d times after logging in, want 1", conn.calls.Load())
+	}
+}
+
+func TestKeepRefreshedOptions(t *testing.T) {
+	account := cloud.NewAccount()
+
+	if _, err := account.KeepRefreshed(context.Background(), cloud.RefreshOptions{RetryInterval: -time.Second}); err == nil {
+		t.Error("KeepRefreshed accepted a negative retry interval")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	events, err := account.KeepRefreshed(ctx, cloud.RefreshOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cancel()
+
+	select {
+	case _, ok := <-events:
+		if ok {
+			t.Error("event without a token")
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("events not closed after cancelling")
+	}
+}
+
+func TestKeepRefreshedReconnectsPrinter(t *testing.T) {
+	server, err := bambutest.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	options := bambutest.DefaultCloudOptions()
+	options.TokenLifetime = shortLived
+	fake := bambutest.NewCloudServerWithOptions(options)
+	defer fake.Close()
+	server.AcceptCloudLogins(fake)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+
+	account := newAccount(t, fake, server)
+	cfg, err := account.PrinterConfig(ctx, bambutest.DefaultDeviceID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := printer.NewPrinter(cfg)
+	reconnected := make(chan struct{}, 1)
+	p.OnConnectionStateChange(func(event mqtt.ConnectionEvent) {
+		if event.State == mqtt.StateConnected && event.Reconnected {
+			select {
+			case reconnected <- struct{}{}:
+			default:
+			}
+		}
+	})
+	if err := p.Connect(ctx); err != nil {
+		t.Fatal(err)
+	}
+	defer p.Disconnect()
+
+	events, err := account.KeepRefreshed(ctx, cloud.RefreshOptions{Reconnect: []cloud.Reconnecter{p}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if event := nextEvent(t, events); event.Err != nil {
+		t.Fatalf("refresh failed: %v", event.Err)
+	}
+	select {
+	case <-reconnected:
+	case <-ctx.Done():
+		t.Fatal("printer did not reconnect")
+	}
+
+	// The new session carries requests like the old one
+	if err := p.SendGCodeAndWait(ctx, "M400"); err != nil {
+		t.Errorf("request after reconnecting: %v", err)
+	}
+}
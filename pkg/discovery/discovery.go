@@ -0,0 +1,235 @@
+// Package discovery finds Bambu Lab printers on the local network. Printers
+// broadcast SSDP NOTIFY messages on UDP port 2021 every few seconds carrying
+// their serial number, model, name and IP address.
+package discovery
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"net/textproto"
+	"strings"
+	"time"
+
+	"github.com/RobertMNewton/bambu-golang-api/pkg/types/config"
+)
+
+const (
+	DefaultPort = 2021
+
+	// SearchTarget is the notification type sent by Bambu Lab printers
+	SearchTarget = "urn:bambulab-com:device:3dprinter:1"
+
+	DefaultScanDuration = 5 * time.Second
+
+	maxDatagramSize = 2048
+)
+
+var modelNames = map[string]string{
+	"3DPrinter-X1-Carbon": "X1 Carbon",
+	"3DPrinter-X1":        "X1",
+	"C13":                 "X1E",
+	"C11":                 "P1P",
+	"C12":                 "P1S",
+	"N1":                  "A1 mini",
+	"N2S":                 "A1",
+}
+
+type DiscoveredPrinter struct {
+	Serial    string
+	IPAddress string
+	Name      string
+
+	// Model is the model code sent by the printer, see ModelName
+	Model string
+
+	// Connection is "lan" in LAN only mode and "cloud" otherwise
+	Connection string
+	Bind       string
+	Signal     string
+	Version    string
+
+	SeenAt time.Time
+}
+
+// ModelName returns the marketing name of the printer's model, or the model
+// code if it is unknown.
+func (printer DiscoveredPrinter) ModelName() string {
+	if name, ok := modelNames[printer.Model]; ok {
+		return name
+	}
+	return printer.Model
+}
+
+// Config returns a config for connecting to the printer over the LAN. The
+// access code is shown on the printer's screen.
+func (printer DiscoveredPrinter) Config(accessCode string) config.LocalPrinterConfig {
+	return config.NewLocalPrinterConfig(printer.Serial, printer.IPAddress, accessCode, "")
+}
+
+func (printer DiscoveredPrinter) sameAs(other DiscoveredPrinter) bool {
+	printer.SeenAt, other.SeenAt = time.Time{}, time.Time{}
+	return printer == other
+}
+
+type Options struct {
+	// Address is the UDP address to listen on, defaults to :2021
+	Address string
+}
+
+// Listen listens for printers until ctx is done. A printer is sent when it
+// is first seen and again whenever its announcement changes. The channel is
+// closed when ctx is done; an error is returned if the port cannot be opened.
+func Listen(ctx context.Context, options Options) (<-chan DiscoveredPrinter, error) {
+	address := options.Address
+	if address == "" {
+		address = fmt.Sprintf(":%d", DefaultPort)
+	}
+
+	var listenConfig net.ListenConfig
+	conn, err := listenConfig.ListenPacket(ctx, "udp4", address)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on %s: %w", address, err)
+	}
+
+	printers := make(chan DiscoveredPrinter)
+
+	stop := context.AfterFunc(ctx, func() {
+		conn.Close()
+	})
+
+	go func() {
+		defer close(printers)
+		defer stop()
+		defer conn.Close()
+
+		seen := make(map[string]DiscoveredPrinter)
+		buf := make([]byte, maxDatagramSize)
+
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+
+			printer, ok := parseNotify(buf[:n])
+			if !ok {
+				continue
+			}
+			if printer.IPAddress == "" {
+				if udpAddr, ok := addr.(*net.UDPAddr); ok {
+					printer.IPAddress = udpAddr.IP.String()
+				}
+			}
+
+			if previous, ok := seen[printer.Serial]; ok && previous.sameAs(printer) {
+				continue
+			}
+			seen[printer.Serial] = printer
+
+			select {
+			case printers <- printer:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return printers, nil
+}
+
+// Scan listens for DefaultScanDuration, or until ctx is done if it has an
+// earlier deadline, and returns every printer found.
+func Scan(ctx context.Context) ([]DiscoveredPrinter, error) {
+	return ScanWithOptions(ctx, Options{}, DefaultScanDuration)
+}
+
+func ScanWithOptions(ctx context.Context, options Options, duration time.Duration) ([]DiscoveredPrinter, error) {
+	ctx, cancel := context.WithTimeout(ctx, duration)
+	defer cancel()
+
+	found, err := Listen(ctx, options)
+	if err != nil {
+		return nil, err
+	}
+
+	var printers []DiscoveredPrinter
+	index := make(map[string]int)
+	for printer := range found {
+		if i, ok := index[printer.Serial]; ok {
+			printers[i] = printer
+			continue
+		}
+		index[printer.Serial] = len(printers)
+		printers = append(printers, printer)
+	}
+
+	return printers, nil
+}
+
+// ScanConfigs scans the network and returns configs for the printers whose
+// access codes are given, keyed by serial number. Printers without an
+// access code are skipped.
+func ScanConfigs(ctx context.Context, accessCodes map[string]string) ([]config.LocalPrinterConfig, error) {
+	printers, err := Scan(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var configs []config.LocalPrinterConfig
+	for _, printer := range printers {
+		accessCode, ok := accessCodes[printer.Serial]
+		if !ok {
+			continue
+		}
+		configs = append(configs, printer.Config(accessCode))
+	}
+
+	return configs, nil
+}
+
+// parseNotify parses an SSDP NOTIFY message or M-SEARCH response sent by a
+// printer.
+func parseNotify(data []byte) (DiscoveredPrinter, bool) {
+	reader := textproto.NewReader(bufio.NewReader(bytes.NewReader(data)))
+
+	startLine, err := reader.ReadLine()
+	if err != nil {
+		return DiscoveredPrinter{}, false
+	}
+	if !strings.HasPrefix(startLine, "NOTIFY ") && !strings.HasPrefix(startLine, "HTTP/1.1 200") {
+		return DiscoveredPrinter{}, false
+	}
+
+	header, err := reader.ReadMIMEHeader()
+	if err != nil && len(header) == 0 {
+		return DiscoveredPrinter{}, false
+	}
+
+	notificationType := header.Get("NT")
+	if notificationType == "" {
+		notificationType = header.Get("ST")
+	}
+	if notificationType != SearchTarget {
+		return DiscoveredPrinter{}, false
+	}
+
+	serial := header.Get("USN")
+	if serial == "" {
+		return DiscoveredPrinter{}, false
+	}
+
+	return DiscoveredPrinter{
+		Serial:     serial,
+		IPAddress:  header.Get("Location"),
+		Name:       header.Get("DevName.bambu.com"),
+		Model:      header.Get("DevModel.bambu.com"),
+		Connection: header.Get("DevConnect.bambu.com"),
+		Bind:       header.Get("DevBind.bambu.com"),
+		Signal:     header.Get("DevSignal.bambu.com"),
+		Version:    header.Get("DevVersion.bambu.com"),
+		SeenAt:     time.Now(),
+	}, true
+}
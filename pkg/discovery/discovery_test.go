@@ -0,0 +1,265 @@
+package discovery_test
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/RobertMNewton/bambu-golang-api/pkg/bambutest"
+	"github.com/RobertMNewton/bambu-golang-api/pkg/discovery"
+)
+
+const timeout = 5 * time.Second
+
+// listen listens on a free loopback port and returns its address.
+func listen(t *testing.T, ctx context.Context) (<-chan discovery.DiscoveredPrinter, string) {
+	t.Helper()
+
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := conn.LocalAddr().String()
+	conn.Close()
+
+	printers, err := discovery.Listen(ctx, discovery.Options{Address: address})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return printers, address
+}
+
+// send sends a datagram made of lines to address. It is safe to call from
+// other goroutines.
+func send(t *testing.T, address string, lines ...string) {
+	t.Helper()
+
+	conn, err := net.Dial("udp4", address)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer conn.Close()
+
+	if _, err := conn.Write([]byte(strings.Join(lines, "\r\n") + "\r\n\r\n")); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestListen(t *testing.T) {
+	server, err := bambutest.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	printers, address := listen(t, ctx)
+	if err := server.Announce(address); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case printer := <-printers:
+		if printer.Serial != server.DeviceID() || printer.IPAddress != "127.0.0.1" {
+			t.Errorf("printer = %+v", printer)
+		}
+		if printer.Name != "bambutest" || printer.ModelName() != "P1S" || printer.Connection != "lan" {
+			t.Errorf("printer = %+v", printer)
+		}
+		if printer.SeenAt.IsZero() {
+			t.Error("SeenAt not set")
+		}
+
+		cfg := printer.Config(bambutest.DefaultAccessCode)
+		if cfg.GetDeviceID() != server.DeviceID() || cfg.GetDeviceIPAddress() != "127.0.0.1" {
+			t.Errorf("config = %+v", cfg)
+		}
+	case <-ctx.Done():
+		t.Fatal("printer not discovered")
+	}
+}
+
+func TestListenMessages(t *testing.T) {
+	tests := []struct {
+		name  string
+		lines []string
+		want  *discovery.DiscoveredPrinter
+	}{
+		{
+			name: "notify",
+			lines: []string{
+				"NOTIFY * HTTP/1.1",
+				"Location: 192.168.1.20",
+				"NT: " + discovery.SearchTarget,
+				"USN: 01P00A000000001",
+				"DevModel.bambu.com: N2S",
+				"DevName.bambu.com: garage",
+			},
+			want: &discovery.DiscoveredPrinter{Serial: "01P00A000000001", IPAddress: "192.168.1.20", Name: "garage", Model: "N2S"},
+		},
+		{
+			name: "search response",
+			lines: []string{
+				"HTTP/1.1 200 OK",
+				"Location: 192.168.1.21",
+				"ST: " + discovery.SearchTarget,
+				"USN: 01P00A000000002",
+			},
+			want: &discovery.DiscoveredPrinter{Serial: "01P00A000000002", IPAddress: "192.168.1.21"},
+		},
+		{
+			name: "address from sender",
+			lines: []string{
+				"NOTIFY * HTTP/1.1",
+				"NT: " + discovery.SearchTarget,
+				"USN: 01P00A000000003",
+			},
+			want: &discovery.DiscoveredPrinter{Serial: "01P00A000000003", IPAddress: "127.0.0.1"},
+		},
+		{
+			name: "other device",
+			lines: []string{
+				"NOTIFY * HTTP/1.1",
+				"NT: urn:schemas-upnp-org:device:MediaRenderer:1",
+				"USN: uuid:tv",
+			},
+		},
+		{
+			name: "no serial",
+			lines: []string{
+				"NOTIFY * HTTP/1.1",
+				"NT: " + discovery.SearchTarget,
+			},
+		},
+		{
+			name:  "search request",
+			lines: []string{"M-SEARCH * HTTP/1.1", "ST: " + discovery.SearchTarget, "USN: 01P00A000000004"},
+		},
+		{
+			name:  "garbage",
+			lines: []string{"\x00\x01\x02"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), timeout)
+			defer cancel()
+
+			printers, address := listen(t, ctx)
+			send(t, address, test.lines...)
+
+			if test.want == nil {
+				select {
+				case printer := <-printers:
+					t.Fatalf("discovered %+v", printer)
+				case <-time.After(100 * time.Millisecond):
+				}
+				return
+			}
+
+			select {
+			case printer := <-printers:
+				printer.SeenAt = time.Time{}
+				if printer != *test.want {
+					t.Errorf("printer = %+v, want %+v", printer, *test.want)
+				}
+			case <-ctx.Done():
+				t.Fatal("printer not discovered")
+			}
+		})
+	}
+}
+
+func TestListenSkipsRepeats(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	printers, address := listen(t, ctx)
+	announce := func(name string) {
+		send(t, address, "NOTIFY * HTTP/1.1", "NT: "+discovery.SearchTarget, "USN: 01P00A000000001", "DevName.bambu.com: "+name)
+	}
+
+	announce("garage")
+	announce("garage")
+	announce("workshop")
+
+	for _, want := range []string{"garage", "workshop"} {
+		select {
+		case printer := <-printers:
+			if printer.Name != want {
+				t.Errorf("name = %q, want %q", printer.Name, want)
+			}
+		case <-ctx.Done():
+			t.Fatalf("%s not discovered", want)
+		}
+	}
+}
+
+func TestListenClosesOnCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	printers, address := listen(t, ctx)
+	cancel()
+
+	select {
+	case _, ok := <-printers:
+		if ok {
+			t.Fatal("printer discovered after cancel")
+		}
+	case <-time.After(timeout):
+		t.Fatal("channel not closed")
+	}
+
+	// The port is released
+	conn, err := net.ListenPacket("udp4", address)
+	if err != nil {
+		t.Fatalf("port still in use: %v", err)
+	}
+	conn.Close()
+}
+
+func TestListenError(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	_, address := listen(t, ctx)
+	if _, err := discovery.Listen(ctx, discovery.Options{Address: address}); err == nil {
+		t.Error("Listen succeeded on a port in use")
+	}
+	if _, err := discovery.ScanWithOptions(ctx, discovery.Options{Address: address}, time.Millisecond); err == nil {
+		t.Error("ScanWithOptions succeeded on a port in use")
+	}
+}
+
+func TestScanWithOptions(t *testing.T) {
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := conn.LocalAddr().String()
+	conn.Close()
+
+	go func() {
+		for _, name := range []string{"garage", "workshop"} {
+			time.Sleep(50 * time.Millisecond)
+			send(t, address, "NOTIFY * HTTP/1.1", "NT: "+discovery.SearchTarget, "USN: 01P00A000000001", "DevName.bambu.com: "+name)
+		}
+		send(t, address, "NOTIFY * HTTP/1.1", "NT: "+discovery.SearchTarget, "USN: 01P00A000000002")
+	}()
+
+	printers, err := discovery.ScanWithOptions(context.Background(), discovery.Options{Address: address}, 500*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(printers) != 2 {
+		t.Fatalf("printers = %+v, want 2", printers)
+	}
+	if printers[0].Name != "workshop" || printers[1].Serial != "01P00A000000002" {
+		t.Errorf("printers = %+v", printers)
+	}
+}
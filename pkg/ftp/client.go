@@ -2,9 +2,10 @@ package ftp
 
 import (
 	"context"
+	"crypto/tls"
 	"fmt"
 	"os"
-	"path/filepath"
+	"sync"
 	"time"
 
 	"github.com/RobertMNewton/bambu-golang-api/pkg/types/config"
@@ -12,14 +13,20 @@ import (
 )
 
 const (
-	ftpPort        = 990
+	ftpUsername    = "bblp"
 	connectTimeout = 10 * time.Second
+	quitTimeout    = 5 * time.Second
+
+	sessionCacheSize = 8
 )
 
 // Client represents an FTP client for file transfers
 type Client struct {
 	config config.PrinterConfig
-	conn   *ftp.ServerConn
+
+	mu      sync.Mutex
+	conn    *ftp.ServerConn
+	tracker *connTracker
 }
 
 func NewClient(config config.PrinterConfig) *Client {
@@ -28,95 +35,164 @@ func NewClient(config config.PrinterConfig) *Client {
 	}
 }
 
+// Connect opens an implicit FTPS session with the printer. The printer only
+// accepts TLS on port 990 and requires data connections to resume the TLS
+// session of the control connection. Disconnect before connecting again.
 func (client *Client) Connect(ctx context.Context) error {
-	// Create a channel for the connection result
-	connChan := make(chan error, 1)
-
-	go func() {
-		addr := fmt.Sprintf("%s:%d", client.config.GetDeviceIPAddress(), ftpPort)
-
-		conn, err := ftp.Dial(addr, ftp.DialWithTimeout(connectTimeout))
-		if err != nil {
-			connChan <- fmt.Errorf("ftp dial failed: %w", err)
-			return
-		}
-
-		if err := conn.Login("bblp", client.config.GetDeviceAccessCode()); err != nil {
-			conn.Quit()
-			connChan <- fmt.Errorf("ftp login failed: %w", err)
-			return
-		}
-
-		client.conn = conn
-		connChan <- nil
-	}()
-
-	select {
-	case err := <-connChan:
-		return err
-	case <-ctx.Done():
-		return fmt.Errorf("connection timeout: %w", ctx.Err())
+	tlsConfig, err := client.createTLSConfig()
+	if err != nil {
+		return fmt.Errorf("failed to create tls config: %w", err)
 	}
-}
 
-func (client *Client) UploadFile(ctx context.Context, localPath, remotePath string) error {
-	if client.conn == nil {
-		return fmt.Errorf("not connected")
+	client.mu.Lock()
+	defer client.mu.Unlock()
+
+	// The printer allows few sessions, a second one would be leaked
+	if client.conn != nil {
+		return fmt.Errorf("already connected")
+	}
+
+	tracker := newConnTracker(tlsConfig)
+	stop := tracker.watch(ctx)
+	defer stop()
+
+	addr := fmt.Sprintf("%s:%d", client.config.GetDeviceIPAddress(), client.config.GetPort(config.ServiceFTP))
+
+	conn, err := ftp.Dial(
+		addr,
+		ftp.DialWithTLS(tlsConfig),
+		ftp.DialWithDialFunc(tracker.dial),
+	)
+	if err != nil {
+		return client.contextError(ctx, fmt.Errorf("ftp dial failed: %w", err))
+	}
+
+	if err := conn.Login(ftpUsername, client.config.GetDeviceAccessCode()); err != nil {
+		quit(conn, tracker)
+		return client.contextError(ctx, fmt.Errorf("ftp login failed: %w", err))
 	}
 
+	client.conn = conn
+	client.tracker = tracker
+
+	return nil
+}
+
+// UploadFile uploads a local file, resuming the transfer once if it is
+// interrupted.
+func (client *Client) UploadFile(ctx context.Context, localPath, remotePath string) error {
 	file, err := os.Open(localPath)
 	if err != nil {
 		return fmt.Errorf("failed to open local file: %w", err)
 	}
 	defer file.Close()
 
-	remoteDir := filepath.Dir(remotePath)
-	if err := client.createDirectory(remoteDir); err != nil {
-		return fmt.Errorf("failed to create remote directory: %w", err)
+	info, err := file.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to stat local file: %w", err)
 	}
 
-	err = client.conn.Stor(remotePath, file)
+	return client.Upload(ctx, remotePath, file, info.Size(), UploadOptions{Retries: 1})
+}
+
+// ListFiles lists the files and directories directly inside a remote
+// directory.
+func (client *Client) ListFiles(ctx context.Context, dir string) ([]FileInfo, error) {
+	var files []FileInfo
+
+	err := client.do(ctx, func(conn *ftp.ServerConn) error {
+		var err error
+		files, err = listDir(conn, dir)
+		return err
+	})
 	if err != nil {
-		return fmt.Errorf("failed to upload file: %w", err)
+		return nil, err
 	}
 
-	return nil
+	return files, nil
 }
 
-func (client *Client) ListFiles(ctx context.Context, path string) ([]string, error) {
+func (client *Client) Disconnect() error {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+
 	if client.conn == nil {
-		return nil, fmt.Errorf("not connected")
+		return nil
 	}
 
-	entries, err := client.conn.List(path)
-	if err != nil {
-		return nil, fmt.Errorf("failed to list files: %w", err)
+	conn, tracker := client.conn, client.tracker
+	client.conn = nil
+	client.tracker = nil
+
+	return quit(conn, tracker)
+}
+
+// quit ends the session. A printer that stopped responding cannot block it
+// for longer than quitTimeout.
+func quit(conn *ftp.ServerConn, tracker *connTracker) error {
+	tracker.setDeadline(time.Now().Add(quitTimeout))
+	return conn.Quit()
+}
+
+// do runs op on the control connection. The connection cannot be shared, so
+// operations are serialised. If ctx is cancelled while op is blocked on the
+// network the connections are interrupted and the client is disconnected,
+// since the session is left in an unknown state.
+func (client *Client) do(ctx context.Context, op func(conn *ftp.ServerConn) error) error {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+
+	if client.conn == nil {
+		return fmt.Errorf("not connected")
 	}
 
-	var files []string
-	for _, entry := range entries {
-		if entry.Type == ftp.EntryTypeFile {
-			files = append(files, entry.Name)
-		}
+	if err := ctx.Err(); err != nil {
+		return err
 	}
 
-	return files, nil
+	stop := client.tracker.watch(ctx)
+	err := op(client.conn)
+
+	if !stop() {
+		// The connections were interrupted and can no longer be used.
+		client.conn.Quit()
+		client.conn = nil
+		client.tracker = nil
+
+		return client.contextError(ctx, err)
+	}
+
+	return err
 }
 
-func (client *Client) Disconnect() error {
-	if client.conn != nil {
-		return client.conn.Quit()
+func (client *Client) contextError(ctx context.Context, err error) error {
+	if err != nil && ctx.Err() != nil {
+		return fmt.Errorf("%w: %v", ctx.Err(), err)
 	}
-	return nil
+	return err
+}
+
+func (client *Client) createTLSConfig() (*tls.Config, error) {
+	tlsConfig, err := client.config.CreateLANTLSConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	// Data connections share this config, so they resume the control
+	// connection's session through the cache.
+	tlsConfig = tlsConfig.Clone()
+	tlsConfig.ClientSessionCache = tls.NewLRUClientSessionCache(sessionCacheSize)
+
+	return tlsConfig, nil
 }
 
-func (client *Client) createDirectory(path string) error {
-	if err := client.conn.ChangeDir(path); err == nil {
-		return client.conn.ChangeDir("/")
+func createDirectory(conn *ftp.ServerConn, dir string) error {
+	if err := conn.ChangeDir(dir); err == nil {
+		return conn.ChangeDir("/")
 	}
 
 	// Directory doesn't exist, create it
-	if err := client.conn.MakeDir(path); err != nil {
+	if err := conn.MakeDir(dir); err != nil {
 		return err
 	}
 
@@ -0,0 +1,392 @@
+package ftp_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/RobertMNewton/bambu-golang-api/pkg/bambutest"
+	"github.com/RobertMNewton/bambu-golang-api/pkg/ftp"
+	"github.com/RobertMNewton/bambu-golang-api/pkg/types/config"
+)
+
+func newServer(t *testing.T) *bambutest.Server {
+	t.Helper()
+
+	server, err := bambutest.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { server.Close() })
+	return server
+}
+
+func connect(t *testing.T, cfg config.PrinterConfig) *ftp.Client {
+	t.Helper()
+
+	client := ftp.NewClient(cfg)
+	if err := client.Connect(context.Background()); err != nil {
+		t.Fatalf("connect failed: %v", err)
+	}
+	t.Cleanup(func() { client.Disconnect() })
+	return client
+}
+
+func TestConnect(t *testing.T) {
+	server := newServer(t)
+	ctx := context.Background()
+
+	client := connect(t, server.Config())
+	if err := client.Connect(ctx); err == nil {
+		t.Error("second Connect succeeded")
+	}
+
+	if err := client.Disconnect(); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Disconnect(); err != nil {
+		t.Errorf("second Disconnect: %v", err)
+	}
+	if _, err := client.ListFiles(ctx, "/"); err == nil {
+		t.Error("ListFiles succeeded after Disconnect")
+	}
+
+	if err := client.Connect(ctx); err != nil {
+		t.Fatalf("Connect after Disconnect: %v", err)
+	}
+	if _, err := client.ListFiles(ctx, "/"); err != nil {
+		t.Errorf("ListFiles after reconnecting: %v", err)
+	}
+}
+
+func TestConnectRejected(t *testing.T) {
+	server := newServer(t)
+	good := server.Config()
+
+	caPath := filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(caPath, server.CACertPEM(), 0600); err != nil {
+		t.Fatal(err)
+	}
+	wrongCode := config.NewLocalPrinterConfig(server.DeviceID(), "127.0.0.1", "00000000", caPath)
+	wrongCode.SetPort(config.ServiceFTP, good.GetPort(config.ServiceFTP))
+
+	otherCAPath := filepath.Join(t.TempDir(), "other-ca.pem")
+	other := newServer(t)
+	if err := os.WriteFile(otherCAPath, other.CACertPEM(), 0600); err != nil {
+		t.Fatal(err)
+	}
+	wrongCA := config.NewLocalPrinterConfig(server.DeviceID(), "127.0.0.1", bambutest.DefaultAccessCode, otherCAPath)
+	wrongCA.SetPort(config.ServiceFTP, good.GetPort(config.ServiceFTP))
+
+	tests := []struct {
+		name   string
+		config *config.LocalPrinterConfig
+	}{
+		{name: "wrong access code", config: &wrongCode},
+		{name: "untrusted certificate", config: &wrongCA},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+
+			if err := ftp.NewClient(test.config).Connect(ctx); err == nil {
+				t.Error("Connect succeeded")
+			}
+		})
+	}
+}
+
+func TestCloudConfig(t *testing.T) {
+	server := newServer(t)
+	other := newServer(t)
+
+	dir := t.TempDir()
+	caPath := filepath.Join(dir, "ca.pem")
+	if err := os.WriteFile(caPath, server.CACertPEM(), 0600); err != nil {
+		t.Fatal(err)
+	}
+	otherCAPath := filepath.Join(dir, "other-ca.pem")
+	if err := os.WriteFile(otherCAPath, other.CACertPEM(), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	pins, err := config.LoadPinStore(filepath.Join(dir, "known_printers"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		setup   func(cfg *config.CloudPrinterConfig)
+		wantErr bool
+	}{
+		{name: "CA", setup: func(cfg *config.CloudPrinterConfig) { cfg.SetCACertPath(caPath) }},
+		{name: "pinned on first use", setup: func(cfg *config.CloudPrinterConfig) { cfg.SetPinStore(pins) }},
+		{name: "untrusted CA", setup: func(cfg *config.CloudPrinterConfig) { cfg.SetCACertPath(otherCAPath) }, wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// The broker is never contacted, FTP only needs the LAN address
+			cfg := config.NewCloudPrinterConfig(server.DeviceID(), "1", "token", "127.0.0.1", bambutest.DefaultAccessCode)
+			cfg.SetPort(config.ServiceFTP, server.Config().GetPort(config.ServiceFTP))
+			test.setup(cfg)
+
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+
+			client := ftp.NewClient(cfg)
+			err := client.Connect(ctx)
+			if test.wantErr {
+				if err == nil {
+					client.Disconnect()
+					t.Fatal("Connect succeeded")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer client.Disconnect()
+
+			data := []byte("G28\n")
+			if err := client.Upload(ctx, "/cache/cloud.gcode", bytes.NewReader(data), int64(len(data)), ftp.UploadOptions{}); err != nil {
+				t.Fatal(err)
+			}
+			if stored, _ := server.ReadFile("/cache/cloud.gcode"); !bytes.Equal(stored, data) {
+				t.Errorf("server has %q", stored)
+			}
+		})
+	}
+
+	if _, ok := pins.Pinned(server.DeviceID()); !ok {
+		t.Error("certificate not pinned")
+	}
+}
+
+func TestUpload(t *testing.T) {
+	tests := []struct {
+		name   string
+		remote string
+		size   int
+	}{
+		{name: "empty", remote: "/cache/empty.gcode", size: 0},
+		{name: "small", remote: "/cache/small.gcode", size: 100},
+		{name: "large", remote: "/cache/large.3mf", size: 3 << 20},
+		{name: "new directory", remote: "/projects/part.3mf", size: 1000},
+	}
+
+	server := newServer(t)
+	client := connect(t, server.Config())
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx := context.Background()
+			data := bytes.Repeat([]byte("G1 X1\n"), test.size/6+1)[:test.size]
+
+			var last ftp.UploadProgress
+			err := client.Upload(ctx, test.remote, bytes.NewReader(data), int64(len(data)), ftp.UploadOptions{
+				Progress: func(progress ftp.UploadProgress) { last = progress },
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			stored, ok := server.ReadFile(test.remote)
+			if !ok || !bytes.Equal(stored, data) {
+				t.Fatalf("server has %d bytes, want %d", len(stored), len(data))
+			}
+			if last.BytesSent != int64(len(data)) || last.TotalBytes != int64(len(data)) {
+				t.Errorf("last progress = %+v", last)
+			}
+
+			var downloaded bytes.Buffer
+			if err := client.Download(ctx, test.remote, &downloaded); err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(downloaded.Bytes(), data) {
+				t.Errorf("downloaded %d bytes, want %d", downloaded.Len(), len(data))
+			}
+		})
+	}
+}
+
+// seekRecorder records where an upload seeks to when it resumes.
+type seekRecorder struct {
+	*bytes.Reader
+	resumedAt []int64
+}
+
+func (r *seekRecorder) Seek(offset int64, whence int) (int64, error) {
+	if whence == io.SeekStart {
+		r.resumedAt = append(r.resumedAt, offset)
+	}
+	return r.Reader.Seek(offset, whence)
+}
+
+func TestUploadResume(t *testing.T) {
+	tests := []struct {
+		name    string
+		skip    int64
+		retries int
+		wantErr bool
+	}{
+		{name: "resumed", retries: 1},
+		{name: "reader not at start", skip: 1000, retries: 1},
+		{name: "no retries", retries: 0, wantErr: true},
+	}
+
+	data := make([]byte, 1<<20)
+	for i := range data {
+		data[i] = byte(i % 251)
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := newServer(t)
+			client := connect(t, server.Config())
+
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+
+			r := &seekRecorder{Reader: bytes.NewReader(data)}
+			if _, err := r.Reader.Seek(test.skip, io.SeekStart); err != nil {
+				t.Fatal(err)
+			}
+			want := data[test.skip:]
+
+			server.DropNextUpload(256 << 10)
+
+			err := client.Upload(ctx, "/cache/resume.3mf", r, int64(len(want)), ftp.UploadOptions{Retries: test.retries})
+			if test.wantErr {
+				if err == nil {
+					t.Fatal("dropped upload succeeded without retries")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			stored, _ := server.ReadFile("/cache/resume.3mf")
+			if !bytes.Equal(stored, want) {
+				t.Fatalf("server has %d bytes, want %d", len(stored), len(want))
+			}
+
+			// The upload continued with REST from what the printer had
+			if len(r.resumedAt) != 1 || r.resumedAt[0] <= test.skip {
+				t.Errorf("resumed at %v, want a single seek past %d", r.resumedAt, test.skip)
+			}
+		})
+	}
+}
+
+func TestUploadFile(t *testing.T) {
+	server := newServer(t)
+	client := connect(t, server.Config())
+
+	local := filepath.Join(t.TempDir(), "benchy.gcode")
+	if err := os.WriteFile(local, []byte("G28\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := client.UploadFile(context.Background(), local, "/cache/benchy.gcode"); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := server.ReadFile("/cache/benchy.gcode"); string(data) != "G28\n" {
+		t.Errorf("server has %q", data)
+	}
+
+	if err := client.UploadFile(context.Background(), filepath.Join(t.TempDir(), "missing"), "/cache/missing"); err == nil {
+		t.Error("UploadFile of a missing file succeeded")
+	}
+}
+
+func TestFiles(t *testing.T) {
+	server := newServer(t)
+	server.WriteFile("/timelapse/a.mp4", []byte("aaaa"))
+	server.WriteFile("/timelapse/thumbnail/a.jpg", []byte("jpg"))
+	server.WriteFile("/cache/b.gcode", []byte("bb"))
+
+	ctx := context.Background()
+	client := connect(t, server.Config())
+
+	t.Run("stat", func(t *testing.T) {
+		info, err := client.Stat(ctx, "/timelapse/a.mp4")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Size != 4 || info.IsDir || info.Path != "/timelapse/a.mp4" {
+			t.Errorf("info = %+v", info)
+		}
+
+		if _, err := client.Stat(ctx, "/timelapse/missing.mp4"); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("Stat of a missing file = %v, want fs.ErrNotExist", err)
+		}
+	})
+
+	t.Run("walk", func(t *testing.T) {
+		var paths []string
+		err := client.Walk(ctx, "/", func(info ftp.FileInfo) error {
+			if info.Path == "/cache" {
+				return fs.SkipDir
+			}
+			if !info.IsDir {
+				paths = append(paths, info.Path)
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		sort.Strings(paths)
+		want := []string{"/timelapse/a.mp4", "/timelapse/thumbnail/a.jpg"}
+		if len(paths) != len(want) || paths[0] != want[0] || paths[1] != want[1] {
+			t.Errorf("walked %v, want %v", paths, want)
+		}
+	})
+
+	t.Run("rename and delete", func(t *testing.T) {
+		if err := client.Rename(ctx, "/cache/b.gcode", "/cache/c.gcode"); err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := server.ReadFile("/cache/c.gcode"); !ok {
+			t.Error("renamed file missing")
+		}
+
+		if err := client.Delete(ctx, "/cache/c.gcode"); err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := server.ReadFile("/cache/c.gcode"); ok {
+			t.Error("deleted file still exists")
+		}
+		if err := client.Delete(ctx, "/cache/c.gcode"); err == nil {
+			t.Error("deleting a missing file succeeded")
+		}
+	})
+}
+
+func TestCancelledContext(t *testing.T) {
+	server := newServer(t)
+	client := connect(t, server.Config())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := client.ListFiles(ctx, "/"); !errors.Is(err, context.Canceled) {
+		t.Errorf("ListFiles = %v, want context.Canceled", err)
+	}
+	if _, err := client.ListFiles(context.Background(), "/"); err != nil {
+		t.Errorf("ListFiles after a cancelled call: %v", err)
+	}
+}
@@ -0,0 +1,86 @@
+package ftp
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"sync"
+	"time"
+)
+
+// connTracker dials the control and data connections and keeps track of the
+// ones that are open, so that an operation blocked on the network can be
+// interrupted when its context is cancelled.
+type connTracker struct {
+	tlsConfig *tls.Config
+	dialer    net.Dialer
+
+	mu    sync.Mutex
+	conns map[*trackedConn]struct{}
+}
+
+func newConnTracker(tlsConfig *tls.Config) *connTracker {
+	return &connTracker{
+		tlsConfig: tlsConfig,
+		dialer:    net.Dialer{Timeout: connectTimeout},
+		conns:     make(map[*trackedConn]struct{}),
+	}
+}
+
+// dial opens a TLS connection without performing the handshake. The printer
+// only starts TLS on a data connection after the transfer command has been
+// sent, so the handshake has to wait for the first read or write.
+func (tracker *connTracker) dial(network, address string) (net.Conn, error) {
+	conn, err := tracker.dialer.Dial(network, address)
+	if err != nil {
+		return nil, err
+	}
+
+	tracked := &trackedConn{
+		Conn:    tls.Client(conn, tracker.tlsConfig),
+		tracker: tracker,
+	}
+
+	tracker.mu.Lock()
+	tracker.conns[tracked] = struct{}{}
+	tracker.mu.Unlock()
+
+	return tracked, nil
+}
+
+// interrupt unblocks every pending read and write on the open connections.
+func (tracker *connTracker) interrupt() {
+	tracker.setDeadline(time.Now())
+}
+
+func (tracker *connTracker) setDeadline(deadline time.Time) {
+	tracker.mu.Lock()
+	defer tracker.mu.Unlock()
+
+	for conn := range tracker.conns {
+		conn.SetDeadline(deadline)
+	}
+}
+
+func (tracker *connTracker) remove(conn *trackedConn) {
+	tracker.mu.Lock()
+	defer tracker.mu.Unlock()
+
+	delete(tracker.conns, conn)
+}
+
+// watch interrupts the open connections if ctx is cancelled before the
+// returned stop function is called.
+func (tracker *connTracker) watch(ctx context.Context) (stop func() bool) {
+	return context.AfterFunc(ctx, tracker.interrupt)
+}
+
+type trackedConn struct {
+	*tls.Conn
+	tracker *connTracker
+}
+
+func (conn *trackedConn) Close() error {
+	conn.tracker.remove(conn)
+	return conn.Conn.Close()
+}
@@ -0,0 +1,161 @@
+package ftp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"time"
+
+	"github.com/jlaffaye/ftp"
+)
+
+// Directories on the printer's SD card
+const (
+	CacheDir     = "/cache"
+	TimelapseDir = "/timelapse"
+	ModelDir     = "/model"
+)
+
+// FileInfo describes a file or directory on the printer.
+type FileInfo struct {
+	Name    string
+	Path    string
+	Size    int64
+	ModTime time.Time
+	IsDir   bool
+}
+
+// WalkFunc is called by Walk for every file and directory. Returning
+// fs.SkipDir from a directory skips its contents, returning it from a file
+// skips the remaining entries of the parent directory. Any other error stops
+// the walk.
+type WalkFunc func(info FileInfo) error
+
+// Download writes the contents of a remote file to w.
+func (client *Client) Download(ctx context.Context, remotePath string, w io.Writer) error {
+	return client.do(ctx, func(conn *ftp.ServerConn) error {
+		resp, err := conn.Retr(remotePath)
+		if err != nil {
+			return fmt.Errorf("failed to download file: %w", err)
+		}
+
+		if _, err := io.Copy(w, resp); err != nil {
+			resp.Close()
+			return fmt.Errorf("failed to download file: %w", err)
+		}
+
+		if err := resp.Close(); err != nil {
+			return fmt.Errorf("failed to download file: %w", err)
+		}
+
+		return nil
+	})
+}
+
+func (client *Client) Delete(ctx context.Context, remotePath string) error {
+	return client.do(ctx, func(conn *ftp.ServerConn) error {
+		if err := conn.Delete(remotePath); err != nil {
+			return fmt.Errorf("failed to delete file: %w", err)
+		}
+		return nil
+	})
+}
+
+func (client *Client) Rename(ctx context.Context, from, to string) error {
+	return client.do(ctx, func(conn *ftp.ServerConn) error {
+		if err := conn.Rename(from, to); err != nil {
+			return fmt.Errorf("failed to rename file: %w", err)
+		}
+		return nil
+	})
+}
+
+// Stat returns information about a single remote file or directory. It
+// returns an error wrapping fs.ErrNotExist if nothing exists at remotePath.
+func (client *Client) Stat(ctx context.Context, remotePath string) (FileInfo, error) {
+	remotePath = path.Clean(remotePath)
+	if remotePath == "/" {
+		return FileInfo{Name: "/", Path: "/", IsDir: true}, nil
+	}
+
+	files, err := client.ListFiles(ctx, path.Dir(remotePath))
+	if err != nil {
+		return FileInfo{}, err
+	}
+
+	for _, file := range files {
+		if file.Name == path.Base(remotePath) {
+			return file, nil
+		}
+	}
+
+	return FileInfo{}, fmt.Errorf("failed to stat %s: %w", remotePath, fs.ErrNotExist)
+}
+
+// Walk calls fn for every file and directory below root. Directories are
+// listed one at a time, so fn may use the client.
+func (client *Client) Walk(ctx context.Context, root string, fn WalkFunc) error {
+	err := client.walk(ctx, root, fn)
+	if errors.Is(err, fs.SkipDir) {
+		return nil
+	}
+	return err
+}
+
+func (client *Client) walk(ctx context.Context, dir string, fn WalkFunc) error {
+	files, err := client.ListFiles(ctx, dir)
+	if err != nil {
+		return err
+	}
+
+	for _, file := range files {
+		err := fn(file)
+		if errors.Is(err, fs.SkipDir) {
+			if file.IsDir {
+				continue
+			}
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if file.IsDir {
+			if err := client.walk(ctx, file.Path, fn); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func listDir(conn *ftp.ServerConn, dir string) ([]FileInfo, error) {
+	entries, err := conn.List(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list files: %w", err)
+	}
+
+	var files []FileInfo
+	for _, entry := range entries {
+		if entry.Name == "." || entry.Name == ".." {
+			continue
+		}
+
+		switch entry.Type {
+		case ftp.EntryTypeFile, ftp.EntryTypeFolder:
+			files = append(files, FileInfo{
+				Name:    entry.Name,
+				Path:    path.Join(dir, entry.Name),
+				Size:    int64(entry.Size),
+				ModTime: entry.Time,
+				IsDir:   entry.Type == ftp.EntryTypeFolder,
+			})
+		}
+	}
+
+	return files, nil
+}
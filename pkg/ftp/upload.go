@@ -0,0 +1,211 @@
+package ftp
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/jlaffaye/ftp"
+)
+
+const (
+	progressInterval = 250 * time.Millisecond
+	retryDelay       = time.Second
+)
+
+// UploadProgress is reported periodically while an upload is running.
+type UploadProgress struct {
+	BytesSent  int64
+	TotalBytes int64
+	Rate       float64 // bytes per second
+	ETA        time.Duration
+}
+
+type UploadOptions struct {
+	// Progress is called from the uploading goroutine, so it should return
+	// quickly.
+	Progress func(UploadProgress)
+
+	// Retries is the number of times an interrupted transfer is resumed with
+	// REST. Resuming requires the reader to implement io.Seeker, the upload
+	// resumes relative to the reader's position when Upload was called.
+	Retries int
+}
+
+// Upload streams r to remotePath. size is the number of bytes r will produce
+// and is used for progress reporting and to verify the upload with SIZE once
+// the transfer completes. Pass a negative size if it is unknown.
+func (client *Client) Upload(ctx context.Context, remotePath string, r io.Reader, size int64, options UploadOptions) error {
+	progress := newProgressReader(r, size, options.Progress)
+
+	// The reader may not be at the start of its stream, so resume offsets are
+	// relative to where it is now
+	seeker, canResume := r.(io.Seeker)
+	var start int64
+	if canResume && options.Retries > 0 {
+		var err error
+		if start, err = seeker.Seek(0, io.SeekCurrent); err != nil {
+			canResume = false
+		}
+	}
+
+	var offset int64
+	for attempt := 0; ; attempt++ {
+		err := client.do(ctx, func(conn *ftp.ServerConn) error {
+			if offset == 0 {
+				if err := createDirectory(conn, path.Dir(remotePath)); err != nil {
+					return fmt.Errorf("failed to create remote directory: %w", err)
+				}
+			}
+
+			if err := conn.StorFrom(remotePath, progress, uint64(offset)); err != nil {
+				return fmt.Errorf("failed to upload file: %w", err)
+			}
+
+			return nil
+		})
+		if err == nil {
+			break
+		}
+
+		if !canResume || attempt >= options.Retries || ctx.Err() != nil {
+			return err
+		}
+
+		offset, err = client.resumeOffset(ctx, remotePath, attempt)
+		if err != nil {
+			return fmt.Errorf("failed to resume upload: %w", err)
+		}
+
+		if _, err := seeker.Seek(start+offset, io.SeekStart); err != nil {
+			return fmt.Errorf("failed to resume upload: %w", err)
+		}
+		progress.reset(offset)
+	}
+
+	progress.report(true)
+
+	if size < 0 {
+		return nil
+	}
+
+	var remoteSize int64
+	err := client.do(ctx, func(conn *ftp.ServerConn) error {
+		var err error
+		remoteSize, err = conn.FileSize(remotePath)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to verify upload: %w", err)
+	}
+
+	if remoteSize != size {
+		return fmt.Errorf("failed to verify upload: remote size %d does not match %d", remoteSize, size)
+	}
+
+	return nil
+}
+
+// resumeOffset reconnects after a failed transfer and returns the number of
+// bytes the printer already received.
+func (client *Client) resumeOffset(ctx context.Context, remotePath string, attempt int) (int64, error) {
+	select {
+	case <-time.After(retryDelay * time.Duration(attempt+1)):
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	}
+
+	client.Disconnect()
+	if err := client.Connect(ctx); err != nil {
+		return 0, err
+	}
+
+	var offset int64
+	err := client.do(ctx, func(conn *ftp.ServerConn) error {
+		var err error
+		offset, err = conn.FileSize(remotePath)
+		if err != nil {
+			// Nothing was stored, start again from the beginning
+			offset = 0
+		}
+		return nil
+	})
+
+	return offset, err
+}
+
+type progressReader struct {
+	reader   io.Reader
+	total    int64
+	callback func(UploadProgress)
+
+	mu         sync.Mutex
+	sent       int64
+	startBytes int64
+	start      time.Time
+	lastReport time.Time
+}
+
+func newProgressReader(reader io.Reader, total int64, callback func(UploadProgress)) *progressReader {
+	return &progressReader{
+		reader:   reader,
+		total:    total,
+		callback: callback,
+		start:    time.Now(),
+	}
+}
+
+func (reader *progressReader) Read(p []byte) (int, error) {
+	n, err := reader.reader.Read(p)
+
+	reader.mu.Lock()
+	reader.sent += int64(n)
+	reader.mu.Unlock()
+
+	reader.report(false)
+
+	return n, err
+}
+
+// reset rewinds the counter after a resume. The rate is measured from this
+// point so that the skipped bytes do not inflate it.
+func (reader *progressReader) reset(offset int64) {
+	reader.mu.Lock()
+	defer reader.mu.Unlock()
+
+	reader.sent = offset
+	reader.startBytes = offset
+	reader.start = time.Now()
+}
+
+func (reader *progressReader) report(force bool) {
+	if reader.callback == nil {
+		return
+	}
+
+	reader.mu.Lock()
+	now := time.Now()
+	if !force && now.Sub(reader.lastReport) < progressInterval {
+		reader.mu.Unlock()
+		return
+	}
+	reader.lastReport = now
+
+	progress := UploadProgress{
+		BytesSent:  reader.sent,
+		TotalBytes: reader.total,
+	}
+
+	if elapsed := now.Sub(reader.start).Seconds(); elapsed > 0 {
+		progress.Rate = float64(reader.sent-reader.startBytes) / elapsed
+	}
+	if progress.Rate > 0 && reader.total > reader.sent {
+		progress.ETA = time.Duration(float64(reader.total-reader.sent) / progress.Rate * float64(time.Second))
+	}
+	reader.mu.Unlock()
+
+	reader.callback(progress)
+}
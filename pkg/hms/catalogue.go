@@ -0,0 +1,217 @@
+package hms
+
+import (
+	"context"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+//go:embed catalogue.json
+var defaultCatalogueJSON []byte
+
+var (
+	defaultCatalogue     *Catalogue
+	defaultCatalogueOnce sync.Once
+)
+
+// Catalogue maps HMS and print error codes to English messages. HMS entries
+// are keyed by their canonical ID and print errors by XXXX_XXXX. Messages
+// for AMS errors are stored once for AMS A slot 1 and may contain the
+// placeholders {ams} and {slot}.
+//
+// Catalogues are JSON, either in the format of the embedded catalogue:
+//
+//	{"hms": {"HMS_0300_0100_0001_0001": "..."}, "print_error": {"0300_400C": "..."}}
+//
+// or in the format of the full list Bambu Studio downloads from
+// https://e.bambulab.com/query.php?lang=en, of which only the English
+// messages are used.
+type Catalogue struct {
+	mu          sync.RWMutex
+	hms         map[string]string
+	printErrors map[string]string
+}
+
+type catalogueFile struct {
+	HMS         map[string]string `json:"hms"`
+	PrintErrors map[string]string `json:"print_error"`
+
+	// Data is set in Bambu's format
+	Data *struct {
+		HMS         bambuMessages `json:"device_hms"`
+		PrintErrors bambuMessages `json:"device_error"`
+	} `json:"data"`
+}
+
+type bambuMessages struct {
+	English []struct {
+		ECode string `json:"ecode"`
+		Intro string `json:"intro"`
+	} `json:"en"`
+}
+
+func NewCatalogue() *Catalogue {
+	return &Catalogue{
+		hms:         make(map[string]string),
+		printErrors: make(map[string]string),
+	}
+}
+
+// DefaultCatalogue returns the catalogue embedded in the package. It is used
+// by Decode and can be extended with Update, UpdateFromFile and
+// UpdateFromURL. The embedded catalogue only covers common errors, the full
+// list can be merged in from Bambu's servers.
+func DefaultCatalogue() *Catalogue {
+	defaultCatalogueOnce.Do(func() {
+		defaultCatalogue = NewCatalogue()
+
+		// The embedded data is checked by TestEmbeddedCatalogue
+		_ = defaultCatalogue.Update(defaultCatalogueJSON)
+	})
+	return defaultCatalogue
+}
+
+// LoadCatalogue reads a catalogue from a JSON file.
+func LoadCatalogue(path string) (*Catalogue, error) {
+	catalogue := NewCatalogue()
+	if err := catalogue.UpdateFromFile(path); err != nil {
+		return nil, err
+	}
+	return catalogue, nil
+}
+
+// FetchCatalogue downloads a catalogue, such as Bambu's full list.
+func FetchCatalogue(ctx context.Context, url string) (*Catalogue, error) {
+	catalogue := NewCatalogue()
+	if err := catalogue.UpdateFromURL(ctx, url); err != nil {
+		return nil, err
+	}
+	return catalogue, nil
+}
+
+// Update merges JSON catalogue data into the catalogue, replacing existing
+// messages.
+func (catalogue *Catalogue) Update(data []byte) error {
+	var file catalogueFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return fmt.Errorf("failed to parse catalogue: %w", err)
+	}
+
+	catalogue.mu.Lock()
+	defer catalogue.mu.Unlock()
+
+	for id, message := range file.HMS {
+		catalogue.hms[strings.ToUpper(id)] = message
+	}
+	for code, message := range file.PrintErrors {
+		catalogue.printErrors[strings.ToUpper(code)] = message
+	}
+
+	if file.Data != nil {
+		for _, entry := range file.Data.HMS.English {
+			if id, ok := splitECode(entry.ECode, 16); ok && entry.Intro != "" {
+				catalogue.hms["HMS_"+id] = entry.Intro
+			}
+		}
+		for _, entry := range file.Data.PrintErrors.English {
+			if code, ok := splitECode(entry.ECode, 8); ok && entry.Intro != "" {
+				catalogue.printErrors[code] = entry.Intro
+			}
+		}
+	}
+
+	return nil
+}
+
+// splitECode turns Bambu's ecodes, such as 0300400C, into the XXXX_XXXX form
+// used as keys.
+func splitECode(ecode string, length int) (string, bool) {
+	ecode = strings.ToUpper(ecode)
+	if len(ecode) != length || strings.Trim(ecode, "0123456789ABCDEF") != "" {
+		return "", false
+	}
+
+	groups := make([]string, 0, length/4)
+	for i := 0; i < length; i += 4 {
+		groups = append(groups, ecode[i:i+4])
+	}
+	return strings.Join(groups, "_"), true
+}
+
+func (catalogue *Catalogue) UpdateFromFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read catalogue: %w", err)
+	}
+	return catalogue.Update(data)
+}
+
+func (catalogue *Catalogue) UpdateFromURL(ctx context.Context, url string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create catalogue request: %w", err)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to download catalogue: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to download catalogue: %s", resp.Status)
+	}
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to download catalogue: %w", err)
+	}
+	return catalogue.Update(data)
+}
+
+// Describe decodes an HMS error and fills in its message.
+func (catalogue *Catalogue) Describe(attr, code uint32) Error {
+	e := decode(attr, code)
+	e.Message, _ = catalogue.Message(attr, code)
+	return e
+}
+
+// Message returns the message of an HMS error.
+func (catalogue *Catalogue) Message(attr, code uint32) (string, bool) {
+	catalogue.mu.RLock()
+	defer catalogue.mu.RUnlock()
+
+	message, ok := catalogue.hms[FormatID(attr, code)]
+	if !ok {
+		message, ok = catalogue.hms[FormatID(genericAttr(attr), code)]
+	}
+	if !ok {
+		return "", false
+	}
+
+	e := decode(attr, code)
+	if e.AMSUnit >= 0 {
+		message = strings.ReplaceAll(message, "{ams}", string(rune('A'+e.AMSUnit)))
+	}
+	if e.AMSSlot >= 0 {
+		message = strings.ReplaceAll(message, "{slot}", strconv.Itoa(e.AMSSlot+1))
+	}
+
+	return message, true
+}
+
+// PrintErrorMessage returns the message of a print_error code.
+func (catalogue *Catalogue) PrintErrorMessage(code int) (string, bool) {
+	catalogue.mu.RLock()
+	defer catalogue.mu.RUnlock()
+
+	message, ok := catalogue.printErrors[FormatPrintError(code)]
+	return message, ok
+}
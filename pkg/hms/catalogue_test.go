@@ -0,0 +1,199 @@
+package hms
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+)
+
+var (
+	hmsIDPattern      = regexp.MustCompile(`^HMS_[0-9A-F]{4}_[0-9A-F]{4}_[0-9A-F]{4}_[0-9A-F]{4}$`)
+	printErrorPattern = regexp.MustCompile(`^[0-9A-F]{4}_[0-9A-F]{4}$`)
+)
+
+func TestEmbeddedCatalogue(t *testing.T) {
+	var file catalogueFile
+	decoder := json.NewDecoder(bytes.NewReader(defaultCatalogueJSON))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&file); err != nil {
+		t.Fatalf("embedded catalogue: %v", err)
+	}
+
+	modules := make(map[Module]int)
+	for id, message := range file.HMS {
+		if !hmsIDPattern.MatchString(id) {
+			t.Errorf("malformed HMS id %q", id)
+		}
+		if message == "" {
+			t.Errorf("%s has no message", id)
+		}
+
+		var attrHigh, attrLow, codeHigh, codeLow uint32
+		if _, err := fmt.Sscanf(id, "HMS_%04X_%04X_%04X_%04X", &attrHigh, &attrLow, &codeHigh, &codeLow); err != nil {
+			t.Errorf("%s: %v", id, err)
+			continue
+		}
+		attr := attrHigh<<16 | attrLow
+		if genericAttr(attr) != attr {
+			t.Errorf("%s is not stored for AMS A slot 1", id)
+		}
+		modules[Module(attr>>24)]++
+	}
+
+	for _, module := range []Module{ModuleMC, ModuleMainboard, ModuleAMS, ModuleXCam, ModuleAMSLite} {
+		if modules[module] == 0 {
+			t.Errorf("no HMS messages for the %s module", module)
+		}
+	}
+
+	if len(file.PrintErrors) == 0 {
+		t.Error("no print_error messages")
+	}
+	for code, message := range file.PrintErrors {
+		if !printErrorPattern.MatchString(code) || message == "" {
+			t.Errorf("malformed print_error %q: %q", code, message)
+		}
+	}
+}
+
+// bambuCatalogue is an excerpt of the list Bambu Studio downloads.
+const bambuCatalogue = `{
+	"result": 0,
+	"t": 1717000000,
+	"ver": 202405290000,
+	"data": {
+		"device_hms": {
+			"ver": 202405290000,
+			"en": [
+				{"ecode": "0300010000010001", "intro": "Heatbed heater short circuit."},
+				{"ecode": "0701230000020001", "intro": "AMS B Slot 4 filament has run out."},
+				{"ecode": "07", "intro": "Malformed"},
+				{"ecode": "0C0003000003000Z", "intro": "Malformed"}
+			]
+		},
+		"device_error": {
+			"ver": 202405290000,
+			"en": [
+				{"ecode": "0300400c", "intro": "Canceled."},
+				{"ecode": "05004002", "intro": ""}
+			]
+		}
+	}
+}`
+
+func TestUpdate(t *testing.T) {
+	tests := []struct {
+		name       string
+		data       string
+		attr, code uint32
+		want       string
+		printError int
+		wantPrint  string
+		wantErr    bool
+	}{
+		{
+			name:       "catalogue format",
+			data:       `{"hms": {"hms_0300_0100_0001_0001": "Heater"}, "print_error": {"0300_400c": "Canceled"}}`,
+			attr:       0x03000100,
+			code:       0x00010001,
+			want:       "Heater",
+			printError: 0x0300400C,
+			wantPrint:  "Canceled",
+		},
+		{
+			name:       "Bambu format",
+			data:       bambuCatalogue,
+			attr:       0x07012300,
+			code:       0x00020001,
+			want:       "AMS B Slot 4 filament has run out.",
+			printError: 0x0300400C,
+			wantPrint:  "Canceled.",
+		},
+		{name: "invalid", data: `{"hms": []}`, wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			catalogue := NewCatalogue()
+			err := catalogue.Update([]byte(test.data))
+			if test.wantErr {
+				if err == nil {
+					t.Fatal("Update succeeded")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if message, _ := catalogue.Message(test.attr, test.code); message != test.want {
+				t.Errorf("Message = %q, want %q", message, test.want)
+			}
+			if message, _ := catalogue.PrintErrorMessage(test.printError); message != test.wantPrint {
+				t.Errorf("PrintErrorMessage = %q, want %q", message, test.wantPrint)
+			}
+		})
+	}
+
+	catalogue := NewCatalogue()
+	if err := catalogue.Update([]byte(bambuCatalogue)); err != nil {
+		t.Fatal(err)
+	}
+	if len(catalogue.hms) != 2 || len(catalogue.printErrors) != 1 {
+		t.Errorf("kept %v and %v, want malformed and empty entries skipped", catalogue.hms, catalogue.printErrors)
+	}
+}
+
+func TestLoadCatalogue(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/query.php" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(bambuCatalogue))
+	}))
+	defer server.Close()
+
+	local := filepath.Join(t.TempDir(), "catalogue.json")
+	if err := os.WriteFile(local, []byte(bambuCatalogue), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	tests := []struct {
+		name    string
+		load    func() (*Catalogue, error)
+		wantErr bool
+	}{
+		{name: "file", load: func() (*Catalogue, error) { return LoadCatalogue(local) }},
+		{name: "missing file", load: func() (*Catalogue, error) { return LoadCatalogue(local + ".missing") }, wantErr: true},
+		{name: "url", load: func() (*Catalogue, error) { return FetchCatalogue(ctx, server.URL+"/query.php?lang=en") }},
+		{name: "not found", load: func() (*Catalogue, error) { return FetchCatalogue(ctx, server.URL+"/missing") }, wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			catalogue, err := test.load()
+			if test.wantErr {
+				if err == nil {
+					t.Fatal("load succeeded")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if message, ok := catalogue.PrintErrorMessage(0x0300400C); !ok || message != "Canceled." {
+				t.Errorf("PrintErrorMessage = %q, %v", message, ok)
+			}
+		})
+	}
+}
@@ -0,0 +1,156 @@
+// Package hms decodes the Health Management System errors reported by the
+// printer in print.hms and print.print_error.
+package hms
+
+import (
+	"fmt"
+)
+
+type Module uint8
+
+const (
+	ModuleMC        Module = 0x03
+	ModuleMainboard Module = 0x05
+	ModuleAMS       Module = 0x07
+	ModuleToolhead  Module = 0x08
+	ModuleXCam      Module = 0x0C
+	ModuleAMSLite   Module = 0x12
+)
+
+var moduleNames = map[Module]string{
+	ModuleMC:        "mc",
+	ModuleMainboard: "mainboard",
+	ModuleAMS:       "ams",
+	ModuleToolhead:  "toolhead",
+	ModuleXCam:      "xcam",
+	ModuleAMSLite:   "ams lite",
+}
+
+func (module Module) String() string {
+	if name, ok := moduleNames[module]; ok {
+		return name
+	}
+	return fmt.Sprintf("unknown(%02X)", uint8(module))
+}
+
+type Severity uint16
+
+const (
+	SeverityFatal   Severity = 1
+	SeveritySerious Severity = 2
+	SeverityCommon  Severity = 3
+	SeverityInfo    Severity = 4
+)
+
+var severityNames = map[Severity]string{
+	SeverityFatal:   "fatal",
+	SeveritySerious: "serious",
+	SeverityCommon:  "common",
+	SeverityInfo:    "info",
+}
+
+func (severity Severity) String() string {
+	if name, ok := severityNames[severity]; ok {
+		return name
+	}
+	return "unknown"
+}
+
+// Error is a decoded HMS error. AMSUnit and AMSSlot are zero based. AMSUnit
+// is -1 when the error does not concern an AMS and AMSSlot when it does not
+// concern a single slot.
+type Error struct {
+	Attr     uint32
+	Code     uint32
+	ID       string
+	Module   Module
+	Severity Severity
+	AMSUnit  int
+	AMSSlot  int
+	Message  string
+}
+
+func (e Error) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("%s: %s %s error", e.ID, e.Severity, e.Module)
+	}
+	return fmt.Sprintf("%s: %s", e.ID, e.Message)
+}
+
+// Decode decodes an HMS error and looks up its message in the default
+// catalogue.
+func Decode(attr, code uint32) Error {
+	return DefaultCatalogue().Describe(attr, code)
+}
+
+// FormatID returns the canonical HMS_XXXX_XXXX_XXXX_XXXX form of an error, as
+// used by the Bambu Lab wiki and Bambu Studio.
+func FormatID(attr, code uint32) string {
+	return fmt.Sprintf("HMS_%04X_%04X_%04X_%04X", attr>>16, attr&0xFFFF, code>>16, code&0xFFFF)
+}
+
+// FormatPrintError returns the canonical XXXX_XXXX form of a print_error.
+func FormatPrintError(code int) string {
+	return fmt.Sprintf("%04X_%04X", uint32(code)>>16, uint32(code)&0xFFFF)
+}
+
+func decode(attr, code uint32) Error {
+	e := Error{
+		Attr:     attr,
+		Code:     code,
+		ID:       FormatID(attr, code),
+		Module:   Module(attr >> 24),
+		Severity: Severity(code >> 16),
+		AMSUnit:  -1,
+		AMSSlot:  -1,
+	}
+
+	if isAMS(e.Module) {
+		e.AMSUnit = amsUnit(attr)
+		if hasSlot(attr) {
+			e.AMSSlot = amsSlot(attr)
+		}
+	}
+
+	return e
+}
+
+// AMS attrs are laid out as MM UU GS PP: module, AMS unit, an error group
+// whose low nibble is the slot for slot errors, and the part. Groups 1 to 3
+// (slot motor, filament and RFID errors) concern a slot, the rest the whole
+// unit.
+const (
+	amsUnitMask = 0x00FF0000
+	amsSlotMask = 0x00000F00
+)
+
+func isAMS(module Module) bool {
+	return module == ModuleAMS || module == ModuleAMSLite
+}
+
+func amsUnit(attr uint32) int {
+	return int(attr&amsUnitMask) >> 16
+}
+
+func amsSlot(attr uint32) int {
+	return int(attr&amsSlotMask) >> 8
+}
+
+func hasSlot(attr uint32) bool {
+	group := (attr >> 12) & 0x0F
+	return group >= 1 && group <= 3
+}
+
+// genericAttr clears the AMS unit, and the slot of slot errors, so that one
+// catalogue entry for AMS A slot 1 covers every unit and slot.
+func genericAttr(attr uint32) uint32 {
+	if !isAMS(Module(attr >> 24)) {
+		return attr
+	}
+
+	attr &^= amsUnitMask
+	if hasSlot(attr) {
+		attr &^= amsSlotMask
+	}
+	return attr
+}
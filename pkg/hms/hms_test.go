@@ -0,0 +1,115 @@
+package hms
+
+import "testing"
+
+func TestDecode(t *testing.T) {
+	tests := []struct {
+		name    string
+		attr    uint32
+		code    uint32
+		id      string
+		module  Module
+		unit    int
+		slot    int
+		message string
+	}{
+		{
+			name:    "heatbed",
+			attr:    0x03000100,
+			code:    0x00010001,
+			id:      "HMS_0300_0100_0001_0001",
+			module:  ModuleMC,
+			unit:    -1,
+			slot:    -1,
+			message: "The heatbed temperature is abnormal; the heater may be short circuited.",
+		},
+		{
+			name:    "AMS A slot 1 run out",
+			attr:    0x07002000,
+			code:    0x00020001,
+			id:      "HMS_0700_2000_0002_0001",
+			module:  ModuleAMS,
+			unit:    0,
+			slot:    0,
+			message: "AMS A Slot 1 filament has run out.",
+		},
+		{
+			name:    "AMS C slot 4 run out",
+			attr:    0x07022300,
+			code:    0x00020001,
+			id:      "HMS_0702_2300_0002_0001",
+			module:  ModuleAMS,
+			unit:    2,
+			slot:    3,
+			message: "AMS C Slot 4 filament has run out.",
+		},
+		{
+			name:    "AMS B assist motor",
+			attr:    0x07010100,
+			code:    0x00010001,
+			id:      "HMS_0701_0100_0001_0001",
+			module:  ModuleAMS,
+			unit:    1,
+			slot:    -1,
+			message: "The AMS B assist motor has slipped. The extrusion wheel may be worn down, or the filament may be too thin.",
+		},
+		{
+			name:   "unknown AMS lite error",
+			attr:   0x12002100,
+			code:   0x00020009,
+			id:     "HMS_1200_2100_0002_0009",
+			module: ModuleAMSLite,
+			unit:   0,
+			slot:   1,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			e := Decode(test.attr, test.code)
+
+			if e.ID != test.id {
+				t.Errorf("ID = %s, want %s", e.ID, test.id)
+			}
+			if e.Module != test.module {
+				t.Errorf("Module = %s, want %s", e.Module, test.module)
+			}
+			if e.AMSUnit != test.unit || e.AMSSlot != test.slot {
+				t.Errorf("AMS unit, slot = %d, %d, want %d, %d", e.AMSUnit, e.AMSSlot, test.unit, test.slot)
+			}
+			if e.Message != test.message {
+				t.Errorf("Message = %q, want %q", e.Message, test.message)
+			}
+		})
+	}
+}
+
+func TestGenericAttr(t *testing.T) {
+	tests := []struct {
+		attr uint32
+		want uint32
+	}{
+		{0x03000100, 0x03000100},
+		{0x07032200, 0x07002000},
+		{0x07010100, 0x07000100},
+		{0x07024500, 0x07004500},
+		{0x12001300, 0x12001000},
+	}
+
+	for _, test := range tests {
+		if got := genericAttr(test.attr); got != test.want {
+			t.Errorf("genericAttr(%08X) = %08X, want %08X", test.attr, got, test.want)
+		}
+	}
+}
+
+func TestPrintErrorMessage(t *testing.T) {
+	message, ok := DefaultCatalogue().PrintErrorMessage(0x0300400C)
+	if !ok || message != "The task was canceled." {
+		t.Errorf("PrintErrorMessage = %q, %v", message, ok)
+	}
+
+	if _, ok := DefaultCatalogue().PrintErrorMessage(0x12345678); ok {
+		t.Error("PrintErrorMessage found an unknown code")
+	}
+}
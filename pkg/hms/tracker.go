@@ -0,0 +1,151 @@
+package hms
+
+import (
+	"encoding/json"
+	"strconv"
+	"sync"
+
+	"github.com/RobertMNewton/bambu-golang-api/pkg/mqtt/report"
+)
+
+type EventType int
+
+const (
+	HMSRaised EventType = iota
+	HMSCleared
+)
+
+func (eventType EventType) String() string {
+	switch eventType {
+	case HMSRaised:
+		return "raised"
+	case HMSCleared:
+		return "cleared"
+	default:
+		return "unknown"
+	}
+}
+
+type Event struct {
+	Type  EventType
+	Error Error
+}
+
+type key struct {
+	attr uint32
+	code uint32
+}
+
+// Tracker diffs the HMS lists of successive reports. Reports that do not
+// carry an hms list leave the active errors unchanged.
+type Tracker struct {
+	catalogue *Catalogue
+
+	mu     sync.Mutex
+	active map[key]Error
+	order  []key
+}
+
+func NewTracker() *Tracker {
+	return NewTrackerWithCatalogue(DefaultCatalogue())
+}
+
+func NewTrackerWithCatalogue(catalogue *Catalogue) *Tracker {
+	return &Tracker{
+		catalogue: catalogue,
+		active:    make(map[key]Error),
+	}
+}
+
+// Apply updates the active errors from a report and returns the errors that
+// were raised or cleared since the previous hms list.
+func (tracker *Tracker) Apply(r report.Report) []Event {
+	if r.Type != report.TypePrint {
+		return nil
+	}
+
+	value, ok := r.Payload.Params["hms"]
+	if !ok {
+		return nil
+	}
+
+	list, ok := value.([]interface{})
+	if !ok {
+		return nil
+	}
+
+	tracker.mu.Lock()
+	defer tracker.mu.Unlock()
+
+	var events []Event
+
+	current := make(map[key]bool, len(list))
+	var order []key
+	for _, item := range list {
+		obj, ok := item.(map[string]interface{})
+		if !ok {
+			continue
+		}
+
+		k := key{attr: toUint32(obj["attr"]), code: toUint32(obj["code"])}
+		if current[k] {
+			continue
+		}
+		current[k] = true
+		order = append(order, k)
+
+		if _, ok := tracker.active[k]; !ok {
+			e := tracker.catalogue.Describe(k.attr, k.code)
+			tracker.active[k] = e
+			events = append(events, Event{Type: HMSRaised, Error: e})
+		}
+	}
+
+	for _, k := range tracker.order {
+		if !current[k] {
+			events = append(events, Event{Type: HMSCleared, Error: tracker.active[k]})
+			delete(tracker.active, k)
+		}
+	}
+	tracker.order = order
+
+	return events
+}
+
+// Active returns the errors currently reported, in the printer's order.
+func (tracker *Tracker) Active() []Error {
+	tracker.mu.Lock()
+	defer tracker.mu.Unlock()
+
+	errors := make([]Error, 0, len(tracker.order))
+	for _, k := range tracker.order {
+		errors = append(errors, tracker.active[k])
+	}
+	return errors
+}
+
+func (tracker *Tracker) Reset() {
+	tracker.mu.Lock()
+	defer tracker.mu.Unlock()
+
+	tracker.active = make(map[key]Error)
+	tracker.order = nil
+}
+
+func toUint32(value interface{}) uint32 {
+	switch v := value.(type) {
+	case float64:
+		return uint32(v)
+	case json.Number:
+		n, _ := strconv.ParseUint(v.String(), 10, 32)
+		return uint32(n)
+	case string:
+		n, _ := strconv.ParseUint(v, 10, 32)
+		return uint32(n)
+	case int:
+		return uint32(v)
+	case uint32:
+		return v
+	}
+	return 0
+}
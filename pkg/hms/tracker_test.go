@@ -0,0 +1,39 @@
+package hms
+
+import (
+	"testing"
+
+	"github.com/RobertMNewton/bambu-golang-api/pkg/mqtt/report"
+)
+
+func TestTracker(t *testing.T) {
+	tracker := NewTracker()
+
+	apply := func(data string) []Event {
+		t.Helper()
+		r, err := report.Decode([]byte(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tracker.Apply(r)
+	}
+
+	// 0x07002000 = 117448704, 0x03000100 = 50331904
+	events := apply(`{"print":{"command":"push_status","hms":[{"attr":117448704,"code":131073},{"attr":50331904,"code":65537}]}}`)
+	if len(events) != 2 || events[0].Type != HMSRaised || events[0].Error.ID != "HMS_0700_2000_0002_0001" {
+		t.Fatalf("events = %+v, want two raised", events)
+	}
+
+	if events := apply(`{"print":{"command":"push_status","mc_percent":5}}`); len(events) != 0 {
+		t.Errorf("report without hms produced %+v", events)
+	}
+
+	events = apply(`{"print":{"command":"push_status","hms":[{"attr":50331904,"code":65537}]}}`)
+	if len(events) != 1 || events[0].Type != HMSCleared || events[0].Error.ID != "HMS_0700_2000_0002_0001" {
+		t.Errorf("events = %+v, want the AMS error cleared", events)
+	}
+
+	if active := tracker.Active(); len(active) != 1 || active[0].Module != ModuleMC {
+		t.Errorf("active = %+v", active)
+	}
+}
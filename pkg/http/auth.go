@@ -0,0 +1,271 @@
+package http
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+type LoginMethod string
+
+const (
+	// LoginComplete means the login succeeded and the client has a token
+	LoginComplete LoginMethod = ""
+
+	// LoginVerifyCode means a code must be requested with
+	// SendVerificationCode and passed to LoginWithCode
+	LoginVerifyCode LoginMethod = "verifyCode"
+
+	// LoginTFA means the code from the user's authenticator app must be
+	// passed to LoginWithTFA together with LoginResult.TFAKey
+	LoginTFA LoginMethod = "tfa"
+)
+
+// Token is a cloud access token. Access tokens are also the MQTT password
+// of cloud printers.
+type Token struct {
+	AccessToken  string    `json:"access_token"`
+	RefreshToken string    `json:"refresh_token,omitempty"`
+	ExpiresAt    time.Time `json:"expires_at,omitempty"`
+
+	RefreshExpiresAt time.Time `json:"refresh_expires_at,omitempty"`
+}
+
+// Valid reports whether the access token is set and not about to expire.
+func (token Token) Valid() bool {
+	if token.AccessToken == "" {
+		return false
+	}
+	return token.ExpiresAt.IsZero() || time.Now().Add(refreshMargin).Before(token.ExpiresAt)
+}
+
+// RefreshAt returns when the token stops being Valid and will be refreshed,
+// shortly before it expires. It is zero for tokens without an expiry.
+func (token Token) RefreshAt() time.Time {
+	if token.ExpiresAt.IsZero() {
+		return time.Time{}
+	}
+	return token.ExpiresAt.Add(-refreshMargin)
+}
+
+func (token Token) canRefresh() bool {
+	if token.RefreshToken == "" {
+		return false
+	}
+	return token.RefreshExpiresAt.IsZero() || time.Now().Before(token.RefreshExpiresAt)
+}
+
+// LoginResult is the outcome of a password login. Unless Method is
+// LoginComplete, the login must be finished with LoginWithCode or
+// LoginWithTFA.
+type LoginResult struct {
+	Method LoginMethod
+	TFAKey string
+}
+
+type tokenResponse struct {
+	AccessToken      string `json:"accessToken"`
+	RefreshToken     string `json:"refreshToken"`
+	ExpiresIn        int64  `json:"expiresIn"`
+	RefreshExpiresIn int64  `json:"refreshExpiresIn"`
+	LoginType        string `json:"loginType"`
+	TFAKey           string `json:"tfaKey"`
+}
+
+func (resp tokenResponse) token() Token {
+	now := time.Now()
+
+	token := Token{
+		AccessToken:  resp.AccessToken,
+		RefreshToken: resp.RefreshToken,
+	}
+	if resp.ExpiresIn > 0 {
+		token.ExpiresAt = now.Add(time.Duration(resp.ExpiresIn) * time.Second)
+	}
+	if resp.RefreshExpiresIn > 0 {
+		token.RefreshExpiresAt = now.Add(time.Duration(resp.RefreshExpiresIn) * time.Second)
+	}
+	return token
+}
+
+// Login authenticates with the cloud API using the username and password of
+// the client's printer config.
+func (c *Client) Login(ctx context.Context) error {
+	if c.config == nil {
+		return fmt.Errorf("login failed: client has no printer config")
+	}
+
+	result, err := c.LoginWithPassword(ctx, c.config.GetUsername(), c.config.GetPassword())
+	if err != nil {
+		return err
+	}
+	if result.Method != LoginComplete {
+		return fmt.Errorf("login failed: account requires %s login", result.Method)
+	}
+	return nil
+}
+
+// LoginWithPassword logs in with an email address or phone number and a
+// password. Accounts may additionally require a verification code or a
+// two-factor code, see LoginResult.
+func (c *Client) LoginWithPassword(ctx context.Context, account, password string) (LoginResult, error) {
+	reqBody := map[string]string{
+		"account":  account,
+		"password": password,
+		"apiError": "",
+	}
+
+	var resp tokenResponse
+	if err := c.doRequest(ctx, "POST", c.baseURL+"/v1/user-service/user/login", reqBody, &resp); err != nil {
+		return LoginResult{}, fmt.Errorf("login failed: %w", err)
+	}
+
+	if resp.AccessToken == "" {
+		switch LoginMethod(resp.LoginType) {
+		case LoginVerifyCode:
+			return LoginResult{Method: LoginVerifyCode}, nil
+		case LoginTFA:
+			return LoginResult{Method: LoginTFA, TFAKey: resp.TFAKey}, nil
+		default:
+			return LoginResult{}, fmt.Errorf("login failed: no token in response (login type %q)", resp.LoginType)
+		}
+	}
+
+	c.SetToken(resp.token())
+	return LoginResult{Method: LoginComplete}, nil
+}
+
+// SendVerificationCode emails a login code to the account.
+func (c *Client) SendVerificationCode(ctx context.Context, email string) error {
+	reqBody := map[string]string{
+		"email": email,
+		"type":  "codeLogin",
+	}
+
+	if err := c.doRequest(ctx, "POST", c.baseURL+"/v1/user-service/user/sendemail/code", reqBody, nil); err != nil {
+		return fmt.Errorf("failed to send verification code: %w", err)
+	}
+	return nil
+}
+
+// LoginWithCode logs in with a code sent by SendVerificationCode.
+func (c *Client) LoginWithCode(ctx context.Context, account, code string) error {
+	reqBody := map[string]string{
+		"account": account,
+		"code":    code,
+	}
+
+	var resp tokenResponse
+	if err := c.doRequest(ctx, "POST", c.baseURL+"/v1/user-service/user/login", reqBody, &resp); err != nil {
+		return fmt.Errorf("login failed: %w", err)
+	}
+	if resp.AccessToken == "" {
+		return fmt.Errorf("login failed: verification code was not accepted")
+	}
+
+	c.SetToken(resp.token())
+	return nil
+}
+
+// LoginWithTFA finishes a login that requires a two-factor code. The token
+// is returned as a cookie by the sign-in service rather than in the body.
+func (c *Client) LoginWithTFA(ctx context.Context, tfaKey, code string) error {
+	reqBody := map[string]string{
+		"tfaKey":  tfaKey,
+		"tfaCode": code,
+	}
+
+	var resp tokenResponse
+	httpResp, err := c.do(ctx, "POST", c.tfaURL+"/api/sign-in/tfa", reqBody, &resp)
+	if err != nil {
+		return fmt.Errorf("two-factor login failed: %w", err)
+	}
+
+	token := resp.token()
+	for _, cookie := range httpResp.Cookies() {
+		switch cookie.Name {
+		case "token":
+			token.AccessToken = cookie.Value
+			if !cookie.Expires.IsZero() {
+				token.ExpiresAt = cookie.Expires
+			}
+		case "refreshToken":
+			token.RefreshToken = cookie.Value
+		}
+	}
+
+	if token.AccessToken == "" {
+		return fmt.Errorf("two-factor login failed: no token in response")
+	}
+
+	c.SetToken(token)
+	return nil
+}
+
+// Refresh exchanges the refresh token for a new access token.
+func (c *Client) Refresh(ctx context.Context) error {
+	current := c.Token()
+	if current.RefreshToken == "" {
+		return fmt.Errorf("failed to refresh token: %w: no refresh token", ErrUnauthorized)
+	}
+
+	reqBody := map[string]string{
+		"refreshToken": current.RefreshToken,
+	}
+
+	var resp tokenResponse
+	if err := c.doRequest(ctx, "POST", c.baseURL+"/v1/user-service/user/refreshtoken", reqBody, &resp); err != nil {
+		return fmt.Errorf("failed to refresh token: %w", err)
+	}
+	if resp.AccessToken == "" {
+		return fmt.Errorf("failed to refresh token: no token in response")
+	}
+
+	token := resp.token()
+	if token.RefreshToken == "" {
+		token.RefreshToken = current.RefreshToken
+		token.RefreshExpiresAt = current.RefreshExpiresAt
+	}
+
+	c.SetToken(token)
+	return nil
+}
+
+// AccessToken returns a valid access token, refreshing it first if it is
+// about to expire.
+func (c *Client) AccessToken(ctx context.Context) (string, error) {
+	if err := c.ensureToken(ctx); err != nil {
+		return "", err
+	}
+	return c.Token().AccessToken, nil
+}
+
+// ensureToken refreshes the access token if it is about to expire.
+func (c *Client) ensureToken(ctx context.Context) error {
+	c.refreshMu.Lock()
+	defer c.refreshMu.Unlock()
+
+	token := c.Token()
+	if token.Valid() {
+		return nil
+	}
+	if token.canRefresh() {
+		return c.Refresh(ctx)
+	}
+	if token.AccessToken == "" {
+		return fmt.Errorf("%w: not logged in", ErrUnauthorized)
+	}
+	return fmt.Errorf("%w: token expired", ErrUnauthorized)
+}
+
+// replaceToken refreshes a token rejected by the server, unless another
+// request already replaced it.
+func (c *Client) replaceToken(ctx context.Context, rejected string) error {
+	c.refreshMu.Lock()
+	defer c.refreshMu.Unlock()
+
+	if c.Token().AccessToken != rejected {
+		return nil
+	}
+	return c.Refresh(ctx)
+}
@@ -0,0 +1,183 @@
+package http_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/RobertMNewton/bambu-golang-api/pkg/bambutest"
+	bambuhttp "github.com/RobertMNewton/bambu-golang-api/pkg/http"
+)
+
+func TestLogin(t *testing.T) {
+	tests := []struct {
+		name    string
+		options func(*bambutest.CloudOptions)
+		login   func(ctx context.Context, client *bambuhttp.Client) error
+	}{
+		{
+			name: "password",
+			login: func(ctx context.Context, client *bambuhttp.Client) error {
+				result, err := client.LoginWithPassword(ctx, bambutest.DefaultAccount, bambutest.DefaultPassword)
+				if err == nil && result.Method != bambuhttp.LoginComplete {
+					t.Errorf("method = %q, want complete", result.Method)
+				}
+				return err
+			},
+		},
+		{
+			name:    "verification code",
+			options: func(options *bambutest.CloudOptions) { options.RequireCode = true },
+			login: func(ctx context.Context, client *bambuhttp.Client) error {
+				result, err := client.LoginWithPassword(ctx, bambutest.DefaultAccount, bambutest.DefaultPassword)
+				if err != nil {
+					return err
+				}
+				if result.Method != bambuhttp.LoginVerifyCode {
+					t.Fatalf("method = %q, want %q", result.Method, bambuhttp.LoginVerifyCode)
+				}
+				if err := client.SendVerificationCode(ctx, bambutest.DefaultAccount); err != nil {
+					return err
+				}
+				return client.LoginWithCode(ctx, bambutest.DefaultAccount, bambutest.DefaultVerificationCode)
+			},
+		},
+		{
+			name:    "two-factor",
+			options: func(options *bambutest.CloudOptions) { options.RequireTFA = true },
+			login: func(ctx context.Context, client *bambuhttp.Client) error {
+				result, err := client.LoginWithPassword(ctx, bambutest.DefaultAccount, bambutest.DefaultPassword)
+				if err != nil {
+					return err
+				}
+				if result.Method != bambuhttp.LoginTFA || result.TFAKey == "" {
+					t.Fatalf("result = %+v, want a two-factor key", result)
+				}
+				return client.LoginWithTFA(ctx, result.TFAKey, bambutest.DefaultTFACode)
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			options := bambutest.DefaultCloudOptions()
+			if test.options != nil {
+				test.options(&options)
+			}
+			cloud := bambutest.NewCloudServerWithOptions(options)
+			defer cloud.Close()
+
+			ctx := context.Background()
+			client := cloud.Client()
+			if err := test.login(ctx, client); err != nil {
+				t.Fatalf("login failed: %v", err)
+			}
+
+			if !client.Token().Valid() || client.Token().RefreshToken == "" {
+				t.Errorf("token = %+v, want a valid token", client.Token())
+			}
+			if _, err := client.Profile(ctx); err != nil {
+				t.Errorf("Profile with the new token: %v", err)
+			}
+		})
+	}
+}
+
+func TestLoginRejected(t *testing.T) {
+	tests := []struct {
+		name     string
+		account  string
+		password string
+	}{
+		{name: "unknown account", account: "nobody@example.com", password: bambutest.DefaultPassword},
+		{name: "wrong password", account: bambutest.DefaultAccount, password: "wrong"},
+	}
+
+	cloud := bambutest.NewCloudServer()
+	defer cloud.Close()
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			client := cloud.Client()
+
+			_, err := client.LoginWithPassword(context.Background(), test.account, test.password)
+			var apiErr *bambuhttp.APIError
+			if !errors.As(err, &apiErr) {
+				t.Fatalf("LoginWithPassword = %v, want an APIError", err)
+			}
+			if client.Token().AccessToken != "" {
+				t.Error("rejected login set a token")
+			}
+		})
+	}
+}
+
+func TestRefresh(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("revoked token", func(t *testing.T) {
+		cloud := bambutest.NewCloudServer()
+		defer cloud.Close()
+
+		client := login(t, cloud)
+		before := client.Token().AccessToken
+		cloud.RevokeTokens()
+
+		if _, err := client.Devices(ctx); err != nil {
+			t.Fatalf("Devices after revocation: %v", err)
+		}
+		if client.Token().AccessToken == before {
+			t.Error("token was not replaced")
+		}
+	})
+
+	t.Run("expiring token", func(t *testing.T) {
+		cloud := bambutest.NewCloudServer()
+		defer cloud.Close()
+
+		client := login(t, cloud)
+		token := client.Token()
+		token.ExpiresAt = time.Now().Add(time.Second)
+		client.SetToken(token)
+
+		accessToken, err := client.AccessToken(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if accessToken == token.AccessToken {
+			t.Error("token about to expire was not refreshed")
+		}
+	})
+
+	t.Run("not logged in", func(t *testing.T) {
+		cloud := bambutest.NewCloudServer()
+		defer cloud.Close()
+
+		if _, err := cloud.Client().Devices(ctx); !errors.Is(err, bambuhttp.ErrUnauthorized) {
+			t.Errorf("Devices = %v, want ErrUnauthorized", err)
+		}
+	})
+
+	t.Run("no refresh token", func(t *testing.T) {
+		cloud := bambutest.NewCloudServer()
+		defer cloud.Close()
+
+		client := cloud.Client()
+		client.SetToken(bambuhttp.Token{AccessToken: "expired", ExpiresAt: time.Now().Add(-time.Hour)})
+
+		if _, err := client.AccessToken(ctx); !errors.Is(err, bambuhttp.ErrUnauthorized) {
+			t.Errorf("AccessToken = %v, want ErrUnauthorized", err)
+		}
+	})
+}
+
+func login(t *testing.T, cloud *bambutest.CloudServer) *bambuhttp.Client {
+	t.Helper()
+
+	client := cloud.Client()
+	if _, err := client.LoginWithPassword(context.Background(), bambutest.DefaultAccount, bambutest.DefaultPassword); err != nil {
+		t.Fatalf("login failed: %v", err)
+	}
+	return client
+}
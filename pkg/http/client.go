@@ -4,9 +4,12 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
+	"sync"
 	"time"
 
 	"github.com/RobertMNewton/bambu-golang-api/pkg/types/config"
@@ -15,82 +18,218 @@ import (
 const (
 	defaultTimeout = 30 * time.Second
 	baseCloudURL   = "https://api.bambulab.com"
+	baseTFAURL     = "https://bambulab.com"
+
+	// refreshMargin refreshes tokens shortly before they expire
+	refreshMargin = time.Minute
 )
 
+// ErrUnauthorized is wrapped by errors for requests rejected because the
+// client is not logged in or its token expired.
+var ErrUnauthorized = errors.New("unauthorized")
+
+// APIError is an error returned by the cloud API.
+type APIError struct {
+	StatusCode int
+	Code       int
+	Message    string
+}
+
+func (err *APIError) Error() string {
+	if err.Code != 0 {
+		return fmt.Sprintf("cloud API error %d (status %d): %s", err.Code, err.StatusCode, err.Message)
+	}
+	return fmt.Sprintf("cloud API error (status %d): %s", err.StatusCode, err.Message)
+}
+
+func (err *APIError) Unwrap() error {
+	if err.StatusCode == http.StatusUnauthorized {
+		return ErrUnauthorized
+	}
+	return nil
+}
+
+type Options struct {
+	// BaseURL is the API server, defaults to https://api.bambulab.com
+	BaseURL string
+
+	// TFAURL is the server handling two-factor sign in, defaults to
+	// https://bambulab.com
+	TFAURL string
+
+	HTTPClient *http.Client
+}
+
 // Client represents an HTTP client for interacting with Bambu Lab's cloud API
 type Client struct {
 	config     config.PrinterConfig
 	httpClient *http.Client
-	token      string
+	baseURL    string
+	tfaURL     string
+
+	mu    sync.Mutex
+	token Token
+
+	// refreshMu serialises refreshes, a refresh token can only be used once
+	refreshMu sync.Mutex
 }
 
-// NewClient creates a new HTTP client
-func NewClient(config config.PrinterConfig) (*Client, error) {
-	return &Client{
-		config: config,
-		httpClient: &http.Client{
-			Timeout: defaultTimeout,
-		},
-	}, nil
+// NewClient creates a new HTTP client. Cloud printers are reached through
+// the API of their region, other printers through the global one.
+func NewClient(printerConfig config.PrinterConfig) (*Client, error) {
+	var options Options
+	if cloud, ok := printerConfig.(*config.CloudPrinterConfig); ok {
+		options.BaseURL = cloud.GetRegion().APIURL()
+		options.TFAURL = cloud.GetRegion().WebURL()
+	}
+
+	client := NewClientWithOptions(options)
+	client.config = printerConfig
+	return client, nil
 }
 
-// Login authenticates with the cloud API
-func (c *Client) Login(ctx context.Context) error {
-	loginURL := fmt.Sprintf("%s/v1/user-service/user/login", baseCloudURL)
+// NewClientWithOptions creates a client that is not tied to a printer, for
+// account level calls and tests.
+func NewClientWithOptions(options Options) *Client {
+	if options.BaseURL == "" {
+		options.BaseURL = baseCloudURL
+	}
+	if options.TFAURL == "" {
+		options.TFAURL = baseTFAURL
+	}
+	if options.HTTPClient == nil {
+		options.HTTPClient = &http.Client{
+			Timeout: defaultTimeout,
+		}
+	}
 
-	reqBody := map[string]string{
-		"username": c.config.GetUsername(),
-		"password": c.config.GetPassword(),
+	return &Client{
+		httpClient: options.HTTPClient,
+		baseURL:    options.BaseURL,
+		tfaURL:     options.TFAURL,
 	}
+}
 
-	var resp struct {
-		Token string `json:"token"`
+// Token returns the current token.
+func (c *Client) Token() Token {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.token
+}
+
+// SetToken sets a token saved from an earlier login.
+func (c *Client) SetToken(token Token) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.token = token
+}
+
+// get requests an authenticated endpoint, refreshing the token first if it
+// is about to expire.
+func (c *Client) get(ctx context.Context, path string, query url.Values, response interface{}) error {
+	if err := c.ensureToken(ctx); err != nil {
+		return err
 	}
 
-	err := c.doRequest(ctx, "POST", loginURL, reqBody, &resp)
-	if err != nil {
-		return fmt.Errorf("login failed: %w", err)
+	endpoint := c.baseURL + path
+	if len(query) > 0 {
+		endpoint += "?" + query.Encode()
 	}
 
-	c.token = resp.Token
-	return nil
+	used := c.Token().AccessToken
+	err := c.doRequest(ctx, "GET", endpoint, nil, response)
+	if errors.Is(err, ErrUnauthorized) && c.Token().canRefresh() {
+		// The token was revoked before its expiry, retry once with a new one
+		if refreshErr := c.replaceToken(ctx, used); refreshErr != nil {
+			return err
+		}
+		err = c.doRequest(ctx, "GET", endpoint, nil, response)
+	}
+	return err
 }
 
 func (c *Client) doRequest(ctx context.Context, method, url string, body interface{}, response interface{}) error {
+	_, err := c.do(ctx, method, url, body, response)
+	return err
+}
+
+// do sends a request and decodes the JSON response, returning the HTTP
+// response so that callers can read cookies.
+func (c *Client) do(ctx context.Context, method, url string, body interface{}, response interface{}) (*http.Response, error) {
 	var reqBody io.Reader
 	if body != nil {
 		jsonBody, err := json.Marshal(body)
 		if err != nil {
-			return fmt.Errorf("failed to marshal request body: %w", err)
+			return nil, fmt.Errorf("failed to marshal request body: %w", err)
 		}
 		reqBody = bytes.NewBuffer(jsonBody)
 	}
 
 	req, err := http.NewRequestWithContext(ctx, method, url, reqBody)
 	if err != nil {
-		return fmt.Errorf("failed to create request: %w", err)
+		return nil, fmt.Errorf("failed to create request: %w", err)
 	}
 
 	req.Header.Set("Content-Type", "application/json")
-	if c.token != "" {
-		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", c.token))
+	if token := c.Token(); token.AccessToken != "" {
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token.AccessToken))
 	}
 
 	resp, err := c.httpClient.Do(req)
 	if err != nil {
-		return fmt.Errorf("request failed: %w", err)
+		return nil, fmt.Errorf("request failed: %w", err)
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode >= 400 {
-		body, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("request failed with status %d: %s", resp.StatusCode, string(body))
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response: %w", err)
+	}
+
+	if err := decodeError(resp.StatusCode, data); err != nil {
+		return nil, err
+	}
+
+	if response != nil && len(bytes.TrimSpace(data)) > 0 {
+		if err := json.Unmarshal(data, response); err != nil {
+			return nil, fmt.Errorf("failed to decode response: %w", err)
+		}
+	}
+
+	return resp, nil
+}
+
+// decodeError extracts the error of a response. Some endpoints report errors
+// with status 200 and a non-empty error field.
+func decodeError(statusCode int, data []byte) error {
+	var envelope struct {
+		Code    interface{} `json:"code"`
+		Error   interface{} `json:"error"`
+		Message string      `json:"message"`
+	}
+	json.Unmarshal(data, &envelope)
+
+	code := 0
+	if number, ok := envelope.Code.(float64); ok {
+		code = int(number)
 	}
 
-	if response != nil {
-		if err := json.NewDecoder(resp.Body).Decode(response); err != nil {
-			return fmt.Errorf("failed to decode response: %w", err)
+	message := envelope.Message
+	if text, ok := envelope.Error.(string); ok && text != "" {
+		message = text
+	}
+
+	if statusCode >= 400 {
+		if message == "" {
+			message = string(data)
 		}
+		return &APIError{StatusCode: statusCode, Code: code, Message: message}
+	}
+
+	if text, ok := envelope.Error.(string); ok && text != "" {
+		return &APIError{StatusCode: statusCode, Code: code, Message: message}
 	}
 
 	return nil
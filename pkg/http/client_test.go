@@ -0,0 +1,90 @@
+package http
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/RobertMNewton/bambu-golang-api/pkg/types/config"
+)
+
+func TestNewClientRegion(t *testing.T) {
+	china := config.NewCloudPrinterConfig("SERIAL", "1", "token", "", "")
+	china.SetRegion(config.RegionChina)
+	local := config.NewLocalPrinterConfig("SERIAL", "127.0.0.1", "12345678", "")
+
+	tests := []struct {
+		name    string
+		config  config.PrinterConfig
+		baseURL string
+		tfaURL  string
+	}{
+		{
+			name:    "global cloud",
+			config:  config.NewCloudPrinterConfig("SERIAL", "1", "token", "", ""),
+			baseURL: "https://api.bambulab.com",
+			tfaURL:  "https://bambulab.com",
+		},
+		{
+			name:    "china cloud",
+			config:  china,
+			baseURL: "https://api.bambulab.cn",
+			tfaURL:  "https://bambulab.cn",
+		},
+		{
+			name:    "local",
+			config:  &local,
+			baseURL: "https://api.bambulab.com",
+			tfaURL:  "https://bambulab.com",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			client, err := NewClient(test.config)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if client.baseURL != test.baseURL || client.tfaURL != test.tfaURL {
+				t.Errorf("urls = %s, %s, want %s, %s", client.baseURL, client.tfaURL, test.baseURL, test.tfaURL)
+			}
+		})
+	}
+}
+
+func TestDecodeError(t *testing.T) {
+	tests := []struct {
+		name         string
+		status       int
+		body         string
+		wantErr      bool
+		unauthorized bool
+		message      string
+	}{
+		{name: "success", status: 200, body: `{"message":"success","code":null,"error":null}`},
+		{name: "empty body", status: 200, body: ``},
+		{name: "error in 200", status: 200, body: `{"code":5,"error":"Bad things"}`, wantErr: true, message: "Bad things"},
+		{name: "status", status: 400, body: `{"code":3,"error":"Incorrect password"}`, wantErr: true, message: "Incorrect password"},
+		{name: "plain text", status: 502, body: `bad gateway`, wantErr: true, message: "bad gateway"},
+		{name: "unauthorized", status: 401, body: `{"code":401,"error":"Unauthorized"}`, wantErr: true, unauthorized: true, message: "Unauthorized"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := decodeError(test.status, []byte(test.body))
+			if (err != nil) != test.wantErr {
+				t.Fatalf("decodeError = %v, want error %t", err, test.wantErr)
+			}
+			if err == nil {
+				return
+			}
+
+			var apiErr *APIError
+			if !errors.As(err, &apiErr) || apiErr.Message != test.message || apiErr.StatusCode != test.status {
+				t.Errorf("decodeError = %#v", err)
+			}
+			if errors.Is(err, ErrUnauthorized) != test.unauthorized {
+				t.Errorf("errors.Is(ErrUnauthorized) = %t, want %t", !test.unauthorized, test.unauthorized)
+			}
+		})
+	}
+}
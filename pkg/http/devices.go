@@ -0,0 +1,72 @@
+package http
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+)
+
+// Device is a printer bound to the account.
+type Device struct {
+	Serial         string  `json:"dev_id"`
+	Name           string  `json:"name"`
+	Online         bool    `json:"online"`
+	PrintStatus    string  `json:"print_status"`
+	Model          string  `json:"dev_model_name"`
+	ProductName    string  `json:"dev_product_name"`
+	AccessCode     string  `json:"dev_access_code"`
+	NozzleDiameter float64 `json:"nozzle_diameter"`
+}
+
+// UserProfile is the profile of the logged in account.
+type UserProfile struct {
+	UID     int64  `json:"uid"`
+	Name    string `json:"name"`
+	Account string `json:"account"`
+	Avatar  string `json:"avatar"`
+}
+
+// MQTTUsername returns the username for the cloud MQTT broker.
+func (profile UserProfile) MQTTUsername() string {
+	return "u_" + strconv.FormatInt(profile.UID, 10)
+}
+
+// Devices lists the printers bound to the account.
+func (c *Client) Devices(ctx context.Context) ([]Device, error) {
+	var resp struct {
+		Devices []Device `json:"devices"`
+	}
+
+	if err := c.get(ctx, "/v1/iot-service/api/user/bind", nil, &resp); err != nil {
+		return nil, fmt.Errorf("failed to list devices: %w", err)
+	}
+	return resp.Devices, nil
+}
+
+// Device returns the bound printer with the given serial.
+func (c *Client) Device(ctx context.Context, serial string) (Device, error) {
+	devices, err := c.Devices(ctx)
+	if err != nil {
+		return Device{}, err
+	}
+
+	for _, device := range devices {
+		if device.Serial == serial {
+			return device, nil
+		}
+	}
+	return Device{}, fmt.Errorf("printer %s is not bound to this account", serial)
+}
+
+// Profile returns the profile of the logged in account.
+func (c *Client) Profile(ctx context.Context) (UserProfile, error) {
+	var profile UserProfile
+
+	if err := c.get(ctx, "/v1/user-service/my/profile", nil, &profile); err != nil {
+		return UserProfile{}, fmt.Errorf("failed to get profile: %w", err)
+	}
+	if profile.UID == 0 {
+		return UserProfile{}, fmt.Errorf("failed to get profile: no uid in response")
+	}
+	return profile, nil
+}
@@ -0,0 +1,118 @@
+package http_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/RobertMNewton/bambu-golang-api/pkg/bambutest"
+	bambuhttp "github.com/RobertMNewton/bambu-golang-api/pkg/http"
+)
+
+func TestDevices(t *testing.T) {
+	cloud := bambutest.NewCloudServer()
+	defer cloud.Close()
+
+	ctx := context.Background()
+	client := login(t, cloud)
+
+	devices, err := client.Devices(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(devices) != 1 || devices[0].Serial != bambutest.DefaultDeviceID || devices[0].AccessCode != bambutest.DefaultAccessCode {
+		t.Errorf("devices = %+v", devices)
+	}
+
+	if _, err := client.Device(ctx, bambutest.DefaultDeviceID); err != nil {
+		t.Errorf("Device: %v", err)
+	}
+	if _, err := client.Device(ctx, "UNKNOWN"); err == nil {
+		t.Error("Device found an unbound printer")
+	}
+
+	profile, err := client.Profile(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if profile.UID != bambutest.DefaultUID || profile.MQTTUsername() != "u_1234567890" {
+		t.Errorf("profile = %+v", profile)
+	}
+}
+
+func TestTasks(t *testing.T) {
+	options := bambutest.DefaultCloudOptions()
+	options.Tasks = []bambuhttp.Task{
+		{ID: 3, DeviceID: "A", Title: "third"},
+		{ID: 2, DeviceID: "B", Title: "second"},
+		{ID: 1, DeviceID: "A", Title: "first"},
+	}
+	cloud := bambutest.NewCloudServerWithOptions(options)
+	defer cloud.Close()
+
+	client := login(t, cloud)
+
+	tests := []struct {
+		name  string
+		query bambuhttp.TaskQuery
+		want  []int64
+	}{
+		{name: "all", want: []int64{3, 2, 1}},
+		{name: "device", query: bambuhttp.TaskQuery{DeviceID: "A"}, want: []int64{3, 1}},
+		{name: "limit", query: bambuhttp.TaskQuery{Limit: 1}, want: []int64{3}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tasks, err := client.Tasks(context.Background(), test.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var ids []int64
+			for _, task := range tasks {
+				ids = append(ids, task.ID)
+			}
+			if len(ids) != len(test.want) {
+				t.Fatalf("tasks = %v, want %v", ids, test.want)
+			}
+			for i := range ids {
+				if ids[i] != test.want[i] {
+					t.Fatalf("tasks = %v, want %v", ids, test.want)
+				}
+			}
+		})
+	}
+}
+
+func TestProjects(t *testing.T) {
+	options := bambutest.DefaultCloudOptions()
+	options.Projects = []bambuhttp.ProjectDetail{{
+		Project:  bambuhttp.Project{ID: "42", Name: "benchy"},
+		Profiles: []bambuhttp.ProjectProfile{{ID: "7", Name: "plate_1", MD5: "ABC"}},
+	}}
+	cloud := bambutest.NewCloudServerWithOptions(options)
+	defer cloud.Close()
+
+	ctx := context.Background()
+	client := login(t, cloud)
+
+	projects, err := client.Projects(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(projects) != 1 || projects[0].Name != "benchy" {
+		t.Errorf("projects = %+v", projects)
+	}
+
+	project, err := client.Project(ctx, "42")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(project.Profiles) != 1 || project.Profiles[0].MD5 != "ABC" {
+		t.Errorf("project = %+v", project)
+	}
+
+	if _, err := client.Project(ctx, "missing"); err == nil {
+		t.Error("Project found a missing project")
+	}
+}
@@ -0,0 +1,151 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// TaskStatus is the outcome of a cloud print task.
+type TaskStatus int
+
+const (
+	TaskRunning   TaskStatus = 1
+	TaskFinished  TaskStatus = 2
+	TaskFailed    TaskStatus = 3
+	TaskCancelled TaskStatus = 4
+)
+
+func (status TaskStatus) String() string {
+	switch status {
+	case TaskRunning:
+		return "running"
+	case TaskFinished:
+		return "finished"
+	case TaskFailed:
+		return "failed"
+	case TaskCancelled:
+		return "cancelled"
+	default:
+		return fmt.Sprintf("TaskStatus(%d)", int(status))
+	}
+}
+
+// Task is an entry of the account's print history.
+type Task struct {
+	ID          int64      `json:"id"`
+	Title       string     `json:"title"`
+	DesignTitle string     `json:"designTitle"`
+	ModelID     string     `json:"modelId"`
+	ProfileID   int64      `json:"profileId"`
+	PlateIndex  int        `json:"plateIndex"`
+	PlateName   string     `json:"plateName"`
+	Cover       string     `json:"cover"`
+	Status      TaskStatus `json:"status"`
+	StartTime   time.Time  `json:"startTime"`
+	EndTime     time.Time  `json:"endTime"`
+
+	// Weight is the filament used in grams and Length in millimetres
+	Weight float64 `json:"weight"`
+	Length float64 `json:"length"`
+
+	// CostTime is the print duration in seconds
+	CostTime int64 `json:"costTime"`
+
+	DeviceID    string `json:"deviceId"`
+	DeviceName  string `json:"deviceName"`
+	DeviceModel string `json:"deviceModel"`
+	BedType     string `json:"bedType"`
+	Mode        string `json:"mode"`
+}
+
+// Duration returns the print duration.
+func (task Task) Duration() time.Duration {
+	return time.Duration(task.CostTime) * time.Second
+}
+
+// TaskQuery filters the print history. Zero values are not filtered on.
+type TaskQuery struct {
+	DeviceID string
+	Limit    int
+	After    int64
+}
+
+// Tasks returns the print history, newest first.
+func (c *Client) Tasks(ctx context.Context, query TaskQuery) ([]Task, error) {
+	values := url.Values{}
+	if query.DeviceID != "" {
+		values.Set("deviceId", query.DeviceID)
+	}
+	if query.Limit > 0 {
+		values.Set("limit", strconv.Itoa(query.Limit))
+	}
+	if query.After > 0 {
+		values.Set("after", strconv.FormatInt(query.After, 10))
+	}
+
+	var resp struct {
+		Total int    `json:"total"`
+		Hits  []Task `json:"hits"`
+	}
+
+	if err := c.get(ctx, "/v1/user-service/my/tasks", values, &resp); err != nil {
+		return nil, fmt.Errorf("failed to list tasks: %w", err)
+	}
+	return resp.Hits, nil
+}
+
+// Project is a cloud project, created when a sliced model is sent to a
+// printer through the cloud.
+type Project struct {
+	ID         string `json:"project_id"`
+	UserID     string `json:"user_id"`
+	ModelID    string `json:"model_id"`
+	Name       string `json:"name"`
+	Status     string `json:"status"`
+	Content    string `json:"content"`
+	CreateTime string `json:"create_time"`
+	UpdateTime string `json:"update_time"`
+}
+
+// ProjectDetail is a project with its sliced files.
+type ProjectDetail struct {
+	Project
+	Profiles []ProjectProfile `json:"profiles"`
+}
+
+// ProjectProfile is a sliced file of a project. URL is a short lived
+// download link.
+type ProjectProfile struct {
+	ID      string          `json:"profile_id"`
+	ModelID string          `json:"model_id"`
+	Name    string          `json:"name"`
+	URL     string          `json:"url"`
+	MD5     string          `json:"md5"`
+	Context json.RawMessage `json:"context,omitempty"`
+}
+
+// Projects lists the account's projects.
+func (c *Client) Projects(ctx context.Context) ([]Project, error) {
+	var resp struct {
+		Projects []Project `json:"projects"`
+	}
+
+	if err := c.get(ctx, "/v1/iot-service/api/user/project", nil, &resp); err != nil {
+		return nil, fmt.Errorf("failed to list projects: %w", err)
+	}
+	return resp.Projects, nil
+}
+
+// Project returns a project and its files.
+func (c *Client) Project(ctx context.Context, id string) (ProjectDetail, error) {
+	var project ProjectDetail
+
+	if err := c.get(ctx, "/v1/iot-service/api/user/project/"+url.PathEscape(id), nil, &project); err != nil {
+		return ProjectDetail{}, fmt.Errorf("failed to get project %s: %w", id, err)
+	}
+	return project, nil
+}
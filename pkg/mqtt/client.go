@@ -2,6 +2,7 @@ package mqtt
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"sync"
 	"time"
@@ -15,6 +16,7 @@ import (
 const (
 	keepAlive      = 30 * time.Second
 	connectTimeout = 10 * time.Second
+	publishTimeout = 5 * time.Second
 )
 
 type Client struct {
@@ -22,23 +24,78 @@ type Client struct {
 	client    mqtt.Client
 	mu        sync.RWMutex
 	connected bool
+
+	reconnectPolicy ReconnectPolicy
+	stopReconnect   chan struct{}
+	wasConnected    bool
+
+	subscriptions  []*Subscription
+	stateHandlers  []ConnectionStateHandler
+	decodeHandlers []DecodeErrorHandler
+	strict         bool
 }
 
 type ReportHandler func(report.Report)
 
+// DecodeErrorHandler is called with messages from the report topic that could
+// not be decoded.
+type DecodeErrorHandler func(*report.DecodeError)
+
 func NewClient(config config.PrinterConfig) *Client {
 	return &Client{
-		config: config,
+		config:          config,
+		reconnectPolicy: DefaultReconnectPolicy(),
 	}
 }
 
+// SetReconnectPolicy changes how the client reconnects. It applies to
+// connections lost after the call.
+func (client *Client) SetReconnectPolicy(policy ReconnectPolicy) {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+
+	client.reconnectPolicy = policy
+}
+
+// OnConnectionStateChange registers a handler that is called whenever the
+// connection is established, lost or being re-established.
+func (client *Client) OnConnectionStateChange(handler ConnectionStateHandler) {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+
+	client.stateHandlers = append(client.stateHandlers, handler)
+}
+
+// OnDecodeError registers a handler for report messages that could not be
+// decoded. Without one, such messages are dropped.
+func (client *Client) OnDecodeError(handler DecodeErrorHandler) {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+
+	client.decodeHandlers = append(client.decodeHandlers, handler)
+}
+
+// SetStrictDecoding makes the client reject reports with unknown types or
+// unexpected shapes, see report.DecodeStrict. Rejected reports are passed to
+// the decode error handlers instead of the subscribers.
+func (client *Client) SetStrictDecoding(strict bool) {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+
+	client.strict = strict
+}
+
 func (client *Client) Connect(ctx context.Context) error {
 	options, err := client.createClientOptions()
 	if err != nil {
-		return nil
+		return err
 	}
 
+	client.mu.Lock()
 	client.client = mqtt.NewClient(options)
+	client.stopReconnect = make(chan struct{})
+	client.wasConnected = false
+	client.mu.Unlock()
 
 	connectChan := make(chan error, 1)
 	go func() {
@@ -59,17 +116,32 @@ func (client *Client) Connect(ctx context.Context) error {
 		return fmt.Errorf("connection timeout: %w", ctx.Err())
 	}
 
+	// Subscribe before returning so that no reply to a request published
+	// right after connecting is missed.
+	if err := client.subscribe(); err != nil {
+		client.Disconnect()
+		return err
+	}
+
 	client.setConnected(true)
 
 	return nil
 }
 
 func (client *Client) Disconnect() {
+	client.mu.Lock()
+	if client.stopReconnect != nil {
+		close(client.stopReconnect)
+		client.stopReconnect = nil
+	}
+	client.mu.Unlock()
+
 	if client.client != nil && client.client.IsConnected() {
 		client.client.Disconnect(250)
 	}
 
 	client.setConnected(false)
+	client.notifyState(ConnectionEvent{State: StateDisconnected})
 }
 
 func (client *Client) IsConnected() bool {
@@ -79,30 +151,6 @@ func (client *Client) IsConnected() bool {
 	return client.connected
 }
 
-func (client *Client) Subscribe(ctx context.Context, callback ReportHandler) error {
-	if !client.IsConnected() {
-		return fmt.Errorf("mqtt client not connected")
-	}
-
-	topic := fmt.Sprintf("device/%s/report", client.config.GetDeviceID())
-
-	handler := func(client mqtt.Client, msg mqtt.Message) {
-		report, err := report.FromMessage(msg)
-		if err != nil {
-			return
-		}
-
-		callback(report)
-	}
-
-	token := client.client.Subscribe(topic, 1, handler)
-	if err := token.Error(); err != nil {
-		return fmt.Errorf("subscription failed: %w", err)
-	}
-
-	return nil
-}
-
 func (client *Client) Publish(ctx context.Context, request request.Request) error {
 	if !client.IsConnected() {
 		return fmt.Errorf("mqtt client is not connected")
@@ -132,9 +180,45 @@ func (client *Client) Publish(ctx context.Context, request request.Request) erro
 		return nil
 	case <-ctx.Done():
 		return fmt.Errorf("publish timeout: %w", ctx.Err())
-	case <-time.After(5 * time.Second):
-		return nil
+	case <-time.After(publishTimeout):
+		return fmt.Errorf("publish timeout: no acknowledgement from broker after %s", publishTimeout)
+	}
+}
+
+func (client *Client) subscribe() error {
+	topic := fmt.Sprintf("device/%s/report", client.config.GetDeviceID())
+
+	token := client.client.Subscribe(topic, 1, client.handleMessage)
+	if token.Wait() && token.Error() != nil {
+		return fmt.Errorf("subscription failed: %w", token.Error())
 	}
+
+	return nil
+}
+
+func (client *Client) handleMessage(_ mqtt.Client, msg mqtt.Message) {
+	client.mu.RLock()
+	strict := client.strict
+	decodeHandlers := client.decodeHandlers
+	client.mu.RUnlock()
+
+	decode := report.FromMessage
+	if strict {
+		decode = report.FromMessageStrict
+	}
+
+	r, err := decode(msg)
+	if err != nil {
+		var decodeErr *report.DecodeError
+		if errors.As(err, &decodeErr) {
+			for _, handler := range decodeHandlers {
+				handler(decodeErr)
+			}
+		}
+		return
+	}
+
+	client.dispatch(r)
 }
 
 func (client *Client) createClientOptions() (*mqtt.ClientOptions, error) {
@@ -142,9 +226,21 @@ func (client *Client) createClientOptions() (*mqtt.ClientOptions, error) {
 	options.AddBroker(client.config.GetBrokerUrl())
 	options.SetUsername(client.config.GetUsername())
 	options.SetPassword(client.config.GetPassword())
+
+	// Read the credentials again on every (re)connect, cloud configs return
+	// a refreshed token once the previous one expires.
+	options.SetCredentialsProvider(func() (string, string) {
+		return client.config.GetUsername(), client.config.GetPassword()
+	})
 	options.SetKeepAlive(keepAlive)
 	options.SetConnectTimeout(connectTimeout)
 
+	// Reconnection is handled by the client so that it can back off with
+	// jitter and report every attempt.
+	options.SetAutoReconnect(false)
+	options.SetOnConnectHandler(client.onConnect)
+	options.SetConnectionLostHandler(client.onConnectionLost)
+
 	tls_config, err := client.config.CreateTLSConfig()
 	if err != nil {
 		return nil, err
@@ -155,6 +251,53 @@ func (client *Client) createClientOptions() (*mqtt.ClientOptions, error) {
 	return options, nil
 }
 
+func (client *Client) onConnect(_ mqtt.Client) {
+	client.mu.Lock()
+	reconnected := client.wasConnected
+	client.wasConnected = true
+	client.mu.Unlock()
+
+	// The first subscription is made by Connect
+	var err error
+	if reconnected {
+		err = client.subscribe()
+		client.setConnected(true)
+	}
+
+	client.notifyState(ConnectionEvent{State: StateConnected, Reconnected: reconnected, Err: err})
+}
+
+func (client *Client) onConnectionLost(_ mqtt.Client, err error) {
+	client.setConnected(false)
+	client.notifyState(ConnectionEvent{State: StateConnectionLost, Err: err})
+
+	client.mu.RLock()
+	enabled := client.reconnectPolicy.Enabled
+	stop := client.stopReconnect
+	client.mu.RUnlock()
+
+	if enabled && stop != nil {
+		go client.reconnect(stop)
+	}
+}
+
+func (client *Client) getReconnectPolicy() ReconnectPolicy {
+	client.mu.RLock()
+	defer client.mu.RUnlock()
+
+	return client.reconnectPolicy
+}
+
+func (client *Client) notifyState(event ConnectionEvent) {
+	client.mu.RLock()
+	handlers := client.stateHandlers
+	client.mu.RUnlock()
+
+	for _, handler := range handlers {
+		handler(event)
+	}
+}
+
 func (client *Client) setConnected(connected bool) {
 	client.mu.Lock()
 	defer client.mu.Unlock()
@@ -0,0 +1,296 @@
+package mqtt_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/RobertMNewton/bambu-golang-api/pkg/bambutest"
+	"github.com/RobertMNewton/bambu-golang-api/pkg/mqtt"
+	"github.com/RobertMNewton/bambu-golang-api/pkg/mqtt/report"
+	"github.com/RobertMNewton/bambu-golang-api/pkg/mqtt/request"
+	"github.com/RobertMNewton/bambu-golang-api/pkg/types/config"
+)
+
+const timeout = 10 * time.Second
+
+func newServer(t *testing.T) *bambutest.Server {
+	t.Helper()
+
+	server, err := bambutest.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { server.Close() })
+	return server
+}
+
+func connect(t *testing.T, server *bambutest.Server) *mqtt.Client {
+	t.Helper()
+
+	client := mqtt.NewClient(server.Config())
+	client.SetReconnectPolicy(mqtt.ReconnectPolicy{
+		Enabled:        true,
+		InitialBackoff: 10 * time.Millisecond,
+		MaxBackoff:     50 * time.Millisecond,
+		Multiplier:     2,
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	if err := client.Connect(ctx); err != nil {
+		t.Fatalf("connect failed: %v", err)
+	}
+	t.Cleanup(client.Disconnect)
+	return client
+}
+
+func TestConnectAndPublish(t *testing.T) {
+	server := newServer(t)
+	client := connect(t, server)
+
+	if !client.IsConnected() {
+		t.Fatal("not connected after Connect")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	if err := client.Publish(ctx, request.CreateGCodeLineRequest("7", "G28")); err != nil {
+		t.Fatal(err)
+	}
+
+	req, err := server.WaitForRequest(ctx, "gcode_line")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if req.Payload.SequenceID != "7" || req.Payload.Params["param"] != "G28" {
+		t.Errorf("request = %+v", req)
+	}
+
+	client.Disconnect()
+	if client.IsConnected() {
+		t.Error("connected after Disconnect")
+	}
+	if err := client.Publish(ctx, request.CreatePushAllRequest("8")); err == nil {
+		t.Error("Publish succeeded after Disconnect")
+	}
+}
+
+func TestConnectRejected(t *testing.T) {
+	server := newServer(t)
+
+	caPath := filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(caPath, server.CACertPEM(), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := config.NewLocalPrinterConfig(server.DeviceID(), "127.0.0.1", "00000000", caPath)
+	cfg.SetPort(config.ServiceMQTT, server.Config().GetPort(config.ServiceMQTT))
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	client := mqtt.NewClient(&cfg)
+	client.SetReconnectPolicy(mqtt.ReconnectPolicy{})
+	if err := client.Connect(ctx); err == nil {
+		client.Disconnect()
+		t.Fatal("Connect succeeded with the wrong access code")
+	}
+}
+
+func TestSubscribe(t *testing.T) {
+	tests := []struct {
+		name  string
+		types []string
+		want  []string
+	}{
+		{name: "all", want: []string{report.TypeInfo, report.TypePrint}},
+		{name: "print", types: []string{report.TypePrint}, want: []string{report.TypePrint}},
+		{name: "info", types: []string{report.TypeInfo}, want: []string{report.TypeInfo}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := newServer(t)
+			client := connect(t, server)
+
+			ctx, cancel := context.WithTimeout(context.Background(), timeout)
+			defer cancel()
+
+			received := make(chan string, 10)
+			if _, err := client.Subscribe(ctx, func(r report.Report) { received <- r.Type }, test.types...); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := client.Publish(ctx, request.CreateGetVersionRequest("1")); err != nil {
+				t.Fatal(err)
+			}
+			if err := server.Send(bambutest.PushStatus(map[string]interface{}{"mc_percent": 5})); err != nil {
+				t.Fatal(err)
+			}
+
+			for _, want := range test.want {
+				select {
+				case got := <-received:
+					if got != want {
+						t.Errorf("received %s, want %s", got, want)
+					}
+				case <-ctx.Done():
+					t.Fatalf("no %s report", want)
+				}
+			}
+
+			select {
+			case got := <-received:
+				t.Errorf("unexpected %s report", got)
+			case <-time.After(100 * time.Millisecond):
+			}
+		})
+	}
+}
+
+func TestUnsubscribe(t *testing.T) {
+	server := newServer(t)
+	client := connect(t, server)
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	received := make(chan report.Report, 10)
+	subscription, _ := client.Subscribe(ctx, func(r report.Report) { received <- r })
+	reports := client.Reports(ctx, mqtt.DefaultReportsOptions())
+
+	subscription.Unsubscribe()
+	subscription.Unsubscribe()
+
+	server.Send(bambutest.PushStatus(map[string]interface{}{"mc_percent": 5}))
+	select {
+	case <-reports:
+	case <-ctx.Done():
+		t.Fatal("no report on the channel")
+	}
+
+	select {
+	case <-received:
+		t.Error("report delivered after Unsubscribe")
+	default:
+	}
+
+	cancel()
+	for range reports {
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		strict  bool
+		payload string
+	}{
+		{name: "invalid json", payload: `{"print":`},
+		{name: "unknown type strict", strict: true, payload: `{"mystery":{"command":"x"}}`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := newServer(t)
+			client := connect(t, server)
+			client.SetStrictDecoding(test.strict)
+
+			decodeErrors := make(chan *report.DecodeError, 1)
+			client.OnDecodeError(func(err *report.DecodeError) { decodeErrors <- err })
+
+			server.SendRaw([]byte(test.payload))
+
+			select {
+			case err := <-decodeErrors:
+				if string(err.Payload) != test.payload {
+					t.Errorf("payload = %s, want %s", err.Payload, test.payload)
+				}
+			case <-time.After(timeout):
+				t.Fatal("no decode error")
+			}
+		})
+	}
+}
+
+func TestReconnect(t *testing.T) {
+	tests := []struct {
+		name string
+		drop func(t *testing.T, server *bambutest.Server, client *mqtt.Client)
+	}{
+		{
+			name: "connection lost",
+			drop: func(t *testing.T, server *bambutest.Server, client *mqtt.Client) {
+				server.DisconnectClients()
+			},
+		},
+		{
+			name: "explicit",
+			drop: func(t *testing.T, server *bambutest.Server, client *mqtt.Client) {
+				ctx, cancel := context.WithTimeout(context.Background(), timeout)
+				defer cancel()
+
+				if err := client.Reconnect(ctx); err != nil {
+					t.Fatal(err)
+				}
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := newServer(t)
+			client := connect(t, server)
+
+			ctx, cancel := context.WithTimeout(context.Background(), timeout)
+			defer cancel()
+
+			events := make(chan mqtt.ConnectionEvent, 10)
+			client.OnConnectionStateChange(func(event mqtt.ConnectionEvent) { events <- event })
+			reports := client.Reports(ctx, mqtt.DefaultReportsOptions())
+
+			test.drop(t, server, client)
+
+			for {
+				select {
+				case event := <-events:
+					if event.State != mqtt.StateConnected {
+						continue
+					}
+					if !event.Reconnected || event.Err != nil {
+						t.Errorf("event = %+v, want a reconnect", event)
+					}
+				case <-ctx.Done():
+					t.Fatal("not reconnected")
+				}
+				break
+			}
+
+			if !client.IsConnected() {
+				t.Error("not connected after reconnecting")
+			}
+
+			// Subscriptions survive the new connection
+			server.Send(bambutest.PushStatus(map[string]interface{}{"mc_percent": 5}))
+			select {
+			case <-reports:
+			case <-ctx.Done():
+				t.Fatal("no report after reconnecting")
+			}
+		})
+	}
+}
+
+func TestReconnectNotConnected(t *testing.T) {
+	server := newServer(t)
+	client := mqtt.NewClient(server.Config())
+
+	if err := client.Reconnect(context.Background()); err == nil {
+		t.Error("Reconnect succeeded before Connect")
+	}
+}
@@ -0,0 +1,169 @@
+package mqtt
+
+import (
+	"context"
+	"sync"
+
+	"github.com/RobertMNewton/bambu-golang-api/pkg/mqtt/report"
+)
+
+// Subscription is a handler registered with Subscribe.
+type Subscription struct {
+	client  *Client
+	handler ReportHandler
+	types   map[string]bool
+}
+
+// Unsubscribe stops delivering reports to the subscription. It is safe to
+// call more than once.
+func (subscription *Subscription) Unsubscribe() {
+	subscription.client.removeSubscription(subscription)
+}
+
+func (subscription *Subscription) matches(r report.Report) bool {
+	return len(subscription.types) == 0 || subscription.types[r.Type]
+}
+
+// DropPolicy decides what Reports does when a consumer falls behind and its
+// buffer is full.
+type DropPolicy int
+
+const (
+	// DropNewest discards the incoming report.
+	DropNewest DropPolicy = iota
+	// DropOldest discards the oldest buffered report to make room.
+	DropOldest
+	// Block waits for the consumer. This delays every other subscriber, so it
+	// should only be used by consumers that keep up.
+	Block
+)
+
+type ReportsOptions struct {
+	Buffer int
+	Policy DropPolicy
+
+	// Types limits the channel to these report types, e.g. report.TypePrint.
+	// Empty means every report.
+	Types []string
+}
+
+func DefaultReportsOptions() ReportsOptions {
+	return ReportsOptions{
+		Buffer: 64,
+		Policy: DropOldest,
+	}
+}
+
+// Subscribe registers a handler for reports of the given types, or for every
+// report if no type is given. Handlers are called in the order they were
+// registered and are kept across reconnects.
+func (client *Client) Subscribe(ctx context.Context, callback ReportHandler, types ...string) (*Subscription, error) {
+	subscription := &Subscription{
+		client:  client,
+		handler: callback,
+	}
+
+	if len(types) > 0 {
+		subscription.types = make(map[string]bool, len(types))
+		for _, t := range types {
+			subscription.types[t] = true
+		}
+	}
+
+	client.mu.Lock()
+	defer client.mu.Unlock()
+
+	// Copy on write so that dispatch can iterate without holding the lock
+	subscriptions := make([]*Subscription, len(client.subscriptions), len(client.subscriptions)+1)
+	copy(subscriptions, client.subscriptions)
+	client.subscriptions = append(subscriptions, subscription)
+
+	return subscription, nil
+}
+
+// Reports returns a channel of reports that is closed when ctx is done.
+func (client *Client) Reports(ctx context.Context, options ReportsOptions) <-chan report.Report {
+	if options.Buffer < 1 && options.Policy != Block {
+		options.Buffer = 1
+	}
+
+	reports := make(chan report.Report, options.Buffer)
+
+	var mu sync.Mutex
+	closed := false
+
+	deliver := func(r report.Report) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		if closed {
+			return
+		}
+
+		switch options.Policy {
+		case Block:
+			select {
+			case reports <- r:
+			case <-ctx.Done():
+			}
+		case DropOldest:
+			for {
+				select {
+				case reports <- r:
+					return
+				default:
+				}
+
+				select {
+				case <-reports:
+				default:
+				}
+			}
+		default:
+			select {
+			case reports <- r:
+			default:
+			}
+		}
+	}
+
+	subscription, _ := client.Subscribe(ctx, deliver, options.Types...)
+
+	go func() {
+		<-ctx.Done()
+		subscription.Unsubscribe()
+
+		mu.Lock()
+		defer mu.Unlock()
+
+		closed = true
+		close(reports)
+	}()
+
+	return reports
+}
+
+func (client *Client) removeSubscription(subscription *Subscription) {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+
+	subscriptions := make([]*Subscription, 0, len(client.subscriptions))
+	for _, existing := range client.subscriptions {
+		if existing != subscription {
+			subscriptions = append(subscriptions, existing)
+		}
+	}
+	client.subscriptions = subscriptions
+}
+
+func (client *Client) dispatch(r report.Report) {
+	client.mu.RLock()
+	subscriptions := client.subscriptions
+	client.mu.RUnlock()
+
+	for _, subscription := range subscriptions {
+		if subscription.matches(r) {
+			subscription.handler(r)
+		}
+	}
+}
@@ -0,0 +1,162 @@
+package mqtt
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"time"
+)
+
+type ConnectionState int
+
+const (
+	StateConnected ConnectionState = iota
+	StateConnectionLost
+	StateReconnecting
+	StateDisconnected
+)
+
+func (state ConnectionState) String() string {
+	switch state {
+	case StateConnected:
+		return "connected"
+	case StateConnectionLost:
+		return "connection lost"
+	case StateReconnecting:
+		return "reconnecting"
+	case StateDisconnected:
+		return "disconnected"
+	}
+	return "unknown"
+}
+
+type ConnectionEvent struct {
+	State ConnectionState
+
+	// Reconnected is set on StateConnected events that follow a lost
+	// connection.
+	Reconnected bool
+
+	// Attempt is the number of the reconnection attempt, starting at 1.
+	Attempt int
+
+	// Err is the reason the connection was lost or the last reconnection
+	// attempt failed.
+	Err error
+}
+
+type ConnectionStateHandler func(ConnectionEvent)
+
+// ReconnectPolicy controls how the client reconnects after losing its
+// connection. The delay between attempts starts at InitialBackoff and is
+// multiplied by Multiplier after every failed attempt, up to MaxBackoff. Each
+// delay is randomised by up to Jitter (a fraction between 0 and 1) in either
+// direction so that many clients do not reconnect in lock-step.
+type ReconnectPolicy struct {
+	Enabled        bool
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	Multiplier     float64
+	Jitter         float64
+
+	// MaxAttempts stops reconnecting after this many failed attempts. Zero
+	// retries forever.
+	MaxAttempts int
+}
+
+func DefaultReconnectPolicy() ReconnectPolicy {
+	return ReconnectPolicy{
+		Enabled:        true,
+		InitialBackoff: time.Second,
+		MaxBackoff:     2 * time.Minute,
+		Multiplier:     2,
+		Jitter:         0.2,
+	}
+}
+
+// backoff returns the delay before the given attempt, starting at 1.
+func (policy ReconnectPolicy) backoff(attempt int) time.Duration {
+	delay := float64(policy.InitialBackoff)
+	for i := 1; i < attempt; i++ {
+		delay *= policy.Multiplier
+		if delay >= float64(policy.MaxBackoff) {
+			break
+		}
+	}
+
+	if policy.MaxBackoff > 0 && delay > float64(policy.MaxBackoff) {
+		delay = float64(policy.MaxBackoff)
+	}
+
+	if policy.Jitter > 0 {
+		delay += delay * policy.Jitter * (2*rand.Float64() - 1)
+	}
+
+	return time.Duration(delay)
+}
+
+// reconnect keeps trying to reconnect until it succeeds, the policy gives up
+// or Disconnect is called.
+func (client *Client) reconnect(stop <-chan struct{}) {
+	policy := client.getReconnectPolicy()
+
+	for attempt := 1; policy.MaxAttempts == 0 || attempt <= policy.MaxAttempts; attempt++ {
+		select {
+		case <-time.After(policy.backoff(attempt)):
+		case <-stop:
+			return
+		}
+
+		client.notifyState(ConnectionEvent{State: StateReconnecting, Attempt: attempt})
+
+		token := client.client.Connect()
+		token.Wait()
+
+		err := token.Error()
+		if err == nil {
+			// The OnConnect handler resubscribes and reports the new state
+			return
+		}
+
+		client.notifyState(ConnectionEvent{State: StateConnectionLost, Attempt: attempt, Err: err})
+	}
+
+	client.notifyState(ConnectionEvent{State: StateDisconnected})
+}
+
+// Reconnect drops the connection and connects again, so that the broker
+// sees the config's current password, e.g. a refreshed cloud token.
+// Subscriptions are kept. If the new connection fails, the client keeps
+// reconnecting according to its policy.
+func (client *Client) Reconnect(ctx context.Context) error {
+	client.mu.RLock()
+	paho := client.client
+	stop := client.stopReconnect
+	client.mu.RUnlock()
+
+	if paho == nil || stop == nil {
+		return fmt.Errorf("mqtt client is not connected")
+	}
+
+	client.setConnected(false)
+	paho.Disconnect(250)
+	client.notifyState(ConnectionEvent{State: StateReconnecting, Attempt: 1})
+
+	token := paho.Connect()
+	select {
+	case <-token.Done():
+	case <-ctx.Done():
+		return fmt.Errorf("reconnect timeout: %w", ctx.Err())
+	}
+
+	if err := token.Error(); err != nil {
+		client.notifyState(ConnectionEvent{State: StateConnectionLost, Attempt: 1, Err: err})
+		if client.getReconnectPolicy().Enabled {
+			go client.reconnect(stop)
+		}
+		return fmt.Errorf("mqtt reconnection failed: %w", err)
+	}
+
+	// The OnConnect handler resubscribes and reports the new state
+	return nil
+}
@@ -1,6 +1,9 @@
 package report
 
-import "encoding/json"
+import (
+	"encoding/json"
+	"fmt"
+)
 
 type ReportPayload struct {
 	SequenceID string
@@ -38,3 +41,44 @@ func (r *ReportPayload) UnmarshalJSON(data []byte) error {
 
 	return nil
 }
+
+func (r ReportPayload) MarshalJSON() ([]byte, error) {
+	data := make(map[string]interface{}, len(r.Params)+4)
+	for k, v := range r.Params {
+		data[k] = v
+	}
+
+	if r.SequenceID != "" {
+		data["sequence_id"] = r.SequenceID
+	}
+	if r.Command != "" {
+		data["command"] = r.Command
+	}
+	if r.Result != "" {
+		data["result"] = r.Result
+	}
+	if r.Reason != "" {
+		data["reason"] = r.Reason
+	}
+
+	return json.Marshal(data)
+}
+
+// checkPayloadFields reports fields that UnmarshalJSON would silently drop
+// because they do not have the expected type.
+func checkPayloadFields(data []byte) error {
+	var raw map[string]interface{}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+
+	for _, field := range []string{"sequence_id", "command", "result", "reason"} {
+		if value, ok := raw[field]; ok {
+			if _, isString := value.(string); !isString {
+				return fmt.Errorf("field %s is %T, expected string", field, value)
+			}
+		}
+	}
+
+	return nil
+}
@@ -2,10 +2,55 @@ package report
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 
 	mqtt "github.com/eclipse/paho.mqtt.golang"
 )
 
+// Report types sent by the printer
+const (
+	TypePrint   = "print"
+	TypeInfo    = "info"
+	TypeUpgrade = "upgrade"
+	TypeXCam    = "xcam"
+	TypeSystem  = "system"
+	TypeCamera  = "camera"
+	TypePushing = "pushing"
+)
+
+var knownTypes = map[string]bool{
+	TypePrint:   true,
+	TypeInfo:    true,
+	TypeUpgrade: true,
+	TypeXCam:    true,
+	TypeSystem:  true,
+	TypeCamera:  true,
+	TypePushing: true,
+}
+
+var (
+	ErrMalformed   = errors.New("malformed report")
+	ErrUnknownType = errors.New("unknown report type")
+)
+
+// DecodeError is returned when a message on the report topic cannot be
+// decoded. It keeps the raw payload so that firmware changes can be
+// investigated.
+type DecodeError struct {
+	Topic   string
+	Payload []byte
+	Err     error
+}
+
+func (err *DecodeError) Error() string {
+	return fmt.Sprintf("failed to decode report on %s: %v", err.Topic, err.Err)
+}
+
+func (err *DecodeError) Unwrap() error {
+	return err.Err
+}
+
 type Report struct {
 	Type    string
 	Payload ReportPayload
@@ -25,16 +70,76 @@ func (r *Report) UnmarshalJSON(data []byte) error {
 	return nil
 }
 
+func (r *Report) ToMessage() ([]byte, error) {
+	return json.Marshal(map[string]ReportPayload{r.Type: r.Payload})
+}
+
+// Decode decodes a report, accepting any report type.
+func Decode(data []byte) (Report, error) {
+	return decode(data, false)
+}
+
+// DecodeStrict decodes a report and fails on anything that does not look like
+// a report this package knows about: unknown report types, more than one
+// report in a message or a payload that is not an object. It is meant for
+// tests that should notice firmware changes.
+func DecodeStrict(data []byte) (Report, error) {
+	return decode(data, true)
+}
+
 func FromMessage(msg mqtt.Message) (Report, error) {
-	var raw map[string]ReportPayload
-	if err := json.Unmarshal(msg.Payload(), &raw); err != nil {
-		return Report{}, nil
+	return fromMessage(msg, false)
+}
+
+func FromMessageStrict(msg mqtt.Message) (Report, error) {
+	return fromMessage(msg, true)
+}
+
+func fromMessage(msg mqtt.Message, strict bool) (Report, error) {
+	r, err := decode(msg.Payload(), strict)
+	if err != nil {
+		return Report{}, &DecodeError{
+			Topic:   msg.Topic(),
+			Payload: msg.Payload(),
+			Err:     err,
+		}
+	}
+
+	return r, nil
+}
+
+func decode(data []byte, strict bool) (Report, error) {
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return Report{}, fmt.Errorf("%w: %v", ErrMalformed, err)
+	}
+
+	if len(raw) == 0 {
+		return Report{}, fmt.Errorf("%w: empty message", ErrMalformed)
+	}
+	if strict && len(raw) > 1 {
+		return Report{}, fmt.Errorf("%w: message contains %d reports", ErrMalformed, len(raw))
 	}
 
 	var r Report
 	for k, v := range raw {
+		if strict && !knownTypes[k] {
+			return Report{}, fmt.Errorf("%w: %q", ErrUnknownType, k)
+		}
+
+		var payload ReportPayload
+		if err := json.Unmarshal(v, &payload); err != nil {
+			return Report{}, fmt.Errorf("%w: %s payload: %v", ErrMalformed, k, err)
+		}
+
+		if strict {
+			if err := checkPayloadFields(v); err != nil {
+				return Report{}, fmt.Errorf("%w: %s payload: %v", ErrMalformed, k, err)
+			}
+		}
+
 		r.Type = k
-		r.Payload = v
+		r.Payload = payload
 	}
 
 	return r, nil
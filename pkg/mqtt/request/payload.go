@@ -21,3 +21,23 @@ func (r RequestPayload) MarshalJSON() ([]byte, error) {
 	}
 	return json.Marshal(data)
 }
+
+func (r *RequestPayload) UnmarshalJSON(data []byte) error {
+	var raw map[string]interface{}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+
+	if seqID, ok := raw["sequence_id"].(string); ok {
+		r.SequenceID = seqID
+	}
+	if cmd, ok := raw["command"].(string); ok {
+		r.Command = cmd
+	}
+
+	delete(raw, "sequence_id")
+	delete(raw, "command")
+	r.Params = raw
+
+	return nil
+}
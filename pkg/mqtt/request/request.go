@@ -1,6 +1,9 @@
 package request
 
-import "encoding/json"
+import (
+	"encoding/json"
+	"fmt"
+)
 
 type Request struct {
 	Type    string
@@ -11,6 +14,26 @@ func (r *Request) ToMessage() ([]byte, error) {
 	return json.Marshal(map[string]RequestPayload{r.Type: r.Payload})
 }
 
+// FromMessage decodes a request as published on the request topic.
+func FromMessage(data []byte) (Request, error) {
+	var raw map[string]RequestPayload
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return Request{}, err
+	}
+
+	if len(raw) != 1 {
+		return Request{}, fmt.Errorf("expected one request, got %d", len(raw))
+	}
+
+	var r Request
+	for k, v := range raw {
+		r.Type = k
+		r.Payload = v
+	}
+
+	return r, nil
+}
+
 func (r *Request) SetSequenceID(sequence_id string) {
 	r.Payload.SequenceID = sequence_id
 }
@@ -161,6 +161,54 @@ func CreateProjectFileRequest(sequenceID, filename string) Request {
 	return CreateRequest("print", "project_file", sequenceID, params)
 }
 
+// ProjectFileOptions holds the parameters of a project_file request. The
+// zero value for AMSMapping lets the printer pick trays itself.
+type ProjectFileOptions struct {
+	Filename    string
+	URL         string
+	Plate       int
+	MD5         string
+	SubtaskName string
+
+	BedType              string
+	Timelapse            bool
+	BedLeveling          bool
+	FlowCalibration      bool
+	VibrationCalibration bool
+	LayerInspect         bool
+
+	UseAMS     bool
+	AMSMapping []int
+}
+
+func CreateProjectFileRequestWithOptions(sequenceID string, options ProjectFileOptions) Request {
+	amsMapping := options.AMSMapping
+	if amsMapping == nil {
+		amsMapping = []int{}
+	}
+
+	params := map[string]interface{}{
+		"param":          fmt.Sprintf("Metadata/plate_%d.gcode", options.Plate),
+		"project_id":     "0",
+		"profile_id":     "0",
+		"task_id":        "0",
+		"subtask_id":     "0",
+		"subtask_name":   options.SubtaskName,
+		"file":           options.Filename,
+		"url":            options.URL,
+		"md5":            options.MD5,
+		"timelapse":      options.Timelapse,
+		"bed_type":       options.BedType,
+		"bed_levelling":  options.BedLeveling,
+		"flow_cali":      options.FlowCalibration,
+		"vibration_cali": options.VibrationCalibration,
+		"layer_inspect":  options.LayerInspect,
+		"ams_mapping":    amsMapping,
+		"use_ams":        options.UseAMS,
+	}
+	return CreateRequest("print", "project_file", sequenceID, params)
+}
+
 func CreateSkipObjectsRequest(sequenceID string, objList []int) Request {
 	params := map[string]interface{}{
 		"timestamp": time.Now().UnixMilli(),
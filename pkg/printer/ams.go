@@ -0,0 +1,124 @@
+package printer
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/RobertMNewton/bambu-golang-api/pkg/mqtt/request"
+	"github.com/RobertMNewton/bambu-golang-api/pkg/state"
+)
+
+const (
+	maxAMSUnits  = 4
+	traysPerUnit = 4
+
+	// externalAMSID is the ams_id of the external spool holder
+	externalAMSID = 255
+
+	defaultNozzleTemp = 220
+)
+
+type AMSAction string
+
+const (
+	AMSResume AMSAction = "resume"
+	AMSReset  AMSAction = "reset"
+	AMSPause  AMSAction = "pause"
+)
+
+// TrayFilament describes the filament loaded in a tray. InfoIdx is the
+// filament preset id used by Bambu Studio, e.g. GFA00 for Bambu PLA Basic.
+// Color is RRGGBBAA.
+type TrayFilament struct {
+	InfoIdx       string
+	Type          string
+	Color         string
+	NozzleTempMin int
+	NozzleTempMax int
+}
+
+// SetTrayFilament sets the filament of a tray. trayID is the global tray id,
+// unit*4 + slot, or state.ExternalTrayID for the external spool.
+func (printer *Printer) SetTrayFilament(ctx context.Context, trayID int, filament TrayFilament) error {
+	if err := printer.validateTray(trayID); err != nil {
+		return err
+	}
+
+	if filament.NozzleTempMin > filament.NozzleTempMax {
+		return fmt.Errorf("invalid nozzle temperature range %d-%d", filament.NozzleTempMin, filament.NozzleTempMax)
+	}
+
+	amsID, slotID := trayID/traysPerUnit, trayID%traysPerUnit
+	if trayID == state.ExternalTrayID {
+		amsID, slotID = externalAMSID, state.ExternalTrayID
+	}
+
+	req := request.PrintAmsFilamentSettingRequest("", amsID, slotID, filament.InfoIdx, filament.Color, filament.Type, filament.NozzleTempMin, filament.NozzleTempMax)
+	_, err := printer.SendAndWait(ctx, req)
+	return err
+}
+
+// SwitchTray unloads the current filament and loads the filament of another
+// tray, heating the nozzle to the temperatures of both filaments.
+func (printer *Printer) SwitchTray(ctx context.Context, trayID int) error {
+	if err := printer.validateTray(trayID); err != nil {
+		return err
+	}
+
+	ams := printer.State().AMS
+
+	target, _ := ams.Tray(trayID)
+	if target.Empty() {
+		return fmt.Errorf("tray %d is empty", trayID)
+	}
+
+	currentTemp := defaultNozzleTemp
+	if current, ok := ams.ActiveTray(); ok && current.NozzleTempMax > 0 {
+		currentTemp = current.NozzleTempMax
+	}
+
+	targetTemp := defaultNozzleTemp
+	if target.NozzleTempMax > 0 {
+		targetTemp = target.NozzleTempMax
+	}
+
+	req := request.CreateAMSChangeFilamentRequest("", trayID, float64(currentTemp), float64(targetTemp))
+	_, err := printer.SendAndWait(ctx, req)
+	return err
+}
+
+// AMSControl resumes, resets or pauses the AMS, typically after a filament
+// error.
+func (printer *Printer) AMSControl(ctx context.Context, action AMSAction) error {
+	switch action {
+	case AMSResume, AMSReset, AMSPause:
+	default:
+		return fmt.Errorf("invalid AMS action %q", action)
+	}
+
+	_, err := printer.SendAndWait(ctx, request.PrintAmsControlRequest("", string(action)))
+	return err
+}
+
+// validateTray checks that a tray id is in range and, once the printer has
+// reported its AMS units, that the tray exists.
+func (printer *Printer) validateTray(trayID int) error {
+	if trayID == state.ExternalTrayID {
+		return nil
+	}
+
+	if trayID < 0 || trayID >= maxAMSUnits*traysPerUnit {
+		return fmt.Errorf("invalid tray id %d: must be 0-%d or %d for the external spool", trayID, maxAMSUnits*traysPerUnit-1, state.ExternalTrayID)
+	}
+
+	ams := printer.State().AMS
+	if len(ams.Units) == 0 {
+		return nil
+	}
+
+	if _, ok := ams.Tray(trayID); !ok {
+		return fmt.Errorf("invalid tray id %d: AMS %d slot %d is not installed", trayID, trayID/traysPerUnit, trayID%traysPerUnit)
+	}
+
+	return nil
+}
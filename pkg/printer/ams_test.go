@@ -0,0 +1,225 @@
+package printer_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/RobertMNewton/bambu-golang-api/pkg/bambutest"
+	"github.com/RobertMNewton/bambu-golang-api/pkg/printer"
+	"github.com/RobertMNewton/bambu-golang-api/pkg/state"
+)
+
+var petg = printer.TrayFilament{
+	InfoIdx:       "GFG00",
+	Type:          "PETG",
+	Color:         "00FF00FF",
+	NozzleTempMin: 230,
+	NozzleTempMax: 250,
+}
+
+// wantParams checks the params of the first request for command. Numbers
+// arrive as float64 after the JSON round trip.
+func wantParams(t *testing.T, server *bambutest.Server, command string, want map[string]interface{}) {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	req, err := server.WaitForRequest(ctx, command)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for key, value := range want {
+		if got := req.Payload.Params[key]; got != value {
+			t.Errorf("%s %s = %v (%T), want %v (%T)", command, key, got, got, value, value)
+		}
+	}
+}
+
+// wantNoRequest checks that the printer never received command.
+func wantNoRequest(t *testing.T, server *bambutest.Server, command string) {
+	t.Helper()
+
+	for _, req := range server.Requests() {
+		if req.Payload.Command == command {
+			t.Errorf("unexpected %s request: %v", command, req.Payload.Params)
+		}
+	}
+}
+
+func TestSetTrayFilament(t *testing.T) {
+	tests := []struct {
+		name       string
+		trayID     int
+		wantAMSID  float64
+		wantSlotID float64
+		tray       func(s state.PrinterState) state.Tray
+	}{
+		{
+			name:       "AMS tray",
+			trayID:     2,
+			wantAMSID:  0,
+			wantSlotID: 2,
+			tray:       func(s state.PrinterState) state.Tray { return s.AMS.Units[0].Trays[2] },
+		},
+		{
+			name:       "external spool",
+			trayID:     state.ExternalTrayID,
+			wantAMSID:  255,
+			wantSlotID: 254,
+			tray:       func(s state.PrinterState) state.Tray { return s.AMS.ExternalSpool },
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			sim := newSimulator(t)
+			p := connect(t, sim.Server)
+
+			ctx, cancel := context.WithTimeout(context.Background(), timeout)
+			defer cancel()
+
+			if err := p.SetTrayFilament(ctx, test.trayID, petg); err != nil {
+				t.Fatal(err)
+			}
+
+			wantParams(t, sim.Server, "ams_filament_setting", map[string]interface{}{
+				"ams_id":          test.wantAMSID,
+				"tray_id":         test.wantSlotID,
+				"tray_info_idx":   "GFG00",
+				"tray_type":       "PETG",
+				"tray_color":      "00FF00FF",
+				"nozzle_temp_min": 230.0,
+				"nozzle_temp_max": 250.0,
+			})
+
+			waitForState(t, p, func(s state.PrinterState) bool {
+				tray := test.tray(s)
+				return tray.Type == "PETG" && tray.Color == "00FF00FF" && tray.NozzleTempMax == 250
+			})
+		})
+	}
+}
+
+func TestSetTrayFilamentRejected(t *testing.T) {
+	tests := []struct {
+		name     string
+		trayID   int
+		filament printer.TrayFilament
+	}{
+		{name: "negative tray", trayID: -1, filament: petg},
+		{name: "tray out of range", trayID: 16, filament: petg},
+		{name: "AMS out of range", trayID: 253, filament: petg},
+		{name: "external AMS id", trayID: 255, filament: petg},
+		{name: "AMS not installed", trayID: 5, filament: petg},
+		{name: "temperature range", trayID: 1, filament: printer.TrayFilament{Type: "PLA", NozzleTempMin: 230, NozzleTempMax: 190}},
+	}
+
+	sim := newSimulator(t)
+	p := connect(t, sim.Server)
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), timeout)
+			defer cancel()
+
+			if err := p.SetTrayFilament(ctx, test.trayID, test.filament); err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+
+	wantNoRequest(t, sim.Server, "ams_filament_setting")
+}
+
+func TestSwitchTray(t *testing.T) {
+	sim := newSimulator(t)
+	p := connect(t, sim.Server)
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	// Nothing is loaded, so the current temperature is the default
+	if err := p.SwitchTray(ctx, 2); err != nil {
+		t.Fatal(err)
+	}
+	wantParams(t, sim.Server, "ams_change_filament", map[string]interface{}{
+		"target":    2.0,
+		"curr_temp": 220.0,
+		"tar_temp":  260.0,
+	})
+
+	waitForState(t, p, func(s state.PrinterState) bool {
+		active, ok := s.AMS.ActiveTray()
+		return ok && active.Type == "PETG"
+	})
+}
+
+func TestSwitchTrayExternalSpool(t *testing.T) {
+	sim := newSimulator(t)
+	p := connect(t, sim.Server)
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	// The external spool starts empty
+	if err := p.SwitchTray(ctx, state.ExternalTrayID); err == nil {
+		t.Fatal("switched to an empty external spool")
+	}
+	wantNoRequest(t, sim.Server, "ams_change_filament")
+
+	if err := p.SetTrayFilament(ctx, state.ExternalTrayID, petg); err != nil {
+		t.Fatal(err)
+	}
+	waitForState(t, p, func(s state.PrinterState) bool { return !s.AMS.ExternalSpool.Empty() })
+
+	if err := p.SwitchTray(ctx, state.ExternalTrayID); err != nil {
+		t.Fatal(err)
+	}
+	wantParams(t, sim.Server, "ams_change_filament", map[string]interface{}{
+		"target":   254.0,
+		"tar_temp": 250.0,
+	})
+}
+
+func TestSwitchTrayRejected(t *testing.T) {
+	sim := newSimulator(t)
+	p := connect(t, sim.Server)
+
+	for _, trayID := range []int{-1, 3, 7, 16, 255} {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		if err := p.SwitchTray(ctx, trayID); err == nil {
+			t.Errorf("switched to tray %d", trayID)
+		}
+		cancel()
+	}
+
+	wantNoRequest(t, sim.Server, "ams_change_filament")
+}
+
+func TestAMSControl(t *testing.T) {
+	for _, action := range []printer.AMSAction{printer.AMSResume, printer.AMSReset, printer.AMSPause} {
+		t.Run(string(action), func(t *testing.T) {
+			server := newServer(t)
+			p := connect(t, server)
+
+			ctx, cancel := context.WithTimeout(context.Background(), timeout)
+			defer cancel()
+
+			if err := p.AMSControl(ctx, action); err != nil {
+				t.Fatal(err)
+			}
+			wantParams(t, server, "ams_control", map[string]interface{}{"param": string(action)})
+		})
+	}
+
+	t.Run("invalid", func(t *testing.T) {
+		server := newServer(t)
+		p := connect(t, server)
+
+		if err := p.AMSControl(context.Background(), "eject"); err == nil {
+			t.Error("expected an error")
+		}
+		wantNoRequest(t, server, "ams_control")
+	})
+}
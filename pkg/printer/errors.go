@@ -0,0 +1,52 @@
+package printer
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/RobertMNewton/bambu-golang-api/pkg/hms"
+	"github.com/RobertMNewton/bambu-golang-api/pkg/mqtt/report"
+)
+
+const resultFail = "fail"
+
+// CommandError is returned when the printer replies to a request with a
+// failed result.
+type CommandError struct {
+	Command    string
+	SequenceID string
+	Result     string
+	Reason     string
+}
+
+func (err *CommandError) Error() string {
+	if err.Reason == "" {
+		return fmt.Sprintf("printer rejected %s (sequence id %s)", err.Command, err.SequenceID)
+	}
+	return fmt.Sprintf("printer rejected %s (sequence id %s): %s", err.Command, err.SequenceID, err.Reason)
+}
+
+func resultError(r report.Report) error {
+	if !strings.EqualFold(r.Payload.Result, resultFail) {
+		return nil
+	}
+
+	return &CommandError{
+		Command:    r.Payload.Command,
+		SequenceID: r.Payload.SequenceID,
+		Result:     r.Payload.Result,
+		Reason:     r.Payload.Reason,
+	}
+}
+
+// PrintError is returned when the printer reports a print as FAILED.
+type PrintError struct {
+	Code int
+}
+
+func (err *PrintError) Error() string {
+	if message, ok := hms.DefaultCatalogue().PrintErrorMessage(err.Code); ok {
+		return fmt.Sprintf("print failed with error code %08X: %s", err.Code, message)
+	}
+	return fmt.Sprintf("print failed with error code %08X", err.Code)
+}
@@ -0,0 +1,148 @@
+package printer
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/RobertMNewton/bambu-golang-api/pkg/mqtt/request"
+)
+
+type HealthStatus int
+
+const (
+	Healthy HealthStatus = iota
+	Stale
+	Disconnected
+)
+
+func (status HealthStatus) String() string {
+	switch status {
+	case Healthy:
+		return "healthy"
+	case Stale:
+		return "stale"
+	case Disconnected:
+		return "disconnected"
+	}
+	return "unknown"
+}
+
+type HealthEvent struct {
+	Status     HealthStatus
+	LastReport time.Time
+
+	// Err is set if a liveness probe could not be sent.
+	Err error
+}
+
+type WatchdogOptions struct {
+	// ProbeInterval is how often the watchdog checks the printer. A get_version
+	// probe is sent whenever no report arrived during the last interval.
+	ProbeInterval time.Duration
+
+	// StaleAfter is how long the printer may stay silent before it is
+	// considered stale. A pushall is sent when it becomes stale.
+	StaleAfter time.Duration
+}
+
+func DefaultWatchdogOptions() WatchdogOptions {
+	return WatchdogOptions{
+		ProbeInterval: 10 * time.Second,
+		StaleAfter:    30 * time.Second,
+	}
+}
+
+// LastReport returns when the last report was received from the printer.
+func (printer *Printer) LastReport() time.Time {
+	nanos := printer.lastReport.Load()
+	if nanos == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, nanos)
+}
+
+// Watch starts a watchdog that probes the printer and sends an event every
+// time its health changes. The channel is closed when ctx is done. Zero
+// options are taken from DefaultWatchdogOptions.
+func (printer *Printer) Watch(ctx context.Context, options WatchdogOptions) (<-chan HealthEvent, error) {
+	defaults := DefaultWatchdogOptions()
+	if options.ProbeInterval == 0 {
+		options.ProbeInterval = defaults.ProbeInterval
+	}
+	if options.StaleAfter == 0 {
+		options.StaleAfter = defaults.StaleAfter
+	}
+
+	if options.ProbeInterval < 0 {
+		return nil, fmt.Errorf("invalid probe interval %s", options.ProbeInterval)
+	}
+	if options.StaleAfter < options.ProbeInterval {
+		return nil, fmt.Errorf("stale timeout %s is shorter than the probe interval %s", options.StaleAfter, options.ProbeInterval)
+	}
+
+	events := make(chan HealthEvent, 1)
+
+	go func() {
+		defer close(events)
+
+		ticker := time.NewTicker(options.ProbeInterval)
+		defer ticker.Stop()
+
+		current := HealthStatus(-1)
+		for {
+			event := printer.checkHealth(ctx, options, current)
+
+			if event.Status != current || event.Err != nil {
+				current = event.Status
+
+				select {
+				case events <- event:
+				case <-ctx.Done():
+					return
+				}
+			}
+
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return events, nil
+}
+
+func (printer *Printer) checkHealth(ctx context.Context, options WatchdogOptions, current HealthStatus) HealthEvent {
+	event := HealthEvent{
+		Status:     Healthy,
+		LastReport: printer.LastReport(),
+	}
+
+	if !printer.IsConnected() {
+		event.Status = Disconnected
+		return event
+	}
+
+	silence := time.Since(event.LastReport)
+	if silence < options.ProbeInterval {
+		return event
+	}
+
+	probe := request.CreateGetVersionRequest("")
+	if silence >= options.StaleAfter {
+		event.Status = Stale
+
+		if current != Stale {
+			probe = request.CreatePushAllRequest("")
+		}
+	}
+
+	probeCtx, cancel := context.WithTimeout(ctx, options.ProbeInterval)
+	defer cancel()
+
+	event.Err = printer.SendRequest(probe, probeCtx)
+
+	return event
+}
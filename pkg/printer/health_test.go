@@ -0,0 +1,91 @@
+package printer_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/RobertMNewton/bambu-golang-api/pkg/printer"
+)
+
+func TestWatchOptions(t *testing.T) {
+	tests := []struct {
+		name    string
+		options printer.WatchdogOptions
+		wantErr bool
+	}{
+		{name: "zero", options: printer.WatchdogOptions{}},
+		{name: "defaults", options: printer.DefaultWatchdogOptions()},
+		{name: "only probe interval", options: printer.WatchdogOptions{ProbeInterval: time.Second}},
+		{name: "negative probe interval", options: printer.WatchdogOptions{ProbeInterval: -time.Second}, wantErr: true},
+		{name: "stale before probe", options: printer.WatchdogOptions{ProbeInterval: time.Minute, StaleAfter: time.Second}, wantErr: true},
+		{name: "probe interval above default stale timeout", options: printer.WatchdogOptions{ProbeInterval: time.Hour}, wantErr: true},
+	}
+
+	server := newServer(t)
+	p := printer.NewPrinter(server.Config())
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			events, err := p.Watch(ctx, test.options)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("Watch = %v, want error %t", err, test.wantErr)
+			}
+			if err != nil {
+				return
+			}
+
+			cancel()
+			for range events {
+			}
+		})
+	}
+}
+
+func TestWatch(t *testing.T) {
+	server := newServer(t)
+	p := connect(t, server)
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	events, err := p.Watch(ctx, printer.WatchdogOptions{
+		ProbeInterval: 20 * time.Millisecond,
+		StaleAfter:    100 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	next := func(want printer.HealthStatus) {
+		t.Helper()
+
+		for {
+			select {
+			case event := <-events:
+				if event.Status == want {
+					return
+				}
+			case <-ctx.Done():
+				t.Fatalf("printer never became %s", want)
+			}
+		}
+	}
+
+	next(printer.Healthy)
+
+	server.SetSilent(true)
+	next(printer.Stale)
+	if _, err := server.WaitForRequest(ctx, "get_version"); err != nil {
+		t.Errorf("no probe while silent: %v", err)
+	}
+
+	server.SetSilent(false)
+	next(printer.Healthy)
+
+	p.Disconnect()
+	next(printer.Disconnected)
+}
@@ -0,0 +1,252 @@
+package printer
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/RobertMNewton/bambu-golang-api/pkg/ftp"
+	"github.com/RobertMNewton/bambu-golang-api/pkg/mqtt/request"
+	"github.com/RobertMNewton/bambu-golang-api/pkg/state"
+)
+
+const sdCardURL = "file:///sdcard/"
+
+// PrintSource is a sliced 3MF project to upload, either a local file or a
+// reader.
+type PrintSource struct {
+	name   string
+	path   string
+	reader io.Reader
+	size   int64
+}
+
+func FileSource(localPath string) PrintSource {
+	return PrintSource{
+		name: filepath.Base(localPath),
+		path: localPath,
+	}
+}
+
+// ReaderSource uploads the contents of r under name. size may be negative if
+// it is unknown, in which case the upload cannot be verified. If r is an
+// io.ReadSeeker it is read twice, once to hash it and once to upload it, so
+// that an interrupted upload can be resumed; other readers are hashed while
+// they are uploaded and cannot be resumed.
+func ReaderSource(name string, r io.Reader, size int64) PrintSource {
+	return PrintSource{
+		name:   name,
+		reader: r,
+		size:   size,
+	}
+}
+
+func (source PrintSource) validate() error {
+	if source.path == "" && source.reader == nil {
+		return fmt.Errorf("print source has neither a file nor a reader")
+	}
+	return nil
+}
+
+type PrintOptions struct {
+	// RemoteName is the file name on the SD card, defaults to the source name
+	RemoteName string
+	Plate      int
+
+	BedType              string
+	Timelapse            bool
+	BedLeveling          bool
+	FlowCalibration      bool
+	VibrationCalibration bool
+	LayerInspect         bool
+
+	UseAMS     bool
+	AMSMapping []int
+
+	Upload ftp.UploadOptions
+}
+
+// DefaultPrintOptions returns the options Bambu Studio uses by default.
+func DefaultPrintOptions() PrintOptions {
+	return PrintOptions{
+		Plate:                1,
+		BedType:              "auto",
+		Timelapse:            false,
+		BedLeveling:          true,
+		FlowCalibration:      true,
+		VibrationCalibration: true,
+		LayerInspect:         true,
+		UseAMS:               true,
+	}
+}
+
+// PrintFile uploads a project over FTP, starts it with a project_file request
+// and waits until the printer reports that it is running.
+func (printer *Printer) PrintFile(ctx context.Context, source PrintSource, options PrintOptions) error {
+	if err := source.validate(); err != nil {
+		return err
+	}
+
+	if !printer.IsConnected() {
+		return fmt.Errorf("printer is not connected")
+	}
+
+	switch current := printer.State().Progress.GCodeState; current {
+	case state.GCodeStatePrepare, state.GCodeStateRunning, state.GCodeStatePause:
+		return fmt.Errorf("printer is busy: gcode_state is %s", current)
+	}
+
+	remoteName := options.RemoteName
+	if remoteName == "" {
+		remoteName = source.name
+	}
+	if remoteName == "" {
+		return fmt.Errorf("print source has no name")
+	}
+	remotePath := path.Join("/", remoteName)
+
+	checksum, err := printer.uploadSource(ctx, source, remotePath, options.Upload)
+	if err != nil {
+		return err
+	}
+
+	plate := options.Plate
+	if plate == 0 {
+		plate = 1
+	}
+
+	req := request.CreateProjectFileRequestWithOptions("", request.ProjectFileOptions{
+		Filename:             remoteName,
+		URL:                  sdCardURL + remoteName,
+		Plate:                plate,
+		MD5:                  checksum,
+		SubtaskName:          strings.TrimSuffix(strings.TrimSuffix(remoteName, ".3mf"), ".gcode"),
+		BedType:              options.BedType,
+		Timelapse:            options.Timelapse,
+		BedLeveling:          options.BedLeveling,
+		FlowCalibration:      options.FlowCalibration,
+		VibrationCalibration: options.VibrationCalibration,
+		LayerInspect:         options.LayerInspect,
+		UseAMS:               options.UseAMS,
+		AMSMapping:           options.AMSMapping,
+	})
+
+	return printer.startPrint(ctx, req)
+}
+
+func (printer *Printer) uploadSource(ctx context.Context, source PrintSource, remotePath string, options ftp.UploadOptions) (string, error) {
+	if err := source.validate(); err != nil {
+		return "", err
+	}
+
+	var checksum hash.Hash
+
+	err := printer.withFTP(ctx, func() error {
+		var err error
+		if source.path != "" {
+			checksum, err = printer.uploadFile(ctx, source.path, remotePath, options)
+		} else {
+			checksum, err = printer.uploadReader(ctx, source.reader, source.size, remotePath, options)
+		}
+		if err != nil {
+			return fmt.Errorf("upload failed: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return strings.ToUpper(hex.EncodeToString(checksum.Sum(nil))), nil
+}
+
+func (printer *Printer) uploadFile(ctx context.Context, localPath, remotePath string, options ftp.UploadOptions) (hash.Hash, error) {
+	file, err := os.Open(localPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open local file: %w", err)
+	}
+	defer file.Close()
+
+	return printer.uploadReader(ctx, file, -1, remotePath, options)
+}
+
+// uploadReader hashes seekable readers before uploading them, so that the
+// reader itself can still be used to resume the transfer. Other readers are
+// hashed as they are uploaded.
+func (printer *Printer) uploadReader(ctx context.Context, r io.Reader, size int64, remotePath string, options ftp.UploadOptions) (hash.Hash, error) {
+	checksum := md5.New()
+
+	seeker, ok := r.(io.ReadSeeker)
+	if !ok {
+		return checksum, printer.ftpClient.Upload(ctx, remotePath, io.TeeReader(r, checksum), size, options)
+	}
+
+	start, err := seeker.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, fmt.Errorf("failed to hash source: %w", err)
+	}
+
+	n, err := io.Copy(checksum, seeker)
+	if err != nil {
+		return nil, fmt.Errorf("failed to hash source: %w", err)
+	}
+	if size < 0 {
+		size = n
+	}
+
+	if _, err := seeker.Seek(start, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("failed to hash source: %w", err)
+	}
+
+	return checksum, printer.ftpClient.Upload(ctx, remotePath, seeker, size, options)
+}
+
+// startPrint sends a print request and waits for gcode_state to reach
+// RUNNING. The printer does not always reply to project_file, so a failed
+// reply and a transition to FAILED are both treated as errors.
+func (printer *Printer) startPrint(ctx context.Context, req request.Request) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	updates, unwatch := printer.watchState()
+	defer unwatch()
+
+	before := printer.State().Progress.GCodeState
+
+	replyErr := make(chan error, 1)
+	go func() {
+		if _, err := printer.SendAndWait(ctx, req); err != nil && ctx.Err() == nil {
+			replyErr <- err
+		}
+	}()
+
+	started := false
+	for {
+		select {
+		case <-updates:
+			s := printer.State()
+
+			switch s.Progress.GCodeState {
+			case state.GCodeStateRunning:
+				return nil
+			case state.GCodeStatePrepare, state.GCodeStateSlicing:
+				started = true
+			case state.GCodeStateFailed:
+				if started || before != state.GCodeStateFailed {
+					return &PrintError{Code: s.Progress.PrintError}
+				}
+			}
+		case err := <-replyErr:
+			return err
+		case <-ctx.Done():
+			return fmt.Errorf("print did not start: %w", ctx.Err())
+		}
+	}
+}
@@ -0,0 +1,229 @@
+package printer_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/RobertMNewton/bambu-golang-api/pkg/bambutest"
+	"github.com/RobertMNewton/bambu-golang-api/pkg/mqtt/report"
+	"github.com/RobertMNewton/bambu-golang-api/pkg/mqtt/request"
+	"github.com/RobertMNewton/bambu-golang-api/pkg/printer"
+	"github.com/RobertMNewton/bambu-golang-api/pkg/state"
+)
+
+func TestPrintFile(t *testing.T) {
+	project := []byte("sliced project")
+	sum := md5.Sum(project)
+
+	local := filepath.Join(t.TempDir(), "benchy.3mf")
+	if err := os.WriteFile(local, project, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		source  printer.PrintSource
+		options func(*printer.PrintOptions)
+		remote  string
+	}{
+		{name: "file", source: printer.FileSource(local), remote: "/benchy.3mf"},
+		{name: "reader", source: printer.ReaderSource("cube.3mf", bytes.NewReader(project), int64(len(project))), remote: "/cube.3mf"},
+		{name: "unknown size", source: printer.ReaderSource("cube.3mf", bytes.NewReader(project), -1), remote: "/cube.3mf"},
+		{
+			name:    "remote name",
+			source:  printer.FileSource(local),
+			options: func(options *printer.PrintOptions) { options.RemoteName = "renamed.3mf" },
+			remote:  "/renamed.3mf",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			sim := newSimulator(t)
+			p := connect(t, sim.Server)
+
+			ctx, cancel := context.WithTimeout(context.Background(), timeout)
+			defer cancel()
+
+			options := printer.DefaultPrintOptions()
+			if test.options != nil {
+				test.options(&options)
+			}
+			if err := p.PrintFile(ctx, test.source, options); err != nil {
+				t.Fatal(err)
+			}
+
+			if data, ok := sim.ReadFile(test.remote); !ok || !bytes.Equal(data, project) {
+				t.Errorf("uploaded %q to %s", data, test.remote)
+			}
+
+			req, err := sim.WaitForRequest(ctx, "project_file")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if req.Payload.Params["md5"] != strings.ToUpper(hex.EncodeToString(sum[:])) {
+				t.Errorf("md5 = %v", req.Payload.Params["md5"])
+			}
+			if p.State().Progress.GCodeState != state.GCodeStateRunning {
+				t.Errorf("gcode_state = %q, want RUNNING", p.State().Progress.GCodeState)
+			}
+		})
+	}
+}
+
+func TestPrintFileResume(t *testing.T) {
+	project := make([]byte, 1<<20)
+	for i := range project {
+		project[i] = byte(i % 251)
+	}
+	sum := md5.Sum(project)
+
+	local := filepath.Join(t.TempDir(), "large.3mf")
+	if err := os.WriteFile(local, project, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		source  printer.PrintSource
+		wantErr bool
+	}{
+		{name: "file", source: printer.FileSource(local)},
+		{name: "seekable reader", source: printer.ReaderSource("large.3mf", bytes.NewReader(project), int64(len(project)))},
+		{
+			// Only an io.Reader, which cannot be rewound
+			name:    "plain reader",
+			source:  printer.ReaderSource("large.3mf", struct{ io.Reader }{bytes.NewReader(project)}, int64(len(project))),
+			wantErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			sim := newSimulator(t)
+			p := connect(t, sim.Server)
+			sim.DropNextUpload(256 << 10)
+
+			ctx, cancel := context.WithTimeout(context.Background(), timeout)
+			defer cancel()
+
+			options := printer.DefaultPrintOptions()
+			options.Upload.Retries = 1
+
+			err := p.PrintFile(ctx, test.source, options)
+			if test.wantErr {
+				if err == nil {
+					t.Fatal("PrintFile resumed a reader that cannot seek")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if data, _ := sim.ReadFile("/large.3mf"); !bytes.Equal(data, project) {
+				t.Errorf("uploaded %d bytes, want %d", len(data), len(project))
+			}
+
+			req, err := sim.WaitForRequest(ctx, "project_file")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if req.Payload.Params["md5"] != strings.ToUpper(hex.EncodeToString(sum[:])) {
+				t.Errorf("md5 = %v", req.Payload.Params["md5"])
+			}
+		})
+	}
+}
+
+func TestPrintFileRejected(t *testing.T) {
+	tests := []struct {
+		name   string
+		source printer.PrintSource
+		setup  func(t *testing.T, sim *bambutest.Simulator, p *printer.Printer)
+	}{
+		{name: "zero source", source: printer.PrintSource{}},
+		{name: "no name", source: printer.ReaderSource("", strings.NewReader("x"), 1)},
+		{name: "missing file", source: printer.FileSource(filepath.Join(t.TempDir(), "missing.3mf"))},
+		{
+			name:   "busy",
+			source: printer.ReaderSource("cube.3mf", strings.NewReader("x"), 1),
+			setup: func(t *testing.T, sim *bambutest.Simulator, p *printer.Printer) {
+				if err := sim.UpdateStatus(map[string]interface{}{"gcode_state": "RUNNING"}); err != nil {
+					t.Fatal(err)
+				}
+				waitForState(t, p, func(s state.PrinterState) bool { return s.Progress.GCodeState == state.GCodeStateRunning })
+			},
+		},
+		{
+			name:   "printer refuses",
+			source: printer.ReaderSource("cube.3mf", strings.NewReader("x"), 1),
+			setup: func(t *testing.T, sim *bambutest.Simulator, p *printer.Printer) {
+				sim.FailCommand("project_file", "no plate")
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			sim := newSimulator(t)
+			p := connect(t, sim.Server)
+			if test.setup != nil {
+				test.setup(t, sim, p)
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), timeout)
+			defer cancel()
+
+			if err := p.PrintFile(ctx, test.source, printer.DefaultPrintOptions()); err == nil {
+				t.Fatal("PrintFile succeeded")
+			}
+		})
+	}
+}
+
+func TestPrintFileFailure(t *testing.T) {
+	server := newServer(t)
+	server.HandleCommand("project_file", func(req request.Request) []report.Report {
+		return []report.Report{
+			bambutest.Reply(req, "success", ""),
+			bambutest.PushStatus(map[string]interface{}{"gcode_state": "PREPARE"}),
+			bambutest.PushStatus(map[string]interface{}{"gcode_state": "FAILED", "print_error": 0x0300400C}),
+		}
+	})
+	p := connect(t, server)
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	err := p.PrintFile(ctx, printer.ReaderSource("cube.3mf", strings.NewReader("x"), 1), printer.DefaultPrintOptions())
+
+	var printErr *printer.PrintError
+	if !errors.As(err, &printErr) || printErr.Code != 0x0300400C {
+		t.Fatalf("PrintFile = %v, want a PrintError", err)
+	}
+}
+
+func waitForState(t *testing.T, p *printer.Printer, done func(state.PrinterState) bool) {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	for !done(p.State()) {
+		select {
+		case <-time.After(10 * time.Millisecond):
+		case <-ctx.Done():
+			t.Fatalf("state = %+v", p.State().Progress)
+		}
+	}
+}
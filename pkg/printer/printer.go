@@ -5,33 +5,62 @@ import (
 	"fmt"
 	"sync"
 	"sync/atomic"
+	"time"
 
 	"github.com/RobertMNewton/bambu-golang-api/pkg/ftp"
 	"github.com/RobertMNewton/bambu-golang-api/pkg/mqtt"
+	"github.com/RobertMNewton/bambu-golang-api/pkg/mqtt/report"
 	"github.com/RobertMNewton/bambu-golang-api/pkg/mqtt/request"
+	"github.com/RobertMNewton/bambu-golang-api/pkg/state"
 	"github.com/RobertMNewton/bambu-golang-api/pkg/types/config"
 )
 
+const refreshTimeout = 10 * time.Second
+
 type Printer struct {
 	config     config.PrinterConfig
 	mqttClient *mqtt.Client
-	//httpClient *http.Client // currently not useful. Required to finish cloud implementation
-	ftpClient *ftp.Client
+	ftpClient  *ftp.Client
+	ftpMu      sync.Mutex
 
 	mu        sync.RWMutex
 	connected bool
+	pending   map[string]pendingRequest
+	watchers  map[chan struct{}]struct{}
+
+	state *state.Tracker
+	jobs  jobHistory
 
 	sequence_id atomic.Uint32
+	lastReport  atomic.Int64
 }
 
 func NewPrinter(config config.PrinterConfig) *Printer {
-	return &Printer{
+	printer := &Printer{
 		config:     config,
 		mqttClient: mqtt.NewClient(config),
 		ftpClient:  ftp.NewClient(config),
+		state:      state.NewTracker(),
+		pending:    make(map[string]pendingRequest),
+		watchers:   make(map[chan struct{}]struct{}),
 	}
+
+	printer.mqttClient.OnConnectionStateChange(printer.handleConnectionState)
+
+	// Registered first so that the state is merged before any other
+	// subscriber sees the report.
+	printer.mqttClient.Subscribe(context.Background(), printer.handleReport)
+
+	return printer
+}
+
+type pendingRequest struct {
+	command string
+	replies chan report.Report
 }
 
+// Connect connects to the printer, starts tracking its state and requests a
+// full status snapshot.
 func (printer *Printer) Connect(ctx context.Context) error {
 	if err := printer.mqttClient.Connect(ctx); err != nil {
 		return fmt.Errorf("mqtt connection failed: %w", err)
@@ -39,15 +68,57 @@ func (printer *Printer) Connect(ctx context.Context) error {
 
 	printer.setConnected(true)
 
+	if err := printer.SendRequest(request.CreatePushAllRequest(""), ctx); err != nil {
+		return fmt.Errorf("pushall request failed: %w", err)
+	}
+
 	return nil
 }
 
-func (printer *Printer) Subscribe(ctx context.Context, callback mqtt.ReportHandler) error {
-	if err := printer.mqttClient.Subscribe(ctx, callback); err != nil {
-		return fmt.Errorf("mqtt subscription failed: %w", err)
+// Subscribe registers a callback that is invoked for every report of the
+// given types, or every report if no type is given. Reports have already been
+// merged into the printer state when the callback runs.
+func (printer *Printer) Subscribe(ctx context.Context, callback mqtt.ReportHandler, types ...string) (*mqtt.Subscription, error) {
+	subscription, err := printer.mqttClient.Subscribe(ctx, callback, types...)
+	if err != nil {
+		return nil, fmt.Errorf("mqtt subscription failed: %w", err)
 	}
 
-	return nil
+	return subscription, nil
+}
+
+// Reports returns a channel of reports that is closed when ctx is done.
+func (printer *Printer) Reports(ctx context.Context, options mqtt.ReportsOptions) <-chan report.Report {
+	return printer.mqttClient.Reports(ctx, options)
+}
+
+// State returns a snapshot of the printer state merged from every report
+// received since Connect.
+func (printer *Printer) State() state.PrinterState {
+	return printer.state.State()
+}
+
+// OnConnectionStateChange registers a handler that is called whenever the mqtt
+// connection is established, lost or being re-established.
+func (printer *Printer) OnConnectionStateChange(handler mqtt.ConnectionStateHandler) {
+	printer.mqttClient.OnConnectionStateChange(handler)
+}
+
+// SetReconnectPolicy changes how the printer reconnects after losing its mqtt
+// connection.
+func (printer *Printer) SetReconnectPolicy(policy mqtt.ReconnectPolicy) {
+	printer.mqttClient.SetReconnectPolicy(policy)
+}
+
+// OnDecodeError registers a handler for reports that could not be decoded.
+func (printer *Printer) OnDecodeError(handler mqtt.DecodeErrorHandler) {
+	printer.mqttClient.OnDecodeError(handler)
+}
+
+// Reconnect re-establishes the mqtt connection with the config's current
+// credentials, see mqtt.Client.Reconnect.
+func (printer *Printer) Reconnect(ctx context.Context) error {
+	return printer.mqttClient.Reconnect(ctx)
 }
 
 func (printer *Printer) Disconnect() {
@@ -67,6 +138,27 @@ func (printer *Printer) SendRequest(request request.Request, ctx context.Context
 	return printer.mqttClient.Publish(ctx, request)
 }
 
+// SendAndWait sends a request and waits for the printer to echo its sequence
+// id back. A reply with a failed result is returned as a *CommandError.
+func (printer *Printer) SendAndWait(ctx context.Context, req request.Request) (report.Report, error) {
+	sequenceID := printer.getNextSequenceId()
+	req.SetSequenceID(sequenceID)
+
+	replies := printer.addPending(sequenceID, req.Payload.Command)
+	defer printer.removePending(sequenceID)
+
+	if err := printer.mqttClient.Publish(ctx, req); err != nil {
+		return report.Report{}, err
+	}
+
+	select {
+	case r := <-replies:
+		return r, resultError(r)
+	case <-ctx.Done():
+		return report.Report{}, fmt.Errorf("no reply to %s (sequence id %s): %w", req.Payload.Command, sequenceID, ctx.Err())
+	}
+}
+
 func (printer *Printer) SendGCode(gcode string, ctx context.Context) error {
 	return printer.SendRequest(request.CreateGCodeLineRequest("", gcode), ctx)
 }
@@ -87,6 +179,26 @@ func (printer *Printer) StopPrint(ctx context.Context) error {
 	return printer.SendRequest(request.CreateStopPrintRequest(""), ctx)
 }
 
+func (printer *Printer) SendGCodeAndWait(ctx context.Context, gcode string) error {
+	_, err := printer.SendAndWait(ctx, request.CreateGCodeLineRequest("", gcode))
+	return err
+}
+
+func (printer *Printer) PausePrintAndWait(ctx context.Context) error {
+	_, err := printer.SendAndWait(ctx, request.CreatePausePrintRequest(""))
+	return err
+}
+
+func (printer *Printer) ResumePrintAndWait(ctx context.Context) error {
+	_, err := printer.SendAndWait(ctx, request.CreateResumePrintRequest(""))
+	return err
+}
+
+func (printer *Printer) StopPrintAndWait(ctx context.Context) error {
+	_, err := printer.SendAndWait(ctx, request.CreateStopPrintRequest(""))
+	return err
+}
+
 func (printer *Printer) UnloadFilament(ctx context.Context) error {
 	return printer.SendRequest(request.CreateUnloadFilamentRequest(""), ctx)
 }
@@ -95,12 +207,144 @@ func (printer *Printer) LoadFilament(ctx context.Context) error {
 	return printer.SendRequest(request.CreateLoadFilamentRequest(""), ctx)
 }
 
+// SetSpeed changes the print speed profile.
+func (printer *Printer) SetSpeed(ctx context.Context, level state.SpeedLevel) error {
+	if level < state.SpeedSilent || level > state.SpeedLudicrous {
+		return fmt.Errorf("invalid speed level %d", level)
+	}
+
+	_, err := printer.SendAndWait(ctx, request.CreatePrintSpeedRequest("", int(level)))
+	return err
+}
+
+// SetLight turns a light on or off. node is chamber_light or work_light.
+func (printer *Printer) SetLight(ctx context.Context, node string, on bool) error {
+	mode := "off"
+	if on {
+		mode = "on"
+	}
+
+	_, err := printer.SendAndWait(ctx, request.CreateLEDControlRequest("", node, mode, 500, 500, 0, 0))
+	return err
+}
+
 func (printer *Printer) getNextSequenceId() string {
-	id := printer.sequence_id.Load()
-	printer.sequence_id.Add(1)
+	id := printer.sequence_id.Add(1) - 1
 	return fmt.Sprint(id)
 }
 
+func (printer *Printer) addPending(sequenceID, command string) <-chan report.Report {
+	printer.mu.Lock()
+	defer printer.mu.Unlock()
+
+	replies := make(chan report.Report, 1)
+	printer.pending[sequenceID] = pendingRequest{
+		command: command,
+		replies: replies,
+	}
+
+	return replies
+}
+
+func (printer *Printer) removePending(sequenceID string) {
+	printer.mu.Lock()
+	defer printer.mu.Unlock()
+
+	delete(printer.pending, sequenceID)
+}
+
+// resolvePending hands a reply to the request waiting on its sequence id. The
+// command is compared as well because the printer numbers its own status
+// pushes independently of ours.
+func (printer *Printer) resolvePending(r report.Report) {
+	printer.mu.Lock()
+	defer printer.mu.Unlock()
+
+	pending, ok := printer.pending[r.Payload.SequenceID]
+	if !ok || pending.command != r.Payload.Command {
+		return
+	}
+
+	delete(printer.pending, r.Payload.SequenceID)
+	pending.replies <- r
+}
+
+// handleConnectionState keeps the connected flag in sync with the mqtt client
+// and requests a fresh snapshot after a reconnect, since reports sent while
+// the connection was down are lost.
+func (printer *Printer) handleConnectionState(event mqtt.ConnectionEvent) {
+	switch event.State {
+	case mqtt.StateConnected:
+		if !event.Reconnected {
+			return
+		}
+
+		printer.setConnected(true)
+
+		ctx, cancel := context.WithTimeout(context.Background(), refreshTimeout)
+		defer cancel()
+
+		printer.SendRequest(request.CreatePushAllRequest(""), ctx)
+	case mqtt.StateConnectionLost, mqtt.StateDisconnected:
+		printer.setConnected(false)
+	}
+}
+
+func (printer *Printer) handleReport(r report.Report) {
+	printer.lastReport.Store(time.Now().UnixNano())
+
+	changed := printer.state.Apply(r)
+	printer.resolvePending(r)
+
+	if !changed {
+		return
+	}
+
+	printer.jobs.observe(printer.state.State())
+
+	printer.mu.RLock()
+	defer printer.mu.RUnlock()
+
+	for watcher := range printer.watchers {
+		select {
+		case watcher <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// watchState returns a channel that is signalled whenever a status report has
+// been merged into the state. Signals are coalesced, so receivers should read
+// the latest state with State.
+func (printer *Printer) watchState() (<-chan struct{}, func()) {
+	printer.mu.Lock()
+	defer printer.mu.Unlock()
+
+	watcher := make(chan struct{}, 1)
+	printer.watchers[watcher] = struct{}{}
+
+	return watcher, func() {
+		printer.mu.Lock()
+		defer printer.mu.Unlock()
+
+		delete(printer.watchers, watcher)
+	}
+}
+
+// withFTP runs op on a fresh FTP session. Sessions are serialised because
+// the printer's FTP client holds a single connection.
+func (printer *Printer) withFTP(ctx context.Context, op func() error) error {
+	printer.ftpMu.Lock()
+	defer printer.ftpMu.Unlock()
+
+	if err := printer.ftpClient.Connect(ctx); err != nil {
+		return fmt.Errorf("ftp connection failed: %w", err)
+	}
+	defer printer.ftpClient.Disconnect()
+
+	return op()
+}
+
 func (printer *Printer) setConnected(connected bool) {
 	printer.mu.Lock()
 	defer printer.mu.Unlock()
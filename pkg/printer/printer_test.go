@@ -0,0 +1,272 @@
+package printer_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/RobertMNewton/bambu-golang-api/pkg/bambutest"
+	"github.com/RobertMNewton/bambu-golang-api/pkg/mqtt/report"
+	"github.com/RobertMNewton/bambu-golang-api/pkg/mqtt/request"
+	"github.com/RobertMNewton/bambu-golang-api/pkg/printer"
+	"github.com/RobertMNewton/bambu-golang-api/pkg/state"
+)
+
+const timeout = 10 * time.Second
+
+func newServer(t *testing.T) *bambutest.Server {
+	t.Helper()
+
+	server, err := bambutest.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { server.Close() })
+	return server
+}
+
+func newSimulator(t *testing.T) *bambutest.Simulator {
+	t.Helper()
+
+	options := bambutest.DefaultSimulatorOptions()
+	options.TimeScale = 600
+	options.TickInterval = 10 * time.Millisecond
+
+	sim, err := bambutest.NewSimulator(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { sim.Close() })
+	return sim
+}
+
+// connect connects to server and waits for the status snapshot.
+func connect(t *testing.T, server *bambutest.Server) *printer.Printer {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	p := printer.NewPrinter(server.Config())
+	if err := p.Connect(ctx); err != nil {
+		t.Fatalf("connect failed: %v", err)
+	}
+	t.Cleanup(p.Disconnect)
+
+	for p.State().Progress.GCodeState == "" {
+		select {
+		case <-time.After(10 * time.Millisecond):
+		case <-ctx.Done():
+			t.Fatal("no status after connecting")
+		}
+	}
+	return p
+}
+
+func TestConnect(t *testing.T) {
+	server := newServer(t)
+	p := connect(t, server)
+
+	if !p.IsConnected() {
+		t.Error("not connected")
+	}
+	if p.State().Progress.GCodeState != state.GCodeStateIdle {
+		t.Errorf("gcode_state = %q, want IDLE", p.State().Progress.GCodeState)
+	}
+	if time.Since(p.LastReport()) > timeout {
+		t.Errorf("last report at %s", p.LastReport())
+	}
+
+	p.Disconnect()
+	if p.IsConnected() {
+		t.Error("connected after Disconnect")
+	}
+}
+
+func TestSendAndWait(t *testing.T) {
+	tests := []struct {
+		name    string
+		setup   func(server *bambutest.Server)
+		send    func(ctx context.Context, p *printer.Printer) error
+		wantErr func(err error) bool
+	}{
+		{
+			name: "success",
+			send: func(ctx context.Context, p *printer.Printer) error {
+				return p.SendGCodeAndWait(ctx, "G28")
+			},
+		},
+		{
+			name:  "rejected",
+			setup: func(server *bambutest.Server) { server.FailCommand("gcode_line", "busy") },
+			send: func(ctx context.Context, p *printer.Printer) error {
+				return p.SendGCodeAndWait(ctx, "G28")
+			},
+			wantErr: func(err error) bool {
+				var commandErr *printer.CommandError
+				return errors.As(err, &commandErr) && commandErr.Command == "gcode_line" && commandErr.Reason == "busy"
+			},
+		},
+		{
+			name:  "no reply",
+			setup: func(server *bambutest.Server) { server.SetSilent(true) },
+			send: func(ctx context.Context, p *printer.Printer) error {
+				ctx, cancel := context.WithTimeout(ctx, 200*time.Millisecond)
+				defer cancel()
+				return p.StopPrintAndWait(ctx)
+			},
+			wantErr: func(err error) bool { return errors.Is(err, context.DeadlineExceeded) },
+		},
+		{
+			name: "invalid speed",
+			send: func(ctx context.Context, p *printer.Printer) error {
+				return p.SetSpeed(ctx, state.SpeedLevel(9))
+			},
+			wantErr: func(err error) bool { return err != nil },
+		},
+		{
+			name: "light",
+			send: func(ctx context.Context, p *printer.Printer) error {
+				return p.SetLight(ctx, "chamber_light", true)
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := newServer(t)
+			p := connect(t, server)
+			if test.setup != nil {
+				test.setup(server)
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), timeout)
+			defer cancel()
+
+			err := test.send(ctx, p)
+			if test.wantErr == nil && err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if test.wantErr != nil && !test.wantErr(err) {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestStartPrint(t *testing.T) {
+	server := newServer(t)
+	p := connect(t, server)
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	if err := p.StartPrint("/cache/cube.gcode", ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	req, err := server.WaitForRequest(ctx, "gcode_file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if req.Payload.Params["param"] != "/cache/cube.gcode" {
+		t.Errorf("param = %v", req.Payload.Params["param"])
+	}
+}
+
+func TestSendRequestSequenceIDs(t *testing.T) {
+	server := newServer(t)
+	p := connect(t, server)
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	for _, gcode := range []string{"G28", "M400"} {
+		if err := p.SendGCode(gcode, ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := server.WaitForRequest(ctx, "gcode_line"); err != nil {
+		t.Fatal(err)
+	}
+
+	seen := make(map[string]bool)
+	for deadline := time.Now().Add(timeout); len(seen) < 3 && time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		for _, req := range server.Requests() {
+			seen[req.Payload.SequenceID] = true
+		}
+	}
+	if len(seen) != 3 {
+		t.Errorf("sequence ids = %v, want one per request", seen)
+	}
+}
+
+func TestStateTracking(t *testing.T) {
+	server := newServer(t)
+	p := connect(t, server)
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	updates := make(chan report.Report, 10)
+	if _, err := p.Subscribe(ctx, func(r report.Report) { updates <- r }, report.TypePrint); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := server.UpdateStatus(map[string]interface{}{"gcode_state": "RUNNING", "mc_percent": 42}); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-updates:
+	case <-ctx.Done():
+		t.Fatal("no update")
+	}
+
+	// Subscribers run after the report was merged
+	s := p.State()
+	if s.Progress.GCodeState != state.GCodeStateRunning || s.Progress.Percent != 42 {
+		t.Errorf("progress = %+v", s.Progress)
+	}
+}
+
+func TestReconnectRefreshesState(t *testing.T) {
+	server := newServer(t)
+	p := connect(t, server)
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	if err := p.Reconnect(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// A pushall follows the new connection
+	for pushalls := 0; pushalls < 2; {
+		pushalls = 0
+		for _, req := range server.Requests() {
+			if req.Payload.Command == "pushall" {
+				pushalls++
+			}
+		}
+		select {
+		case <-time.After(10 * time.Millisecond):
+		case <-ctx.Done():
+			t.Fatal("no pushall after reconnecting")
+		}
+	}
+
+	if err := p.SendGCodeAndWait(ctx, "G28"); err != nil {
+		t.Errorf("request after reconnecting: %v", err)
+	}
+}
+
+func TestSendBeforeConnect(t *testing.T) {
+	server := newServer(t)
+	p := printer.NewPrinter(server.Config())
+
+	if err := p.SendRequest(request.CreatePushAllRequest(""), context.Background()); err == nil {
+		t.Error("SendRequest succeeded before Connect")
+	}
+}
@@ -0,0 +1,508 @@
+package printer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/RobertMNewton/bambu-golang-api/pkg/ftp"
+	"github.com/RobertMNewton/bambu-golang-api/pkg/state"
+)
+
+const (
+	timelapseThumbnailDir = ftp.TimelapseDir + "/thumbnail"
+
+	// timelapseTimeLayout is the time in video file names, such as
+	// video_2024-05-01_18-30-00.mp4, in the printer's local time
+	timelapseTimeLayout = "2006-01-02_15-04-05"
+
+	// jobMatchSlack is how far a timelapse may be recorded outside a job and
+	// still be associated with it, the printer encodes the video after the
+	// print and its clock may differ from ours
+	jobMatchSlack = 5 * time.Minute
+
+	maxJobHistory = 32
+)
+
+var (
+	// ErrNoThumbnail is returned for timelapses without a thumbnail.
+	ErrNoThumbnail = errors.New("timelapse has no thumbnail")
+
+	// ErrNoTimelapse is reported by the archiver when no new timelapse
+	// appears after a print, which is expected for prints recorded without
+	// one.
+	ErrNoTimelapse = errors.New("no timelapse found")
+)
+
+// Timelapse is a video recorded by the printer during a print.
+type Timelapse struct {
+	Name string
+	Path string
+	Size int64
+
+	// Time is when the video was recorded, taken from its file name, or its
+	// modification time if the name has no timestamp
+	Time time.Time
+
+	// ThumbnailPath is the remote path of the preview image, empty if the
+	// printer did not save one
+	ThumbnailPath string
+
+	// JobName is the subtask name of the print that recorded the video. It
+	// is only known for the last prints seen while the Printer was
+	// connected, and empty for older videos or those recorded before.
+	JobName string
+}
+
+// Timelapses lists the timelapse videos on the SD card, newest first.
+func (printer *Printer) Timelapses(ctx context.Context) ([]Timelapse, error) {
+	var files, thumbnails []ftp.FileInfo
+
+	err := printer.withFTP(ctx, func() error {
+		var err error
+		if files, err = printer.ftpClient.ListFiles(ctx, ftp.TimelapseDir); err != nil {
+			return err
+		}
+		// Not every printer has the directory
+		thumbnails, _ = printer.ftpClient.ListFiles(ctx, timelapseThumbnailDir)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list timelapses: %w", err)
+	}
+
+	thumbnailPaths := make(map[string]string)
+	for _, thumbnail := range thumbnails {
+		if !thumbnail.IsDir {
+			thumbnailPaths[trimExt(thumbnail.Name)] = thumbnail.Path
+		}
+	}
+
+	var timelapses []Timelapse
+	for _, file := range files {
+		if file.IsDir || !isTimelapseVideo(file.Name) {
+			continue
+		}
+
+		timelapse := Timelapse{
+			Name:          file.Name,
+			Path:          file.Path,
+			Size:          file.Size,
+			Time:          timelapseTime(file),
+			ThumbnailPath: thumbnailPaths[trimExt(file.Name)],
+		}
+		timelapse.JobName, _ = printer.jobs.jobAt(timelapse.Time)
+
+		timelapses = append(timelapses, timelapse)
+	}
+
+	sort.Slice(timelapses, func(i, j int) bool {
+		return timelapses[i].Time.After(timelapses[j].Time)
+	})
+
+	return timelapses, nil
+}
+
+// DownloadTimelapse writes the video to w.
+func (printer *Printer) DownloadTimelapse(ctx context.Context, timelapse Timelapse, w io.Writer) error {
+	return printer.withFTP(ctx, func() error {
+		return printer.ftpClient.Download(ctx, timelapse.Path, w)
+	})
+}
+
+// DownloadTimelapseThumbnail writes the JPEG preview of the video to w.
+func (printer *Printer) DownloadTimelapseThumbnail(ctx context.Context, timelapse Timelapse, w io.Writer) error {
+	if timelapse.ThumbnailPath == "" {
+		return ErrNoThumbnail
+	}
+
+	return printer.withFTP(ctx, func() error {
+		return printer.ftpClient.Download(ctx, timelapse.ThumbnailPath, w)
+	})
+}
+
+// DeleteTimelapse removes the video and its thumbnail from the SD card.
+func (printer *Printer) DeleteTimelapse(ctx context.Context, timelapse Timelapse) error {
+	return printer.withFTP(ctx, func() error {
+		if err := printer.ftpClient.Delete(ctx, timelapse.Path); err != nil {
+			return err
+		}
+		if timelapse.ThumbnailPath != "" {
+			return printer.ftpClient.Delete(ctx, timelapse.ThumbnailPath)
+		}
+		return nil
+	})
+}
+
+type ArchiveOptions struct {
+	// Dir is the local directory timelapses are saved to, along with their
+	// thumbnails. Ignored if Create is set.
+	Dir string
+
+	// Create opens the destination of a timelapse. The writer is closed once
+	// the download is done.
+	Create func(timelapse Timelapse) (io.WriteCloser, error)
+
+	// Delete removes timelapses from the printer once they have been saved.
+	Delete bool
+
+	// PollInterval is how often the SD card is checked for the video after a
+	// print finished. The video is only downloaded once its size has stopped
+	// changing between two checks.
+	PollInterval time.Duration
+
+	// Timeout is how long to wait for the video before reporting
+	// ErrNoTimelapse.
+	Timeout time.Duration
+}
+
+func DefaultArchiveOptions() ArchiveOptions {
+	return ArchiveOptions{
+		PollInterval: 10 * time.Second,
+		Timeout:      5 * time.Minute,
+	}
+}
+
+type ArchiveEvent struct {
+	Timelapse Timelapse
+
+	// Path is the local file the video was saved to when archiving to Dir
+	Path string
+
+	// Deleted is set if the video was removed from the printer
+	Deleted bool
+
+	Err error
+}
+
+// ArchiveTimelapses saves the timelapse of every print that finishes while
+// ctx is active. Videos already on the SD card are left alone. An event is
+// sent for every print, the channel is closed when ctx is done.
+func (printer *Printer) ArchiveTimelapses(ctx context.Context, options ArchiveOptions) (<-chan ArchiveEvent, error) {
+	if options.Dir == "" && options.Create == nil {
+		return nil, fmt.Errorf("archive needs a directory or a create function")
+	}
+
+	defaults := DefaultArchiveOptions()
+	if options.PollInterval <= 0 {
+		options.PollInterval = defaults.PollInterval
+	}
+	if options.Timeout <= 0 {
+		options.Timeout = defaults.Timeout
+	}
+
+	archiver := &archiver{printer: printer, options: options}
+
+	updates, unwatch := printer.watchState()
+	events := make(chan ArchiveEvent, 1)
+
+	go func() {
+		defer close(events)
+		defer unwatch()
+
+		send := func(event ArchiveEvent) bool {
+			select {
+			case events <- event:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		}
+
+		if err := archiver.refresh(ctx); err != nil && ctx.Err() == nil {
+			if !send(ArchiveEvent{Err: err}) {
+				return
+			}
+		}
+
+		last := printer.State().Progress.GCodeState
+		for {
+			select {
+			case <-updates:
+			case <-ctx.Done():
+				return
+			}
+
+			current := printer.State().Progress.GCodeState
+			finished := current == state.GCodeStateFinish && isActive(last)
+			last = current
+
+			if !finished {
+				continue
+			}
+
+			event := archiver.archive(ctx)
+			if ctx.Err() != nil || !send(event) {
+				return
+			}
+		}
+	}()
+
+	return events, nil
+}
+
+type archiver struct {
+	printer *Printer
+	options ArchiveOptions
+
+	// seen holds the videos that were on the SD card before the current
+	// print finished. It is nil if they could not be listed, in which case
+	// the newest video is taken.
+	seen map[string]bool
+}
+
+func (a *archiver) refresh(ctx context.Context) error {
+	timelapses, err := a.printer.Timelapses(ctx)
+	if err != nil {
+		return err
+	}
+
+	a.seen = make(map[string]bool, len(timelapses))
+	for _, timelapse := range timelapses {
+		a.seen[timelapse.Path] = true
+	}
+	return nil
+}
+
+// archive waits for the video of the print that just finished and saves it.
+func (a *archiver) archive(ctx context.Context) ArchiveEvent {
+	deadline := time.Now().Add(a.options.Timeout)
+
+	var candidate Timelapse
+	var lastErr error
+
+	for {
+		timelapses, err := a.printer.Timelapses(ctx)
+		lastErr = err
+
+		if err == nil {
+			newest, ok := a.newest(timelapses)
+			if ok && newest.Path == candidate.Path && newest.Size == candidate.Size {
+				break
+			}
+			candidate = newest
+		}
+
+		if time.Now().After(deadline) {
+			if lastErr == nil {
+				lastErr = ErrNoTimelapse
+			}
+			return ArchiveEvent{Err: lastErr}
+		}
+
+		select {
+		case <-time.After(a.options.PollInterval):
+		case <-ctx.Done():
+			return ArchiveEvent{Err: ctx.Err()}
+		}
+	}
+
+	event := ArchiveEvent{Timelapse: candidate}
+	if a.seen != nil {
+		a.seen[candidate.Path] = true
+	}
+
+	event.Path, event.Err = a.save(ctx, candidate)
+	if event.Err != nil {
+		return event
+	}
+
+	if a.options.Delete {
+		if err := a.printer.DeleteTimelapse(ctx, candidate); err != nil {
+			event.Err = fmt.Errorf("failed to delete timelapse: %w", err)
+			return event
+		}
+		event.Deleted = true
+	}
+
+	// Pick up videos saved outside of prints, so they are not mistaken for
+	// the next print's
+	a.refresh(ctx)
+
+	return event
+}
+
+func (a *archiver) newest(timelapses []Timelapse) (Timelapse, bool) {
+	for _, timelapse := range timelapses {
+		if !a.seen[timelapse.Path] {
+			return timelapse, true
+		}
+	}
+	return Timelapse{}, false
+}
+
+func (a *archiver) save(ctx context.Context, timelapse Timelapse) (string, error) {
+	if a.options.Create != nil {
+		w, err := a.options.Create(timelapse)
+		if err != nil {
+			return "", fmt.Errorf("failed to create destination: %w", err)
+		}
+
+		err = a.printer.DownloadTimelapse(ctx, timelapse, w)
+		if closeErr := w.Close(); err == nil && closeErr != nil {
+			err = closeErr
+		}
+		if err != nil {
+			return "", fmt.Errorf("failed to save timelapse: %w", err)
+		}
+		return "", nil
+	}
+
+	name, err := localName(timelapse.Name)
+	if err != nil {
+		return "", err
+	}
+
+	localPath := filepath.Join(a.options.Dir, name)
+	err = downloadToFile(localPath, func(w io.Writer) error {
+		return a.printer.DownloadTimelapse(ctx, timelapse, w)
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to save timelapse: %w", err)
+	}
+
+	if timelapse.ThumbnailPath != "" {
+		name, err := localName(path.Base(timelapse.ThumbnailPath))
+		if err != nil {
+			return localPath, err
+		}
+
+		thumbnailPath := filepath.Join(a.options.Dir, name)
+		err = downloadToFile(thumbnailPath, func(w io.Writer) error {
+			return a.printer.DownloadTimelapseThumbnail(ctx, timelapse, w)
+		})
+		if err != nil {
+			return localPath, fmt.Errorf("failed to save thumbnail: %w", err)
+		}
+	}
+
+	return localPath, nil
+}
+
+// localName returns the last element of a file name reported by the
+// printer, so that a name such as ../../.bashrc cannot escape the archive
+// directory.
+func localName(name string) (string, error) {
+	base := filepath.Base(name)
+	switch base {
+	case "", ".", "..", string(filepath.Separator):
+		return "", fmt.Errorf("invalid timelapse file name %q", name)
+	}
+	return base, nil
+}
+
+// downloadToFile writes to a temporary file that is renamed once complete,
+// so that an interrupted download never leaves a truncated file behind.
+func downloadToFile(localPath string, download func(w io.Writer) error) error {
+	if err := os.MkdirAll(filepath.Dir(localPath), 0o755); err != nil {
+		return err
+	}
+
+	file, err := os.CreateTemp(filepath.Dir(localPath), "."+filepath.Base(localPath)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+
+	err = download(file)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(file.Name(), localPath)
+}
+
+// jobHistory remembers recent prints so that timelapses can be matched to
+// the job that recorded them.
+type jobHistory struct {
+	mu      sync.Mutex
+	jobs    []jobRecord
+	current *jobRecord
+}
+
+type jobRecord struct {
+	name  string
+	start time.Time
+	end   time.Time
+}
+
+func (history *jobHistory) observe(s state.PrinterState) {
+	history.mu.Lock()
+	defer history.mu.Unlock()
+
+	active := isActive(s.Progress.GCodeState)
+
+	switch {
+	case active && history.current == nil:
+		history.current = &jobRecord{name: s.Progress.SubtaskName, start: s.UpdatedAt}
+	case active:
+		if s.Progress.SubtaskName != "" {
+			history.current.name = s.Progress.SubtaskName
+		}
+	case history.current != nil:
+		history.current.end = s.UpdatedAt
+		history.jobs = append(history.jobs, *history.current)
+		history.current = nil
+
+		if len(history.jobs) > maxJobHistory {
+			history.jobs = history.jobs[len(history.jobs)-maxJobHistory:]
+		}
+	}
+}
+
+// jobAt returns the name of the latest job running around t.
+func (history *jobHistory) jobAt(t time.Time) (string, bool) {
+	history.mu.Lock()
+	defer history.mu.Unlock()
+
+	if current := history.current; current != nil && !t.Before(current.start.Add(-jobMatchSlack)) {
+		return current.name, true
+	}
+
+	for i := len(history.jobs) - 1; i >= 0; i-- {
+		job := history.jobs[i]
+		if !t.Before(job.start.Add(-jobMatchSlack)) && !t.After(job.end.Add(jobMatchSlack)) {
+			return job.name, true
+		}
+	}
+	return "", false
+}
+
+func isActive(gcodeState state.GCodeState) bool {
+	switch gcodeState {
+	case state.GCodeStatePrepare, state.GCodeStateSlicing, state.GCodeStateRunning, state.GCodeStatePause:
+		return true
+	}
+	return false
+}
+
+func isTimelapseVideo(name string) bool {
+	switch strings.ToLower(path.Ext(name)) {
+	case ".mp4", ".avi":
+		return true
+	}
+	return false
+}
+
+func timelapseTime(file ftp.FileInfo) time.Time {
+	stamp := strings.TrimPrefix(trimExt(file.Name), "video_")
+	if t, err := time.ParseInLocation(timelapseTimeLayout, stamp, time.Local); err == nil {
+		return t
+	}
+	return file.ModTime
+}
+
+func trimExt(name string) string {
+	return strings.TrimSuffix(name, path.Ext(name))
+}
@@ -0,0 +1,33 @@
+package printer
+
+import "testing"
+
+func TestLocalName(t *testing.T) {
+	tests := []struct {
+		name    string
+		want    string
+		wantErr bool
+	}{
+		{name: "video_2024-05-01_18-30-00.mp4", want: "video_2024-05-01_18-30-00.mp4"},
+		{name: "../../.bashrc", want: ".bashrc"},
+		{name: "/etc/passwd", want: "passwd"},
+		{name: "thumbnail/video.jpg", want: "video.jpg"},
+		{name: "", wantErr: true},
+		{name: ".", wantErr: true},
+		{name: "..", wantErr: true},
+		{name: "../..", wantErr: true},
+		{name: "/", wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := localName(test.name)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("localName = %q, %v", got, err)
+			}
+			if got != test.want {
+				t.Errorf("localName = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
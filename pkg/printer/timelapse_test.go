@@ -0,0 +1,108 @@
+package printer_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/RobertMNewton/bambu-golang-api/pkg/printer"
+)
+
+func TestTimelapses(t *testing.T) {
+	server := newServer(t)
+	server.WriteFile("/timelapse/video_2024-05-01_18-30-00.mp4", []byte("older"))
+	server.WriteFile("/timelapse/video_2024-05-02_09-00-00.mp4", []byte("newer video"))
+	server.WriteFile("/timelapse/thumbnail/video_2024-05-02_09-00-00.jpg", []byte("jpeg"))
+	server.WriteFile("/timelapse/notes.txt", []byte("not a video"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	p := printer.NewPrinter(server.Config())
+	timelapses, err := p.Timelapses(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(timelapses) != 2 {
+		t.Fatalf("timelapses = %+v, want 2 videos", timelapses)
+	}
+
+	newer, older := timelapses[0], timelapses[1]
+	if newer.Name != "video_2024-05-02_09-00-00.mp4" || newer.Size != 11 {
+		t.Errorf("newest = %+v", newer)
+	}
+	if want := time.Date(2024, 5, 2, 9, 0, 0, 0, time.Local); !newer.Time.Equal(want) {
+		t.Errorf("time = %s, want %s", newer.Time, want)
+	}
+	if newer.ThumbnailPath != "/timelapse/thumbnail/video_2024-05-02_09-00-00.jpg" || older.ThumbnailPath != "" {
+		t.Errorf("thumbnails = %q, %q", newer.ThumbnailPath, older.ThumbnailPath)
+	}
+
+	var video, thumbnail bytes.Buffer
+	if err := p.DownloadTimelapse(ctx, newer, &video); err != nil || video.String() != "newer video" {
+		t.Errorf("DownloadTimelapse = %q, %v", video.String(), err)
+	}
+	if err := p.DownloadTimelapseThumbnail(ctx, newer, &thumbnail); err != nil || thumbnail.String() != "jpeg" {
+		t.Errorf("DownloadTimelapseThumbnail = %q, %v", thumbnail.String(), err)
+	}
+	if err := p.DownloadTimelapseThumbnail(ctx, older, &thumbnail); !errors.Is(err, printer.ErrNoThumbnail) {
+		t.Errorf("DownloadTimelapseThumbnail without a thumbnail = %v", err)
+	}
+
+	if err := p.DeleteTimelapse(ctx, newer); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := server.ReadFile(newer.Path); ok {
+		t.Error("video not deleted")
+	}
+	if _, ok := server.ReadFile(newer.ThumbnailPath); ok {
+		t.Error("thumbnail not deleted")
+	}
+}
+
+func TestArchiveTimelapses(t *testing.T) {
+	sim := newSimulator(t)
+	p := connect(t, sim.Server)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*timeout)
+	defer cancel()
+
+	dir := t.TempDir()
+	events, err := p.ArchiveTimelapses(ctx, printer.ArchiveOptions{
+		Dir:          dir,
+		Delete:       true,
+		PollInterval: 20 * time.Millisecond,
+		Timeout:      5 * time.Second,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	options := printer.DefaultPrintOptions()
+	options.Timelapse = true
+	if err := p.PrintFile(ctx, printer.ReaderSource("cube.3mf", bytes.NewReader([]byte("x")), 1), options); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case event := <-events:
+		if event.Err != nil {
+			t.Fatal(event.Err)
+		}
+		if event.Timelapse.JobName != "cube" || !event.Deleted {
+			t.Errorf("event = %+v", event)
+		}
+		if _, err := os.Stat(filepath.Join(dir, event.Timelapse.Name)); err != nil {
+			t.Errorf("archived video: %v", err)
+		}
+		if _, ok := sim.ReadFile(event.Timelapse.Path); ok {
+			t.Error("video left on the printer")
+		}
+	case <-ctx.Done():
+		t.Fatal("no timelapse archived")
+	}
+}
@@ -0,0 +1,219 @@
+package state
+
+import (
+	"encoding/json"
+	"math"
+	"strconv"
+)
+
+const maxFanLevel = 15
+
+func decodeState(raw map[string]interface{}) PrinterState {
+	return PrinterState{
+		Temperatures: Temperatures{
+			Nozzle:       toFloat(raw["nozzle_temper"]),
+			NozzleTarget: toFloat(raw["nozzle_target_temper"]),
+			Bed:          toFloat(raw["bed_temper"]),
+			BedTarget:    toFloat(raw["bed_target_temper"]),
+			Chamber:      toFloat(raw["chamber_temper"]),
+		},
+		Fans: Fans{
+			PartCooling: fanPercent(raw["cooling_fan_speed"]),
+			Auxiliary:   fanPercent(raw["big_fan1_speed"]),
+			Chamber:     fanPercent(raw["big_fan2_speed"]),
+			Heatbreak:   fanPercent(raw["heatbreak_fan_speed"]),
+		},
+		Progress: Progress{
+			GCodeState:       GCodeState(toString(raw["gcode_state"])),
+			GCodeFile:        toString(raw["gcode_file"]),
+			SubtaskName:      toString(raw["subtask_name"]),
+			Percent:          toInt(raw["mc_percent"]),
+			RemainingMinutes: toInt(raw["mc_remaining_time"]),
+			Layer:            toInt(raw["layer_num"]),
+			TotalLayers:      toInt(raw["total_layer_num"]),
+			PrintError:       toInt(raw["print_error"]),
+		},
+		Stage: Stage{
+			Current:    toInt(raw["stg_cur"]),
+			PrintStage: toInt(raw["mc_print_stage"]),
+		},
+		Speed: Speed{
+			Level:     SpeedLevel(toInt(raw["spd_lvl"])),
+			Magnitude: toInt(raw["spd_mag"]),
+		},
+		Lights:     decodeLights(raw["lights_report"]),
+		AMS:        decodeAMS(raw["ams"], raw["vt_tray"]),
+		HMS:        decodeHMS(raw["hms"]),
+		XCam:       decodeXCam(raw["xcam"]),
+		Upgrade:    decodeUpgrade(raw["upgrade_state"]),
+		WifiSignal: toString(raw["wifi_signal"]),
+		SDCard:     toBool(raw["sdcard"]),
+	}
+}
+
+func decodeLights(value interface{}) []Light {
+	var lights []Light
+	for _, item := range toList(value) {
+		obj := toMap(item)
+		lights = append(lights, Light{
+			Node: toString(obj["node"]),
+			Mode: toString(obj["mode"]),
+		})
+	}
+	return lights
+}
+
+func decodeAMS(value, externalSpool interface{}) AMS {
+	raw := toMap(value)
+
+	ams := AMS{
+		TrayNow:       toString(raw["tray_now"]),
+		TrayTar:       toString(raw["tray_tar"]),
+		ExternalSpool: decodeTray(toMap(externalSpool)),
+	}
+
+	for _, item := range toList(raw["ams"]) {
+		obj := toMap(item)
+
+		unit := AMSUnit{
+			ID:              toString(obj["id"]),
+			Humidity:        toString(obj["humidity"]),
+			HumidityPercent: toInt(obj["humidity_raw"]),
+			Temperature:     toFloat(obj["temp"]),
+		}
+		for _, trayItem := range toList(obj["tray"]) {
+			unit.Trays = append(unit.Trays, decodeTray(toMap(trayItem)))
+		}
+
+		ams.Units = append(ams.Units, unit)
+	}
+
+	return ams
+}
+
+func decodeTray(raw map[string]interface{}) Tray {
+	remain := -1
+	if value, ok := raw["remain"]; ok {
+		remain = toInt(value)
+	}
+
+	return Tray{
+		ID:            toString(raw["id"]),
+		TagUID:        toString(raw["tag_uid"]),
+		InfoIdx:       toString(raw["tray_info_idx"]),
+		Type:          toString(raw["tray_type"]),
+		SubBrand:      toString(raw["tray_sub_brands"]),
+		Color:         toString(raw["tray_color"]),
+		Remain:        remain,
+		NozzleTempMin: toInt(raw["nozzle_temp_min"]),
+		NozzleTempMax: toInt(raw["nozzle_temp_max"]),
+	}
+}
+
+func decodeHMS(value interface{}) []HMS {
+	var hms []HMS
+	for _, item := range toList(value) {
+		obj := toMap(item)
+		hms = append(hms, HMS{
+			Attr: uint32(toInt(obj["attr"])),
+			Code: uint32(toInt(obj["code"])),
+		})
+	}
+	return hms
+}
+
+func decodeXCam(value interface{}) XCam {
+	raw := toMap(value)
+	return XCam{
+		AllowSkipParts:           toBool(raw["allow_skip_parts"]),
+		BuildplateMarkerDetector: toBool(raw["buildplate_marker_detector"]),
+		FirstLayerInspector:      toBool(raw["first_layer_inspector"]),
+		HaltPrintSensitivity:     toString(raw["halt_print_sensitivity"]),
+		PrintHalt:                toBool(raw["print_halt"]),
+		PrintingMonitor:          toBool(raw["printing_monitor"]),
+		SpaghettiDetector:        toBool(raw["spaghetti_detector"]),
+	}
+}
+
+func decodeUpgrade(value interface{}) Upgrade {
+	raw := toMap(value)
+	return Upgrade{
+		Status:              toString(raw["status"]),
+		Module:              toString(raw["module"]),
+		Message:             toString(raw["message"]),
+		Progress:            toInt(raw["progress"]),
+		ErrorCode:           toInt(raw["err_code"]),
+		NewVersionAvailable: toInt(raw["new_version_state"]) == 1,
+		ForceUpgrade:        toBool(raw["force_upgrade"]),
+	}
+}
+
+func fanPercent(value interface{}) int {
+	level := toFloat(value)
+	return int(math.Round(level / maxFanLevel * 100))
+}
+
+func toMap(value interface{}) map[string]interface{} {
+	if m, ok := value.(map[string]interface{}); ok {
+		return m
+	}
+	return nil
+}
+
+func toList(value interface{}) []interface{} {
+	if l, ok := value.([]interface{}); ok {
+		return l
+	}
+	return nil
+}
+
+// The printer is not consistent about types, numbers are regularly sent as
+// strings and booleans as numbers, so every conversion accepts both.
+
+func toFloat(value interface{}) float64 {
+	switch v := value.(type) {
+	case float64:
+		return v
+	case json.Number:
+		f, _ := v.Float64()
+		return f
+	case string:
+		f, _ := strconv.ParseFloat(v, 64)
+		return f
+	case bool:
+		if v {
+			return 1
+		}
+	}
+	return 0
+}
+
+func toInt(value interface{}) int {
+	return int(toFloat(value))
+}
+
+func toString(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case json.Number:
+		return v.String()
+	case bool:
+		return strconv.FormatBool(v)
+	}
+	return ""
+}
+
+func toBool(value interface{}) bool {
+	switch v := value.(type) {
+	case bool:
+		return v
+	case string:
+		b, _ := strconv.ParseBool(v)
+		return b
+	default:
+		return toFloat(value) != 0
+	}
+}
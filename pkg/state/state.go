@@ -0,0 +1,256 @@
+package state
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
+
+type GCodeState string
+
+const (
+	GCodeStateIdle    GCodeState = "IDLE"
+	GCodeStatePrepare GCodeState = "PREPARE"
+	GCodeStateSlicing GCodeState = "SLICING"
+	GCodeStateRunning GCodeState = "RUNNING"
+	GCodeStatePause   GCodeState = "PAUSE"
+	GCodeStateFinish  GCodeState = "FINISH"
+	GCodeStateFailed  GCodeState = "FAILED"
+)
+
+type SpeedLevel int
+
+const (
+	SpeedSilent    SpeedLevel = 1
+	SpeedStandard  SpeedLevel = 2
+	SpeedSport     SpeedLevel = 3
+	SpeedLudicrous SpeedLevel = 4
+)
+
+var speedLevelNames = map[SpeedLevel]string{
+	SpeedSilent:    "silent",
+	SpeedStandard:  "standard",
+	SpeedSport:     "sport",
+	SpeedLudicrous: "ludicrous",
+}
+
+func (level SpeedLevel) String() string {
+	if name, ok := speedLevelNames[level]; ok {
+		return name
+	}
+	return fmt.Sprintf("SpeedLevel(%d)", int(level))
+}
+
+// PrinterState is a snapshot of everything the printer has reported so far.
+// Values are rebuilt on every report and never modified afterwards, so a
+// snapshot can be shared freely between goroutines.
+type PrinterState struct {
+	Temperatures Temperatures
+	Fans         Fans
+	Progress     Progress
+	Stage        Stage
+	Speed        Speed
+	Lights       []Light
+	AMS          AMS
+	HMS          []HMS
+	XCam         XCam
+	Upgrade      Upgrade
+
+	WifiSignal string
+	SDCard     bool
+
+	UpdatedAt time.Time
+}
+
+type Temperatures struct {
+	Nozzle       float64
+	NozzleTarget float64
+	Bed          float64
+	BedTarget    float64
+	Chamber      float64
+}
+
+// Fans holds fan speeds as a percentage. The printer reports them as a
+// level between 0 and 15.
+type Fans struct {
+	PartCooling int
+	Auxiliary   int
+	Chamber     int
+	Heatbreak   int
+}
+
+type Progress struct {
+	GCodeState       GCodeState
+	GCodeFile        string
+	SubtaskName      string
+	Percent          int
+	RemainingMinutes int
+	Layer            int
+	TotalLayers      int
+	PrintError       int
+}
+
+type Stage struct {
+	Current    int
+	PrintStage int
+}
+
+// Name returns a human readable description of the current stage.
+func (stage Stage) Name() string {
+	if name, ok := stageNames[stage.Current]; ok {
+		return name
+	}
+	return "Unknown"
+}
+
+var stageNames = map[int]string{
+	-1: "Idle",
+	0:  "Printing",
+	1:  "Auto bed leveling",
+	2:  "Heatbed preheating",
+	3:  "Sweeping XY mech mode",
+	4:  "Changing filament",
+	5:  "M400 pause",
+	6:  "Paused due to filament runout",
+	7:  "Heating hotend",
+	8:  "Calibrating extrusion",
+	9:  "Scanning bed surface",
+	10: "Inspecting first layer",
+	11: "Identifying build plate type",
+	12: "Calibrating micro lidar",
+	13: "Homing toolhead",
+	14: "Cleaning nozzle tip",
+	15: "Checking extruder temperature",
+	16: "Paused by the user",
+	17: "Pause of front cover falling",
+	18: "Calibrating micro lidar",
+	19: "Calibrating extrusion flow",
+	20: "Paused due to nozzle temperature malfunction",
+	21: "Paused due to heat bed temperature malfunction",
+	22: "Filament unloading",
+	23: "Skip step pause",
+	24: "Filament loading",
+	25: "Motor noise calibration",
+	26: "Paused due to AMS lost",
+	27: "Paused due to low speed of the heat break fan",
+	28: "Paused due to chamber temperature control error",
+	29: "Cooling chamber",
+	30: "Paused by the G-code inserted by user",
+	31: "Motor noise showoff",
+	32: "Nozzle filament covered detected pause",
+	33: "Cutter error pause",
+	34: "First layer error pause",
+	35: "Nozzle clog pause",
+}
+
+type Speed struct {
+	Level     SpeedLevel
+	Magnitude int
+}
+
+type Light struct {
+	Node string
+	Mode string
+}
+
+// Tray ids used by TrayNow and TrayTar that are not AMS slots.
+const (
+	ExternalTrayID = 254
+	NoTrayID       = 255
+)
+
+type AMS struct {
+	Units   []AMSUnit
+	TrayNow string
+	TrayTar string
+
+	// ExternalSpool is the spool on the holder at the back of the printer
+	ExternalSpool Tray
+}
+
+// ActiveTray returns the tray currently feeding the extruder.
+func (ams AMS) ActiveTray() (Tray, bool) {
+	id, err := strconv.Atoi(ams.TrayNow)
+	if err != nil {
+		return Tray{}, false
+	}
+	return ams.Tray(id)
+}
+
+// Tray returns a tray by its global id: unit*4 + slot for AMS trays, or
+// ExternalTrayID for the external spool.
+func (ams AMS) Tray(id int) (Tray, bool) {
+	if id == ExternalTrayID {
+		return ams.ExternalSpool, true
+	}
+
+	unitID, slotID := strconv.Itoa(id/4), strconv.Itoa(id%4)
+	for _, unit := range ams.Units {
+		if unit.ID != unitID {
+			continue
+		}
+		for _, tray := range unit.Trays {
+			if tray.ID == slotID {
+				return tray, true
+			}
+		}
+	}
+	return Tray{}, false
+}
+
+type AMSUnit struct {
+	ID string
+
+	// Humidity is the humidity level from 1 (wet) to 5 (dry) shown in Bambu
+	// Studio, HumidityPercent the raw reading when the firmware reports it.
+	Humidity        string
+	HumidityPercent int
+	Temperature     float64
+
+	Trays []Tray
+}
+
+type Tray struct {
+	ID       string
+	TagUID   string
+	InfoIdx  string
+	Type     string
+	SubBrand string
+	Color    string
+
+	// Remain is the remaining filament in percent, or -1 when unknown
+	Remain int
+
+	NozzleTempMin int
+	NozzleTempMax int
+}
+
+// Empty reports whether no filament is loaded in the tray.
+func (tray Tray) Empty() bool {
+	return tray.Type == ""
+}
+
+type HMS struct {
+	Attr uint32
+	Code uint32
+}
+
+type XCam struct {
+	AllowSkipParts           bool
+	BuildplateMarkerDetector bool
+	FirstLayerInspector      bool
+	HaltPrintSensitivity     string
+	PrintHalt                bool
+	PrintingMonitor          bool
+	SpaghettiDetector        bool
+}
+
+type Upgrade struct {
+	Status              string
+	Module              string
+	Message             string
+	Progress            int
+	ErrorCode           int
+	NewVersionAvailable bool
+	ForceUpgrade        bool
+}
@@ -0,0 +1,163 @@
+package state
+
+import (
+	"sync"
+	"time"
+
+	"github.com/RobertMNewton/bambu-golang-api/pkg/mqtt/report"
+)
+
+const pushStatusCommand = "push_status"
+
+// replacedEntries are lists whose entries are always reported in full, so an
+// entry replaces the previous one instead of being merged into it. An
+// emptied AMS tray is reported as just its id.
+var replacedEntries = map[string]bool{
+	"tray": true,
+}
+
+// Tracker merges the partial push_status reports sent by the printer into a
+// single PrinterState. P1 and A1 printers only send the fields that changed,
+// so the first full pushall snapshot is kept and every delta is merged on top.
+type Tracker struct {
+	mu    sync.RWMutex
+	raw   map[string]interface{}
+	state PrinterState
+}
+
+func NewTracker() *Tracker {
+	return &Tracker{
+		raw: make(map[string]interface{}),
+	}
+}
+
+// Apply merges a report into the tracked state. It returns false if the
+// report does not carry printer status.
+func (tracker *Tracker) Apply(r report.Report) bool {
+	if r.Type != "print" || r.Payload.Command != pushStatusCommand {
+		return false
+	}
+
+	tracker.mu.Lock()
+	defer tracker.mu.Unlock()
+
+	mergeMaps(tracker.raw, r.Payload.Params)
+	tracker.state = decodeState(tracker.raw)
+	tracker.state.UpdatedAt = time.Now()
+
+	return true
+}
+
+// State returns a snapshot of the merged printer state.
+func (tracker *Tracker) State() PrinterState {
+	tracker.mu.RLock()
+	defer tracker.mu.RUnlock()
+
+	return tracker.state
+}
+
+// Reset discards everything tracked so far.
+func (tracker *Tracker) Reset() {
+	tracker.mu.Lock()
+	defer tracker.mu.Unlock()
+
+	tracker.raw = make(map[string]interface{})
+	tracker.state = PrinterState{}
+}
+
+func mergeMaps(dst, src map[string]interface{}) {
+	for key, value := range src {
+		switch v := value.(type) {
+		case map[string]interface{}:
+			existing, ok := dst[key].(map[string]interface{})
+			if !ok {
+				existing = make(map[string]interface{})
+				dst[key] = existing
+			}
+			mergeMaps(existing, v)
+		case []interface{}:
+			existing, _ := dst[key].([]interface{})
+			dst[key] = mergeLists(existing, v, replacedEntries[key])
+		default:
+			dst[key] = value
+		}
+	}
+}
+
+// mergeLists merges lists of objects that identify themselves through an
+// "id" or "node" key, such as AMS units, trays and lights. Entries are merged
+// field by field unless replace is set. Any other list is replaced wholesale.
+func mergeLists(dst, src []interface{}, replace bool) []interface{} {
+	key := listKey(src)
+	if key == "" || len(dst) == 0 {
+		return copyList(src)
+	}
+
+	merged := copyList(dst)
+	for _, item := range src {
+		obj := item.(map[string]interface{})
+
+		found := false
+		for i, existing := range merged {
+			existingObj, ok := existing.(map[string]interface{})
+			if !ok || existingObj[key] != obj[key] {
+				continue
+			}
+
+			if replace {
+				merged[i] = copyValue(obj)
+			} else {
+				mergeMaps(existingObj, obj)
+			}
+			found = true
+			break
+		}
+
+		if !found {
+			merged = append(merged, copyValue(obj))
+		}
+	}
+
+	return merged
+}
+
+func listKey(list []interface{}) string {
+	for _, key := range []string{"id", "node"} {
+		ok := len(list) > 0
+		for _, item := range list {
+			obj, isObj := item.(map[string]interface{})
+			if !isObj {
+				return ""
+			}
+			if _, has := obj[key]; !has {
+				ok = false
+				break
+			}
+		}
+		if ok {
+			return key
+		}
+	}
+	return ""
+}
+
+func copyList(list []interface{}) []interface{} {
+	copied := make([]interface{}, len(list))
+	for i, item := range list {
+		copied[i] = copyValue(item)
+	}
+	return copied
+}
+
+func copyValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		copied := make(map[string]interface{}, len(v))
+		mergeMaps(copied, v)
+		return copied
+	case []interface{}:
+		return copyList(v)
+	default:
+		return value
+	}
+}
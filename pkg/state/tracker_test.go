@@ -0,0 +1,120 @@
+package state
+
+import (
+	"testing"
+
+	"github.com/RobertMNewton/bambu-golang-api/pkg/mqtt/report"
+)
+
+func applyAll(t *testing.T, reports ...string) PrinterState {
+	t.Helper()
+
+	tracker := NewTracker()
+	for _, data := range reports {
+		r, err := report.Decode([]byte(data))
+		if err != nil {
+			t.Fatalf("failed to decode %s: %v", data, err)
+		}
+		tracker.Apply(r)
+	}
+	return tracker.State()
+}
+
+const fullAMS = `{"print":{"command":"push_status","ams":{"tray_now":"0","ams":[{"id":"0","humidity":"4","temp":"24.5","tray":[
+	{"id":"0","tray_type":"PLA","tray_color":"FF0000FF","remain":80,"tray_info_idx":"GFA00"},
+	{"id":"1","tray_type":"PETG","tray_color":"00FF00FF","remain":40}
+]}]}}}`
+
+func TestTrackerMergesDeltas(t *testing.T) {
+	s := applyAll(t,
+		`{"print":{"command":"push_status","gcode_state":"RUNNING","mc_percent":10,"nozzle_temper":220.5}}`,
+		`{"print":{"command":"push_status","mc_percent":11}}`,
+	)
+
+	if s.Progress.GCodeState != GCodeStateRunning {
+		t.Errorf("gcode_state = %q, want RUNNING", s.Progress.GCodeState)
+	}
+	if s.Progress.Percent != 11 {
+		t.Errorf("percent = %d, want 11", s.Progress.Percent)
+	}
+	if s.Temperatures.Nozzle != 220.5 {
+		t.Errorf("nozzle = %v, want 220.5", s.Temperatures.Nozzle)
+	}
+}
+
+func TestTrackerIgnoresOtherReports(t *testing.T) {
+	tracker := NewTracker()
+	r, _ := report.Decode([]byte(`{"print":{"command":"gcode_line","sequence_id":"1","result":"success"}}`))
+
+	if tracker.Apply(r) {
+		t.Error("Apply accepted a gcode_line reply")
+	}
+}
+
+func TestTrackerAMSTrays(t *testing.T) {
+	tests := []struct {
+		name  string
+		delta string
+		check func(t *testing.T, trays []Tray)
+		humid string
+	}{
+		{
+			name:  "unit fields merge",
+			delta: `{"print":{"command":"push_status","ams":{"ams":[{"id":"0","humidity":"3"}]}}}`,
+			humid: "3",
+			check: func(t *testing.T, trays []Tray) {
+				if len(trays) != 2 || trays[0].Type != "PLA" {
+					t.Errorf("trays = %+v, want both trays kept", trays)
+				}
+			},
+		},
+		{
+			name:  "emptied tray",
+			delta: `{"print":{"command":"push_status","ams":{"ams":[{"id":"0","tray":[{"id":"1"}]}]}}}`,
+			humid: "4",
+			check: func(t *testing.T, trays []Tray) {
+				if !trays[1].Empty() || trays[1].Color != "" || trays[1].Remain != -1 {
+					t.Errorf("tray 1 = %+v, want empty", trays[1])
+				}
+				if trays[0].Type != "PLA" {
+					t.Errorf("tray 0 = %+v, want untouched", trays[0])
+				}
+			},
+		},
+		{
+			name:  "tray replaced",
+			delta: `{"print":{"command":"push_status","ams":{"ams":[{"id":"0","tray":[{"id":"0","tray_type":"ABS","tray_color":"000000FF"}]}]}}}`,
+			humid: "4",
+			check: func(t *testing.T, trays []Tray) {
+				if trays[0].Type != "ABS" || trays[0].Remain != -1 || trays[0].InfoIdx != "" {
+					t.Errorf("tray 0 = %+v, want only the new fields", trays[0])
+				}
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := applyAll(t, fullAMS, test.delta)
+
+			if len(s.AMS.Units) != 1 {
+				t.Fatalf("units = %+v, want 1", s.AMS.Units)
+			}
+			if s.AMS.Units[0].Humidity != test.humid {
+				t.Errorf("humidity = %q, want %q", s.AMS.Units[0].Humidity, test.humid)
+			}
+			test.check(t, s.AMS.Units[0].Trays)
+		})
+	}
+}
+
+func TestTrackerLightsMerge(t *testing.T) {
+	s := applyAll(t,
+		`{"print":{"command":"push_status","lights_report":[{"node":"chamber_light","mode":"on"},{"node":"work_light","mode":"flashing"}]}}`,
+		`{"print":{"command":"push_status","lights_report":[{"node":"chamber_light","mode":"off"}]}}`,
+	)
+
+	if len(s.Lights) != 2 || s.Lights[0].Mode != "off" || s.Lights[1].Mode != "flashing" {
+		t.Errorf("lights = %+v", s.Lights)
+	}
+}
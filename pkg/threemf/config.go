@@ -0,0 +1,329 @@
+package threemf
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// modelNames maps the printer_model_id of slice_info.config to the model
+// name.
+var modelNames = map[string]string{
+	"BL-P001": "X1 Carbon",
+	"BL-P002": "X1",
+	"C13":     "X1E",
+	"C11":     "P1P",
+	"C12":     "P1S",
+	"N1":      "A1 mini",
+	"N2S":     "A1",
+}
+
+// Plate is a build plate of the project.
+type Plate struct {
+	// Index is the 1-based plate number used by project_file requests
+	Index int
+	Name  string
+
+	// GCodePath is the G-code inside the archive, empty if the plate has not
+	// been sliced. GCodeMD5 is its checksum if the slicer stored one.
+	GCodePath string
+	GCodeMD5  string
+
+	// ThumbnailPath is the PNG preview shown by the printer. TopPath and
+	// PickPath are the top view and the object picking image, which colours
+	// every object differently.
+	ThumbnailPath string
+	TopPath       string
+	PickPath      string
+
+	// The fields below are read from slice_info.config and only set for
+	// sliced plates.
+
+	// PrinterModelID is the model code of the target printer, see ModelName
+	PrinterModelID string
+	NozzleDiameter float64
+
+	// Prediction is the estimated print time
+	Prediction time.Duration
+
+	// Weight is the total filament weight in grams
+	Weight float64
+
+	SupportUsed bool
+
+	// LabelObjects is set if the G-code labels objects, which is needed to
+	// skip them during a print
+	LabelObjects bool
+
+	Objects   []Object
+	Filaments []Filament
+	Warnings  []Warning
+}
+
+// ModelName returns the name of the printer model the plate was sliced for,
+// or the model code if it is unknown.
+func (plate Plate) ModelName() string {
+	if name, ok := modelNames[plate.PrinterModelID]; ok {
+		return name
+	}
+	return plate.PrinterModelID
+}
+
+// ObjectIDs returns the ids of the objects that are printed, for
+// CreateSkipObjectsRequest.
+func (plate Plate) ObjectIDs() []int {
+	var ids []int
+	for _, object := range plate.Objects {
+		if !object.Skipped {
+			ids = append(ids, object.ID)
+		}
+	}
+	return ids
+}
+
+// AMSMapping returns a mapping for project_file requests that loads every
+// filament slot from the AMS tray with the same number, slot 1 from tray 0
+// and so on. Unused slots are -1.
+func (plate Plate) AMSMapping() []int {
+	var slots int
+	for _, filament := range plate.Filaments {
+		slots = max(slots, filament.Slot)
+	}
+
+	mapping := make([]int, slots)
+	for i := range mapping {
+		mapping[i] = -1
+	}
+	for _, filament := range plate.Filaments {
+		if filament.Slot > 0 {
+			mapping[filament.Slot-1] = filament.Slot - 1
+		}
+	}
+	return mapping
+}
+
+// Object is a part on a plate.
+type Object struct {
+	// ID is the identify_id the printer uses to skip the object
+	ID      int
+	Name    string
+	Skipped bool
+}
+
+// Filament is a filament used by a plate.
+type Filament struct {
+	// Slot is the 1-based filament number in the project
+	Slot int
+
+	// Type is the material, such as PLA or PETG, and Color is #RRGGBB or
+	// #RRGGBBAA
+	Type  string
+	Color string
+
+	// TrayInfoIdx is the filament preset id, such as GFA00 for Bambu PLA
+	// Basic
+	TrayInfoIdx string
+
+	UsedMeters float64
+	UsedGrams  float64
+}
+
+// Warning is a problem the slicer found with a plate.
+type Warning struct {
+	Message   string
+	Level     int
+	ErrorCode string
+}
+
+type configEntry struct {
+	Key   string `xml:"key,attr"`
+	Value string `xml:"value,attr"`
+}
+
+type configEntries []configEntry
+
+func (entries configEntries) get(key string) string {
+	for _, entry := range entries {
+		if entry.Key == key {
+			return entry.Value
+		}
+	}
+	return ""
+}
+
+type sliceInfoXML struct {
+	Header configEntries `xml:"header>header_item"`
+	Plates []struct {
+		Metadata configEntries `xml:"metadata"`
+		Objects  []struct {
+			IdentifyID string `xml:"identify_id,attr"`
+			Name       string `xml:"name,attr"`
+			Skipped    string `xml:"skipped,attr"`
+		} `xml:"object"`
+		Filaments []struct {
+			ID          string `xml:"id,attr"`
+			TrayInfoIdx string `xml:"tray_info_idx,attr"`
+			Type        string `xml:"type,attr"`
+			Color       string `xml:"color,attr"`
+			UsedM       string `xml:"used_m,attr"`
+			UsedG       string `xml:"used_g,attr"`
+		} `xml:"filament"`
+		Warnings []struct {
+			Message   string `xml:"msg,attr"`
+			Level     string `xml:"level,attr"`
+			ErrorCode string `xml:"error_code,attr"`
+		} `xml:"warning"`
+	} `xml:"plate"`
+}
+
+type sliceInfo struct {
+	version string
+	plates  []Plate
+}
+
+func (archive *Archive) readSliceInfo() (sliceInfo, error) {
+	var raw sliceInfoXML
+	if err := archive.decodeXML(sliceInfoPath, &raw); err != nil {
+		return sliceInfo{}, err
+	}
+
+	info := sliceInfo{version: raw.Header.get("X-BBL-Client-Version")}
+
+	for _, rawPlate := range raw.Plates {
+		plate := Plate{
+			Index:          toInt(rawPlate.Metadata.get("index")),
+			PrinterModelID: rawPlate.Metadata.get("printer_model_id"),
+			NozzleDiameter: toFloat(firstField(rawPlate.Metadata.get("nozzle_diameters"))),
+			Prediction:     time.Duration(toInt(rawPlate.Metadata.get("prediction"))) * time.Second,
+			Weight:         toFloat(rawPlate.Metadata.get("weight")),
+			SupportUsed:    toBool(rawPlate.Metadata.get("support_used")),
+			LabelObjects:   toBool(rawPlate.Metadata.get("label_object_enabled")),
+		}
+
+		for _, object := range rawPlate.Objects {
+			plate.Objects = append(plate.Objects, Object{
+				ID:      toInt(object.IdentifyID),
+				Name:    object.Name,
+				Skipped: toBool(object.Skipped),
+			})
+		}
+
+		for _, filament := range rawPlate.Filaments {
+			plate.Filaments = append(plate.Filaments, Filament{
+				Slot:        toInt(filament.ID),
+				Type:        filament.Type,
+				Color:       filament.Color,
+				TrayInfoIdx: filament.TrayInfoIdx,
+				UsedMeters:  toFloat(filament.UsedM),
+				UsedGrams:   toFloat(filament.UsedG),
+			})
+		}
+
+		for _, warning := range rawPlate.Warnings {
+			plate.Warnings = append(plate.Warnings, Warning{
+				Message:   warning.Message,
+				Level:     toInt(warning.Level),
+				ErrorCode: warning.ErrorCode,
+			})
+		}
+
+		info.plates = append(info.plates, plate)
+	}
+
+	return info, nil
+}
+
+type modelSettingsXML struct {
+	Plates []struct {
+		Metadata configEntries `xml:"metadata"`
+	} `xml:"plate"`
+}
+
+type plateSettings struct {
+	index         int
+	name          string
+	gcodeFile     string
+	thumbnailFile string
+	topFile       string
+	pickFile      string
+}
+
+func (archive *Archive) readModelSettings() ([]plateSettings, error) {
+	var raw modelSettingsXML
+	if err := archive.decodeXML(modelSettingsPath, &raw); err != nil {
+		return nil, err
+	}
+
+	var plates []plateSettings
+	for _, rawPlate := range raw.Plates {
+		plates = append(plates, plateSettings{
+			index:         toInt(rawPlate.Metadata.get("plater_id")),
+			name:          rawPlate.Metadata.get("plater_name"),
+			gcodeFile:     rawPlate.Metadata.get("gcode_file"),
+			thumbnailFile: rawPlate.Metadata.get("thumbnail_file"),
+			topFile:       rawPlate.Metadata.get("top_file"),
+			pickFile:      rawPlate.Metadata.get("pick_file"),
+		})
+	}
+	return plates, nil
+}
+
+type projectSettings struct {
+	PrinterModel string `json:"printer_model"`
+}
+
+func (archive *Archive) readProjectSettings() (projectSettings, error) {
+	data, err := archive.ReadFile(projectSettingsPath)
+	if err != nil {
+		return projectSettings{}, fmt.Errorf("failed to read %s: %w", projectSettingsPath, err)
+	}
+
+	var settings projectSettings
+	if err := json.Unmarshal(data, &settings); err != nil {
+		return projectSettings{}, fmt.Errorf("failed to parse %s: %w", projectSettingsPath, err)
+	}
+	return settings, nil
+}
+
+func (archive *Archive) decodeXML(name string, v interface{}) error {
+	r, err := archive.Open(name)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", name, err)
+	}
+	defer r.Close()
+
+	if err := xml.NewDecoder(r).Decode(v); err != nil {
+		return fmt.Errorf("failed to parse %s: %w", name, err)
+	}
+	return nil
+}
+
+// The slicer writes every value as a string, missing or malformed values
+// read as zero.
+
+func toInt(value string) int {
+	i, _ := strconv.Atoi(strings.TrimSpace(value))
+	return i
+}
+
+func toFloat(value string) float64 {
+	f, _ := strconv.ParseFloat(strings.TrimSpace(value), 64)
+	return f
+}
+
+// firstField returns the first of several space separated values, printers
+// with two nozzles list a diameter for each.
+func firstField(value string) string {
+	if fields := strings.Fields(value); len(fields) > 0 {
+		return fields[0]
+	}
+	return ""
+}
+
+func toBool(value string) bool {
+	b, _ := strconv.ParseBool(strings.TrimSpace(value))
+	return b
+}
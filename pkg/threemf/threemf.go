@@ -0,0 +1,268 @@
+// Package threemf reads 3MF projects sliced by Bambu Studio. A .gcode.3mf
+// is a zip archive holding the G-code of every sliced plate under Metadata/
+// along with thumbnails and slice_info.config, which describes what the
+// slicer produced: printer model, estimated time, filament usage and the
+// objects on each plate.
+package threemf
+
+import (
+	"archive/zip"
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strings"
+)
+
+const (
+	sliceInfoPath       = "Metadata/slice_info.config"
+	modelSettingsPath   = "Metadata/model_settings.config"
+	projectSettingsPath = "Metadata/project_settings.config"
+)
+
+var (
+	ErrPlateNotFound = errors.New("plate not found")
+
+	// ErrNotSliced is returned for plates without G-code, which cannot be
+	// printed.
+	ErrNotSliced = errors.New("plate has not been sliced")
+)
+
+// Archive is an opened 3MF project.
+type Archive struct {
+	// PrinterModel is the printer profile the project was sliced with, such
+	// as "Bambu Lab X1 Carbon". Empty if the project has no settings.
+	PrinterModel string
+
+	// SlicerVersion is the Bambu Studio version that sliced the project
+	SlicerVersion string
+
+	// MD5 is the upper case hex checksum of the whole archive, as expected by
+	// project_file requests
+	MD5 string
+
+	// Plates are sorted by index. Plates of unsliced projects have no G-code.
+	Plates []Plate
+
+	closer io.Closer
+	files  map[string]*zip.File
+}
+
+// Open opens a 3MF file from disk.
+func Open(name string) (*Archive, error) {
+	file, err := os.Open(name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open 3mf: %w", err)
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to stat 3mf: %w", err)
+	}
+
+	archive, err := NewReader(file, info.Size())
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	archive.closer = file
+	return archive, nil
+}
+
+// NewReader reads a 3MF archive of the given size from r.
+func NewReader(r io.ReaderAt, size int64) (*Archive, error) {
+	checksum := md5.New()
+	if _, err := io.Copy(checksum, io.NewSectionReader(r, 0, size)); err != nil {
+		return nil, fmt.Errorf("failed to read 3mf: %w", err)
+	}
+
+	reader, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read 3mf: %w", err)
+	}
+
+	archive := &Archive{
+		MD5:   strings.ToUpper(hex.EncodeToString(checksum.Sum(nil))),
+		files: make(map[string]*zip.File),
+	}
+	for _, file := range reader.File {
+		archive.files[strings.TrimPrefix(file.Name, "/")] = file
+	}
+
+	if err := archive.load(); err != nil {
+		return nil, err
+	}
+
+	return archive, nil
+}
+
+// Close closes the underlying file if the archive was opened with Open.
+func (archive *Archive) Close() error {
+	if archive.closer == nil {
+		return nil
+	}
+	return archive.closer.Close()
+}
+
+// Plate returns the plate with the given 1-based index.
+func (archive *Archive) Plate(index int) (Plate, error) {
+	for _, plate := range archive.Plates {
+		if plate.Index == index {
+			return plate, nil
+		}
+	}
+	return Plate{}, fmt.Errorf("plate %d: %w", index, ErrPlateNotFound)
+}
+
+// Open opens a file inside the archive, such as a plate's GCodePath.
+func (archive *Archive) Open(name string) (io.ReadCloser, error) {
+	file, ok := archive.files[strings.TrimPrefix(name, "/")]
+	if !ok {
+		return nil, fmt.Errorf("%s: %w", name, os.ErrNotExist)
+	}
+	return file.Open()
+}
+
+// ReadFile returns the contents of a file inside the archive.
+func (archive *Archive) ReadFile(name string) ([]byte, error) {
+	r, err := archive.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	return io.ReadAll(r)
+}
+
+// GCode opens the G-code of a plate.
+func (archive *Archive) GCode(index int) (io.ReadCloser, error) {
+	plate, err := archive.Plate(index)
+	if err != nil {
+		return nil, err
+	}
+	if plate.GCodePath == "" {
+		return nil, fmt.Errorf("plate %d: %w", index, ErrNotSliced)
+	}
+	return archive.Open(plate.GCodePath)
+}
+
+// Thumbnail returns the PNG preview of a plate.
+func (archive *Archive) Thumbnail(index int) ([]byte, error) {
+	plate, err := archive.Plate(index)
+	if err != nil {
+		return nil, err
+	}
+	if plate.ThumbnailPath == "" {
+		return nil, fmt.Errorf("plate %d has no thumbnail: %w", index, os.ErrNotExist)
+	}
+	return archive.ReadFile(plate.ThumbnailPath)
+}
+
+func (archive *Archive) has(name string) bool {
+	_, ok := archive.files[name]
+	return ok
+}
+
+// load merges the plates described by model_settings.config, which lists
+// every plate, with slice_info.config, which only lists sliced ones.
+func (archive *Archive) load() error {
+	plates := make(map[int]*Plate)
+	plate := func(index int) *Plate {
+		if plates[index] == nil {
+			plates[index] = &Plate{Index: index}
+		}
+		return plates[index]
+	}
+
+	if archive.has(modelSettingsPath) {
+		settings, err := archive.readModelSettings()
+		if err != nil {
+			return err
+		}
+		for _, info := range settings {
+			p := plate(info.index)
+			p.Name = info.name
+			p.GCodePath = info.gcodeFile
+			p.ThumbnailPath = info.thumbnailFile
+			p.TopPath = info.topFile
+			p.PickPath = info.pickFile
+		}
+	}
+
+	if archive.has(sliceInfoPath) {
+		info, err := archive.readSliceInfo()
+		if err != nil {
+			return err
+		}
+		archive.SlicerVersion = info.version
+
+		for _, sliced := range info.plates {
+			p := plate(sliced.Index)
+			sliced.Name, sliced.GCodePath = p.Name, p.GCodePath
+			sliced.ThumbnailPath, sliced.TopPath, sliced.PickPath = p.ThumbnailPath, p.TopPath, p.PickPath
+			*p = sliced
+		}
+	}
+
+	if archive.has(projectSettingsPath) {
+		settings, err := archive.readProjectSettings()
+		if err != nil {
+			return err
+		}
+		archive.PrinterModel = settings.PrinterModel
+	}
+
+	// Sliced archives exported with "Export plate sliced file" may only
+	// contain the G-code
+	for name := range archive.files {
+		var index int
+		if _, err := fmt.Sscanf(path.Base(name), "plate_%d.gcode", &index); err == nil && strings.HasSuffix(name, ".gcode") && plates[index] == nil {
+			plate(index).GCodePath = name
+		}
+	}
+
+	// Older projects leave out the file names, the slicer always uses the
+	// same ones
+	for index, p := range plates {
+		if p.GCodePath == "" {
+			p.GCodePath = fmt.Sprintf("Metadata/plate_%d.gcode", index)
+		}
+		if p.ThumbnailPath == "" {
+			p.ThumbnailPath = fmt.Sprintf("Metadata/plate_%d.png", index)
+		}
+		p.GCodePath = archive.existing(p.GCodePath)
+		p.ThumbnailPath = archive.existing(p.ThumbnailPath)
+		p.TopPath = archive.existing(p.TopPath)
+		p.PickPath = archive.existing(p.PickPath)
+
+		if p.GCodePath != "" {
+			if sum, err := archive.ReadFile(p.GCodePath + ".md5"); err == nil {
+				p.GCodeMD5 = strings.ToUpper(strings.TrimSpace(string(sum)))
+			}
+		}
+	}
+
+	for _, p := range plates {
+		archive.Plates = append(archive.Plates, *p)
+	}
+	sort.Slice(archive.Plates, func(i, j int) bool {
+		return archive.Plates[i].Index < archive.Plates[j].Index
+	})
+
+	return nil
+}
+
+// existing returns name if the archive contains it and "" otherwise.
+func (archive *Archive) existing(name string) string {
+	name = strings.TrimPrefix(name, "/")
+	if name == "" || !archive.has(name) {
+		return ""
+	}
+	return name
+}
@@ -0,0 +1,301 @@
+package threemf_test
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/RobertMNewton/bambu-golang-api/pkg/threemf"
+)
+
+// cube.gcode.3mf is a P1S project with two plates: "Cubes" is sliced with
+// three objects, one of them skipped, and filament slots 1 and 3; "Spare"
+// has not been sliced.
+const fixture = "testdata/cube.gcode.3mf"
+
+func open(t *testing.T) *threemf.Archive {
+	t.Helper()
+
+	archive, err := threemf.Open(fixture)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { archive.Close() })
+	return archive
+}
+
+// newArchive builds a 3MF in memory from file names and contents.
+func newArchive(t *testing.T, files map[string]string) (*threemf.Archive, error) {
+	t.Helper()
+
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	for name, content := range files {
+		f, err := w.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.WriteString(f, content); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return threemf.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+}
+
+func TestOpen(t *testing.T) {
+	archive := open(t)
+
+	if archive.PrinterModel != "Bambu Lab P1S" {
+		t.Errorf("PrinterModel = %q", archive.PrinterModel)
+	}
+	if archive.SlicerVersion != "01.09.01.67" {
+		t.Errorf("SlicerVersion = %q", archive.SlicerVersion)
+	}
+
+	data, err := os.ReadFile(fixture)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := md5.Sum(data)
+	if want := strings.ToUpper(hex.EncodeToString(sum[:])); archive.MD5 != want {
+		t.Errorf("MD5 = %s, want %s", archive.MD5, want)
+	}
+
+	var indexes []int
+	for _, plate := range archive.Plates {
+		indexes = append(indexes, plate.Index)
+	}
+	if !reflect.DeepEqual(indexes, []int{1, 2}) {
+		t.Fatalf("plates = %v, want [1 2]", indexes)
+	}
+
+	spare := archive.Plates[1]
+	if spare.Name != "Spare" || spare.GCodePath != "" || spare.ThumbnailPath != "" || spare.Objects != nil {
+		t.Errorf("unsliced plate = %+v", spare)
+	}
+}
+
+func TestSliceInfo(t *testing.T) {
+	plate, err := open(t).Plate(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := threemf.Plate{
+		Index:          1,
+		Name:           "Cubes",
+		GCodePath:      "Metadata/plate_1.gcode",
+		GCodeMD5:       plate.GCodeMD5,
+		ThumbnailPath:  "Metadata/plate_1.png",
+		TopPath:        "Metadata/top_1.png",
+		PickPath:       "Metadata/pick_1.png",
+		PrinterModelID: "C12",
+		NozzleDiameter: 0.4,
+		Prediction:     time.Hour + 2*time.Minute + 3*time.Second,
+		Weight:         12.34,
+		LabelObjects:   true,
+		Objects: []threemf.Object{
+			{ID: 105, Name: "Cube"},
+			{ID: 212, Name: "Cylinder", Skipped: true},
+			{ID: 330, Name: "Cone"},
+		},
+		Filaments: []threemf.Filament{
+			{Slot: 1, Type: "PLA", Color: "#FFFFFF", TrayInfoIdx: "GFA00", UsedMeters: 4.12, UsedGrams: 12.29},
+			{Slot: 3, Type: "PETG", Color: "#FF0000FF", TrayInfoIdx: "GFG00", UsedMeters: 0.02, UsedGrams: 0.05},
+		},
+		Warnings: []threemf.Warning{
+			{Message: "bed_temperature_too_high_than_filament", Level: 1, ErrorCode: "1000C001"},
+		},
+	}
+	if !reflect.DeepEqual(plate, want) {
+		t.Errorf("plate =\n%+v\nwant\n%+v", plate, want)
+	}
+
+	if name := plate.ModelName(); name != "P1S" {
+		t.Errorf("ModelName = %q", name)
+	}
+	if ids := plate.ObjectIDs(); !reflect.DeepEqual(ids, []int{105, 330}) {
+		t.Errorf("ObjectIDs = %v, want [105 330]", ids)
+	}
+	if mapping := plate.AMSMapping(); !reflect.DeepEqual(mapping, []int{0, -1, 2}) {
+		t.Errorf("AMSMapping = %v, want [0 -1 2]", mapping)
+	}
+}
+
+func TestGCode(t *testing.T) {
+	archive := open(t)
+
+	r, err := archive.GCode(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	gcode, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(gcode, []byte("; HEADER_BLOCK_START")) {
+		t.Errorf("gcode = %q", gcode)
+	}
+
+	// The slicer stores the checksum in lower case
+	sum := md5.Sum(gcode)
+	if want := strings.ToUpper(hex.EncodeToString(sum[:])); archive.Plates[0].GCodeMD5 != want {
+		t.Errorf("GCodeMD5 = %q, want %q", archive.Plates[0].GCodeMD5, want)
+	}
+
+	thumbnail, err := archive.Thumbnail(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(thumbnail, []byte("\x89PNG")) {
+		t.Errorf("thumbnail = %q", thumbnail)
+	}
+}
+
+func TestPlateErrors(t *testing.T) {
+	archive := open(t)
+
+	tests := []struct {
+		name    string
+		read    func() error
+		wantErr error
+	}{
+		{
+			name:    "unsliced plate",
+			read:    func() error { _, err := archive.GCode(2); return err },
+			wantErr: threemf.ErrNotSliced,
+		},
+		{
+			name:    "missing plate",
+			read:    func() error { _, err := archive.GCode(3); return err },
+			wantErr: threemf.ErrPlateNotFound,
+		},
+		{
+			name:    "missing thumbnail",
+			read:    func() error { _, err := archive.Thumbnail(2); return err },
+			wantErr: os.ErrNotExist,
+		},
+		{
+			name:    "missing file",
+			read:    func() error { _, err := archive.ReadFile("Metadata/plate_9.gcode"); return err },
+			wantErr: os.ErrNotExist,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err := test.read(); !errors.Is(err, test.wantErr) {
+				t.Errorf("err = %v, want %v", err, test.wantErr)
+			}
+		})
+	}
+}
+
+func TestMalformed(t *testing.T) {
+	tests := []struct {
+		name  string
+		files map[string]string
+		want  string
+	}{
+		{
+			name:  "slice info",
+			files: map[string]string{"Metadata/slice_info.config": "<config><plate>"},
+			want:  "failed to parse Metadata/slice_info.config",
+		},
+		{
+			name:  "model settings",
+			files: map[string]string{"Metadata/model_settings.config": "not xml"},
+			want:  "failed to parse Metadata/model_settings.config",
+		},
+		{
+			name:  "project settings",
+			files: map[string]string{"Metadata/project_settings.config": "{"},
+			want:  "failed to parse Metadata/project_settings.config",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := newArchive(t, test.files)
+			if err == nil || !strings.Contains(err.Error(), test.want) {
+				t.Errorf("err = %v, want %q", err, test.want)
+			}
+		})
+	}
+
+	if _, err := threemf.NewReader(strings.NewReader("not a zip"), 9); err == nil {
+		t.Error("read a file that is not a zip")
+	}
+	if _, err := threemf.Open("testdata/missing.3mf"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Open of a missing file = %v", err)
+	}
+}
+
+func TestMissingEntries(t *testing.T) {
+	tests := []struct {
+		name      string
+		files     map[string]string
+		wantPlate threemf.Plate
+	}{
+		{
+			// Exported with "Export plate sliced file"
+			name:      "only gcode",
+			files:     map[string]string{"Metadata/plate_3.gcode": "G28"},
+			wantPlate: threemf.Plate{Index: 3, GCodePath: "Metadata/plate_3.gcode"},
+		},
+		{
+			name: "default file names",
+			files: map[string]string{
+				"Metadata/slice_info.config": `<config><plate><metadata key="index" value="1"/></plate></config>`,
+				"Metadata/plate_1.gcode":     "G28",
+				"Metadata/plate_1.png":       "png",
+			},
+			wantPlate: threemf.Plate{Index: 1, GCodePath: "Metadata/plate_1.gcode", ThumbnailPath: "Metadata/plate_1.png"},
+		},
+		{
+			name: "listed gcode missing",
+			files: map[string]string{
+				"Metadata/model_settings.config": `<config><plate><metadata key="plater_id" value="1"/><metadata key="gcode_file" value="Metadata/plate_1.gcode"/></plate></config>`,
+			},
+			wantPlate: threemf.Plate{Index: 1},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			archive, err := newArchive(t, test.files)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(archive.Plates) != 1 || !reflect.DeepEqual(archive.Plates[0], test.wantPlate) {
+				t.Errorf("plates = %+v, want %+v", archive.Plates, test.wantPlate)
+			}
+			if archive.PrinterModel != "" || archive.SlicerVersion != "" {
+				t.Errorf("model %q, version %q", archive.PrinterModel, archive.SlicerVersion)
+			}
+		})
+	}
+
+	archive, err := newArchive(t, map[string]string{"3D/3dmodel.model": "<model/>"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(archive.Plates) != 0 {
+		t.Errorf("plates of an empty project = %+v", archive.Plates)
+	}
+}
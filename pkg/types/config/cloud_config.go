@@ -2,23 +2,102 @@ package config
 
 import (
 	"crypto/tls"
+	"crypto/x509"
 	"fmt"
+	"sync"
 )
 
 const (
-	cloudBrokerUrl      = "tls://us.mqtt.bambulab.com:8883"
 	cloudUsernameFormat = "u_%s"
 )
 
+// Region selects the Bambu Lab cloud an account belongs to. Accounts
+// registered in mainland China use separate API and MQTT hosts.
+type Region string
+
+const (
+	RegionGlobal Region = "global"
+	RegionChina  Region = "china"
+)
+
+type regionHosts struct {
+	broker string
+	api    string
+	web    string
+}
+
+var regions = map[Region]regionHosts{
+	RegionGlobal: {
+		broker: "tls://us.mqtt.bambulab.com:8883",
+		api:    "https://api.bambulab.com",
+		web:    "https://bambulab.com",
+	},
+	RegionChina: {
+		broker: "tls://cn.mqtt.bambulab.com:8883",
+		api:    "https://api.bambulab.cn",
+		web:    "https://bambulab.cn",
+	},
+}
+
+// ParseRegion parses "global" or "china". An empty string is RegionGlobal.
+func ParseRegion(s string) (Region, error) {
+	if s == "" {
+		return RegionGlobal, nil
+	}
+	if _, ok := regions[Region(s)]; !ok {
+		return "", fmt.Errorf("unknown region %q, expected %q or %q", s, RegionGlobal, RegionChina)
+	}
+	return Region(s), nil
+}
+
+func (region Region) hosts() regionHosts {
+	if hosts, ok := regions[region]; ok {
+		return hosts
+	}
+	return regions[RegionGlobal]
+}
+
+// BrokerURL returns the MQTT broker of the region.
+func (region Region) BrokerURL() string {
+	return region.hosts().broker
+}
+
+// APIURL returns the base URL of the region's cloud API.
+func (region Region) APIURL() string {
+	return region.hosts().api
+}
+
+// WebURL returns the region's website, which handles two-factor sign in.
+func (region Region) WebURL() string {
+	return region.hosts().web
+}
+
+// TokenSource provides the access token used as the MQTT password of cloud
+// printers, refreshing it as needed.
+type TokenSource interface {
+	AccessToken() (string, error)
+}
+
 type CloudPrinterConfig struct {
 	deviceID string
 
 	userID      string
 	accessToken string
 
-	// needed for FTP
+	// needed for FTP and the camera
 	ipAddress  string
 	accessCode string
+	caCertPath string
+	pinStore   *PinStore
+	insecure   bool
+
+	region    Region
+	brokerURL string
+	rootCAs   *x509.CertPool
+	ports     map[Service]int
+
+	mu          sync.Mutex
+	tokenSource TokenSource
 }
 
 func NewCloudPrinterConfig(device_id, user_id, access_token, ipAddress, accessCode string) *CloudPrinterConfig {
@@ -30,6 +109,8 @@ func NewCloudPrinterConfig(device_id, user_id, access_token, ipAddress, accessCo
 
 		ipAddress:  ipAddress,
 		accessCode: accessCode,
+
+		region: RegionGlobal,
 	}
 }
 
@@ -37,8 +118,17 @@ func (config *CloudPrinterConfig) GetDeviceID() string {
 	return config.deviceID
 }
 
+// GetBrokerUrl returns the broker of the config's region, unless it was
+// overridden with SetBrokerURL.
 func (config *CloudPrinterConfig) GetBrokerUrl() string {
-	return cloudBrokerUrl
+	if config.brokerURL != "" {
+		return config.brokerURL
+	}
+	return config.region.BrokerURL()
+}
+
+func (config *CloudPrinterConfig) GetDeviceIPAddress() string {
+	return config.ipAddress
 }
 
 func (config *CloudPrinterConfig) GetDeviceAccessCode() string {
@@ -49,10 +139,102 @@ func (config *CloudPrinterConfig) GetUsername() string {
 	return fmt.Sprintf(cloudUsernameFormat, config.userID)
 }
 
+// GetPassword returns the access token. With a token source, the token is
+// requested from the source on every call so that reconnects use a fresh
+// token; if the source fails, the last token is returned.
 func (config *CloudPrinterConfig) GetPassword() string {
+	config.mu.Lock()
+	defer config.mu.Unlock()
+
+	if config.tokenSource != nil {
+		if token, err := config.tokenSource.AccessToken(); err == nil {
+			config.accessToken = token
+		}
+	}
 	return config.accessToken
 }
 
+// GetPort returns the port of one of the printer's LAN services, which is
+// the printer's default unless it was overridden with SetPort. The broker
+// port is part of the broker URL.
+func (config *CloudPrinterConfig) GetPort(service Service) int {
+	if port, ok := config.ports[service]; ok {
+		return port
+	}
+	return DefaultPort(service)
+}
+
+// SetPort overrides the port of one of the printer's LAN services, for
+// printers reached through port forwarding or test doubles.
+func (config *CloudPrinterConfig) SetPort(service Service, port int) {
+	if config.ports == nil {
+		config.ports = make(map[Service]int)
+	}
+	config.ports[service] = port
+}
+
+// CreateTLSConfig verifies the cloud broker against the system roots, or
+// those set with SetRootCAs.
 func (config *CloudPrinterConfig) CreateTLSConfig() (*tls.Config, error) {
-	return &tls.Config{}, nil
+	return &tls.Config{RootCAs: config.rootCAs}, nil
+}
+
+// CreateLANTLSConfig verifies the printer the way
+// LocalPrinterConfig.CreateTLSConfig does, against the CA certificates set
+// with SetCACertPath and the pin store set with SetPinStore.
+func (config *CloudPrinterConfig) CreateLANTLSConfig() (*tls.Config, error) {
+	return createPrinterTLSConfig(config.deviceID, config.caCertPath, config.pinStore, config.insecure)
+}
+
+// SetCACertPath sets the CA certificates the printer is verified against on
+// the LAN, see LocalPrinterConfig.CreateTLSConfig.
+func (config *CloudPrinterConfig) SetCACertPath(path string) {
+	config.caCertPath = path
+}
+
+// SetPinStore pins the printer's LAN certificate in store, see
+// LocalPrinterConfig.SetPinStore.
+func (config *CloudPrinterConfig) SetPinStore(store *PinStore) {
+	config.pinStore = store
+}
+
+// SetInsecureSkipVerify makes the config accept any certificate the printer
+// presents on the LAN, see LocalPrinterConfig.SetInsecureSkipVerify.
+func (config *CloudPrinterConfig) SetInsecureSkipVerify(insecure bool) {
+	config.insecure = insecure
+}
+
+// GetRegion returns the cloud region of the printer.
+func (config *CloudPrinterConfig) GetRegion() Region {
+	return config.region
+}
+
+// SetRegion selects the cloud region, RegionGlobal by default.
+func (config *CloudPrinterConfig) SetRegion(region Region) {
+	config.region = region
+}
+
+// SetBrokerURL overrides the region's broker, for test doubles.
+func (config *CloudPrinterConfig) SetBrokerURL(url string) {
+	config.brokerURL = url
+}
+
+// SetRootCAs replaces the system roots used to verify the broker.
+func (config *CloudPrinterConfig) SetRootCAs(pool *x509.CertPool) {
+	config.rootCAs = pool
+}
+
+// SetTokenSource makes the config take its MQTT password from a token
+// source, see cloud.Account.
+func (config *CloudPrinterConfig) SetTokenSource(source TokenSource) {
+	config.mu.Lock()
+	defer config.mu.Unlock()
+
+	config.tokenSource = source
+}
+
+// SetIPAddress sets the LAN address of the printer, which the cloud does not
+// report but FTP needs.
+func (config *CloudPrinterConfig) SetIPAddress(ipAddress string) {
+	config.ipAddress = ipAddress
 }
@@ -2,8 +2,37 @@ package config
 
 import (
 	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
 )
 
+// Service is a network service exposed by a printer.
+type Service string
+
+const (
+	ServiceMQTT Service = "mqtt"
+	ServiceFTP  Service = "ftp"
+
+	// ServiceCamera is the JPEG frame stream of P1 and A1 series printers
+	ServiceCamera Service = "camera"
+
+	// ServiceRTSP is the RTSPS live view of X1 series printers
+	ServiceRTSP Service = "rtsp"
+)
+
+var defaultPorts = map[Service]int{
+	ServiceMQTT:   8883,
+	ServiceFTP:    990,
+	ServiceCamera: 6000,
+	ServiceRTSP:   322,
+}
+
+// DefaultPort returns the port a printer serves a service on.
+func DefaultPort(service Service) int {
+	return defaultPorts[service]
+}
+
 type PrinterConfig interface {
 	GetDeviceID() string
 	GetBrokerUrl() string
@@ -11,5 +40,71 @@ type PrinterConfig interface {
 	GetDeviceAccessCode() string
 	GetUsername() string
 	GetPassword() string
+	GetPort(service Service) int
+
+	// CreateTLSConfig verifies the MQTT broker
 	CreateTLSConfig() (*tls.Config, error)
+
+	// CreateLANTLSConfig verifies the printer itself, for FTP and the camera
+	CreateLANTLSConfig() (*tls.Config, error)
+}
+
+// createPrinterTLSConfig verifies the certificate a printer presents on its
+// own services, see LocalPrinterConfig.CreateTLSConfig.
+func createPrinterTLSConfig(deviceID, caCertPath string, pinStore *PinStore, insecure bool) (*tls.Config, error) {
+	var roots *x509.CertPool
+
+	if caCertPath != "" {
+		caCert, err := os.ReadFile(caCertPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA certificate: %v", err)
+		}
+
+		roots = x509.NewCertPool()
+		if !roots.AppendCertsFromPEM(caCert) {
+			return nil, fmt.Errorf("failed to append CA certificate")
+		}
+	}
+
+	tlsConfig := &tls.Config{
+		ServerName:         deviceID,
+		InsecureSkipVerify: true, // Verified below, the certificate has no SANs
+		VerifyConnection: func(cs tls.ConnectionState) error {
+			if insecure {
+				return nil
+			}
+			if len(cs.PeerCertificates) == 0 {
+				return fmt.Errorf("printer sent no certificate")
+			}
+			cert := cs.PeerCertificates[0]
+
+			if len(cert.DNSNames) == 0 && cert.Subject.CommonName != deviceID {
+				return fmt.Errorf("certificate does not contain SANs, and CN does not match host")
+			}
+
+			if roots != nil {
+				intermediates := x509.NewCertPool()
+				for _, intermediate := range cs.PeerCertificates[1:] {
+					intermediates.AddCert(intermediate)
+				}
+
+				opts := x509.VerifyOptions{
+					Roots:         roots,
+					Intermediates: intermediates,
+					KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+				}
+				if _, err := cert.Verify(opts); err != nil {
+					return err
+				}
+			}
+
+			if pinStore != nil {
+				return pinStore.Verify(deviceID, cert)
+			}
+
+			return nil
+		},
+	}
+
+	return tlsConfig, nil
 }
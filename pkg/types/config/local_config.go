@@ -2,16 +2,12 @@ package config
 
 import (
 	"crypto/tls"
-	"crypto/x509"
 	"fmt"
-	"os"
-
-	"github.com/RobertMNewton/bambu-golang-api/pkg/utils"
 )
 
 const (
 	localUsername     = "bblp"
-	localBrokerFormat = "tls://%s:8883"
+	localBrokerFormat = "tls://%s:%d"
 )
 
 type LocalPrinterConfig struct {
@@ -19,6 +15,9 @@ type LocalPrinterConfig struct {
 	ipAddress  string
 	accessCode string
 	caCertPath string
+	ports      map[Service]int
+	pinStore   *PinStore
+	insecure   bool
 }
 
 func NewLocalPrinterConfig(device_id, ip_address, access_code, ca_cert_path string) LocalPrinterConfig {
@@ -35,7 +34,7 @@ func (config *LocalPrinterConfig) GetDeviceID() string {
 }
 
 func (config *LocalPrinterConfig) GetBrokerUrl() string {
-	return fmt.Sprintf(localBrokerFormat, config.ipAddress)
+	return fmt.Sprintf(localBrokerFormat, config.ipAddress, config.GetPort(ServiceMQTT))
 }
 
 func (config *LocalPrinterConfig) GetDeviceIPAddress() string {
@@ -54,49 +53,63 @@ func (config *LocalPrinterConfig) GetPassword() string {
 	return config.accessCode
 }
 
-func (config *LocalPrinterConfig) CreateTLSConfig() (*tls.Config, error) {
-	caCertPool := x509.NewCertPool()
+// GetPort returns the port of a service, which is the printer's default unless
+// it was overridden with SetPort.
+func (config *LocalPrinterConfig) GetPort(service Service) int {
+	if port, ok := config.ports[service]; ok {
+		return port
+	}
+	return DefaultPort(service)
+}
 
-	if config.caCertPath != "" {
-		caCert, err := os.ReadFile(config.caCertPath)
-		if err != nil {
-			return nil, fmt.Errorf("failed to read CA certificate: %v", err)
-		}
+// SetPort overrides the port of a service, for printers reached through port
+// forwarding or test doubles.
+func (config *LocalPrinterConfig) SetPort(service Service, port int) {
+	if config.ports == nil {
+		config.ports = make(map[Service]int)
+	}
+	config.ports[service] = port
+}
 
-		if !caCertPool.AppendCertsFromPEM(caCert) {
-			return nil, fmt.Errorf("failed to append CA certificate")
+// Clone returns a copy whose ports can be changed without affecting config.
+// The pin store is shared.
+func (config *LocalPrinterConfig) Clone() LocalPrinterConfig {
+	clone := *config
+	if config.ports != nil {
+		clone.ports = make(map[Service]int, len(config.ports))
+		for service, port := range config.ports {
+			clone.ports[service] = port
 		}
-	} else {
-		caCert, err := utils.GetPrinterCert(config.GetDeviceIPAddress(), "8883")
-		if err != nil {
-			return nil, fmt.Errorf("failed to get CA certificate: %v", err)
-		}
-
-		caCertPool.AddCert(caCert)
 	}
+	return clone
+}
 
-	tlsConfig := &tls.Config{
-		RootCAs:            caCertPool,
-		ServerName:         config.GetDeviceID(),
-		InsecureSkipVerify: true, // Disable default verification
-		VerifyConnection: func(cs tls.ConnectionState) error {
-			cert := cs.PeerCertificates[0]
-
-			if len(cert.DNSNames) == 0 {
-				if cert.Subject.CommonName == config.GetDeviceID() {
-					return nil // Allow CN match
-				}
-				return fmt.Errorf("certificate does not contain SANs, and CN does not match host")
-			}
-
-			// Check if the cert chains properly
-			opts := x509.VerifyOptions{
-				Roots: caCertPool,
-			}
-			_, err := cert.Verify(opts)
-			return err
-		},
-	}
+// SetPinStore pins the printer's certificate in store, which is how printers
+// without a CA certificate are verified: the first certificate seen is pinned and any other certificate is rejected with a
+// CertificateMismatchError. Pinning is off until a store is set, and
+// SetPinStore(nil) turns it off again.
+func (config *LocalPrinterConfig) SetPinStore(store *PinStore) {
+	config.pinStore = store
+}
+
+// SetInsecureSkipVerify makes the config accept any certificate the printer
+// presents, without a CA certificate or pin. Anyone on the network can then
+// impersonate the printer and read its access code.
+func (config *LocalPrinterConfig) SetInsecureSkipVerify(insecure bool) {
+	config.insecure = insecure
+}
+
+// CreateTLSConfig verifies the printer's certificate against the CA
+// certificates at the configured path, which may be a bundle such as Bambu
+// Lab's published CAs, and against the pin store if one is set. Without
+// either, only the certificate's common name is checked against the serial
+// number, which does not protect against impersonation; see SetPinStore.
+// Any certificate is accepted after SetInsecureSkipVerify.
+func (config *LocalPrinterConfig) CreateTLSConfig() (*tls.Config, error) {
+	return createPrinterTLSConfig(config.deviceID, config.caCertPath, config.pinStore, config.insecure)
+}
 
-	return tlsConfig, nil
+// CreateLANTLSConfig is CreateTLSConfig, the printer serves MQTT itself.
+func (config *LocalPrinterConfig) CreateLANTLSConfig() (*tls.Config, error) {
+	return config.CreateTLSConfig()
 }
@@ -0,0 +1,217 @@
+package config
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// ErrCertificateMismatch is wrapped by CertificateMismatchError.
+var ErrCertificateMismatch = errors.New("printer certificate does not match pinned certificate")
+
+// CertificateMismatchError is returned when a printer presents a certificate
+// other than the one pinned on first use. Either the printer was reset or
+// replaced, in which case the pin must be replaced with PinStore.Repin, or
+// something is impersonating it.
+type CertificateMismatchError struct {
+	Serial    string
+	Pinned    string
+	Presented string
+}
+
+func (err *CertificateMismatchError) Error() string {
+	return fmt.Sprintf("certificate of printer %s changed: pinned SHA256 %s, presented %s; re-pin it if the printer was reset",
+		err.Serial, err.Pinned, err.Presented)
+}
+
+func (err *CertificateMismatchError) Unwrap() error {
+	return ErrCertificateMismatch
+}
+
+// Fingerprint returns the hex encoded SHA-256 of a certificate.
+func Fingerprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	return hex.EncodeToString(sum[:])
+}
+
+// PinStore is a trust on first use store of printer certificate
+// fingerprints, kept in a known-hosts style file with one
+// "<serial> <sha256>" line per printer.
+type PinStore struct {
+	path string
+
+	mu   sync.Mutex
+	pins map[string]string
+}
+
+// DefaultPinStorePath returns known_printers in the bambu user config
+// directory.
+func DefaultPinStorePath() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to find config directory: %w", err)
+	}
+	return filepath.Join(dir, "bambu", "known_printers"), nil
+}
+
+// LoadPinStore reads a pin file. A missing file is an empty store, the file
+// is created when the first certificate is pinned.
+func LoadPinStore(path string) (*PinStore, error) {
+	store := &PinStore{
+		path: path,
+		pins: make(map[string]string),
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return store, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read pin store: %w", err)
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		fields := strings.Fields(text)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("%s:%d: expected \"<serial> <sha256>\"", path, line)
+		}
+		if _, err := hex.DecodeString(fields[1]); err != nil || len(fields[1]) != sha256.Size*2 {
+			return nil, fmt.Errorf("%s:%d: invalid SHA-256 fingerprint %q", path, line, fields[1])
+		}
+
+		store.pins[fields[0]] = strings.ToLower(fields[1])
+	}
+
+	return store, nil
+}
+
+// Pinned returns the pinned fingerprint of a printer.
+func (store *PinStore) Pinned(serial string) (string, bool) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	fingerprint, ok := store.pins[serial]
+	return fingerprint, ok
+}
+
+// Verify checks a printer's certificate against its pin, pinning it if the
+// printer has not been seen before.
+func (store *PinStore) Verify(serial string, cert *x509.Certificate) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	presented := Fingerprint(cert)
+
+	pinned, ok := store.pins[serial]
+	if !ok {
+		// The pin only counts once it is on disk, otherwise a failed save
+		// would leave the printer trusted for the rest of the process
+		store.pins[serial] = presented
+		if err := store.save(); err != nil {
+			delete(store.pins, serial)
+			return err
+		}
+		return nil
+	}
+
+	if pinned != presented {
+		return &CertificateMismatchError{
+			Serial:    serial,
+			Pinned:    pinned,
+			Presented: presented,
+		}
+	}
+
+	return nil
+}
+
+// Repin replaces the pin of a printer, e.g. after a factory reset.
+func (store *PinStore) Repin(serial string, cert *x509.Certificate) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	previous, ok := store.pins[serial]
+	store.pins[serial] = Fingerprint(cert)
+	if err := store.save(); err != nil {
+		if ok {
+			store.pins[serial] = previous
+		} else {
+			delete(store.pins, serial)
+		}
+		return err
+	}
+	return nil
+}
+
+// Unpin forgets a printer, so that its next certificate is trusted on first
+// use again.
+func (store *PinStore) Unpin(serial string) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	previous, ok := store.pins[serial]
+	if !ok {
+		return nil
+	}
+
+	delete(store.pins, serial)
+	if err := store.save(); err != nil {
+		store.pins[serial] = previous
+		return err
+	}
+	return nil
+}
+
+// save writes the store through a temporary file so that a crash never
+// leaves it truncated.
+func (store *PinStore) save() error {
+	serials := make([]string, 0, len(store.pins))
+	for serial := range store.pins {
+		serials = append(serials, serial)
+	}
+	sort.Strings(serials)
+
+	var buf bytes.Buffer
+	buf.WriteString("# Printer certificates pinned on first use: <serial> <sha256>\n")
+	for _, serial := range serials {
+		fmt.Fprintf(&buf, "%s %s\n", serial, store.pins[serial])
+	}
+
+	if err := os.MkdirAll(filepath.Dir(store.path), 0700); err != nil {
+		return fmt.Errorf("failed to create pin store directory: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(store.path), filepath.Base(store.path)+".tmp*")
+	if err != nil {
+		return fmt.Errorf("failed to write pin store: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(buf.Bytes()); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write pin store: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write pin store: %w", err)
+	}
+
+	if err := os.Rename(tmp.Name(), store.path); err != nil {
+		return fmt.Errorf("failed to write pin store: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,199 @@
+package config
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testCert(t *testing.T, serial string) *x509.Certificate {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: serial},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+func TestPinStoreTrustOnFirstUse(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "known_printers")
+	first, second := testCert(t, "SERIAL"), testCert(t, "SERIAL")
+
+	store, err := LoadPinStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Verify("SERIAL", first); err != nil {
+		t.Fatalf("first use: %v", err)
+	}
+
+	reloaded, err := LoadPinStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := reloaded.Verify("SERIAL", first); err != nil {
+		t.Errorf("pinned certificate: %v", err)
+	}
+
+	err = reloaded.Verify("SERIAL", second)
+	var mismatch *CertificateMismatchError
+	if !errors.As(err, &mismatch) || !errors.Is(err, ErrCertificateMismatch) {
+		t.Fatalf("other certificate: got %v, want CertificateMismatchError", err)
+	}
+	if mismatch.Pinned != Fingerprint(first) || mismatch.Presented != Fingerprint(second) {
+		t.Errorf("mismatch = %+v", mismatch)
+	}
+
+	if err := reloaded.Repin("SERIAL", second); err != nil {
+		t.Fatal(err)
+	}
+	if err := reloaded.Verify("SERIAL", second); err != nil {
+		t.Errorf("repinned certificate: %v", err)
+	}
+}
+
+func TestPinStoreFailedSave(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "bambu")
+	store, err := LoadPinStore(filepath.Join(dir, "known_printers"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A file where the store's directory should be makes every save fail
+	if err := os.WriteFile(dir, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	cert := testCert(t, "SERIAL")
+	if err := store.Verify("SERIAL", cert); err == nil {
+		t.Fatal("Verify succeeded without saving the pin")
+	}
+	if _, ok := store.Pinned("SERIAL"); ok {
+		t.Error("pin kept after a failed save")
+	}
+	if err := store.Repin("SERIAL", cert); err == nil {
+		t.Fatal("Repin succeeded without saving the pin")
+	}
+	if _, ok := store.Pinned("SERIAL"); ok {
+		t.Error("pin kept after a failed repin")
+	}
+}
+
+func TestLoadPinStoreRejectsMalformedLines(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+	}{
+		{name: "missing fingerprint", data: "SERIAL\n"},
+		{name: "short fingerprint", data: "SERIAL abcd\n"},
+		{name: "not hex", data: "SERIAL " + strings.Repeat("zz", 32) + "\n"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "known_printers")
+			if err := os.WriteFile(path, []byte(test.data), 0600); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := LoadPinStore(path); err == nil {
+				t.Error("LoadPinStore accepted a malformed line")
+			}
+		})
+	}
+}
+
+func TestCreateTLSConfigPinning(t *testing.T) {
+	// Nothing may be written to the user's config directory by default
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("XDG_CONFIG_HOME", filepath.Join(home, ".config"))
+	t.Setenv("AppData", filepath.Join(home, "AppData"))
+
+	cert := testCert(t, "SERIAL")
+	store, err := LoadPinStore(filepath.Join(t.TempDir(), "known_printers"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Verify("SERIAL", testCert(t, "SERIAL")); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		setup   func(config *LocalPrinterConfig)
+		cert    *x509.Certificate
+		wantErr bool
+	}{
+		{name: "off by default", cert: cert},
+		{name: "off by default, other serial", cert: testCert(t, "OTHER"), wantErr: true},
+		{name: "pinned", setup: func(config *LocalPrinterConfig) { config.SetPinStore(store) }, cert: cert, wantErr: true},
+		{
+			name: "turned off",
+			setup: func(config *LocalPrinterConfig) {
+				config.SetPinStore(store)
+				config.SetPinStore(nil)
+			},
+			cert: cert,
+		},
+		{
+			name: "insecure",
+			setup: func(config *LocalPrinterConfig) {
+				config.SetPinStore(store)
+				config.SetInsecureSkipVerify(true)
+			},
+			cert: testCert(t, "OTHER"),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config := NewLocalPrinterConfig("SERIAL", "127.0.0.1", "12345678", "")
+			if test.setup != nil {
+				test.setup(&config)
+			}
+
+			tlsConfig, err := config.CreateTLSConfig()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = tlsConfig.VerifyConnection(tls.ConnectionState{PeerCertificates: []*x509.Certificate{test.cert}})
+			if (err != nil) != test.wantErr {
+				t.Errorf("VerifyConnection = %v, want error %t", err, test.wantErr)
+			}
+		})
+	}
+
+	entries, err := os.ReadDir(home)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("wrote %v to the home directory", entries)
+	}
+}
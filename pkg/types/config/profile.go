@@ -0,0 +1,601 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	ProfileLocal = "local"
+	ProfileCloud = "cloud"
+
+	DefaultEnvPrefix = "BAMBU"
+)
+
+type Format string
+
+const (
+	FormatJSON Format = "json"
+	FormatYAML Format = "yaml"
+	FormatTOML Format = "toml"
+)
+
+// FormatFromPath returns the format of a profile file from its extension.
+func FormatFromPath(path string) (Format, error) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		return FormatJSON, nil
+	case ".yaml", ".yml":
+		return FormatYAML, nil
+	case ".toml":
+		return FormatTOML, nil
+	default:
+		return "", fmt.Errorf("unknown profile format %q, use .json, .yaml or .toml", filepath.Ext(path))
+	}
+}
+
+// Profile describes how to reach a printer. Local profiles connect to the
+// printer's own broker with the access code, cloud profiles connect through
+// Bambu Lab's broker and only use the host, access code, CA certificate and
+// FTP port for FTP.
+type Profile struct {
+	Type       string `json:"type,omitempty" yaml:"type,omitempty" toml:"type,omitempty"`
+	Serial     string `json:"serial" yaml:"serial" toml:"serial"`
+	Host       string `json:"host,omitempty" yaml:"host,omitempty" toml:"host,omitempty"`
+	AccessCode string `json:"access_code,omitempty" yaml:"access_code,omitempty" toml:"access_code,omitempty"`
+	CACert     string `json:"ca_cert,omitempty" yaml:"ca_cert,omitempty" toml:"ca_cert,omitempty"`
+	MQTTPort   int    `json:"mqtt_port,omitempty" yaml:"mqtt_port,omitempty" toml:"mqtt_port,omitempty"`
+	FTPPort    int    `json:"ftp_port,omitempty" yaml:"ftp_port,omitempty" toml:"ftp_port,omitempty"`
+
+	UserID      string `json:"user_id,omitempty" yaml:"user_id,omitempty" toml:"user_id,omitempty"`
+	AccessToken string `json:"access_token,omitempty" yaml:"access_token,omitempty" toml:"access_token,omitempty"`
+	Region      string `json:"region,omitempty" yaml:"region,omitempty" toml:"region,omitempty"`
+}
+
+// Config returns the printer config described by the profile.
+func (profile Profile) Config() PrinterConfig {
+	if profile.Type == ProfileCloud {
+		config := NewCloudPrinterConfig(profile.Serial, profile.UserID, profile.AccessToken, profile.Host, profile.AccessCode)
+		if region, err := ParseRegion(profile.Region); err == nil {
+			config.SetRegion(region)
+		}
+		if profile.FTPPort != 0 {
+			config.SetPort(ServiceFTP, profile.FTPPort)
+		}
+		config.SetCACertPath(profile.CACert)
+		return config
+	}
+
+	config := NewLocalPrinterConfig(profile.Serial, profile.Host, profile.AccessCode, profile.CACert)
+	if profile.MQTTPort != 0 {
+		config.SetPort(ServiceMQTT, profile.MQTTPort)
+	}
+	if profile.FTPPort != 0 {
+		config.SetPort(ServiceFTP, profile.FTPPort)
+	}
+	return &config
+}
+
+// profileFields lists the fields that can be interpolated and overridden
+// from the environment, by their key in profile files.
+var profileFields = []struct {
+	key    string
+	text   func(*Profile) *string
+	number func(*Profile) *int
+}{
+	{key: "type", text: func(p *Profile) *string { return &p.Type }},
+	{key: "serial", text: func(p *Profile) *string { return &p.Serial }},
+	{key: "host", text: func(p *Profile) *string { return &p.Host }},
+	{key: "access_code", text: func(p *Profile) *string { return &p.AccessCode }},
+	{key: "ca_cert", text: func(p *Profile) *string { return &p.CACert }},
+	{key: "mqtt_port", number: func(p *Profile) *int { return &p.MQTTPort }},
+	{key: "ftp_port", number: func(p *Profile) *int { return &p.FTPPort }},
+	{key: "user_id", text: func(p *Profile) *string { return &p.UserID }},
+	{key: "access_token", text: func(p *Profile) *string { return &p.AccessToken }},
+	{key: "region", text: func(p *Profile) *string { return &p.Region }},
+}
+
+// Profiles is a set of named printer profiles loaded from a file.
+type Profiles struct {
+	Default  string             `json:"default,omitempty" yaml:"default,omitempty" toml:"default,omitempty"`
+	Printers map[string]Profile `json:"printers" yaml:"printers" toml:"printers"`
+}
+
+type LoadOptions struct {
+	// EnvPrefix is the prefix of environment overrides, which are named
+	// <prefix>_<PRINTER>_<FIELD>, e.g. BAMBU_WORKSHOP_ACCESS_CODE.
+	// Defaults to DefaultEnvPrefix.
+	EnvPrefix string
+
+	// LookupEnv defaults to os.LookupEnv
+	LookupEnv func(key string) (string, bool)
+
+	// BaseDir resolves relative ca_cert paths, LoadProfiles sets it to the
+	// directory of the file.
+	BaseDir string
+}
+
+// FieldError is a problem with a single field of a profile file. Path is
+// the field's location, e.g. printers.workshop.access_code.
+type FieldError struct {
+	Path    string
+	Message string
+}
+
+func (err *FieldError) Error() string {
+	return fmt.Sprintf("%s: %s", err.Path, err.Message)
+}
+
+// ValidationError lists every invalid field of a profile file.
+type ValidationError struct {
+	File   string
+	Fields []*FieldError
+}
+
+func (err *ValidationError) Error() string {
+	messages := make([]string, len(err.Fields))
+	for i, field := range err.Fields {
+		messages[i] = field.Error()
+	}
+
+	prefix := "invalid profiles"
+	if err.File != "" {
+		prefix = "invalid profiles in " + err.File
+	}
+	return prefix + ": " + strings.Join(messages, "; ")
+}
+
+func (err *ValidationError) add(path, format string, args ...interface{}) {
+	err.Fields = append(err.Fields, &FieldError{Path: path, Message: fmt.Sprintf(format, args...)})
+}
+
+// DefaultProfilePath returns $BAMBU_CONFIG, or the first of config.yaml,
+// config.yml, config.toml and config.json in the bambu user config
+// directory, defaulting to config.json.
+func DefaultProfilePath() (string, error) {
+	if path := os.Getenv(DefaultEnvPrefix + "_CONFIG"); path != "" {
+		return path, nil
+	}
+
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to find config directory: %w", err)
+	}
+	dir = filepath.Join(dir, "bambu")
+
+	for _, name := range []string{"config.yaml", "config.yml", "config.toml", "config.json"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err == nil {
+			return filepath.Join(dir, name), nil
+		}
+	}
+
+	return filepath.Join(dir, "config.json"), nil
+}
+
+func LoadProfiles(path string) (*Profiles, error) {
+	return LoadProfilesWithOptions(path, LoadOptions{})
+}
+
+// LoadProfilesWithOptions reads a profile file, interpolates ${ENV}
+// references, applies environment overrides and validates the result.
+func LoadProfilesWithOptions(path string, options LoadOptions) (*Profiles, error) {
+	format, err := FormatFromPath(path)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read profiles: %w", err)
+	}
+
+	if options.BaseDir == "" {
+		options.BaseDir = filepath.Dir(path)
+	}
+
+	profiles, err := ParseProfiles(data, format, options)
+
+	var validationErr *ValidationError
+	if errors.As(err, &validationErr) {
+		validationErr.File = path
+	} else if err != nil {
+		err = fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+
+	return profiles, err
+}
+
+// ParseProfiles parses profile data in the given format. Every value is
+// read as text first, so that ports can be interpolated like other fields
+// and all formats are validated by the same rules.
+func ParseProfiles(data []byte, format Format, options LoadOptions) (*Profiles, error) {
+	if options.EnvPrefix == "" {
+		options.EnvPrefix = DefaultEnvPrefix
+	}
+	if options.LookupEnv == nil {
+		options.LookupEnv = os.LookupEnv
+	}
+
+	document, err := decodeDocument(data, format)
+	if err != nil {
+		return nil, err
+	}
+
+	profiles := &Profiles{Printers: make(map[string]Profile)}
+	validation := &ValidationError{}
+
+	var printers map[string]interface{}
+	for _, key := range sortedKeys(document) {
+		switch value := document[key]; key {
+		case "default":
+			text, ok := value.(string)
+			if !ok && value != nil {
+				validation.add(key, "must be a printer name")
+			}
+			profiles.Default = text
+		case "printers":
+			table, ok := value.(map[string]interface{})
+			if !ok && value != nil {
+				validation.add(key, "must be a table of printers")
+			}
+			printers = table
+		default:
+			validation.add(key, "unknown field")
+		}
+	}
+
+	if len(printers) == 0 {
+		validation.add("printers", "no printers defined")
+	}
+
+	for _, name := range sortedKeys(printers) {
+		path := "printers." + name
+
+		fields, ok := printers[name].(map[string]interface{})
+		if !ok {
+			validation.add(path, "must be a table of fields")
+			continue
+		}
+
+		profile := parseProfile(fields, name, path, options, validation)
+
+		if profile.Type == "" {
+			profile.Type = ProfileLocal
+		}
+		if profile.CACert != "" && options.BaseDir != "" && !filepath.IsAbs(profile.CACert) {
+			profile.CACert = filepath.Join(options.BaseDir, profile.CACert)
+		}
+
+		validateProfile(profile, path, validation)
+		profiles.Printers[name] = profile
+	}
+
+	if profiles.Default != "" {
+		if _, ok := profiles.Printers[profiles.Default]; !ok {
+			validation.add("default", "no printer named %q", profiles.Default)
+		}
+	}
+
+	if len(validation.Fields) > 0 {
+		return nil, validation
+	}
+
+	return profiles, nil
+}
+
+// decodeDocument decodes a profile file into tables whose values are text.
+// YAML is read from its nodes, so that unquoted access codes such as
+// 01234567 keep their leading zeros.
+func decodeDocument(data []byte, format Format) (map[string]interface{}, error) {
+	var document interface{}
+
+	switch format {
+	case FormatJSON:
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.UseNumber()
+		if err := decoder.Decode(&document); err != nil {
+			return nil, err
+		}
+	case FormatYAML:
+		var node yaml.Node
+		if err := yaml.Unmarshal(data, &node); err != nil {
+			return nil, err
+		}
+		document = yamlValue(&node)
+	case FormatTOML:
+		var table map[string]interface{}
+		if _, err := toml.Decode(string(data), &table); err != nil {
+			return nil, err
+		}
+		document = table
+	default:
+		return nil, fmt.Errorf("unknown profile format %q", format)
+	}
+
+	switch document := textValue(document).(type) {
+	case nil:
+		return map[string]interface{}{}, nil
+	case map[string]interface{}:
+		return document, nil
+	default:
+		return nil, fmt.Errorf("profiles must be a table")
+	}
+}
+
+func yamlValue(node *yaml.Node) interface{} {
+	switch node.Kind {
+	case yaml.DocumentNode:
+		if len(node.Content) == 0 {
+			return nil
+		}
+		return yamlValue(node.Content[0])
+	case yaml.MappingNode:
+		table := make(map[string]interface{})
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			table[node.Content[i].Value] = yamlValue(node.Content[i+1])
+		}
+		return table
+	case yaml.SequenceNode:
+		list := make([]interface{}, len(node.Content))
+		for i, item := range node.Content {
+			list[i] = yamlValue(item)
+		}
+		return list
+	case yaml.AliasNode:
+		return yamlValue(node.Alias)
+	case yaml.ScalarNode:
+		if node.Tag == "!!null" {
+			return nil
+		}
+		return node.Value
+	default:
+		return nil
+	}
+}
+
+// textValue turns the numbers and booleans of decoded JSON and TOML into
+// text.
+func textValue(value interface{}) interface{} {
+	switch value := value.(type) {
+	case nil, string:
+		return value
+	case map[string]interface{}:
+		for key, item := range value {
+			value[key] = textValue(item)
+		}
+		return value
+	case []interface{}:
+		for i, item := range value {
+			value[i] = textValue(item)
+		}
+		return value
+	case []map[string]interface{}:
+		list := make([]interface{}, len(value))
+		for i, item := range value {
+			list[i] = textValue(item)
+		}
+		return list
+	default:
+		return fmt.Sprint(value)
+	}
+}
+
+func sortedKeys(table map[string]interface{}) []string {
+	keys := make([]string, 0, len(table))
+	for key := range table {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Names returns the printer names in alphabetical order.
+func (profiles *Profiles) Names() []string {
+	names := make([]string, 0, len(profiles.Printers))
+	for name := range profiles.Printers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Get returns a profile by name. An empty name selects the default printer,
+// or the only printer if there is just one.
+func (profiles *Profiles) Get(name string) (Profile, error) {
+	if name == "" {
+		name = profiles.Default
+	}
+	if name == "" && len(profiles.Printers) == 1 {
+		name = profiles.Names()[0]
+	}
+	if name == "" {
+		return Profile{}, fmt.Errorf("no printer selected, choose one of %s", strings.Join(profiles.Names(), ", "))
+	}
+
+	profile, ok := profiles.Printers[name]
+	if !ok {
+		return Profile{}, fmt.Errorf("no printer named %q", name)
+	}
+	return profile, nil
+}
+
+// Config returns the printer config of a profile, see Get.
+func (profiles *Profiles) Config(name string) (PrinterConfig, error) {
+	profile, err := profiles.Get(name)
+	if err != nil {
+		return nil, err
+	}
+	return profile.Config(), nil
+}
+
+var interpolation = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)(:-([^}]*))?\}`)
+
+// parseProfile reads the fields of a printer, applies environment overrides
+// and interpolates ${VAR} and ${VAR:-default} in every field, ports included.
+func parseProfile(fields map[string]interface{}, name, path string, options LoadOptions, validation *ValidationError) Profile {
+	values := make(map[string]string)
+	for _, key := range sortedKeys(fields) {
+		if !isProfileField(key) {
+			validation.add(path+"."+key, "unknown field")
+			continue
+		}
+
+		switch value := fields[key].(type) {
+		case nil:
+		case string:
+			values[key] = value
+		default:
+			validation.add(path+"."+key, "must be a single value")
+		}
+	}
+
+	overrides := overrideProfile(values, name, options)
+
+	var profile Profile
+	for _, field := range profileFields {
+		value, ok := values[field.key]
+		if !ok {
+			continue
+		}
+
+		fieldPath := path + "." + field.key
+		if _, overridden := overrides[field.key]; !overridden {
+			value = interpolate(value, fieldPath, options, validation)
+		}
+
+		if field.text != nil {
+			*field.text(&profile) = value
+			continue
+		}
+
+		if value == "" {
+			continue
+		}
+		number, err := strconv.Atoi(strings.TrimSpace(value))
+		if err != nil {
+			if key, ok := overrides[field.key]; ok {
+				validation.add(fieldPath, "%s is not a number: %q", key, value)
+			} else {
+				validation.add(fieldPath, "must be a number, got %q", value)
+			}
+			continue
+		}
+		*field.number(&profile) = number
+	}
+
+	return profile
+}
+
+func isProfileField(key string) bool {
+	for _, field := range profileFields {
+		if field.key == key {
+			return true
+		}
+	}
+	return false
+}
+
+// interpolate replaces ${VAR} and ${VAR:-default} in a value. As in the
+// shell, the default is also used when VAR is set but empty.
+func interpolate(value, path string, options LoadOptions, validation *ValidationError) string {
+	return interpolation.ReplaceAllStringFunc(value, func(reference string) string {
+		match := interpolation.FindStringSubmatch(reference)
+		if env, ok := options.LookupEnv(match[1]); ok && (env != "" || match[2] == "") {
+			return env
+		}
+		if match[2] != "" {
+			return match[3]
+		}
+		validation.add(path, "environment variable %s is not set", match[1])
+		return ""
+	})
+}
+
+// overrideProfile applies <prefix>_<PRINTER>_<FIELD> environment variables
+// and returns the names of the variables that were applied, by field key.
+// Overrides are used as they are, without interpolation.
+func overrideProfile(values map[string]string, name string, options LoadOptions) map[string]string {
+	prefix := options.EnvPrefix + "_" + envName(name) + "_"
+	overrides := make(map[string]string)
+
+	for _, field := range profileFields {
+		key := prefix + envName(field.key)
+		if env, ok := options.LookupEnv(key); ok {
+			values[field.key] = env
+			overrides[field.key] = key
+		}
+	}
+
+	return overrides
+}
+
+func envName(name string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		case r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		default:
+			return '_'
+		}
+	}, name)
+}
+
+var serialPattern = regexp.MustCompile(`^[0-9A-Z]+$`)
+
+func validateProfile(profile Profile, path string, validation *ValidationError) {
+	require := func(key, value string) {
+		if value == "" {
+			validation.add(path+"."+key, "is required")
+		}
+	}
+
+	switch profile.Type {
+	case ProfileLocal:
+		require("host", profile.Host)
+		require("access_code", profile.AccessCode)
+	case ProfileCloud:
+		require("user_id", profile.UserID)
+		require("access_token", profile.AccessToken)
+		if profile.MQTTPort != 0 {
+			validation.add(path+".mqtt_port", "is not used by cloud profiles, which connect to the cloud broker")
+		}
+		if _, err := ParseRegion(profile.Region); err != nil {
+			validation.add(path+".region", "must be %q or %q, got %q", RegionGlobal, RegionChina, profile.Region)
+		}
+	default:
+		validation.add(path+".type", "must be %q or %q, got %q", ProfileLocal, ProfileCloud, profile.Type)
+	}
+
+	require("serial", profile.Serial)
+	if profile.Serial != "" && !serialPattern.MatchString(profile.Serial) {
+		validation.add(path+".serial", "must be upper case letters and digits, got %q", profile.Serial)
+	}
+
+	for _, port := range []struct {
+		key   string
+		value int
+	}{{"mqtt_port", profile.MQTTPort}, {"ftp_port", profile.FTPPort}} {
+		if port.value < 0 || port.value > 65535 {
+			validation.add(path+"."+port.key, "must be between 1 and 65535, got %d", port.value)
+		}
+	}
+
+	if profile.CACert != "" {
+		if _, err := os.Stat(profile.CACert); err != nil {
+			validation.add(path+".ca_cert", "%v", err)
+		}
+	}
+}
@@ -0,0 +1,400 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func lookupEnv(env map[string]string) func(string) (string, bool) {
+	return func(key string) (string, bool) {
+		value, ok := env[key]
+		return value, ok
+	}
+}
+
+func parse(t *testing.T, data string, format Format, env map[string]string) (*Profiles, error) {
+	t.Helper()
+	return ParseProfiles([]byte(data), format, LoadOptions{LookupEnv: lookupEnv(env)})
+}
+
+func TestParseProfilesFormats(t *testing.T) {
+	want := Profile{
+		Type:       ProfileLocal,
+		Serial:     "01S00A000000000",
+		Host:       "192.168.1.20",
+		AccessCode: "01234567",
+		MQTTPort:   8883,
+		FTPPort:    990,
+	}
+
+	tests := []struct {
+		format Format
+		data   string
+	}{
+		{
+			format: FormatYAML,
+			data: `
+default: workshop
+printers:
+  workshop:
+    serial: 01S00A000000000
+    host: 192.168.1.20
+    access_code: 01234567
+    mqtt_port: 8883
+    ftp_port: "990"
+`,
+		},
+		{
+			format: FormatTOML,
+			data: `
+default = "workshop"
+
+[printers.workshop]
+serial = "01S00A000000000"
+host = "192.168.1.20"
+access_code = "01234567"
+mqtt_port = 8883
+ftp_port = "990"
+`,
+		},
+		{
+			format: FormatJSON,
+			data: `{
+	"default": "workshop",
+	"printers": {
+		"workshop": {
+			"serial": "01S00A000000000",
+			"host": "192.168.1.20",
+			"access_code": "01234567",
+			"mqtt_port": 8883,
+			"ftp_port": "990"
+		}
+	}
+}`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(string(test.format), func(t *testing.T) {
+			profiles, err := parse(t, test.data, test.format, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if profiles.Default != "workshop" {
+				t.Errorf("Default = %q", profiles.Default)
+			}
+			if got := profiles.Printers["workshop"]; got != want {
+				t.Errorf("profile =\n%+v\nwant\n%+v", got, want)
+			}
+		})
+	}
+}
+
+func TestParseProfilesInterpolation(t *testing.T) {
+	env := map[string]string{
+		"ACCESS_CODE": "12345678",
+		"HOST_SUFFIX": "20",
+		"MQTT_PORT":   "18883",
+		"EMPTY":       "",
+	}
+
+	tests := []struct {
+		name  string
+		field string
+		value string
+		want  Profile
+	}{
+		{name: "variable", field: "access_code", value: "${ACCESS_CODE}", want: Profile{AccessCode: "12345678"}},
+		{name: "inside text", field: "host", value: "192.168.1.${HOST_SUFFIX}", want: Profile{Host: "192.168.1.20"}},
+		{name: "default unused", field: "access_code", value: "${ACCESS_CODE:-00000000}", want: Profile{AccessCode: "12345678"}},
+		{name: "default", field: "access_code", value: "${MISSING:-00000000}", want: Profile{AccessCode: "00000000"}},
+		{name: "empty", field: "region", value: "${EMPTY}", want: Profile{}},
+		{name: "default when empty", field: "region", value: "${EMPTY:-china}", want: Profile{Region: "china"}},
+		{name: "port", field: "mqtt_port", value: "${MQTT_PORT}", want: Profile{MQTTPort: 18883}},
+		{name: "port default", field: "ftp_port", value: "${FTP_PORT:-2121}", want: Profile{FTPPort: 2121}},
+		{name: "port unset", field: "ftp_port", value: "${FTP_PORT:-}", want: Profile{}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			data := `{"printers": {"p": {"serial": "S1", "host": "h", "access_code": "c", "` + test.field + `": "` + test.value + `"}}}`
+			profiles, err := parse(t, data, FormatJSON, env)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			want := Profile{Type: ProfileLocal, Serial: "S1", Host: "h", AccessCode: "c"}
+			for _, field := range profileFields {
+				if field.key != test.field {
+					continue
+				}
+				if field.text != nil {
+					*field.text(&want) = *field.text(&test.want)
+				} else {
+					*field.number(&want) = *field.number(&test.want)
+				}
+			}
+
+			if got := profiles.Printers["p"]; got != want {
+				t.Errorf("profile =\n%+v\nwant\n%+v", got, want)
+			}
+		})
+	}
+}
+
+func TestParseProfilesOverrides(t *testing.T) {
+	data := `
+printers:
+  my-printer:
+    serial: 01S00A000000000
+    host: ${HOST}
+    access_code: "12345678"
+    mqtt_port: 8883
+`
+	tests := []struct {
+		name   string
+		prefix string
+		env    map[string]string
+		want   Profile
+	}{
+		{
+			name: "none",
+			env:  map[string]string{"HOST": "192.168.1.20"},
+			want: Profile{Host: "192.168.1.20", AccessCode: "12345678", MQTTPort: 8883},
+		},
+		{
+			name: "text",
+			env:  map[string]string{"HOST": "192.168.1.20", "BAMBU_MY_PRINTER_ACCESS_CODE": "87654321"},
+			want: Profile{Host: "192.168.1.20", AccessCode: "87654321", MQTTPort: 8883},
+		},
+		{
+			// Overrides replace the file's value before it is interpolated
+			name: "replaces a reference",
+			env:  map[string]string{"BAMBU_MY_PRINTER_HOST": "10.0.0.5"},
+			want: Profile{Host: "10.0.0.5", AccessCode: "12345678", MQTTPort: 8883},
+		},
+		{
+			name: "port",
+			env:  map[string]string{"HOST": "h", "BAMBU_MY_PRINTER_MQTT_PORT": "1883", "BAMBU_MY_PRINTER_FTP_PORT": "2121"},
+			want: Profile{Host: "h", AccessCode: "12345678", MQTTPort: 1883, FTPPort: 2121},
+		},
+		{
+			name:   "prefix",
+			prefix: "PRINTERS",
+			env:    map[string]string{"HOST": "h", "BAMBU_MY_PRINTER_HOST": "ignored", "PRINTERS_MY_PRINTER_HOST": "10.0.0.6"},
+			want:   Profile{Host: "10.0.0.6", AccessCode: "12345678", MQTTPort: 8883},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			profiles, err := ParseProfiles([]byte(data), FormatYAML, LoadOptions{EnvPrefix: test.prefix, LookupEnv: lookupEnv(test.env)})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			test.want.Type = ProfileLocal
+			test.want.Serial = "01S00A000000000"
+			if got := profiles.Printers["my-printer"]; got != test.want {
+				t.Errorf("profile =\n%+v\nwant\n%+v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestParseProfilesErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		format Format
+		data   string
+		env    map[string]string
+		want   []string
+	}{
+		{
+			name:   "unknown fields in YAML",
+			format: FormatYAML,
+			data:   "colour: red\nprinters:\n  p:\n    serial: S1\n    host: h\n    access_code: c\n    nozzle: 0.4\n",
+			want:   []string{"colour: unknown field", "printers.p.nozzle: unknown field"},
+		},
+		{
+			name:   "unknown fields in TOML",
+			format: FormatTOML,
+			data:   "colour = \"red\"\n[printers.p]\nserial = \"S1\"\nhost = \"h\"\naccess_code = \"c\"\nnozzle = 0.4\n",
+			want:   []string{"colour: unknown field", "printers.p.nozzle: unknown field"},
+		},
+		{
+			name:   "unknown fields in JSON",
+			format: FormatJSON,
+			data:   `{"colour": "red", "printers": {"p": {"serial": "S1", "host": "h", "access_code": "c", "nozzle": 0.4}}}`,
+			want:   []string{"colour: unknown field", "printers.p.nozzle: unknown field"},
+		},
+		{
+			name:   "no printers",
+			format: FormatYAML,
+			data:   "default: p\n",
+			want:   []string{"printers: no printers defined", `default: no printer named "p"`},
+		},
+		{
+			name:   "not tables",
+			format: FormatJSON,
+			data:   `{"default": ["p"], "printers": {"p": "S1", "q": {"serial": "S1", "host": ["h"], "access_code": "c"}}}`,
+			want: []string{
+				"default: must be a printer name",
+				"printers.p: must be a table of fields",
+				"printers.q.host: must be a single value",
+				"printers.q.host: is required",
+			},
+		},
+		{
+			name:   "missing variable",
+			format: FormatYAML,
+			data:   "printers:\n  p:\n    serial: S1\n    host: h\n    access_code: ${CODE}\n",
+			want:   []string{"printers.p.access_code: environment variable CODE is not set", "printers.p.access_code: is required"},
+		},
+		{
+			name:   "interpolated port is not a number",
+			format: FormatYAML,
+			data:   "printers:\n  p:\n    serial: S1\n    host: h\n    access_code: c\n    mqtt_port: ${PORT}\n",
+			env:    map[string]string{"PORT": "mqtt"},
+			want:   []string{`printers.p.mqtt_port: must be a number, got "mqtt"`},
+		},
+		{
+			name:   "overridden port is not a number",
+			format: FormatYAML,
+			data:   "printers:\n  p:\n    serial: S1\n    host: h\n    access_code: c\n",
+			env:    map[string]string{"BAMBU_P_FTP_PORT": "ftp"},
+			want:   []string{`printers.p.ftp_port: BAMBU_P_FTP_PORT is not a number: "ftp"`},
+		},
+		{
+			name:   "port out of range",
+			format: FormatTOML,
+			data:   "[printers.p]\nserial = \"S1\"\nhost = \"h\"\naccess_code = \"c\"\nftp_port = 70000\n",
+			want:   []string{"printers.p.ftp_port: must be between 1 and 65535, got 70000"},
+		},
+		{
+			name:   "local",
+			format: FormatYAML,
+			data:   "printers:\n  p:\n    serial: s1\n",
+			want: []string{
+				"printers.p.host: is required",
+				"printers.p.access_code: is required",
+				`printers.p.serial: must be upper case letters and digits, got "s1"`,
+			},
+		},
+		{
+			name:   "cloud",
+			format: FormatYAML,
+			data:   "printers:\n  p:\n    type: cloud\n    serial: S1\n    region: mars\n    mqtt_port: 8883\n",
+			want: []string{
+				"printers.p.user_id: is required",
+				"printers.p.access_token: is required",
+				"printers.p.mqtt_port: is not used by cloud profiles, which connect to the cloud broker",
+				`printers.p.region: must be "global" or "china", got "mars"`,
+			},
+		},
+		{
+			name:   "type",
+			format: FormatYAML,
+			data:   "printers:\n  p:\n    type: usb\n    serial: S1\n",
+			want:   []string{`printers.p.type: must be "local" or "cloud", got "usb"`},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := parse(t, test.data, test.format, test.env)
+
+			var validationErr *ValidationError
+			if !errors.As(err, &validationErr) {
+				t.Fatalf("err = %v, want a ValidationError", err)
+			}
+
+			var got []string
+			for _, field := range validationErr.Fields {
+				got = append(got, field.Error())
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("fields =\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(test.want, "\n"))
+			}
+		})
+	}
+}
+
+func TestParseProfilesSyntaxErrors(t *testing.T) {
+	tests := []struct {
+		format Format
+		data   string
+	}{
+		{format: FormatYAML, data: "printers: [\n"},
+		{format: FormatYAML, data: "- p\n"},
+		{format: FormatTOML, data: "printers = \n"},
+		{format: FormatJSON, data: `{"printers": `},
+		{format: "ini", data: "[printers]"},
+	}
+
+	for _, test := range tests {
+		t.Run(string(test.format), func(t *testing.T) {
+			_, err := parse(t, test.data, test.format, nil)
+
+			var validationErr *ValidationError
+			if err == nil || errors.As(err, &validationErr) {
+				t.Errorf("err = %v, want a syntax error", err)
+			}
+		})
+	}
+}
+
+func TestProfileConfig(t *testing.T) {
+	local := Profile{Type: ProfileLocal, Serial: "S1", Host: "h", AccessCode: "c", MQTTPort: 1883}.Config()
+	if local.GetPort(ServiceMQTT) != 1883 || local.GetPort(ServiceFTP) != DefaultPort(ServiceFTP) {
+		t.Errorf("local ports = %d, %d", local.GetPort(ServiceMQTT), local.GetPort(ServiceFTP))
+	}
+	if local.GetBrokerUrl() != "tls://h:1883" {
+		t.Errorf("local broker = %s", local.GetBrokerUrl())
+	}
+
+	cloud := Profile{Type: ProfileCloud, Serial: "S1", Host: "h", UserID: "1", AccessToken: "t", Region: "china", FTPPort: 2121}.Config()
+	if cloud.GetPort(ServiceFTP) != 2121 {
+		t.Errorf("cloud FTP port = %d", cloud.GetPort(ServiceFTP))
+	}
+	if cloud.GetBrokerUrl() != RegionChina.BrokerURL() {
+		t.Errorf("cloud broker = %s", cloud.GetBrokerUrl())
+	}
+}
+
+func TestLoadProfiles(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "ca.pem"), []byte("ca"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(dir, "config.yaml")
+	if err := os.WriteFile(path, []byte("printers:\n  p:\n    serial: S1\n    host: h\n    access_code: c\n    ca_cert: ca.pem\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	profiles, err := LoadProfiles(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ca := profiles.Printers["p"].CACert; ca != filepath.Join(dir, "ca.pem") {
+		t.Errorf("ca_cert = %s", ca)
+	}
+
+	invalid := filepath.Join(dir, "invalid.json")
+	if err := os.WriteFile(invalid, []byte(`{"printers": {}}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	var validationErr *ValidationError
+	if _, err := LoadProfiles(invalid); !errors.As(err, &validationErr) || validationErr.File != invalid {
+		t.Errorf("err = %v, want a ValidationError for %s", err, invalid)
+	}
+
+	if _, err := LoadProfiles(filepath.Join(dir, "config.ini")); err == nil {
+		t.Error("loaded a file with an unknown extension")
+	}
+}